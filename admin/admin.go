@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/goadesign/goa"
 	"github.com/zenoss/zenkit/admin/app"
+	"github.com/zenoss/zenkit/metrics"
 )
 
 // AdminController implements the admin resource.
@@ -21,6 +22,12 @@ func (c *AdminController) Metrics(ctx *app.MetricsAdminContext) error {
 	// AdminController_Metrics: start_implement
 
 	registry := ContextMetrics(ctx)
+	switch metricsFormat(ctx) {
+	case "prometheus":
+		return writeExposition(ctx, metrics.PrometheusContentType, metrics.WritePrometheus, registry)
+	case "openmetrics":
+		return writeExposition(ctx, metrics.OpenMetricsContentType, metrics.WriteOpenMetrics, registry)
+	}
 	if registry == nil {
 		// No registry was registered; must not be using metrics middleware.
 		return ctx.OK([]byte("{}"))
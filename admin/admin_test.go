@@ -3,16 +3,20 @@ package admin_test
 import (
 	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 
 	"github.com/goadesign/goa"
 	gometrics "github.com/rcrowley/go-metrics"
 	"github.com/zenoss/zenkit"
 	. "github.com/zenoss/zenkit/admin"
+	"github.com/zenoss/zenkit/admin/app"
 	"github.com/zenoss/zenkit/admin/app/test"
 	"github.com/zenoss/zenkit/metrics"
 
 	. "github.com/onsi/ginkgo"
-	//. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega"
 )
 
 // We need a registry that refuses to Marshal
@@ -55,12 +59,69 @@ var _ = Describe("Admin", func() {
 					parent.Context = metrics.WithMetrics(parent.Context, &Registry{})
 				})
 				It("should produce an error", func() {
-					test.MetricsAdminInternalServerError(t, ctx, svc, ctrl, true)
+					test.MetricsAdminInternalServerError(t, ctx, svc, ctrl, nil, true)
 				})
 			})
 			Context("when the registry can be encoded", func() {
 				It("should respond OK", func() {
-					test.MetricsAdminOK(t, ctx, svc, ctrl, true)
+					test.MetricsAdminOK(t, ctx, svc, ctrl, nil, true)
+				})
+			})
+			Context("when a Prometheus format is requested", func() {
+				BeforeEach(func() {
+					registry := gometrics.NewRegistry()
+					gometrics.GetOrRegisterCounter("my.counter", registry).Inc(3)
+					parent.Context = metrics.WithMetrics(parent.Context, registry)
+				})
+				It("should respond with the Prometheus text format", func() {
+					format := "prometheus"
+					rw := test.MetricsAdminOK(t, ctx, svc, ctrl, &format, true)
+					Ω(rw.Header().Get("Content-Type")).Should(Equal(metrics.PrometheusContentType))
+					Ω(rw.(*httptest.ResponseRecorder).Body.String()).Should(ContainSubstring("my_counter_total 3\n"))
+				})
+				It("should respond with the OpenMetrics format", func() {
+					format := "openmetrics"
+					rw := test.MetricsAdminOK(t, ctx, svc, ctrl, &format, true)
+					Ω(rw.Header().Get("Content-Type")).Should(Equal(metrics.OpenMetricsContentType))
+					Ω(rw.(*httptest.ResponseRecorder).Body.String()).Should(HaveSuffix("# EOF\n"))
+				})
+			})
+			Context("when the format is negotiated", func() {
+				metricsWithAccept := func(accept string) *httptest.ResponseRecorder {
+					rw := httptest.NewRecorder()
+					req, _ := http.NewRequest("GET", "/metrics", nil)
+					req.Header.Set("Accept", accept)
+					goaCtx := goa.NewContext(goa.WithAction(ctx, "AdminTest"), rw, req, url.Values{})
+					mctx, err := app.NewMetricsAdminContext(goaCtx, req, svc)
+					Ω(err).ShouldNot(HaveOccurred())
+					Ω(ctrl.Metrics(mctx)).Should(Succeed())
+					return rw
+				}
+				It("should prefer OpenMetrics when a scraper accepts it", func() {
+					rw := metricsWithAccept("application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal(metrics.OpenMetricsContentType))
+				})
+				It("should use the Prometheus text format when a scraper accepts it", func() {
+					rw := metricsWithAccept("text/plain;version=0.0.4;q=1,*/*;q=0.1")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal(metrics.PrometheusContentType))
+				})
+				It("should fall back to JSON", func() {
+					rw := metricsWithAccept("*/*")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal("application/json"))
+				})
+				It("should not use formats that aren't acceptable", func() {
+					rw := metricsWithAccept("application/openmetrics-text;q=0,text/plain;q=0.5")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal(metrics.PrometheusContentType))
+					rw = metricsWithAccept("application/openmetrics-text;q=0")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal("application/json"))
+				})
+				It("should use the format with the highest q-value", func() {
+					rw := metricsWithAccept("application/openmetrics-text;version=1.0.0;q=0.2,text/plain;version=0.0.4;q=0.8")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal(metrics.PrometheusContentType))
+				})
+				It("should not match media types by substring", func() {
+					rw := metricsWithAccept("text/plainly")
+					Ω(rw.Header().Get("Content-Type")).Should(Equal("application/json"))
 				})
 			})
 		})
@@ -69,7 +130,13 @@ var _ = Describe("Admin", func() {
 			It("should respond OK", func() {
 				s := ContextParentService(ctx)
 				s.Context = metrics.WithMetrics(s.Context, nil)
-				test.MetricsAdminOK(t, ctx, svc, ctrl, false)
+				test.MetricsAdminOK(t, ctx, svc, ctrl, nil, false)
+			})
+			It("should respond OK in the Prometheus format", func() {
+				s := ContextParentService(ctx)
+				s.Context = metrics.WithMetrics(s.Context, nil)
+				format := "prometheus"
+				test.MetricsAdminOK(t, ctx, svc, ctrl, &format, false)
 			})
 		})
 	})
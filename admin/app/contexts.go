@@ -22,6 +22,7 @@ type MetricsAdminContext struct {
 	context.Context
 	*goa.ResponseData
 	*goa.RequestData
+	Format *string
 	Pretty bool
 }
 
@@ -34,6 +35,16 @@ func NewMetricsAdminContext(ctx context.Context, r *http.Request, service *goa.S
 	req := goa.ContextRequest(ctx)
 	req.Request = r
 	rctx := MetricsAdminContext{Context: ctx, ResponseData: resp, RequestData: req}
+	paramFormat := req.Params["format"]
+	if len(paramFormat) > 0 {
+		rawFormat := paramFormat[0]
+		rctx.Format = &rawFormat
+		if rctx.Format != nil {
+			if !(*rctx.Format == "json" || *rctx.Format == "prometheus" || *rctx.Format == "openmetrics") {
+				err = goa.MergeErrors(err, goa.InvalidEnumValueError(`format`, *rctx.Format, []interface{}{"json", "prometheus", "openmetrics"}))
+			}
+		}
+	}
 	paramPretty := req.Params["pretty"]
 	if len(paramPretty) == 0 {
 		rctx.Pretty = true
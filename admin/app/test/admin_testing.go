@@ -28,7 +28,7 @@ import (
 // It returns the response writer so it's possible to inspect the response headers and the media type struct written to the response.
 // If ctx is nil then context.Background() is used.
 // If service is nil then a default service is created.
-func MetricsAdminInternalServerError(t goatest.TInterface, ctx context.Context, service *goa.Service, ctrl app.AdminController, pretty bool) (http.ResponseWriter, error) {
+func MetricsAdminInternalServerError(t goatest.TInterface, ctx context.Context, service *goa.Service, ctrl app.AdminController, format *string, pretty bool) (http.ResponseWriter, error) {
 	// Setup service
 	var (
 		logBuf bytes.Buffer
@@ -49,6 +49,10 @@ func MetricsAdminInternalServerError(t goatest.TInterface, ctx context.Context,
 	// Setup request context
 	rw := httptest.NewRecorder()
 	query := url.Values{}
+	if format != nil {
+		sliceVal := []string{*format}
+		query["format"] = sliceVal
+	}
 	{
 		sliceVal := []string{fmt.Sprintf("%v", pretty)}
 		query["pretty"] = sliceVal
@@ -62,6 +66,10 @@ func MetricsAdminInternalServerError(t goatest.TInterface, ctx context.Context,
 		panic("invalid test " + err.Error()) // bug
 	}
 	prms := url.Values{}
+	if format != nil {
+		sliceVal := []string{*format}
+		prms["format"] = sliceVal
+	}
 	{
 		sliceVal := []string{fmt.Sprintf("%v", pretty)}
 		prms["pretty"] = sliceVal
@@ -102,7 +110,7 @@ func MetricsAdminInternalServerError(t goatest.TInterface, ctx context.Context,
 // It returns the response writer so it's possible to inspect the response headers.
 // If ctx is nil then context.Background() is used.
 // If service is nil then a default service is created.
-func MetricsAdminOK(t goatest.TInterface, ctx context.Context, service *goa.Service, ctrl app.AdminController, pretty bool) http.ResponseWriter {
+func MetricsAdminOK(t goatest.TInterface, ctx context.Context, service *goa.Service, ctrl app.AdminController, format *string, pretty bool) http.ResponseWriter {
 	// Setup service
 	var (
 		logBuf bytes.Buffer
@@ -123,6 +131,10 @@ func MetricsAdminOK(t goatest.TInterface, ctx context.Context, service *goa.Serv
 	// Setup request context
 	rw := httptest.NewRecorder()
 	query := url.Values{}
+	if format != nil {
+		sliceVal := []string{*format}
+		query["format"] = sliceVal
+	}
 	{
 		sliceVal := []string{fmt.Sprintf("%v", pretty)}
 		query["pretty"] = sliceVal
@@ -136,6 +148,10 @@ func MetricsAdminOK(t goatest.TInterface, ctx context.Context, service *goa.Serv
 		panic("invalid test " + err.Error()) // bug
 	}
 	prms := url.Values{}
+	if format != nil {
+		sliceVal := []string{*format}
+		prms["format"] = sliceVal
+	}
 	{
 		sliceVal := []string{fmt.Sprintf("%v", pretty)}
 		prms["pretty"] = sliceVal
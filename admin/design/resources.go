@@ -13,9 +13,12 @@ var _ = Resource("admin", func() {
 		Response(OK)
 	})
 	Action("metrics", func() {
-		Description("Return a snapshot of metrics")
+		Description("Return a snapshot of metrics. Without a format, the format is negotiated from the Accept header, falling back to JSON.")
 		Routing(GET("/metrics"))
 		Params(func() {
+			Param("format", String, "Format of the snapshot", func() {
+				Enum("json", "prometheus", "openmetrics")
+			})
 			Param("pretty", Boolean, "Indent resulting JSON", func() {
 				Default(true)
 			})
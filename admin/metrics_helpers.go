@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/zenoss/zenkit/admin/app"
+)
+
+// metricsMediaTypes are the formats metrics can be negotiated in by media
+// type, in order of preference when the Accept header ranks them equally.
+var metricsMediaTypes = []struct {
+	mediaType string
+	format    string
+}{
+	{"application/openmetrics-text", "openmetrics"},
+	{"text/plain", "prometheus"},
+	{"application/json", "json"},
+}
+
+// metricsFormat returns the format requested by the format param or, absent
+// that, negotiated from the Accept header: the format whose media type the
+// header ranks highest by q-value. Prometheus scrapers ask for OpenMetrics
+// first and fall back to the text format. Wildcards and media types that
+// aren't acceptable (q=0) select JSON.
+func metricsFormat(ctx *app.MetricsAdminContext) string {
+	if ctx.Format != nil {
+		return *ctx.Format
+	}
+	qualities := acceptQualities(ctx.Request.Header.Get("Accept"))
+	format, best := "json", 0.0
+	for _, t := range metricsMediaTypes {
+		if q := qualities[t.mediaType]; q > best {
+			format, best = t.format, q
+		}
+	}
+	return format
+}
+
+// acceptQualities parses the media ranges of an Accept header into their
+// q-values. Ranges that can't be parsed are ignored.
+func acceptQualities(accept string) map[string]float64 {
+	qualities := map[string]float64{}
+	for _, r := range strings.Split(accept, ",") {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(r)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if existing, ok := qualities[mediaType]; !ok || q > existing {
+			qualities[mediaType] = q
+		}
+	}
+	return qualities
+}
+
+// writeExposition responds with the registry rendered by write. An absent
+// registry renders as an empty one.
+func writeExposition(ctx *app.MetricsAdminContext, contentType string, write func(io.Writer, gometrics.Registry) error, registry gometrics.Registry) error {
+	var buf bytes.Buffer
+	if err := write(&buf, registry); err != nil {
+		return ctx.InternalServerError(err)
+	}
+	ctx.ResponseData.Header().Set("Content-Type", contentType)
+	ctx.ResponseData.WriteHeader(200)
+	_, err := ctx.ResponseData.Write(buf.Bytes())
+	return err
+}
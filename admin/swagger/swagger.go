@@ -69,7 +69,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _swaggerSwaggerJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xcc\x57\xdd\x6e\x1b\x37\x13\x7d\x95\x01\xfd\x5d\x7c\x05\x36\xb6\xf2\x8b\x56\x45\x2f\x0a\xb8\x41\xdc\xb4\x4d\x60\x27\xb9\x09\x82\x60\xb4\x1c\x49\x0c\xb8\x24\x4d\x0e\x65\x0b\x81\xde\xbd\x18\xee\xae\xac\xb5\xa4\x58\x69\x5d\x24\x57\x6b\xef\x70\x87\xe7\x9c\x39\x33\xa4\x3e\xab\x74\x85\xb3\x19\x45\x35\x56\x8f\x8e\x47\xaa\x52\xc6\x4d\xbd\x1a\x7f\x56\x6c\xd8\x92\x1a\xab\x5f\x75\x63\x1c\x5c\x50\x5c\x98\x9a\x54\xa5\x34\xa5\x3a\x9a\xc0\xc6\x3b\x35\x56\x6f\xd9\x58\xc3\x86\x12\x84\xe8\x17\x46\x93\x86\xc9\x12\x78\x4e\x80\xe5\x3b\x72\x3a\x78\xe3\x58\x55\x6a\x41\x31\xb5\x1f\xa9\x55\xa5\x52\x3d\xa7\x86\x92\x1a\xbf\x57\x73\xe6\xa0\x3e\x54\xaa\xf6\x2e\xe5\xee\x1d\x86\x60\x4d\x8d\xb2\xcb\xc9\xa7\xe4\x9d\xc4\x43\xf4\x3a\xd7\x5f\x88\x23\xcf\x93\x40\x3f\x99\x13\x5a\x9e\xcb\x9f\x33\xe2\x42\x06\x67\xed\x56\x6d\xe0\x43\xa5\x52\x6e\x1a\x8c\x4b\x35\xee\xde\x41\x17\xba\x4d\xf0\x9c\x82\x8f\x5c\x18\x75\x0b\xfd\xb4\xfc\x97\xd6\x8a\xf8\x40\xb1\x20\x39\xd3\xeb\x74\x47\xdd\xe3\xa1\x1a\xe2\x66\xba\xe6\x93\x60\xd1\x14\xc4\x91\x52\xf0\x2e\x51\x41\xfd\x68\x34\x92\xc7\x70\xfb\x57\x2f\x45\xac\xa7\xa3\xc7\xdb\xa1\xae\x24\xf0\xd6\xe1\x02\x8d\xc5\x89\x25\xb5\xda\xa5\xec\xaa\x12\x50\xfa\x5b\xea\xf0\x3d\xa8\xb0\xaa\x7a\x63\x9c\x68\x7f\xe5\x24\x55\xf0\xe9\x4e\x7b\xc8\xda\x7d\xa2\x5c\x10\x27\x68\xd0\x65\xb4\x1f\x65\x97\x8f\x89\x91\x73\x02\xf6\x80\x0e\x28\x46\x1f\xf7\xe9\x52\x20\x7c\x49\x95\x80\x11\x1b\x62\x8a\x12\xfb\xac\x1c\x36\xd2\x8d\x01\x97\xd6\xa3\x2e\x7d\xaa\xc6\x6a\xe2\xf5\x52\x89\x82\x97\xd9\x44\xd2\x6a\xcc\x31\x53\x47\x1e\x85\xd9\xff\x22\x4d\xd5\x58\x1d\x9d\x68\x9a\x1a\x67\x04\x45\x3a\x39\xf5\x57\xee\x45\x81\xf1\xba\x4b\xb7\x5a\x1d\x5c\x87\xbb\xb4\xcd\xe1\x50\x65\x73\xf8\x07\xba\x3a\x63\xf7\x49\x9a\xc3\x3d\xd8\x6c\x2f\xbd\x86\x38\x9a\x3a\xed\x18\x2a\x65\xd2\x0d\xa9\x75\x8b\xdb\x21\xb8\xa3\x97\x38\x47\x07\x08\xc9\x61\x48\x73\xcf\xd2\x4b\x7d\xfe\xdb\xe4\x4a\x8a\xa3\x9b\xe8\x17\xa7\x60\x35\x78\xb5\x70\xfa\x78\xe6\xf1\xb8\x35\xe2\x7e\x4f\x45\x62\x5e\xf6\x96\xba\xcc\x14\x97\x5b\x90\xcf\x9c\x26\xc7\x10\x29\x65\xcb\xc6\xcd\xe0\xf7\x8b\x57\x7f\x0d\x9c\x37\x45\x9b\xa8\x52\xbc\x0c\x54\x8c\xe9\x2d\x61\x4b\x7d\x8a\xd9\x72\x6b\xcd\xc3\x6d\x26\xed\xbe\x23\x74\xe6\x98\xa2\x43\x5b\x0e\x24\x8a\xf0\x5b\xd7\x63\x77\x38\xbe\x55\x60\xb5\xbf\xba\xc1\xb8\xd9\x61\xa5\x95\x95\x7b\xeb\x2a\xcc\x34\x5c\x19\x9e\x03\xc2\xa3\xd1\x08\xcc\x60\x48\x82\x49\x70\x33\xa6\x76\x57\x5a\xf2\xdf\xcb\xb1\x71\xe0\x51\xf0\x8d\x28\xfe\x97\xad\xba\xbe\xd2\x6c\xd5\xb3\x8f\x0c\xe8\x76\x2f\xa1\x0f\xde\xe6\x7c\x6a\x52\xb0\xb8\x84\x8b\x6e\x5d\x4e\xa2\xcf\x39\x9d\xfa\x7a\x8b\x60\x97\xe3\xe8\x26\xd7\x16\xcb\x39\x37\xf6\xfe\x48\x1e\x97\xbe\x3f\x90\xa9\xac\xdd\x4b\xf3\x5c\x46\x0c\x2d\x68\xcd\x33\x05\xaa\x01\x53\xdf\xea\xbb\x89\x76\x63\xe7\xdf\x4c\xa5\xef\x6f\x20\xac\xca\xd8\xea\x17\x4b\x92\xed\x23\x73\xe3\x8a\xbc\x1d\x5c\xcf\x41\x3f\xf9\x44\x35\xb7\x02\x05\x8a\x72\x53\x96\x2f\x23\x61\x57\xb6\x6e\x5d\xe2\xd8\x36\x05\x5d\x63\x13\x4a\xd6\x3f\x30\x6b\x74\x6c\x72\x03\x97\xd9\x00\x5d\xcb\x63\x92\x93\xc6\x06\x12\x06\x43\x8e\x09\x98\x9a\xe0\x23\x1e\x17\xa3\xac\xbf\xbd\xd9\xe0\x6b\xb3\x6c\x4e\xf4\xf7\x7d\x16\x19\x1b\xad\x60\x1b\xa4\xff\x24\x6d\x50\xd0\x83\x91\xa3\xc1\x4c\x0d\xc5\x31\xec\x2d\xf4\xcf\xb0\x30\x74\xf5\x4b\x7f\x1a\xdc\x25\x50\xed\x35\xed\x92\x67\x58\x7c\x74\x9b\x1b\x3e\x10\xc3\x9a\xa9\xa9\xdb\x9b\x17\x48\x8e\x0a\xe8\x3a\x44\x4a\x89\xb4\x58\x19\xa1\xcd\x04\x0b\xb4\x99\x8e\x07\x7a\x1b\xb7\x40\x6b\xf4\xc7\x12\x52\xc5\x02\x8c\xc6\x1e\x80\x02\xe6\xb9\x41\xf7\x20\x12\x6a\x99\x7a\xb2\xa5\x45\x57\x30\xc1\x1a\x13\x7b\xe0\xb9\x49\xe0\xeb\x3a\xc7\x48\xae\xa6\xfe\x06\x1d\xa2\x9f\x58\x6a\x86\x68\xde\x09\x0a\x59\x71\x76\x0a\x4d\x4e\x0c\x13\x92\x3b\xa5\x71\x4c\xd2\xbc\xab\x4a\x19\x7d\x08\xb4\xec\xcc\x65\xde\xac\x11\x4c\x7d\x6c\x91\x04\x8c\x6c\xea\x6c\x31\x1e\x0a\xea\xf1\xf3\x87\xcf\x5f\xbe\x3b\x3f\x97\xed\x1b\x62\xdc\x00\xb0\xae\xe3\x6d\x00\xb2\x0e\xda\x28\xd4\xde\x31\x1a\x27\x05\x70\x52\x2f\x46\xa7\x31\xea\xb2\xe6\x81\xfc\xf0\x8c\x4d\xab\x1a\x4e\x7c\x6e\x7f\x6c\x94\x52\x0e\x50\x88\x05\x1b\x4a\x8c\x4d\x50\xe3\x87\x4f\x9e\xfe\xf8\x6c\xf4\xd3\xe8\xd9\xb3\x55\xa5\x50\xeb\xd2\xb1\x68\x5f\x6f\x98\xa9\xdc\x3b\x2a\xd5\x5e\x21\xef\x96\x4c\x36\x7d\xf1\xe6\xcd\x6b\xe8\xee\x9c\xe2\xa2\xde\x65\x52\xdc\xbe\x8e\x9d\x40\x5f\x61\xb0\x27\xa3\x91\x6a\x47\xcb\xe6\x7e\x65\x60\x41\x3f\x03\xa1\x91\xb6\x82\xd2\x57\xff\xef\x9a\xa5\x74\xce\x0f\x43\x09\xda\xfe\xb8\xe5\xd9\x1b\xcb\xde\x65\x9f\xd6\x3d\x37\xf5\xdc\x28\xe7\x1e\x6d\x7b\xf9\x3a\x16\xab\x5b\x63\xfb\xd5\xcb\x3d\xc7\xd7\xea\xef\x00\x00\x00\xff\xff\xcc\x95\x1b\x1f\x62\x10\x00\x00")
+var _swaggerSwaggerJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xcd\x57\x6d\x6f\xdb\x36\x10\xfe\x2b\x84\xd2\x0f\x1b\xe0\xd8\x4e\x5f\x82\x35\xc3\x3e\x14\xc8\x82\x66\xdd\xd6\x20\x69\xbb\x0f\xc5\x10\x9c\xc5\xb3\xc5\x4e\x22\x59\xbe\x38\x31\x02\xff\xf7\xdd\x51\x92\x6d\xc5\x76\xec\x74\x1d\xda\x4f\xb6\x74\xe4\xf1\xb9\xe7\xee\x1e\x9e\xee\x32\x7f\x03\x93\x09\xba\xec\x24\x7b\xda\x1f\x66\xbd\x4c\xe9\xb1\xc9\x4e\xee\xb2\xa0\x42\x89\xf4\xf6\x95\xac\x94\x16\x57\xe8\xa6\x2a\x47\xb2\x4b\xf4\xb9\x53\x36\x28\xa3\xc9\xfa\x3e\xa8\x52\x05\x85\x5e\x58\x67\xa6\x4a\xa2\x14\xa3\x99\x08\x05\x0a\x48\xfb\x50\x4b\x6b\x94\x0e\xb4\x71\x8a\xce\xd7\x9b\xb2\x79\x2f\xf3\x79\x81\x15\xfa\xec\xe4\x63\x56\x84\x60\xb3\xbf\x7b\x59\x6e\xb4\x8f\xcd\x3b\xb0\xb6\x54\x39\xf0\x29\x83\x4f\x9e\x76\x91\x9d\x4e\x90\x31\x7f\xc0\x0e\xa1\xf0\x0c\x7d\x50\x20\x94\xa1\xe0\xbf\x13\x0c\x29\x18\x98\xd4\x47\xd5\x06\x5a\x4c\x27\x55\xe0\x66\x84\xa6\x7e\x27\x1a\xd3\xfd\x00\x2f\xd1\x1a\x17\x52\x44\xcd\x42\x33\x4e\x4f\x7e\xc1\x88\xb1\xe8\x12\x92\x73\xb9\x70\x77\xd0\xfc\x1c\x65\x5d\xdc\x01\x6f\xc3\xc0\x96\xa0\x12\x62\x87\xde\x52\xd0\x98\x50\x3f\x1d\x0e\xf9\xa7\x7b\xfc\xdb\x37\x4c\xd6\x8b\xe1\xb3\x75\x53\x93\x12\xf1\x5e\xc3\x14\x54\x09\x23\x4a\xd7\x7c\x13\xb3\xf4\x8e\xd0\xc8\x6f\xc9\xc3\xf7\xc0\x02\xbd\x6c\x0a\x63\x20\xcd\x8d\x66\x57\xd6\xf8\x9d\xe5\xc1\x6b\xb7\x91\x72\x85\xc1\x8b\x0a\x74\x84\xf2\x9a\x4f\xb9\xf6\x01\x42\xf4\x22\x18\x01\x54\xfb\xce\x19\xb7\x8d\x97\x04\xe1\x21\x56\x2c\x38\xa8\x30\x50\xd3\x90\xed\x2e\xd3\xf4\x40\xbb\x2d\xcc\x4a\x43\xb9\xe4\x3e\xa5\xc7\x91\x91\xb3\x8c\x19\xfc\x1c\x95\x43\x72\x1f\x5c\xc4\x26\x78\xe0\xc8\x9e\x38\x1c\xd3\xba\x83\x81\xc4\xb1\xd2\x8a\x51\xf8\xc1\x29\x9d\xfd\x3a\xc1\xb8\x68\xdc\xcd\xe7\x7b\xe7\x61\x17\xb7\xd1\xee\xcb\x6c\xb4\x5f\xc0\xab\x56\xe5\x36\x4a\xe9\xe4\xff\x5e\x66\x5b\xc3\xa3\x5c\x38\x95\xfb\x0d\xa2\x92\x94\xae\x1b\x5a\xb3\xb8\x16\xc1\x0d\xbd\x14\xa2\xd3\x02\x84\xd7\x60\x7d\x61\x02\xf7\x52\xb3\xa5\x2f\xfe\x52\xa1\x30\x31\x90\x79\x6c\x5c\x05\xa1\x97\xda\xac\xfe\x2f\x94\x17\x1a\x27\x26\x28\x08\x24\xb5\x63\x67\xaa\x64\x7d\x95\xe7\x68\x03\xd3\x29\xd1\xf5\xc4\x18\xca\x52\xe9\x89\x18\x41\xfe\x0f\x93\xf6\xdb\xd5\xdb\x3f\xfb\x6b\xb4\x25\x70\x07\x6d\x5c\x3b\xf4\xb5\xd7\x79\x35\xd5\xb2\x3f\x31\xd0\xaf\x4b\x7c\x6b\xb5\xd6\xa0\xdb\x62\xfd\x1c\xd1\xcd\xd6\xc8\x38\xab\x03\x6b\xd5\xa4\x61\xa4\x53\xd4\x14\x8e\xa7\xaa\x0e\x33\xcb\x4e\x3d\xe1\xd5\x13\x5a\x80\x3a\x56\x8c\xb5\xc1\x47\xf0\x09\x41\x81\xd1\xd7\x91\xea\x36\x32\x12\xc0\x65\xff\x38\x0c\x61\xf6\x30\xa2\x73\x2d\x51\x07\x41\xf5\x12\xcb\xc0\x34\x32\x7d\x0f\x00\x1a\x19\x53\x22\xd4\x69\x1e\x03\xed\xa9\xdb\x70\xfe\x28\x69\xdb\x60\x3a\xd7\xc4\xa7\x86\x32\x5d\xbe\xe8\xc4\xaf\x8d\x9e\xec\xe8\xee\x3a\x27\xf3\xed\x95\x6c\x99\xbe\xbd\xca\x98\x57\x6e\xad\x61\x8e\x4c\x8a\x1b\xaa\x56\x2a\x55\x8a\x4d\xa8\xce\x85\xc0\xa5\xba\x94\xe4\xcd\xb5\xc7\xfe\xbf\xca\x15\xb9\xe7\xb5\xf7\x8d\x42\xfc\x3f\x65\x69\x31\xbe\xad\xe5\xb3\xb5\x74\xc2\x6d\x5e\x8a\xd6\x78\x3f\xe6\x53\xe5\x09\xd9\x4c\x5c\x35\xeb\xa2\x67\x7e\x2e\xf1\xd4\xe4\x6b\x01\x36\x3e\x0e\x96\xbe\xd6\xa2\x2c\x42\x55\x7e\xbd\x20\xfb\xa9\xd3\xf7\x8c\x94\xd7\x6e\x0d\xf3\x92\xa5\x01\xa7\xb8\x88\xd3\x5b\xcc\x05\xf8\xb6\xd5\x37\x07\xba\x14\x9a\x2f\xd6\xc9\xef\x4f\x10\xe6\x49\xb6\xda\xc5\xec\x64\x7d\x3c\x58\xf9\x1c\x58\x37\x2e\x74\xd0\x8c\x3e\x61\x1e\x6a\x82\x88\x3f\xfe\x2a\xe0\x9d\x0e\xa1\x49\xdb\x9a\x80\xdf\x42\x65\x93\xd7\xdf\x21\x4a\xd0\x41\xc5\x4a\x90\xc6\x0a\xbc\xe5\x9f\x51\xf4\x12\x2a\xe1\xc1\x2a\xd2\x63\x14\x01\x2b\x9a\x41\xa1\x9f\x0a\x65\xb1\x77\x79\xc0\x63\xbd\xac\x2a\xfa\xc7\xd6\x0b\xcb\x46\x4d\xd8\x4a\xd0\x7f\xa0\xa4\x0b\x97\xd0\x0b\xc5\x57\x83\x1a\x2b\x74\x27\x62\x6b\xa2\x7f\x16\x53\x85\x37\xbf\xb4\xb7\xc1\x2e\x82\x72\x23\x71\x13\x3d\xdd\xe4\xd3\x40\xb9\x72\xe0\x21\x17\x2c\xe1\xc8\xeb\x29\x53\xb0\x8f\x1e\x45\x4c\xf7\x9b\xf7\x34\x19\x50\x29\xd3\x78\x91\x3c\x89\x29\x94\x11\xfb\x1d\xbe\x95\xa6\x97\x4a\x5e\x27\x53\x96\x4a\x20\x90\x8e\xed\x81\x42\x14\x91\x06\xb3\x43\x62\x4b\xb2\xea\xf1\x91\x25\xe8\x84\x49\x2c\x30\xd1\xc8\x11\x0a\x12\x47\x93\xe7\xd1\x39\xd4\x24\x95\xcd\xfd\x4e\x91\xd3\xae\xaa\x8b\xe6\x03\xa3\xe0\x15\xe7\xa7\xa2\x8a\x3e\x88\x11\xf2\xfc\x4c\x9f\x8d\xc8\xcd\x4b\xf0\x94\xdc\x07\x5a\xd4\x8a\x6e\xf4\x95\x1c\xf1\xd4\x54\x23\xa1\xf9\x24\xa8\x3c\x96\xe0\xf6\x05\xf5\xec\xec\xe8\xec\xcd\x87\xcb\x4b\x3e\x9e\x26\x09\x58\x01\xb0\xc8\xe3\x7d\x00\xbc\x4e\xd4\x56\xca\x88\x26\x4a\x35\x27\x40\x73\xbe\x02\x68\x09\x4e\xa6\x35\x87\xfc\x91\xcd\x63\x0f\xb3\x06\x23\x9e\xf6\x18\x47\x4a\x65\x07\x05\x97\x20\xf5\x6b\xa0\xc7\xec\xe4\xe8\xf9\x8b\x9f\x8e\x87\x2f\x87\xc7\xc7\x04\x09\xa4\x4c\x1d\x0b\xe5\xc5\x4a\x31\xa5\xb9\x83\xda\x3c\x8d\xcb\xbb\x29\xe3\x43\x5f\xbf\x7b\x77\x21\x9a\xf9\x9a\xab\xa8\xad\x32\x4e\x6e\x9b\xc7\x86\xa0\x47\x14\xd8\x73\xd2\xaf\x5a\x5a\x56\xcf\x4b\x82\x25\x5a\x0d\x24\x2a\xa8\xad\x44\xea\xab\x1f\x9a\x66\x49\x9d\xf3\x63\x97\x82\xba\x3f\xee\xd5\xec\xb2\x64\x77\x95\x4f\x5d\x3d\xcb\x7c\xae\xa4\x73\x0b\xb7\x2d\x7d\x4d\x14\xf3\x7b\xb2\x4d\x0a\xbd\xf9\xfa\x9a\xff\x0b\x4e\x0b\x21\x50\x4e\x11\x00\x00")
 
 func swaggerSwaggerJsonBytes() ([]byte, error) {
 	return bindataRead(
@@ -84,12 +84,12 @@ func swaggerSwaggerJson() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "swagger/swagger.json", size: 4194, mode: os.FileMode(420), modTime: time.Unix(1507300625, 0)}
+	info := bindataFileInfo{name: "swagger/swagger.json", size: 4430, mode: os.FileMode(420), modTime: time.Unix(1792277815, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
 
-var _swaggerSwaggerYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xec\x98\x4f\x6f\x1b\x37\x13\xc6\xef\xfa\x14\x03\xf9\x05\xfc\x16\xad\x25\xe5\x5f\xd1\x6c\xd1\x43\x01\x37\x88\xeb\xb6\x0e\xec\x24\xd7\x60\xb4\x1c\x49\x13\x70\x49\x9a\x1c\xca\xd6\xb7\x2f\x48\x51\xd2\xae\xb5\x56\x9c\x20\x35\x5a\xb4\xba\x2c\x76\x39\x4b\x3e\xfc\xcd\xc3\x21\x57\xb5\x35\x21\x36\x14\xaa\xc1\x09\xa0\x73\x9a\x6b\x14\xb6\x66\xfc\x31\x58\x33\x50\x34\x63\xc3\xe9\x3e\x54\x03\x80\x53\x7b\x63\x5e\x13\x6a\x59\xbc\xc1\x95\xb6\xa8\xd2\x43\x00\xba\xc5\xc6\x69\x5a\xdf\x00\x78\xc2\x60\x4d\x05\xbf\x61\x54\x68\x84\x63\x03\xd7\x91\x81\x6e\xd3\x65\x1a\x83\xc2\x06\x02\x3a\x26\x23\x04\x42\x8d\xb3\x1e\x47\xf9\x5d\xe7\xad\x23\x2f\x4c\xe1\x4e\x5f\xe5\x6e\x37\xd4\x17\x74\x9e\x7e\xb2\x72\x54\x41\x10\xcf\x66\x3e\x58\xf7\x7f\x1d\xd9\x53\x99\xc8\x49\x19\x2f\xdf\x08\x4b\x1a\x68\x6f\xce\x83\x5d\x47\x76\xfa\x91\x6a\x19\x00\x90\xf7\xd6\xaf\xfb\x50\x14\x6a\xcf\x2e\x31\xab\xe0\x97\xf4\x1c\x3c\x05\x67\x4d\x20\x68\x48\x31\xe6\x77\xe1\xff\x8a\x66\x18\xb5\xc0\x92\xe9\xe6\x9b\x3e\x8c\xb5\x55\x54\x01\x9b\x25\x6a\x56\x1f\x96\xa8\x23\x95\x16\x45\x82\xac\x2b\x78\x9f\x9e\x81\x9d\xc1\xd9\x29\x34\x31\x08\x4c\x09\xd0\x00\x1b\xa1\x39\xf9\x12\xcc\xaa\x82\x67\xaf\x9e\xbc\x3a\x7f\x7f\x79\x59\x1e\x35\x24\xb8\x43\x2a\xdc\x50\x10\x6c\x5c\x05\x4f\x46\xcf\x5f\xfc\xf0\xfd\xe4\x25\x7d\x3b\x79\x59\xda\x83\xa0\xc4\x50\xc1\xf0\xf9\x64\x32\xbc\x27\x49\x59\xe9\xb6\xbf\x0e\x00\x34\x6d\x53\x9d\x04\x47\x35\xcf\xb8\x5e\x03\xcb\x2f\x7e\x07\x74\xeb\x3c\x85\x40\x0a\x30\x00\x96\xec\x40\x9e\xf0\x68\x3f\xf1\x7d\x40\x7a\x12\xbb\xa5\x74\x8f\x2e\x58\xc4\x06\xcd\x89\x27\x54\x38\xd5\x94\x44\x68\x34\x59\x25\x6c\x55\x8a\x05\x59\x70\x00\x5b\xd7\xd1\x7b\x32\x75\xa6\x2d\x8b\xdd\xb8\x19\xc7\x54\x53\xd3\xa3\xf4\x41\xe9\xe9\x95\xce\xea\x5e\xd9\xd1\xf0\x75\x24\x60\x45\x46\x78\xc6\xe4\x61\x66\xfd\x5a\xa5\x43\x2f\x5c\x47\x8d\x7e\x5f\xf0\x01\x99\x77\xcc\xd1\x2b\xa8\xeb\x18\x54\x2a\xd7\x04\xd4\x6f\x76\x5e\x00\xf1\xad\x7c\xdc\x51\x9d\xde\x2f\xab\x05\x6a\x6b\x04\xd9\xa4\x1c\x9b\x64\x09\x41\xa3\xd0\xab\x1c\x73\xc2\x66\x66\x7d\xb3\x4e\x03\x4e\x6d\x94\x16\xe9\x34\x8d\xec\x9b\xfd\x49\xb4\xa3\x0e\xda\x79\x6f\xe5\xb6\x3c\xde\x2f\x3e\x8d\xfa\xfa\xed\xdb\x37\x25\x2a\x7b\x76\xe3\xe9\x64\x9c\x8d\x47\x0a\xe0\xae\x9d\x5b\xba\x3e\x69\xec\xdd\x0a\xeb\x4d\x42\x29\x47\xc7\xbf\xa7\x1a\x92\x4b\xc8\xce\x03\x55\xa7\x72\x2f\x8d\x1a\xcd\x2d\x8e\x32\xab\x1f\x73\x81\xf9\xa9\x54\x9b\xe3\xfd\xe2\x95\x88\xa7\xa9\x77\x26\xfd\x4e\x58\x73\x4a\x6b\x9a\xd6\x92\x15\x29\x98\xae\x32\x0a\x54\x0d\x1b\x20\xa3\x9c\x65\x93\x00\x16\x5d\x3f\xe7\xe7\x57\xe4\x97\x5c\x27\x1b\x2c\xc9\x87\xdc\xd5\x70\x38\x70\x28\x8b\x8c\x77\xbc\xc8\x85\x74\x4d\x7a\x4e\x52\x0d\x7a\x80\x5f\x92\xb3\x5e\xf2\x60\xeb\xf0\x8d\x85\xc3\xb6\xf3\xf4\x4b\xc6\xcb\xf3\x3d\x53\x55\x09\x3c\x2a\x97\x27\x83\xed\xd2\x54\xb1\xde\xd5\xa9\x13\x10\xba\x95\xb1\xd3\xc8\x66\xbb\xbf\xac\x4b\x73\x2b\xfb\xc3\xa7\x93\xc9\xb0\xed\xa8\x8e\xba\x8b\xf3\x5d\xe0\x8b\xc9\xb3\xfb\x03\x0b\x0a\x78\x67\x70\x89\xac\x93\x57\x36\x76\xab\x17\xd4\xb4\x55\x2d\x44\xdc\xa6\x2d\x36\x0d\xfa\xd5\x66\x46\xe5\x52\x1a\x05\xe7\xed\xb7\x76\x4d\x0b\x42\xf5\xd7\xb0\xfc\x77\x91\x2c\xfe\x1c\x2b\x7b\x53\xce\x1c\xce\x86\x7e\x97\x5e\x91\x04\x68\xd0\x44\xd4\x1f\xd2\xa8\x1f\x4a\x79\x10\x9b\x6a\x7c\x5e\x7a\x07\xe0\xa6\x01\x36\x68\xd1\x63\x43\x42\xbe\x25\x88\x4d\x05\x53\xab\x56\x5b\x22\x06\x1b\xaa\xc0\xb5\x0e\x20\xd0\x3e\xbc\x74\x6b\x6f\xa6\x82\x6d\x9c\xff\xf3\x34\xab\xe0\xf8\x68\xdc\x3a\xcf\x8d\xf7\x0e\x36\xc7\x8f\x91\xec\x07\xa5\x2c\xe1\xf9\xac\x84\x45\xf7\xe5\xe9\x32\xac\x0f\x64\x2a\xba\xbf\x0d\x95\xe8\x1e\xc6\xa4\x21\xf1\x5c\x87\x4f\x55\x59\x89\xde\xa4\x5d\xc9\xa0\x0b\x0b\x2b\xa9\x32\x94\x37\xfb\x70\xe4\xba\x7f\xd4\x0d\xe8\xb3\x6e\xd9\x68\x0e\x1d\x07\xce\x4c\xda\xb8\x12\xad\xa8\x25\xed\x88\xbf\x5e\x5d\xfc\xb1\x0d\x4d\xde\xbf\x8e\xe4\xf7\xcc\xef\x49\x64\xd5\xe3\xfd\x19\xea\x70\xf7\x20\x38\xb5\x56\x13\x9a\x7b\x53\xb7\xf7\xa1\xd3\xd7\xd0\xd9\x47\xbf\x4e\x95\x3b\x10\x78\x66\x84\xbc\x41\x9d\xcb\x1d\xf9\xf5\xa7\x43\x2b\x78\x7f\x55\xdf\xb3\xae\xb3\xdc\xe3\xcf\x31\x56\xc9\xea\x3a\xc7\xbd\xe6\xda\xb4\x8c\x1d\x9b\xf9\xa7\x8c\x95\x08\x29\xb8\x61\x59\x00\xc2\xd3\xc9\x04\xb8\xb3\xe7\x00\x07\xb8\x5b\xc8\x7b\xac\x96\x46\x7a\x9c\x9d\xfc\x41\x8c\x92\x9c\x07\x00\x3a\xb8\x17\x7f\x3d\x30\xff\x34\x2c\xe3\x70\x83\xf3\x39\xf9\xc3\xd6\x39\xe5\xe0\x34\xae\xe0\x6a\x1d\x0c\x31\xa4\xde\x2f\xe9\xd4\xd6\x7d\x38\x4a\x9f\x47\xe5\x7a\x98\xc9\x42\x1a\xfd\x38\x48\x8a\x1c\xe8\xca\xea\x72\xd9\xb5\x6d\xc8\x8c\x3e\x6e\xff\xe7\x38\x50\xb2\x3d\xd3\x92\xb6\x7c\xd2\x77\x6a\xfa\x66\x6e\xd5\xcf\x5e\x40\xad\x0a\xf7\x5f\x29\x3c\x9c\xbc\x44\xe0\x41\x99\xdb\x81\xec\x41\xd8\x41\x73\x71\xde\xf3\xc7\xd0\xc5\xf9\x60\xab\xa7\x28\xd9\x2c\x11\x18\x3e\x1d\x4d\x86\x83\x3f\x03\x00\x00\xff\xff\x50\x1f\xdf\x65\x98\x13\x00\x00")
+var _swaggerSwaggerYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xed\x58\xdf\x6f\xdb\x36\x10\x7e\xf7\x5f\x71\x48\x06\x64\xc3\x62\x3b\x59\xdb\x61\xf5\xb0\x87\x02\x69\xd0\x34\xdb\x12\x24\x6d\xf7\x18\xd0\xe2\xd9\x62\x26\x91\x2c\x7f\x38\xf5\x7f\xbf\x23\x45\x59\x52\xac\x28\x6e\xd1\x05\x1b\x36\x3f\x58\x96\x78\x24\xbf\xfb\xee\xbb\x3b\xca\x99\x92\xd6\x97\x68\x67\xa3\x31\x30\xad\x0b\x91\x31\x27\x94\x9c\xde\x5a\x25\x47\x1c\x17\x42\x8a\x70\x4f\xe3\x00\x27\xea\x4e\xbe\x41\x56\xb8\xfc\x92\xad\x0b\xc5\x78\x78\x08\x80\x9f\x58\xa9\x0b\xac\x6e\x00\x0c\x32\x9a\x3b\x83\x5f\x99\xe7\x4c\x3a\xe1\x4b\xf8\xe8\x05\x59\x85\xcb\xdc\x5b\xce\x4a\xb0\x4c\x0b\x94\x0e\xc1\x61\xa9\x95\x61\x93\x38\x57\x1b\xa5\xd1\x38\x81\xf6\xde\x5a\xe9\xae\xd9\xea\x0b\x16\x0f\x1f\xb7\xd6\x34\xd7\x3a\x23\xe4\x72\x54\xad\x4f\xd3\x0c\x26\x47\xc6\x69\xbf\x78\xe3\x84\x0b\x1b\x6d\xf9\x3c\x6a\x16\x52\xf3\x5b\xcc\x1c\x3d\x40\x63\x94\xa9\xd6\xe0\x68\x33\x23\x74\xe0\x6c\x06\xaf\xc3\x73\x5a\xd4\x6a\x62\x10\xa1\x44\x2e\x58\x9c\x0b\xdf\x12\xb5\xcc\x17\x0e\x56\x02\xef\xbe\xeb\xa3\x31\x53\x9c\xb6\x10\x72\xc5\x0a\xc1\x6f\xe8\xdb\x63\x1a\xe1\xe8\x98\x28\x66\xf0\x21\x3c\x03\xb5\x80\xb3\x13\x28\xbd\x75\x30\x47\x60\x92\xa6\x38\x5c\xa2\x49\xc6\x82\xcf\xe0\xd9\xe9\xf1\xe9\xf9\x87\xab\xab\xf4\xa8\xa4\xf9\x0d\xa5\x4e\x50\xf4\x1d\x6d\x3d\x83\xe3\xc9\xf3\x17\x3f\xfd\x78\xf4\x12\xbf\x3f\x7a\x99\xc6\x69\xc4\x79\x3b\x83\xbd\xe7\x47\x47\x7b\x0f\x04\x29\x22\xdd\xac\xd7\x21\x80\xe0\xb4\x44\x35\xb6\x1a\x33\xb1\x10\x59\x45\x58\x9c\x78\x48\x7e\x6b\x62\xc8\x22\x07\x66\x81\xa5\xe8\x40\x74\x78\xb2\x1d\xf8\x3e\x42\x7a\x02\xbb\x61\xe9\x01\x5c\x90\xfb\x92\xc9\x31\xc5\x9b\xb3\x79\x81\x01\x44\xc1\x64\x44\x09\x1b\x94\x4e\x81\xcb\x85\x05\x95\x65\xde\x18\x94\x59\x64\xdb\xe5\xcd\xbe\x91\x0e\x9a\x5f\xf6\x20\xdd\x29\x3c\xbd\xd0\x05\x7f\x10\xb6\x97\xe2\x23\xad\x2a\x38\x09\x9c\x30\xa2\x81\x05\x11\x19\x51\x6a\x46\x51\xc9\x7c\xc1\xcc\x36\xe0\x01\x98\xf7\xc4\xd1\x0b\xa8\xab\x18\xc6\x79\xac\x09\xac\xb8\x6c\xb4\x00\xce\xb4\xe2\x71\x0f\x75\x98\x9f\xb2\x85\x82\x2e\x29\x30\x32\xc4\x58\x06\x49\x38\x26\x39\x33\x3c\xda\x8c\x85\x24\x77\xca\x2a\x0c\x6c\xae\xbc\x6b\x31\x1d\xdc\x88\xba\xd9\x76\xa2\x6d\x35\x28\xe7\xad\xcc\x6d\x69\xbc\x1f\x7c\xd8\xf5\xcd\xbb\x77\x97\xc9\x2a\x6a\xb6\xd6\x74\x10\x4e\xad\x91\x44\x70\x57\xce\x2d\x5c\x8f\x0a\xbb\xc9\xb0\xde\x20\xa4\x72\x74\xf0\x5b\xa8\x21\xb1\x84\x34\x1a\x98\x75\x2a\xf7\x4a\xf2\xc9\x52\xb1\x49\xe4\xea\xe7\x58\x60\x7e\x49\xd5\xe6\x60\xbb\x78\x05\xc6\x83\xeb\x1d\xa7\xdf\x3b\x51\x88\x10\xd6\xe0\xd6\x8a\x36\xe2\x30\x5f\x47\x2a\x18\x2f\x85\x04\x94\x5c\x2b\x12\xf2\x68\x83\xeb\x55\x7c\x7e\x8d\x66\x25\xb2\x20\x83\x15\x1a\x1b\x97\xda\xdb\x1b\x69\xe6\xf2\x48\xef\x34\x8f\x85\xb4\x62\x7a\x89\x6e\x36\xea\x21\xfc\x0a\xa9\x66\xbb\xb8\x59\x65\x5e\x4b\xd8\x6e\x16\x0f\x9f\x20\xbc\xe8\xef\x19\x15\xb8\xca\x70\x3f\x5d\x8e\x47\x9b\xd4\xe4\x3e\x6b\xea\xd4\x98\x1a\xc2\x27\x37\xa5\x44\x17\x72\xd3\x5f\xaa\xd2\xdc\x8a\xfe\xde\x0f\x14\x87\xb6\xa2\x3a\xe8\x2e\xce\x1b\xc3\x17\x47\xcf\x1e\x36\x4c\x54\xc0\x7b\xc9\x56\x54\x87\x82\x56\x6a\xb9\x65\x39\x96\x6d\x54\xb9\x73\xba\x1e\xf3\x65\xc9\xcc\xba\xf6\x28\x5d\xd2\xa0\x63\xcb\xf6\xac\x66\x88\x7e\xf2\xbf\x87\xcb\xff\x16\x93\x49\x9f\x53\x4e\x3d\xbf\x1a\xd6\xca\xf6\xab\xf4\x1a\x9d\x05\xea\x20\x9e\x15\x37\x61\xd7\x9b\x54\x1e\xa8\x1c\x50\x8d\x8f\xa9\x37\x40\x6e\xd8\xa0\xa6\x96\x19\x46\xa5\x8f\xd2\xa5\x01\x24\x68\x83\xb9\xe2\xeb\x0d\x23\x92\x4c\x66\x64\xda\x1c\x40\x3a\x87\x97\x6e\xed\x8d\xac\xb0\x36\x9d\xdf\x18\x5c\x50\xe9\xd8\x9f\xb6\xce\x73\xd3\xad\x83\xcd\xc1\x53\x04\x7b\xa7\x90\x05\x7a\x3e\x2b\x60\x5e\x7f\x79\xb8\xa4\x28\x06\x22\xe5\xf5\x3f\x86\x15\xaf\x77\xe3\x84\xd4\x64\x44\x66\x1f\xab\xb2\xce\x1b\x19\xba\x92\x64\xda\xe6\xca\x85\xca\x90\x66\x4e\xe0\x0f\xe1\x72\x6a\xbe\x34\x5c\xb5\xe3\xc3\x58\x34\xaa\xdf\x20\x9a\xb6\x26\x71\xa9\x1c\xb5\x23\xea\x0f\x0b\xa3\xca\x68\xf5\x2a\xcb\x50\xbb\x58\x93\xd0\x1c\xc2\x82\x15\x45\x68\x7b\x73\x96\xfd\x19\xe8\x7e\x7b\x7d\xf1\xfb\xa4\x8f\xf0\xd8\x59\xf6\x13\x84\x81\xe4\xe8\xb8\x71\x5a\x41\xaa\xab\x5a\xf2\xa5\x69\xae\xd2\x97\x0d\xfb\x63\xb8\xad\x0f\xf7\xd5\x2d\xc5\x94\x56\xcf\xd1\xdb\xd6\x43\x02\x25\xbb\x28\x20\x26\x24\x1d\xbb\xcc\xfd\x8c\xac\x18\xe9\x49\x48\xf2\xda\x0e\x9e\x4e\x83\x1f\xb1\x25\x0f\x1d\x9c\xce\x64\x68\xf1\x41\x57\x64\x18\x48\x0c\xe4\x3d\x0a\x8a\x8e\x1f\xce\xad\x77\x04\x35\x57\xaa\x40\x26\x1f\x14\xf9\xd6\x2b\x61\xdf\x40\xe7\xc4\xf1\x75\xfa\xc1\x80\xe1\x19\x1d\xa0\x0d\x1d\x3e\x63\x63\xa0\x03\xf0\xeb\xd6\xae\xfd\xf5\xef\x81\x0a\x18\xe1\x1e\x7c\x4e\x0a\x26\x5d\x54\x5a\xed\x4d\xc3\x7a\x64\xaa\x29\x5e\x8f\xa5\x60\x60\x88\xc3\x1d\x25\x1b\x65\x1a\x91\x03\xa2\xd3\x9d\x29\xd3\xe0\x7e\xcb\xeb\x49\x99\xb0\xd3\xd3\x9c\x79\x76\xe2\x28\xc0\xd9\x81\xa0\xc1\x53\xcb\xd7\x23\xe6\xdf\x46\xcb\xd4\xde\xb1\x25\xbd\x1e\x0e\x4b\xe7\x44\x58\x82\xbe\x86\xeb\xca\x18\xbc\x0d\xab\x5f\xe1\x89\xca\xfa\xe8\x48\x6b\xee\xa7\xeb\x30\x27\xb9\x2b\x8b\xa7\xa1\x24\xc1\x81\x2e\xac\x2e\x2f\xcd\x58\xcd\xcc\xe4\x76\xf3\x8f\xd0\x40\x73\x33\x02\x57\xb8\xe1\x27\xbc\xd1\x87\x7f\x17\x5a\xf5\xb3\x97\xa0\x56\x85\xfb\xbf\x14\x0e\x07\x2f\x30\xb0\x53\xe4\x1a\x22\x7b\x28\xec\x50\x73\x71\xde\xf3\x17\x1a\x71\xb1\xc1\x93\x90\xd4\x29\x42\x2c\x4e\xe8\x95\xf9\x2f\xda\x17\x18\x65\xc2\x14\x00\x00")
 
 func swaggerSwaggerYamlBytes() ([]byte, error) {
 	return bindataRead(
@@ -104,7 +104,7 @@ func swaggerSwaggerYaml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "swagger/swagger.yaml", size: 5016, mode: os.FileMode(420), modTime: time.Unix(1507300625, 0)}
+	info := bindataFileInfo{name: "swagger/swagger.yaml", size: 5314, mode: os.FileMode(420), modTime: time.Unix(1792277815, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
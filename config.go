@@ -24,6 +24,8 @@ const (
 	HTTPPortConfig  = "http.port"
 	AdminPortConfig = "admin.port"
 
+	ShutdownGracePeriodConfig = "shutdown.grace_period"
+
 	GCProjectIDConfig                 = "gcloud.project_id"
 	GCDatastoreCredentialsConfig      = "gcloud.datastore.credentials"
 	GCEmulatorBigtableConfig          = "gcloud.emulator.bigtable"
@@ -35,6 +37,7 @@ const (
 func AddStandardServerOptions(cmd *cobra.Command, port, adminPort int) {
 	AddHTTPOptions(cmd, port)
 	AddAdminOptions(cmd, adminPort)
+	AddShutdownOptions(cmd)
 	AddAuthConfigOptions(cmd)
 	AddTracingConfigOptions(cmd)
 }
@@ -95,6 +98,12 @@ func AddAdminOptions(cmd *cobra.Command, adminPort int) {
 	viper.SetDefault(AdminPortConfig, fmt.Sprintf("%d", adminPort))
 }
 
+func AddShutdownOptions(cmd *cobra.Command) {
+	cmd.PersistentFlags().Duration("shutdown-grace-period", DefaultGracePeriod, "Time to keep serving in-flight requests after the service starts draining")
+	viper.BindPFlag(ShutdownGracePeriodConfig, cmd.PersistentFlags().Lookup("shutdown-grace-period"))
+	viper.SetDefault(ShutdownGracePeriodConfig, DefaultGracePeriod)
+}
+
 func AddGCloudOptions(cmd *cobra.Command) {
 	cmd.PersistentFlags().String("gcloud-project-id", "", "Google Cloud project/dataset id")
 	viper.BindPFlag(GCProjectIDConfig, cmd.PersistentFlags().Lookup("gcloud-project-id"))
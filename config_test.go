@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -93,6 +94,23 @@ var _ = Describe("Config", func() {
 		})
 	}
 
+	TestShutdownFlags := func() {
+		It("should have the default grace period", func() {
+			Ω(viper.GetDuration(ShutdownGracePeriodConfig)).Should(Equal(DefaultGracePeriod))
+		})
+
+		It("should allow setting the grace period via env var", func() {
+			setenv("SHUTDOWN_GRACE_PERIOD", "17s")
+			Ω(viper.GetDuration(ShutdownGracePeriodConfig)).Should(Equal(17 * time.Second))
+		})
+
+		It("should allow setting the grace period via command line", func() {
+			err := cmd.ParseFlags([]string{"--shutdown-grace-period", "17s"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(viper.GetDuration(ShutdownGracePeriodConfig)).Should(Equal(17 * time.Second))
+		})
+	}
+
 	TestTracingFlags := func() {
 		It("should have tracing disabled by default", func() {
 			Ω(viper.GetBool(TracingEnabledConfig)).Should(BeFalse())
@@ -350,6 +368,16 @@ var _ = Describe("Config", func() {
 
 	})
 
+	Context("with shutdown flags", func() {
+
+		BeforeEach(func() {
+			AddShutdownOptions(cmd)
+		})
+
+		TestShutdownFlags()
+
+	})
+
 	Context("with logging flags", func() {
 
 		BeforeEach(func() {
@@ -370,6 +398,7 @@ var _ = Describe("Config", func() {
 		})
 
 		TestHTTPFlags()
+		TestShutdownFlags()
 		TestAuthFlags()
 		TestTracingFlags()
 	})
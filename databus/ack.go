@@ -0,0 +1,141 @@
+package databus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrAcknowledged is returned when acknowledging a message more than
+	// once.
+	ErrAcknowledged = errors.New("message has already been acknowledged")
+	// ErrNacked is the error a consumer's failure policy is given for a
+	// message that was negatively acknowledged.
+	ErrNacked = errors.New("message was negatively acknowledged")
+	// ErrPartitionBlocked is returned when a message is negatively
+	// acknowledged by a consumer without a failure policy, since no message
+	// after it in its partition can be marked.
+	ErrPartitionBlocked = errors.New("partition is blocked by a negatively acknowledged message")
+)
+
+// AckConsumer is implemented by consumers that leave marking each message as
+// processed to the caller, for at-least-once processing: a message is only
+// marked once it, and every message before it in its partition, has been
+// acknowledged. The consumers returned by NewDatabusConsumer,
+// NewSaramaClusterDatabusConsumer and NewMemoryDatabusConsumer all implement
+// it. A consumer shouldn't be used with both Consume and ConsumeWithAck, since
+// Consume marks every message before the one it returns.
+type AckConsumer interface {
+	// ConsumeWithAck reads a message from the databus and applies it to the
+	// object at the pointer provided, as Consume does, but doesn't mark it
+	// as processed until the Acknowledgement returned is acknowledged.
+	ConsumeWithAck(context.Context, interface{}) (*Acknowledgement, error)
+}
+
+// Acknowledgement acknowledges a message received by ConsumeWithAck once it
+// has been processed. Messages may be acknowledged in any order.
+type Acknowledgement struct {
+	// Message is the message received.
+	Message *ReceivedMessage
+
+	once    sync.Once
+	source  MessageSource
+	options *consumerOptions
+}
+
+// Ack acknowledges that the message has been processed. The offset of its
+// partition is marked up to the last message below which every message has
+// been acknowledged.
+func (a *Acknowledgement) Ack() error {
+	err := errors.WithStack(ErrAcknowledged)
+	a.once.Do(func() {
+		err = a.ack()
+	})
+	return err
+}
+
+// Nack reports that the message couldn't be processed. If the consumer has a
+// failure policy, the message is passed to it, with ErrNacked, and counts as
+// acknowledged once the policy has dealt with it. Otherwise, neither it nor
+// any message after it in its partition is marked, so they are all delivered
+// again once the partition is assigned to a consumer anew: Nack returns
+// ErrPartitionBlocked, which is reported as a consumer error, and the
+// consumer is reported unhealthy until its group rebalances the partition
+// away from it.
+func (a *Acknowledgement) Nack() error {
+	err := errors.WithStack(ErrAcknowledged)
+	a.once.Do(func() {
+		a.Message.metrics.failed(handlerError)
+		policy := a.options.failurePolicy
+		if policy == nil {
+			a.options.acks.block(a.Message)
+			err = errors.Wrapf(ErrPartitionBlocked, "offset %d of partition %d of topic %s", a.Message.Offset, a.Message.Partition, a.Message.Topic())
+			a.options.consumerError(err)
+			return
+		}
+		ctx := WithHeaders(context.Background(), MessageHeaders(a.Message))
+		if perr := policy.Failed(ctx, a.Message, errors.WithStack(ErrNacked)); perr != nil {
+			err = errors.Wrap(perr, "failure policy failed after nack")
+			return
+		}
+		err = a.ack()
+	})
+	return err
+}
+
+func (a *Acknowledgement) ack() error {
+	if mark := a.options.acks.done(a.Message); mark != nil {
+		if err := a.source.MarkOffset(mark); err != nil {
+			return errors.Wrap(err, "failed to mark offset")
+		}
+	}
+	return nil
+}
+
+// consumeWithAck implements ConsumeWithAck for a consumer receiving from
+// source.
+func consumeWithAck(ctx context.Context, source MessageSource, o *consumerOptions, v interface{}) (*Acknowledgement, error) {
+	keyField, valueField, err := validateType(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for {
+		msg, err := source.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		o.acks.add(msg)
+		ack := &Acknowledgement{
+			Message: msg,
+			source:  source,
+			options: o,
+		}
+		err = msg.decode(v, keyField, valueField)
+		if err == nil {
+			return ack, nil
+		}
+		err = errors.Wrap(err, "failed to decode message")
+		if o.failurePolicy == nil {
+			return nil, err
+		}
+		// Let the failure policy deal with the message and move on
+		if perr := o.failurePolicy.Failed(ctx, msg, err); perr != nil {
+			return nil, errors.Wrapf(perr, "failure policy failed after %s", err)
+		}
+		if err := ack.Ack(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ConsumeWithAck receives a message from Kafka to be acknowledged.
+func (c *saramaClusterDatabusConsumer) ConsumeWithAck(ctx context.Context, v interface{}) (*Acknowledgement, error) {
+	return consumeWithAck(ctx, c, c.consumerOptions, v)
+}
+
+// ConsumeWithAck receives a message from the broker to be acknowledged.
+func (c *memoryDatabusConsumer) ConsumeWithAck(ctx context.Context, v interface{}) (*Acknowledgement, error) {
+	return consumeWithAck(ctx, c, c.consumerOptions, v)
+}
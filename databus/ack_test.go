@@ -0,0 +1,156 @@
+package databus_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/healthcheck"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Acknowledgement", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		broker   *MemoryBroker
+		factory  MessageFactory
+		producer DatabusProducer
+		topic    string
+	)
+
+	newConsumer := func(opts ...ConsumerOption) AckConsumer {
+		c, err := NewMemoryDatabusConsumer(broker, factory, "group", opts...)
+		Ω(err).ShouldNot(HaveOccurred())
+		return c.(AckConsumer)
+	}
+
+	consume := func(consumer AckConsumer, n int) []*Acknowledgement {
+		acks := make([]*Acknowledgement, n)
+		for i := range acks {
+			var msg memoryTestMessage
+			ack, err := consumer.ConsumeWithAck(ctx, &msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(msg.Value.TotallyCool).Should(Equal(fmt.Sprint(i)))
+			acks[i] = ack
+		}
+		return acks
+	}
+
+	committed := func() int64 {
+		return broker.Offsets("group", topic)[0]
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		topic = test.RandString(8)
+		var err error
+		factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		broker = NewMemoryBroker(1)
+		producer = NewMemoryDatabusProducer(broker, factory)
+		for i := 0; i < 4; i++ {
+			Ω(producer.Send(KeyTest{SomeString: "a", AnInt: i}, ValTest{TotallyCool: fmt.Sprint(i)})).Should(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should only mark messages once they have been acknowledged", func() {
+		acks := consume(newConsumer(), 2)
+		Ω(committed()).Should(BeZero())
+		Ω(acks[0].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 1))
+		Ω(acks[1].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 2))
+	})
+
+	It("should mark up to the lowest contiguous acknowledged offset", func() {
+		acks := consume(newConsumer(), 4)
+		Ω(acks[1].Ack()).Should(Succeed())
+		Ω(acks[3].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeZero())
+		Ω(acks[0].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 2))
+		Ω(acks[2].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 4))
+	})
+
+	It("should only acknowledge a message once", func() {
+		acks := consume(newConsumer(), 1)
+		Ω(acks[0].Ack()).Should(Succeed())
+		Ω(errors.Cause(acks[0].Ack())).Should(Equal(ErrAcknowledged))
+		Ω(errors.Cause(acks[0].Nack())).Should(Equal(ErrAcknowledged))
+	})
+
+	It("should not mark past a nacked message without a failure policy", func() {
+		acks := consume(newConsumer(), 3)
+		Ω(acks[0].Ack()).Should(Succeed())
+		Ω(errors.Cause(acks[1].Nack())).Should(Equal(ErrPartitionBlocked))
+		Ω(acks[2].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 1))
+	})
+
+	It("should report a partition blocked by a nacked message", func() {
+		health := healthcheck.NewStatusUpdater()
+		errs := make(chan error, 10)
+		consumer := newConsumer(WithConsumerHealth(health), OnConsumerError(func(err error) { errs <- err }))
+		acks := consume(consumer, 2)
+		Ω(acks[0].Nack()).Should(HaveOccurred())
+		Ω(errors.Cause(<-errs)).Should(Equal(ErrPartitionBlocked))
+		Ω(errors.Cause(health.Check())).Should(Equal(ErrPartitionBlocked))
+
+		// Receiving more messages doesn't unblock it
+		var msg memoryTestMessage
+		ack, err := consumer.ConsumeWithAck(ctx, &msg)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(acks[1].Ack()).Should(Succeed())
+		Ω(ack.Ack()).Should(Succeed())
+		Ω(errors.Cause(health.Check())).Should(Equal(ErrPartitionBlocked))
+		Ω(committed()).Should(BeZero())
+	})
+
+	It("should pass nacked messages to the failure policy", func() {
+		var failed []error
+		consumer := newConsumer(WithFailurePolicy(FailurePolicyFunc(func(ctx context.Context, msg *ReceivedMessage, err error) error {
+			failed = append(failed, err)
+			return nil
+		})))
+		acks := consume(consumer, 2)
+		Ω(acks[0].Nack()).Should(Succeed())
+		Ω(acks[1].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 2))
+		Ω(failed).Should(HaveLen(1))
+		Ω(errors.Cause(failed[0])).Should(Equal(ErrNacked))
+	})
+
+	It("should report failure policies that fail", func() {
+		consumer := newConsumer(WithFailurePolicy(FailurePolicyFunc(func(ctx context.Context, msg *ReceivedMessage, err error) error {
+			return errors.New("oops")
+		})))
+		acks := consume(consumer, 1)
+		Ω(acks[0].Nack()).Should(HaveOccurred())
+		Ω(committed()).Should(BeZero())
+	})
+
+	It("should mark messages the failure policy dealt with when decoding fails", func() {
+		broker = NewMemoryBroker(1)
+		broker.Send(NewMessage(topic, []byte("nope"), []byte("nope")))
+		Ω(NewMemoryDatabusProducer(broker, factory).Send(KeyTest{}, ValTest{TotallyCool: "0"})).Should(Succeed())
+		acks := consume(newConsumer(WithFailurePolicy(SkipFailures())), 1)
+		Ω(committed()).Should(BeNumerically("==", 1))
+		Ω(acks[0].Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 2))
+	})
+})
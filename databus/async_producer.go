@@ -0,0 +1,334 @@
+package databus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// AsyncDatabusProducer sends messages in the background, batching them for
+// throughput instead of waiting for each to be acknowledged. Send queues a
+// message and only returns errors encoding it; delivery results are reported
+// to the producer's delivery callback and through the Delivery returned by
+// SendAsync. Close flushes the messages still queued and reports those that
+// couldn't be delivered as an *UndeliveredError.
+type AsyncDatabusProducer interface {
+	DatabusProducer
+	// SendAsync queues a message, returning a Delivery that completes once
+	// the message has been acknowledged or has failed.
+	SendAsync(key, value interface{}) *Delivery
+}
+
+// Acks is how many replicas must acknowledge a message before it is
+// considered delivered.
+type Acks int
+
+const (
+	// AcksLeader waits for the partition leader only.
+	AcksLeader Acks = iota
+	// AcksAll waits for every in-sync replica.
+	AcksAll
+	// AcksNone doesn't wait for any acknowledgement.
+	AcksNone
+)
+
+func (a Acks) required() sarama.RequiredAcks {
+	switch a {
+	case AcksAll:
+		return sarama.WaitForAll
+	case AcksNone:
+		return sarama.NoResponse
+	}
+	return sarama.WaitForLocal
+}
+
+// AsyncProducerOptions configures an AsyncDatabusProducer.
+type AsyncProducerOptions struct {
+	// Linger is the longest a message waits for its batch to fill before it
+	// is sent. Zero sends batches as soon as possible.
+	Linger time.Duration
+	// BatchSize is the number of messages that triggers sending a batch.
+	// Zero means no limit.
+	BatchSize int
+	// BatchBytes is the size of messages in bytes that triggers sending a
+	// batch. Zero means no limit.
+	BatchBytes int
+	// Compression is the codec batches are compressed with. If it isn't
+	// nil, it overrides Kafka.Compression, so that CompressionNone turns
+	// compression off.
+	Compression *sarama.CompressionCodec
+	// Idempotent makes retried sends write each message exactly once. It
+	// requires Kafka 0.11 or later and waits for every in-sync replica.
+	Idempotent bool
+	// Acks is how many replicas must acknowledge a message. Defaults to
+	// AcksLeader.
+	Acks Acks
+	// Version is the version of Kafka the brokers run. Defaults to 0.11,
+	// the oldest version supporting record headers and idempotence. It
+	// overrides Kafka.Version if set.
+	Version sarama.KafkaVersion
+	// Kafka configures the connection to Kafka.
+	Kafka KafkaOptions
+	// OnDelivery is called with each message once it has been acknowledged
+	// or has failed. It is called from a single goroutine and should not
+	// block.
+	OnDelivery func(*Delivery)
+}
+
+// Config returns the sarama configuration for the options.
+func (o AsyncProducerOptions) Config() *sarama.Config {
+	config := o.Kafka.Config()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Flush.Frequency = o.Linger
+	config.Producer.Flush.Messages = o.BatchSize
+	config.Producer.Flush.Bytes = o.BatchBytes
+	if o.Compression != nil {
+		config.Producer.Compression = *o.Compression
+	}
+	config.Producer.RequiredAcks = o.Acks.required()
+	if o.Idempotent {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+	}
+	if o.Version != (sarama.KafkaVersion{}) {
+		config.Version = o.Version
+	}
+	return config
+}
+
+// NewAsyncDatabusProducer returns an AsyncDatabusProducer, which sends
+// Avro-encoded messages to a Kafka topic in batches.
+func NewAsyncDatabusProducer(brokers []string, schemaRegistry, topic, keySubject, valueSubject string, opts AsyncProducerOptions) (AsyncDatabusProducer, error) {
+	schemaRegistryClient, err := SharedSchemaCache(schemaRegistry)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get schema registry client")
+	}
+
+	messageFactory, err := NewMessageFactory(topic, keySubject, valueSubject, schemaRegistryClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create message factory")
+	}
+
+	producer, err := sarama.NewAsyncProducer(brokers, opts.Config())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create sarama producer")
+	}
+	return NewSaramaAsyncDatabusProducer(producer, messageFactory, opts.OnDelivery), nil
+}
+
+// NewSaramaAsyncDatabusProducer returns an AsyncDatabusProducer using an
+// existing AsyncProducer, which must return both successes and errors. The
+// delivery callback may be nil.
+func NewSaramaAsyncDatabusProducer(producer sarama.AsyncProducer, factory MessageFactory, onDelivery func(*Delivery)) AsyncDatabusProducer {
+	p := &saramaAsyncDatabusProducer{
+		producer:   producer,
+		factory:    factory,
+		onDelivery: onDelivery,
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go p.deliver()
+	return p
+}
+
+type saramaAsyncDatabusProducer struct {
+	mu          sync.RWMutex
+	producer    sarama.AsyncProducer
+	factory     MessageFactory
+	onDelivery  func(*Delivery)
+	closed      bool
+	closing     chan struct{}
+	done        chan struct{}
+	undelivered []*Delivery
+}
+
+func (p *saramaAsyncDatabusProducer) Send(key, value interface{}) error {
+	message, err := p.factory.Message(key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+	return p.queue(newDelivery(message))
+}
+
+// SendContext queues a message with record headers propagating the request
+// ID, trace and tenant identity of the context provided.
+func (p *saramaAsyncDatabusProducer) SendContext(ctx context.Context, key, value interface{}) error {
+	message, m, err := encodeMessage(ctx, p.factory, key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+	d := newDelivery(message)
+	d.metrics = m
+	if err := p.queue(d); err != nil {
+		m.sent(err)
+		return err
+	}
+	return nil
+}
+
+// SendAsync queues a message. A message that can't be encoded, or is sent
+// after the producer is closed, fails immediately without being reported to
+// the delivery callback.
+func (p *saramaAsyncDatabusProducer) SendAsync(key, value interface{}) *Delivery {
+	message, err := p.factory.Message(key, value)
+	if err != nil {
+		d := newDelivery(nil)
+		d.complete(errors.Wrap(err, "failed to get message from factory"))
+		return d
+	}
+	d := newDelivery(message)
+	if err := p.queue(d); err != nil {
+		d.complete(err)
+	}
+	return d
+}
+
+// SendMessage sends an encoded message, e.g. to a retry or dead-letter
+// topic, and waits for it to be delivered.
+func (p *saramaAsyncDatabusProducer) SendMessage(message Message) error {
+	d := newDelivery(message)
+	if err := p.queue(d); err != nil {
+		return err
+	}
+	return d.Err()
+}
+
+// queue hands a message to sarama, unless the producer is closed.
+func (p *saramaAsyncDatabusProducer) queue(d *Delivery) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.WithStack(ErrProducerClosed)
+	}
+	p.producer.Input() <- &sarama.ProducerMessage{
+		Topic:    d.Message.Topic(),
+		Key:      sarama.ByteEncoder(d.Message.Key()),
+		Value:    sarama.ByteEncoder(d.Message.Value()),
+		Headers:  recordHeaders(MessageHeaders(d.Message)),
+		Metadata: d,
+	}
+	return nil
+}
+
+// deliver completes deliveries as sarama reports them, until both of its
+// channels are closed.
+func (p *saramaAsyncDatabusProducer) deliver() {
+	defer close(p.done)
+	successes, errs := p.producer.Successes(), p.producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			d := msg.Metadata.(*Delivery)
+			d.Partition, d.Offset = msg.Partition, msg.Offset
+			p.complete(d, nil)
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			d := perr.Msg.Metadata.(*Delivery)
+			select {
+			case <-p.closing:
+				p.undelivered = append(p.undelivered, d)
+			default:
+			}
+			p.complete(d, errors.Wrap(perr.Err, "failed to deliver message"))
+		}
+	}
+}
+
+func (p *saramaAsyncDatabusProducer) complete(d *Delivery, err error) {
+	d.complete(err)
+	if p.onDelivery != nil {
+		p.onDelivery(d)
+	}
+}
+
+// Close stops accepting messages, waits for the messages already queued to
+// be delivered, and returns an *UndeliveredError with those that failed while
+// it waited.
+func (p *saramaAsyncDatabusProducer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.closing)
+	p.mu.Unlock()
+
+	p.producer.AsyncClose()
+	<-p.done
+	if len(p.undelivered) > 0 {
+		return &UndeliveredError{p.undelivered}
+	}
+	return nil
+}
+
+// Delivery is the result of sending a message asynchronously.
+type Delivery struct {
+	// Message is the encoded message, if it could be encoded.
+	Message Message
+	// Partition and Offset are where the message was written, once it has
+	// been delivered.
+	Partition int32
+	Offset    int64
+
+	err     error
+	done    chan struct{}
+	metrics *databusMetrics
+}
+
+func newDelivery(msg Message) *Delivery {
+	return &Delivery{Message: msg, done: make(chan struct{})}
+}
+
+func (d *Delivery) complete(err error) {
+	d.metrics.sent(err)
+	d.err = err
+	close(d.done)
+}
+
+// Done returns a channel that is closed once the message has been delivered
+// or has failed.
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err waits for the message to be delivered, and returns the reason it
+// failed, if any.
+func (d *Delivery) Err() error {
+	<-d.done
+	return d.err
+}
+
+// Wait waits for the message to be delivered or the context to be cancelled,
+// and returns the reason it failed, if any.
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UndeliveredError is returned when closing an AsyncDatabusProducer with the
+// messages that failed to be delivered.
+type UndeliveredError struct {
+	Deliveries []*Delivery
+}
+
+func (e *UndeliveredError) Error() string {
+	return fmt.Sprintf("%d messages were not delivered: %s", len(e.Deliveries), e.Deliveries[0].err)
+}
@@ -0,0 +1,205 @@
+package databus_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+	pkgerrors "github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockAsyncProducer acknowledges or fails every message it is given. If it
+// is holding, it doesn't deliver anything until it is closed.
+type mockAsyncProducer struct {
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	err       error
+	holding   bool
+	offset    int64
+}
+
+func newMockAsyncProducer(err error, holding bool) *mockAsyncProducer {
+	p := &mockAsyncProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+		err:       err,
+		holding:   holding,
+	}
+	go p.run()
+	return p
+}
+
+func (p *mockAsyncProducer) run() {
+	var pending []*sarama.ProducerMessage
+	for msg := range p.input {
+		pending = append(pending, msg)
+		if !p.holding {
+			pending = p.flush(pending)
+		}
+	}
+	p.flush(pending)
+	close(p.successes)
+	close(p.errors)
+}
+
+func (p *mockAsyncProducer) flush(msgs []*sarama.ProducerMessage) []*sarama.ProducerMessage {
+	for _, msg := range msgs {
+		if p.err != nil {
+			p.errors <- &sarama.ProducerError{Msg: msg, Err: p.err}
+			continue
+		}
+		msg.Offset = p.offset
+		p.offset++
+		p.successes <- msg
+	}
+	return nil
+}
+
+func (p *mockAsyncProducer) AsyncClose() {
+	close(p.input)
+}
+
+func (p *mockAsyncProducer) Close() error {
+	p.AsyncClose()
+	return nil
+}
+
+func (p *mockAsyncProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *mockAsyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *mockAsyncProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+var _ = Describe("Async producer", func() {
+
+	var (
+		factory    MessageFactory
+		producer   AsyncDatabusProducer
+		deliveries chan *Delivery
+		topic      string
+		ctx        context.Context
+		cancel     context.CancelFunc
+	)
+
+	newProducer := func(err error, holding bool) {
+		deliveries = make(chan *Delivery, 10)
+		producer = NewSaramaAsyncDatabusProducer(newMockAsyncProducer(err, holding), factory, func(d *Delivery) {
+			deliveries <- d
+		})
+	}
+
+	BeforeEach(func() {
+		topic = test.RandString(8)
+		client := GetSchemaRegistryMockClient(map[string]string{
+			"object-key":   `"string"`,
+			"object-value": `"int"`,
+		}, map[string]int{
+			"object-key":   1,
+			"object-value": 2,
+		})
+		var err error
+		factory, err = NewMessageFactory(topic, "object-key", "object-value", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should report successful deliveries", func() {
+		newProducer(nil, false)
+		first := producer.SendAsync("a", 1)
+		second := producer.SendAsync("b", 2)
+		Ω(first.Wait(ctx)).Should(Succeed())
+		Ω(second.Wait(ctx)).Should(Succeed())
+		Ω(second.Offset).Should(BeNumerically("==", 1))
+		Ω(second.Message.Topic()).Should(Equal(topic))
+		Eventually(deliveries).Should(Receive(Equal(first)))
+		Eventually(deliveries).Should(Receive(Equal(second)))
+		Ω(producer.Close()).Should(Succeed())
+	})
+
+	It("should report failed deliveries", func() {
+		newProducer(errors.New("o no"), false)
+		d := producer.SendAsync("a", 1)
+		Ω(d.Wait(ctx)).Should(MatchError(ContainSubstring("o no")))
+		Eventually(deliveries).Should(Receive(Equal(d)))
+	})
+
+	It("should queue messages without waiting for them to be delivered", func() {
+		newProducer(nil, true)
+		Ω(producer.Send("a", 1)).Should(Succeed())
+		Consistently(deliveries).ShouldNot(Receive())
+		Ω(producer.Close()).Should(Succeed())
+		Ω(deliveries).Should(Receive())
+	})
+
+	It("should fail to send messages that can't be encoded", func() {
+		newProducer(nil, false)
+		Ω(producer.Send(1, "a")).ShouldNot(Succeed())
+		d := producer.SendAsync(1, "a")
+		Ω(d.Done()).Should(BeClosed())
+		Ω(d.Err()).Should(HaveOccurred())
+		Ω(deliveries).ShouldNot(Receive())
+	})
+
+	It("should flush when closed and report undelivered messages", func() {
+		newProducer(errors.New("o no"), true)
+		producer.SendAsync("a", 1)
+		producer.SendAsync("b", 2)
+		err := producer.Close()
+		Ω(err).Should(BeAssignableToTypeOf(&UndeliveredError{}))
+		undelivered := err.(*UndeliveredError).Deliveries
+		Ω(undelivered).Should(HaveLen(2))
+		Ω(undelivered[0].Err()).Should(MatchError(ContainSubstring("o no")))
+	})
+
+	It("should fail to send after it is closed", func() {
+		newProducer(nil, false)
+		Ω(producer.Close()).Should(Succeed())
+		Ω(pkgerrors.Cause(producer.Send("a", 1))).Should(Equal(ErrProducerClosed))
+		d := producer.SendAsync("a", 1)
+		Ω(pkgerrors.Cause(d.Err())).Should(Equal(ErrProducerClosed))
+	})
+
+	It("should build a sarama config from its options", func() {
+		snappy := sarama.CompressionSnappy
+		config := AsyncProducerOptions{
+			Linger:      10 * time.Millisecond,
+			BatchSize:   100,
+			Compression: &snappy,
+			Idempotent:  true,
+		}.Config()
+		Ω(config.Producer.Flush.Frequency).Should(Equal(10 * time.Millisecond))
+		Ω(config.Producer.Flush.Messages).Should(Equal(100))
+		Ω(config.Producer.Compression).Should(Equal(sarama.CompressionSnappy))
+		Ω(config.Producer.Idempotent).Should(BeTrue())
+		Ω(config.Producer.RequiredAcks).Should(Equal(sarama.WaitForAll))
+		Ω(config.Producer.Return.Successes).Should(BeTrue())
+		Ω(config.Validate()).Should(Succeed())
+
+		none := sarama.CompressionNone
+		config = AsyncProducerOptions{Compression: &none, Kafka: KafkaOptions{Compression: sarama.CompressionGZIP}}.Config()
+		Ω(config.Producer.Compression).Should(Equal(sarama.CompressionNone))
+		config = AsyncProducerOptions{Kafka: KafkaOptions{Compression: sarama.CompressionGZIP}}.Config()
+		Ω(config.Producer.Compression).Should(Equal(sarama.CompressionGZIP))
+
+		config = AsyncProducerOptions{Acks: AcksNone}.Config()
+		Ω(config.Producer.RequiredAcks).Should(Equal(sarama.NoResponse))
+	})
+})
@@ -10,29 +10,47 @@ import (
 var (
 	magicByte = []byte{0}
 
-	// ErrAvroSerialization is thrown when a message does not include a proper Avro header
-	ErrAvroSerialization = errors.New("message did not include a proper Avro header")
+	// ErrWireFormat is returned when a message does not begin with the schema
+	// registry's wire format header.
+	ErrWireFormat = errors.New("message did not include a proper wire format header")
+
+	// ErrAvroSerialization is thrown when a message does not include a proper
+	// Avro header. It is ErrWireFormat, which every payload format shares.
+	ErrAvroSerialization = ErrWireFormat
 )
 
-// AvroSerialize prefaces a message with a proper Avro header including the
-// schema registry ID
-func AvroSerialize(msg []byte, schemaID int) []byte {
+// SerializePayload prefaces a payload in any PayloadFormat with the schema
+// registry's wire format header: a zero magic byte followed by the 4-byte ID
+// of the schema it was written with.
+func SerializePayload(payload []byte, schemaID int) []byte {
 	var b bytes.Buffer
 	buf := &b
 	buf.Write(magicByte)
 	idSlice := make([]byte, 4)
 	binary.BigEndian.PutUint32(idSlice, uint32(schemaID))
 	buf.Write(idSlice)
-	buf.Write(msg)
+	buf.Write(payload)
 	return buf.Bytes()
 }
 
-// AvroDeserialize deserializes an Avro message header and returns the schema
-// registry ID and the rest of the message
-func AvroDeserialize(msg []byte) (int, []byte, error) {
+// DeserializePayload reads the wire format header SerializePayload writes and
+// returns the schema registry ID and the payload after it.
+func DeserializePayload(msg []byte) (int, []byte, error) {
 	if len(msg) < 5 || msg[0] != magicByte[0] {
-		return 0, nil, ErrAvroSerialization
+		return 0, nil, ErrWireFormat
 	}
 	schemaID := int(binary.BigEndian.Uint32(msg[1:5]))
 	return schemaID, msg[5:], nil
 }
+
+// AvroSerialize prefaces a message with a proper Avro header including the
+// schema registry ID. It is SerializePayload.
+func AvroSerialize(msg []byte, schemaID int) []byte {
+	return SerializePayload(msg, schemaID)
+}
+
+// AvroDeserialize deserializes an Avro message header and returns the schema
+// registry ID and the rest of the message. It is DeserializePayload.
+func AvroDeserialize(msg []byte) (int, []byte, error) {
+	return DeserializePayload(msg)
+}
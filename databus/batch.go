@@ -0,0 +1,155 @@
+package databus
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidBatchSize is returned by ConsumeBatch when the maximum size
+	// of a batch isn't positive.
+	ErrInvalidBatchSize = errors.New("batch size must be positive")
+	// ErrBatchAcked is returned when acknowledging a batch more than once.
+	ErrBatchAcked = errors.New("batch has already been acknowledged")
+)
+
+// BatchConsumer is implemented by consumers that can receive messages in
+// batches, e.g. to write them to a datastore in bulk. The consumers returned
+// by NewDatabusConsumer, NewSaramaClusterDatabusConsumer and
+// NewMemoryDatabusConsumer all implement it.
+type BatchConsumer interface {
+	// ConsumeBatch receives up to max messages, waiting at most maxWait for
+	// the batch to fill, and decodes them into the slice at slicePtr, whose
+	// elements are structs, or pointers to structs, tagged as for Consume.
+	// A maxWait of zero waits until the batch is full. None of the messages
+	// are marked as processed until the batch returned is acknowledged.
+	ConsumeBatch(ctx context.Context, max int, maxWait time.Duration, slicePtr interface{}) (*Batch, error)
+}
+
+// Batch is a batch of messages received by ConsumeBatch.
+type Batch struct {
+	// Messages are the messages received, in the order they were received,
+	// including those the consumer's failure policy dealt with, which aren't
+	// decoded into the slice.
+	Messages []*ReceivedMessage
+
+	source MessageSource
+	acked  bool
+}
+
+// Len returns the number of messages received.
+func (b *Batch) Len() int {
+	return len(b.Messages)
+}
+
+// Ack marks every message in the batch as processed. It should be called once
+// all of them have been processed; if it isn't, they are delivered again the
+// next time the consumer group starts.
+func (b *Batch) Ack() error {
+	if b.acked {
+		return errors.WithStack(ErrBatchAcked)
+	}
+	b.acked = true
+	// Marking the last message of a partition marks every message before it
+	last := map[topicPartition]*ReceivedMessage{}
+	var partitions []topicPartition
+	for _, msg := range b.Messages {
+		tp := topicPartition{msg.Topic(), msg.Partition}
+		prev, ok := last[tp]
+		if !ok {
+			partitions = append(partitions, tp)
+		}
+		if !ok || msg.Offset > prev.Offset {
+			last[tp] = msg
+		}
+	}
+	for _, tp := range partitions {
+		if err := b.source.MarkOffset(last[tp]); err != nil {
+			return errors.Wrap(err, "failed to mark offset")
+		}
+	}
+	return nil
+}
+
+// consumeBatch implements ConsumeBatch for a consumer receiving from source.
+func consumeBatch(ctx context.Context, source MessageSource, o *consumerOptions, max int, maxWait time.Duration, slicePtr interface{}) (*Batch, error) {
+	if max <= 0 {
+		return nil, errors.WithStack(ErrInvalidBatchSize)
+	}
+	slice, elemType, keyField, valueField, err := validateSliceType(slicePtr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	receiveCtx := ctx
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		receiveCtx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+
+	batch := &Batch{source: source}
+	slice.Set(slice.Slice(0, 0))
+	for batch.Len() < max {
+		msg, err := source.Receive(receiveCtx)
+		if err != nil {
+			// Return what was received, or an empty batch if the wait is
+			// over, unless the consumer or context is done
+			if batch.Len() > 0 || (ctx.Err() == nil && receiveCtx.Err() != nil) {
+				break
+			}
+			return nil, err
+		}
+		batch.Messages = append(batch.Messages, msg)
+
+		elem := reflect.New(elemType)
+		if err := msg.decode(elem.Interface(), keyField, valueField); err != nil {
+			err = errors.Wrap(err, "failed to decode message")
+			if o.failurePolicy == nil {
+				return nil, err
+			}
+			// The message is marked along with the rest of the batch
+			if perr := o.failurePolicy.Failed(ctx, msg, err); perr != nil {
+				return nil, errors.Wrapf(perr, "failure policy failed after %s", err)
+			}
+			continue
+		}
+		if slice.Type().Elem().Kind() == reflect.Ptr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+	return batch, nil
+}
+
+// validateSliceType ensures that slicePtr points to a slice of structs, or
+// pointers to structs, that are valid message types. It returns the slice,
+// the struct type and the indices of its key and value fields.
+func validateSliceType(slicePtr interface{}) (reflect.Value, reflect.Type, int, int, error) {
+	v := reflect.ValueOf(slicePtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return reflect.Value{}, nil, 0, 0, errors.Wrap(ErrInvalidMessageType, "type is not a pointer to a slice")
+	}
+	elemType := v.Elem().Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	keyField, valueField, err := validateType(reflect.New(elemType).Interface())
+	if err != nil {
+		return reflect.Value{}, nil, 0, 0, err
+	}
+	return v.Elem(), elemType, keyField, valueField, nil
+}
+
+// ConsumeBatch receives a batch of messages from Kafka.
+func (c *saramaClusterDatabusConsumer) ConsumeBatch(ctx context.Context, max int, maxWait time.Duration, slicePtr interface{}) (*Batch, error) {
+	return consumeBatch(ctx, c, c.consumerOptions, max, maxWait, slicePtr)
+}
+
+// ConsumeBatch receives a batch of messages from the broker.
+func (c *memoryDatabusConsumer) ConsumeBatch(ctx context.Context, max int, maxWait time.Duration, slicePtr interface{}) (*Batch, error) {
+	return consumeBatch(ctx, c, c.consumerOptions, max, maxWait, slicePtr)
+}
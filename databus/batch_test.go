@@ -0,0 +1,180 @@
+package databus_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		broker   *MemoryBroker
+		factory  MessageFactory
+		producer DatabusProducer
+		consumer BatchConsumer
+		topic    string
+	)
+
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			Ω(producer.Send(KeyTest{SomeString: test.RandString(8), AnInt: i}, ValTest{TotallyCool: fmt.Sprint(i)})).Should(Succeed())
+		}
+	}
+
+	committed := func() (total int64) {
+		for _, o := range broker.Offsets("group", topic) {
+			total += o
+		}
+		return total
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		topic = test.RandString(8)
+		var err error
+		factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		broker = NewMemoryBroker(0)
+		producer = NewMemoryDatabusProducer(broker, factory)
+		c, err := NewMemoryDatabusConsumer(broker, factory, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		consumer = c.(BatchConsumer)
+	})
+
+	AfterEach(func() {
+		consumer.(DatabusConsumer).Close()
+		cancel()
+	})
+
+	It("should fill a slice with up to max messages", func() {
+		send(7)
+		var msgs []memoryTestMessage
+		batch, err := consumer.ConsumeBatch(ctx, 5, time.Second, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(batch.Len()).Should(Equal(5))
+		Ω(msgs).Should(HaveLen(5))
+		Ω(batch.Ack()).Should(Succeed())
+
+		batch, err = consumer.ConsumeBatch(ctx, 5, 50*time.Millisecond, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(msgs).Should(HaveLen(2))
+		Ω(batch.Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 7))
+	})
+
+	It("should return an empty batch once it has waited long enough", func() {
+		var msgs []*memoryTestMessage
+		batch, err := consumer.ConsumeBatch(ctx, 5, 20*time.Millisecond, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(batch.Len()).Should(BeZero())
+		Ω(msgs).Should(BeEmpty())
+	})
+
+	It("should decode into slices of pointers", func() {
+		send(2)
+		var msgs []*memoryTestMessage
+		_, err := consumer.ConsumeBatch(ctx, 2, 0, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(msgs).Should(HaveLen(2))
+		Ω(msgs[0].Value.TotallyCool).ShouldNot(BeEmpty())
+	})
+
+	It("should only mark offsets once the batch is acknowledged", func() {
+		send(3)
+		var msgs []memoryTestMessage
+		batch, err := consumer.ConsumeBatch(ctx, 3, 0, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(committed()).Should(BeZero())
+		Ω(batch.Ack()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("==", 3))
+		Ω(errors.Cause(batch.Ack())).Should(Equal(ErrBatchAcked))
+	})
+
+	It("should reject invalid sizes and types", func() {
+		var msgs []memoryTestMessage
+		_, err := consumer.ConsumeBatch(ctx, 0, 0, &msgs)
+		Ω(errors.Cause(err)).Should(Equal(ErrInvalidBatchSize))
+		_, err = consumer.ConsumeBatch(ctx, 1, 0, msgs)
+		Ω(errors.Cause(err)).Should(Equal(ErrInvalidMessageType))
+		var strs []string
+		_, err = consumer.ConsumeBatch(ctx, 1, 0, &strs)
+		Ω(errors.Cause(err)).Should(Equal(ErrInvalidMessageType))
+	})
+
+	It("should fail if the context is cancelled before anything is received", func() {
+		cancel()
+		var msgs []memoryTestMessage
+		_, err := consumer.ConsumeBatch(ctx, 1, time.Second, &msgs)
+		Ω(errors.Cause(err)).Should(Equal(ErrConsumerClosed))
+	})
+
+	It("should leave messages that can't be decoded to the failure policy", func() {
+		send(1)
+		broker.Send(NewMessage(topic, []byte("nope"), []byte("nope")))
+		send(1)
+		var failed []int64
+		c, err := NewMemoryDatabusConsumer(broker, factory, "other", WithFailurePolicy(FailurePolicyFunc(func(ctx context.Context, msg *ReceivedMessage, err error) error {
+			failed = append(failed, msg.Offset)
+			return nil
+		})))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer c.Close()
+		var msgs []memoryTestMessage
+		batch, err := c.(BatchConsumer).ConsumeBatch(ctx, 3, 0, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(batch.Len()).Should(Equal(3))
+		Ω(msgs).Should(HaveLen(2))
+		Ω(failed).Should(HaveLen(1))
+		for _, o := range broker.Offsets("other", topic) {
+			Ω(o).Should(BeZero())
+		}
+
+		_, err = consumer.ConsumeBatch(ctx, 3, 0, &msgs)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should mark the last message of each Kafka partition", func() {
+		mock := newMockClusterConsumer(4)
+		var marked []*sarama.ConsumerMessage
+		c, err := NewSaramaClusterDatabusConsumer(&markingClusterConsumer{mock, &marked}, factory)
+		Ω(err).ShouldNot(HaveOccurred())
+		message, _ := factory.Message(KeyTest{}, ValTest{})
+		for i, p := range []int32{0, 1, 0, 1} {
+			mock.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: p, Offset: int64(10 + i), Key: message.Key(), Value: message.Value()}
+		}
+		var msgs []memoryTestMessage
+		batch, err := c.(BatchConsumer).ConsumeBatch(ctx, 4, 0, &msgs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(marked).Should(BeEmpty())
+		Ω(batch.Ack()).Should(Succeed())
+		Ω(marked).Should(HaveLen(2))
+		Ω(marked[0].Partition).Should(BeNumerically("==", 0))
+		Ω(marked[0].Offset).Should(BeNumerically("==", 12))
+		Ω(marked[1].Partition).Should(BeNumerically("==", 1))
+		Ω(marked[1].Offset).Should(BeNumerically("==", 13))
+	})
+})
+
+// markingClusterConsumer records the messages marked.
+type markingClusterConsumer struct {
+	*mockClusterConsumer
+	marked *[]*sarama.ConsumerMessage
+}
+
+func (c *markingClusterConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	*c.marked = append(*c.marked, msg)
+}
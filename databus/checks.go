@@ -1,10 +1,10 @@
 package databus
 
 import (
-	"bytes"
-	"fmt"
-	"io/ioutil"
+	"crypto/tls"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -24,27 +24,163 @@ func KafkaChecker(addrs ...string) healthcheck.Checker {
 	})
 }
 
-// SchemaRegistryChecker does a GET request and verifies that the response is
-// valid.
-func SchemaRegistryChecker(addr string, timeout time.Duration) healthcheck.Checker {
+// KafkaCheckerWithOptions verifies a connection to a kafka broker, e.g. over
+// TLS or with SASL authentication, as configured by the options provided.
+func KafkaCheckerWithOptions(opts KafkaOptions, addrs ...string) healthcheck.Checker {
 	return healthcheck.CheckFunc(func() error {
-		client := http.Client{
-			Timeout: timeout,
-		}
-		r := fmt.Sprintf("http://%s/config", addr)
-		req, err := http.NewRequest("GET", r, nil)
-		if err != nil {
-			return errors.Wrapf(err, "error creating request: "+r)
-		}
-		response, err := client.Do(req)
+		client, err := sarama.NewClient(addrs, opts.Config())
 		if err != nil {
-			return errors.Wrap(err, "error while checking: "+r)
-		}
-		defer response.Body.Close()
-		data, _ := ioutil.ReadAll(response.Body)
-		if bytes.Compare(data, []byte(`{"compatibilityLevel":"BACKWARD"}`)) != 0 {
-			return errors.New("schema registry is not ok")
+			return errors.WithStack(err)
 		}
+		client.Close()
 		return nil
 	})
 }
+
+// SchemaRegistryCheckerOption configures a SchemaRegistryChecker.
+type SchemaRegistryCheckerOption func(*schemaRegistryChecker)
+
+// WithCompatibilityLevels sets the default compatibility levels the schema
+// registry may have, which is only BACKWARD unless they are set. With no
+// levels, any level is acceptable.
+func WithCompatibilityLevels(levels ...CompatibilityLevel) SchemaRegistryCheckerOption {
+	return func(c *schemaRegistryChecker) {
+		c.levels = levels
+	}
+}
+
+// WithRequiredSubjects sets subjects that must have schemas registered.
+func WithRequiredSubjects(subjects ...string) SchemaRegistryCheckerOption {
+	return func(c *schemaRegistryChecker) {
+		c.subjects = subjects
+	}
+}
+
+// WithRegistryBasicAuth authenticates requests to the schema registry with
+// the username and password provided.
+func WithRegistryBasicAuth(username, password string) SchemaRegistryCheckerOption {
+	return func(c *schemaRegistryChecker) {
+		c.auth.username, c.auth.password = username, password
+	}
+}
+
+// WithRegistryBearerToken authenticates requests to the schema registry with
+// the bearer token provided.
+func WithRegistryBearerToken(token string) SchemaRegistryCheckerOption {
+	return func(c *schemaRegistryChecker) {
+		c.auth.token = token
+	}
+}
+
+// WithRegistryTLSConfig sets the TLS configuration used to connect to a
+// schema registry at an https:// address.
+func WithRegistryTLSConfig(config *tls.Config) SchemaRegistryCheckerOption {
+	return func(c *schemaRegistryChecker) {
+		c.tls = config
+	}
+}
+
+// SchemaRegistryChecker verifies that the schema registry at addr responds
+// with its default compatibility level within the timeout, and that the level
+// is acceptable: only BACKWARD, unless WithCompatibilityLevels says
+// otherwise. The address may be a URL, e.g. for HTTPS; otherwise it is the
+// host and port of a registry served over HTTP.
+func SchemaRegistryChecker(addr string, timeout time.Duration, opts ...SchemaRegistryCheckerOption) healthcheck.Checker {
+	c := &schemaRegistryChecker{addr: addr, levels: []CompatibilityLevel{CompatibilityBackward}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: c.tls,
+	}
+	c.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &authTransport{transport, c.auth},
+	}
+	return c
+}
+
+type schemaRegistryChecker struct {
+	addr     string
+	levels   []CompatibilityLevel
+	subjects []string
+	auth     registryAuth
+	tls      *tls.Config
+	client   *http.Client
+}
+
+// Check implements healthcheck.Checker.
+func (c *schemaRegistryChecker) Check() error {
+	base := c.addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	if _, err := url.Parse(base); err != nil {
+		return errors.Wrap(err, "invalid schema registry address: "+c.addr)
+	}
+	registry := newHTTPRegistryClient(base, c.client)
+
+	level, err := registry.CompatibilityLevel("")
+	if err != nil {
+		return errors.Wrap(err, "error while checking: "+base)
+	}
+	if !c.acceptable(level) {
+		return errors.Errorf("schema registry compatibility level %s is not one of %v", level, c.levels)
+	}
+	for _, subject := range c.subjects {
+		if _, err := registry.Versions(subject); err != nil {
+			return errors.Wrapf(err, "required subject %s is not available", subject)
+		}
+	}
+	return nil
+}
+
+func (c *schemaRegistryChecker) acceptable(level CompatibilityLevel) bool {
+	if len(c.levels) == 0 {
+		return level != ""
+	}
+	for _, l := range c.levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// registryAuth is the credentials with which to authenticate requests to a
+// schema registry.
+type registryAuth struct {
+	username, password string
+	token              string
+}
+
+// authTransport adds credentials to requests.
+type authTransport struct {
+	base http.RoundTripper
+	auth registryAuth
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch {
+	case t.auth.token != "":
+		req = cloneRequest(req)
+		req.Header.Set("Authorization", "Bearer "+t.auth.token)
+	case t.auth.username != "":
+		req = cloneRequest(req)
+		req.SetBasicAuth(t.auth.username, t.auth.password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// cloneRequest copies a request and its headers, so that a RoundTripper
+// doesn't modify the request it was given.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+	return clone
+}
@@ -0,0 +1,93 @@
+package databus_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SchemaRegistryChecker", func() {
+
+	var (
+		server *httptest.Server
+		level  string
+		auth   string
+	)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/config":
+			w.Write([]byte(`{"compatibilityLevel": "` + level + `"}`))
+		case "/subjects/val-test/versions":
+			w.Write([]byte(`[1, 2]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+		}
+	})
+
+	BeforeEach(func() {
+		level, auth = "BACKWARD", ""
+		server = httptest.NewServer(handler)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	addr := func() string {
+		return strings.TrimPrefix(server.URL, "http://")
+	}
+
+	It("should only accept the BACKWARD compatibility level by default", func() {
+		c := SchemaRegistryChecker(addr(), time.Second)
+		Ω(c.Check()).Should(Succeed())
+		level = "NONE"
+		Ω(c.Check()).Should(HaveOccurred())
+	})
+
+	It("should accept any compatibility level when no levels are provided", func() {
+		level = "NONE"
+		Ω(SchemaRegistryChecker(addr(), time.Second, WithCompatibilityLevels()).Check()).Should(Succeed())
+	})
+
+	It("should only accept the compatibility levels provided", func() {
+		c := SchemaRegistryChecker(addr(), time.Second, WithCompatibilityLevels(CompatibilityBackward, CompatibilityFull))
+		Ω(c.Check()).Should(Succeed())
+		level = "NONE"
+		Ω(c.Check()).Should(HaveOccurred())
+	})
+
+	It("should fail if the response isn't a compatibility level", func() {
+		level = `", "oops": "`
+		Ω(SchemaRegistryChecker(addr(), time.Second).Check()).Should(HaveOccurred())
+	})
+
+	It("should verify required subjects exist", func() {
+		Ω(SchemaRegistryChecker(addr(), time.Second, WithRequiredSubjects("val-test")).Check()).Should(Succeed())
+		Ω(SchemaRegistryChecker(addr(), time.Second, WithRequiredSubjects("val-test", "nothing")).Check()).Should(HaveOccurred())
+	})
+
+	It("should authenticate with basic auth or bearer tokens", func() {
+		Ω(SchemaRegistryChecker(addr(), time.Second, WithRegistryBasicAuth("user", "pass")).Check()).Should(Succeed())
+		Ω(auth).Should(Equal("Basic dXNlcjpwYXNz"))
+		Ω(SchemaRegistryChecker(addr(), time.Second, WithRegistryBearerToken("token")).Check()).Should(Succeed())
+		Ω(auth).Should(Equal("Bearer token"))
+	})
+
+	It("should connect to registries over HTTPS", func() {
+		tlsServer := httptest.NewTLSServer(handler)
+		defer tlsServer.Close()
+		Ω(SchemaRegistryChecker(tlsServer.URL, time.Second).Check()).Should(HaveOccurred())
+		c := SchemaRegistryChecker(tlsServer.URL, time.Second, WithRegistryTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+		Ω(c.Check()).Should(Succeed())
+	})
+})
@@ -0,0 +1,336 @@
+package databus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSubjectNotFound is returned by the schema registry for a subject
+	// with no schemas (error code 40401).
+	ErrSubjectNotFound = errors.New("subject not found")
+	// ErrVersionNotFound is returned by the schema registry for a version of
+	// a subject that doesn't exist (error code 40402).
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrSchemaNotFound is returned by the schema registry for a schema ID
+	// that doesn't exist (error code 40403).
+	ErrSchemaNotFound = errors.New("schema not found")
+	// ErrIncompatibleSchema is returned for a schema that isn't compatible
+	// with the schemas already registered under a subject (error code 409).
+	ErrIncompatibleSchema = errors.New("schema is incompatible with an earlier schema")
+	// ErrInvalidSchema is returned by the schema registry for a schema that
+	// can't be parsed (error code 42201).
+	ErrInvalidSchema = errors.New("schema is invalid")
+	// ErrInvalidCompatibilityLevel is returned by the schema registry for a
+	// compatibility level it doesn't know (error code 42203).
+	ErrInvalidCompatibilityLevel = errors.New("compatibility level is invalid")
+
+	registryErrors = map[int]error{
+		40401: ErrSubjectNotFound,
+		40402: ErrVersionNotFound,
+		40403: ErrSchemaNotFound,
+		409:   ErrIncompatibleSchema,
+		42201: ErrInvalidSchema,
+		42203: ErrInvalidCompatibilityLevel,
+	}
+
+	// DefaultRegistryTimeout is how long a client created by
+	// NewRegistryClient waits for each response from the schema registry,
+	// unless configured otherwise.
+	DefaultRegistryTimeout = 10 * time.Second
+)
+
+// RegistryError is an error response from the schema registry, with the
+// registry's error code. ParseRegistryError turns it into a typed error.
+type RegistryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.ErrorCode)
+}
+
+// CompatibilityLevel is the compatibility the schema registry requires of a
+// new schema for a subject with the schemas already registered under it.
+type CompatibilityLevel string
+
+const (
+	// CompatibilityNone allows any schema to be registered.
+	CompatibilityNone CompatibilityLevel = "NONE"
+	// CompatibilityBackward requires a new schema to read data written
+	// with the latest schema.
+	CompatibilityBackward CompatibilityLevel = "BACKWARD"
+	// CompatibilityBackwardTransitive requires a new schema to read data
+	// written with every earlier schema.
+	CompatibilityBackwardTransitive CompatibilityLevel = "BACKWARD_TRANSITIVE"
+	// CompatibilityForward requires the latest schema to read data written
+	// with a new schema.
+	CompatibilityForward CompatibilityLevel = "FORWARD"
+	// CompatibilityForwardTransitive requires every earlier schema to read
+	// data written with a new schema.
+	CompatibilityForwardTransitive CompatibilityLevel = "FORWARD_TRANSITIVE"
+	// CompatibilityFull requires a new schema to be both backward and
+	// forward compatible with the latest schema.
+	CompatibilityFull CompatibilityLevel = "FULL"
+	// CompatibilityFullTransitive requires a new schema to be both backward
+	// and forward compatible with every earlier schema.
+	CompatibilityFullTransitive CompatibilityLevel = "FULL_TRANSITIVE"
+)
+
+// CompatibilityClient checks the compatibility of schemas with the schemas
+// registered in a schema registry, and manages the compatibility levels of
+// subjects. The clients returned by NewRegistryClient, and the
+// MemorySchemaRegistryClient, implement it alongside schemaregistry.Client.
+type CompatibilityClient interface {
+	// CheckCompatibility reports whether a schema is compatible with the
+	// schemas registered under a subject, according to its compatibility
+	// level.
+	CheckCompatibility(subject, schema string) (bool, error)
+	// CompatibilityLevel returns the compatibility level of a subject, or
+	// the registry's default level if the subject has none of its own. The
+	// default level is returned for the empty subject.
+	CompatibilityLevel(subject string) (CompatibilityLevel, error)
+	// SetCompatibilityLevel sets the compatibility level of a subject, or
+	// the registry's default level for the empty subject.
+	SetCompatibilityLevel(subject string, level CompatibilityLevel) error
+}
+
+// ParseRegistryError returns the typed error for the error code of a
+// RegistryError returned by the clients of this package, such as
+// ErrSubjectNotFound, wrapped with the message of err. Other errors are
+// returned unchanged.
+func ParseRegistryError(err error) error {
+	if err == nil {
+		return nil
+	}
+	cause := errors.Cause(err)
+	if isRegistryError(cause) {
+		return err
+	}
+	if re, ok := cause.(*RegistryError); ok {
+		if typed, ok := registryErrors[re.ErrorCode]; ok {
+			return errors.Wrap(typed, err.Error())
+		}
+	}
+	return err
+}
+
+// isRegistryError reports whether err is one of the typed registry errors.
+func isRegistryError(err error) bool {
+	for _, typed := range registryErrors {
+		if err == typed {
+			return true
+		}
+	}
+	return false
+}
+
+// RegistryClientOption configures a client created by NewRegistryClient.
+type RegistryClientOption func(*http.Client)
+
+// WithRegistryTimeout sets how long a client waits for each response from
+// the schema registry, DefaultRegistryTimeout unless it is set. A timeout of
+// zero waits forever.
+func WithRegistryTimeout(timeout time.Duration) RegistryClientOption {
+	return func(c *http.Client) {
+		c.Timeout = timeout
+	}
+}
+
+// WithRegistryTransport sets the transport a client sends requests to the
+// schema registry with, e.g. to configure TLS or authentication.
+func WithRegistryTransport(transport http.RoundTripper) RegistryClientOption {
+	return func(c *http.Client) {
+		c.Transport = transport
+	}
+}
+
+// NewRegistryClient returns a client for the schema registry at the URL
+// provided that implements CompatibilityClient as well as
+// schemaregistry.Client. Its errors are typed according to the registry's
+// error codes: their causes are the typed errors, such as ErrSubjectNotFound,
+// or a RegistryError for other codes. Requests time out after
+// DefaultRegistryTimeout, unless WithRegistryTimeout sets another timeout.
+func NewRegistryClient(baseurl string, opts ...RegistryClientOption) (schemaregistry.Client, error) {
+	if _, err := url.Parse(baseurl); err != nil {
+		return nil, errors.Wrap(err, "invalid schema registry URL")
+	}
+	client := &http.Client{Timeout: DefaultRegistryTimeout}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return newHTTPRegistryClient(baseurl, client), nil
+}
+
+// httpRegistryClient is a schemaregistry.Client and CompatibilityClient that
+// uses the schema registry's REST API.
+type httpRegistryClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+var (
+	_ schemaregistry.Client = &httpRegistryClient{}
+	_ CompatibilityClient   = &httpRegistryClient{}
+	_ SchemaTypeClient      = &httpRegistryClient{}
+)
+
+func newHTTPRegistryClient(baseURL string, client *http.Client) *httpRegistryClient {
+	return &httpRegistryClient{strings.TrimSuffix(baseURL, "/"), client}
+}
+
+func (c *httpRegistryClient) Subjects() ([]string, error) {
+	var subjects []string
+	err := c.do(http.MethodGet, "/subjects", nil, &subjects)
+	return subjects, err
+}
+
+func (c *httpRegistryClient) Versions(subject string) ([]int, error) {
+	var versions []int
+	err := c.do(http.MethodGet, subjectPath(subject)+"/versions", nil, &versions)
+	return versions, err
+}
+
+func (c *httpRegistryClient) RegisterNewSchema(subject, schema string) (int, error) {
+	var result struct {
+		ID int `json:"id"`
+	}
+	body := map[string]string{"schema": schema}
+	if err := c.do(http.MethodPost, subjectPath(subject)+"/versions", body, &result); err != nil {
+		return 0, err
+	}
+	return result.ID, nil
+}
+
+func (c *httpRegistryClient) IsRegistered(subject, schema string) (bool, schemaregistry.Schema, error) {
+	var s schemaregistry.Schema
+	body := map[string]string{"schema": schema}
+	err := c.do(http.MethodPost, subjectPath(subject), body, &s)
+	if errors.Cause(err) == ErrSchemaNotFound {
+		return false, schemaregistry.Schema{}, nil
+	}
+	if err != nil {
+		return false, schemaregistry.Schema{}, err
+	}
+	return true, s, nil
+}
+
+func (c *httpRegistryClient) GetSchemaById(id int) (string, error) {
+	var result struct {
+		Schema string `json:"schema"`
+	}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &result); err != nil {
+		return "", err
+	}
+	return result.Schema, nil
+}
+
+// SchemaType returns the type of the schema with the ID provided. The
+// registry omits the type of Avro schemas.
+func (c *httpRegistryClient) SchemaType(id int) (string, error) {
+	var result struct {
+		SchemaType string `json:"schemaType"`
+	}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &result); err != nil {
+		return "", err
+	}
+	if result.SchemaType == "" {
+		return AvroFormat.Name(), nil
+	}
+	return result.SchemaType, nil
+}
+
+func (c *httpRegistryClient) GetSchemaBySubject(subject string, version int) (schemaregistry.Schema, error) {
+	var s schemaregistry.Schema
+	err := c.do(http.MethodGet, fmt.Sprintf("%s/versions/%d", subjectPath(subject), version), nil, &s)
+	return s, err
+}
+
+func (c *httpRegistryClient) GetLatestSchema(subject string) (schemaregistry.Schema, error) {
+	var s schemaregistry.Schema
+	err := c.do(http.MethodGet, subjectPath(subject)+"/versions/latest", nil, &s)
+	return s, err
+}
+
+func (c *httpRegistryClient) CheckCompatibility(subject, schema string) (bool, error) {
+	var result struct {
+		IsCompatible bool `json:"is_compatible"`
+	}
+	body := map[string]string{"schema": schema}
+	if err := c.do(http.MethodPost, "/compatibility"+subjectPath(subject)+"/versions/latest", body, &result); err != nil {
+		return false, err
+	}
+	return result.IsCompatible, nil
+}
+
+func (c *httpRegistryClient) CompatibilityLevel(subject string) (CompatibilityLevel, error) {
+	var result struct {
+		CompatibilityLevel CompatibilityLevel `json:"compatibilityLevel"`
+	}
+	err := c.do(http.MethodGet, configPath(subject), nil, &result)
+	if subject != "" && errors.Cause(err) == ErrSubjectNotFound {
+		return c.CompatibilityLevel("")
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.CompatibilityLevel, nil
+}
+
+func (c *httpRegistryClient) SetCompatibilityLevel(subject string, level CompatibilityLevel) error {
+	body := map[string]CompatibilityLevel{"compatibility": level}
+	return c.do(http.MethodPut, configPath(subject), body, nil)
+}
+
+// do sends a request to the schema registry, decoding the response into the
+// result provided, or into a typed error if the request failed.
+func (c *httpRegistryClient) do(method, path string, body, result interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return errors.Wrap(err, "failed to encode request")
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, &buf)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send request to schema registry")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		registryErr := &RegistryError{}
+		if err := json.NewDecoder(resp.Body).Decode(registryErr); err != nil || registryErr.ErrorCode == 0 {
+			return errors.Errorf("schema registry returned %s", resp.Status)
+		}
+		return ParseRegistryError(registryErr)
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(result), "failed to decode schema registry response")
+}
+
+func subjectPath(subject string) string {
+	return "/subjects/" + url.PathEscape(subject)
+}
+
+func configPath(subject string) string {
+	if subject == "" {
+		return "/config"
+	}
+	return "/config/" + url.PathEscape(subject)
+}
@@ -0,0 +1,292 @@
+package databus_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/goadesign/goa"
+	goalogrus "github.com/goadesign/goa/logging/logrus"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// valTestV3Schema drops a field of valTestV2Schema and adds one without a
+// default, so it can't read data written with the earlier schemas.
+var valTestV3Schema = `{
+	"type": "record",
+	"name": "valTest",
+	"fields": [{
+		"type": "long",
+		"name": "Count",
+		"default": 42
+	},{
+		"type": "string",
+		"name": "Required"
+	}]
+}`
+
+var _ = Describe("Compatibility", func() {
+
+	It("should parse the error codes of registry errors", func() {
+		Ω(errors.Cause(ParseRegistryError(&RegistryError{40401, "Subject not found."}))).Should(Equal(ErrSubjectNotFound))
+		Ω(errors.Cause(ParseRegistryError(errors.Wrap(&RegistryError{40403, "Schema not found"}, "oops")))).Should(Equal(ErrSchemaNotFound))
+		Ω(errors.Cause(ParseRegistryError(&RegistryError{409, "incompatible"}))).Should(Equal(ErrIncompatibleSchema))
+		Ω(errors.Cause(ParseRegistryError(errors.Wrap(ErrInvalidSchema, "oops")))).Should(Equal(ErrInvalidSchema))
+		unknown := &RegistryError{50001, "Error in the backend data store"}
+		Ω(ParseRegistryError(unknown)).Should(Equal(unknown))
+		other := errors.New("connection refused")
+		Ω(ParseRegistryError(other)).Should(Equal(other))
+		// Codes are only read from RegistryErrors, not from messages
+		named := errors.New("failed to look up subject users-40401 (409)")
+		Ω(ParseRegistryError(named)).Should(Equal(named))
+		Ω(ParseRegistryError(nil)).Should(BeNil())
+	})
+
+	Context("with the memory registry", func() {
+
+		var client *MemorySchemaRegistryClient
+
+		BeforeEach(func() {
+			client = NewMemorySchemaRegistryClient()
+			_, err := client.RegisterNewSchema("val-test", valTestSchema)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should require backward compatibility by default", func() {
+			Ω(client.CompatibilityLevel("val-test")).Should(Equal(CompatibilityBackward))
+			Ω(client.CheckCompatibility("val-test", valTestV2Schema)).Should(BeTrue())
+			Ω(client.CheckCompatibility("val-test", valTestV3Schema)).Should(BeFalse())
+			_, err := client.RegisterNewSchema("val-test", valTestV3Schema)
+			Ω(errors.Cause(ParseRegistryError(err))).Should(Equal(ErrIncompatibleSchema))
+		})
+
+		It("should check every version for transitive compatibility", func() {
+			_, err := client.RegisterNewSchema("val-test", valTestV2Schema)
+			Ω(err).ShouldNot(HaveOccurred())
+			// v3 reads v2, but not v1
+			v3 := `{"type": "record", "name": "valTest", "fields": [{"type": "long", "name": "Count"}]}`
+			Ω(client.CheckCompatibility("val-test", v3)).Should(BeTrue())
+			Ω(client.SetCompatibilityLevel("val-test", CompatibilityBackwardTransitive)).Should(Succeed())
+			Ω(client.CheckCompatibility("val-test", v3)).Should(BeFalse())
+		})
+
+		It("should check forward and full compatibility", func() {
+			// v1 reads data written with a required field it doesn't know
+			required := `{"type": "record", "name": "valTest", "fields": [{"type": "string", "name": "TotallyCool"}, {"type": "string", "name": "Extra"}]}`
+			Ω(client.CheckCompatibility("val-test", required)).Should(BeFalse())
+			Ω(client.SetCompatibilityLevel("val-test", CompatibilityForward)).Should(Succeed())
+			Ω(client.CheckCompatibility("val-test", required)).Should(BeTrue())
+			Ω(client.CheckCompatibility("val-test", valTestV3Schema)).Should(BeFalse())
+			Ω(client.SetCompatibilityLevel("val-test", CompatibilityFull)).Should(Succeed())
+			Ω(client.CheckCompatibility("val-test", required)).Should(BeFalse())
+			Ω(client.CheckCompatibility("val-test", valTestV2Schema)).Should(BeTrue())
+		})
+
+		It("should allow any schema with no compatibility", func() {
+			Ω(client.SetCompatibilityLevel("", CompatibilityNone)).Should(Succeed())
+			Ω(client.CompatibilityLevel("val-test")).Should(Equal(CompatibilityNone))
+			_, err := client.RegisterNewSchema("val-test", `"string"`)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should reject invalid schemas and levels", func() {
+			_, err := client.CheckCompatibility("val-test", `{"type": "nope"}`)
+			Ω(errors.Cause(ParseRegistryError(err))).Should(Equal(ErrInvalidSchema))
+			err = client.SetCompatibilityLevel("val-test", CompatibilityLevel("SOMETIMES"))
+			Ω(errors.Cause(ParseRegistryError(err))).Should(Equal(ErrInvalidCompatibilityLevel))
+			_, err = client.CheckCompatibility("nothing", valTestSchema)
+			Ω(errors.Cause(ParseRegistryError(err))).Should(Equal(ErrSubjectNotFound))
+		})
+
+		Context("behind a schema registry", func() {
+
+			var ctx context.Context
+
+			newRegistry := func(opts ...SchemaRegistryOption) CompatibilityRegistry {
+				factory := BuildSchemaRegistryFactory(ctx, func(string) (schemaregistry.Client, error) {
+					return client, nil
+				}, opts...)
+				return factory.NewSchemaRegistry("memory").(CompatibilityRegistry)
+			}
+
+			BeforeEach(func() {
+				ctx = goa.WithLogger(context.Background(), goalogrus.New(test.TestLogger()))
+			})
+
+			It("should refuse to register incompatible schemas", func() {
+				registry := newRegistry()
+				Ω(registry.CheckCompatibility("val-test", valTestV2Schema)).Should(Succeed())
+				Ω(registry.CheckCompatibility("new-subject", valTestV3Schema)).Should(Succeed())
+				err := registry.CheckCompatibility("val-test", valTestV3Schema)
+				Ω(errors.Cause(err)).Should(Equal(ErrIncompatibleSchema))
+				err = registry.Register("key-test", keyTestSchema, "val-test", valTestV3Schema)
+				Ω(errors.Cause(err)).Should(Equal(ErrIncompatibleSchema))
+			})
+
+			It("should only check compatibility in a dry run", func() {
+				registry := newRegistry(WithDryRun())
+				Ω(registry.Register("key-test", keyTestSchema, "val-test", valTestV2Schema)).Should(Succeed())
+				Ω(client.Subjects()).Should(Equal([]string{"val-test"}))
+				Ω(client.Versions("val-test")).Should(HaveLen(1))
+				err := registry.Register("key-test", keyTestSchema, "val-test", valTestV3Schema)
+				Ω(errors.Cause(err)).Should(Equal(ErrIncompatibleSchema))
+
+				Ω(registry.SetCompatibilityLevel("val-test", CompatibilityNone)).Should(Succeed())
+				Ω(client.CompatibilityLevel("val-test")).Should(Equal(CompatibilityBackward))
+			})
+
+			It("should manage the compatibility levels of subjects", func() {
+				registry := newRegistry()
+				Ω(registry.SetCompatibilityLevel("val-test", CompatibilityNone)).Should(Succeed())
+				Ω(registry.CompatibilityLevel("val-test")).Should(Equal(CompatibilityNone))
+				Ω(registry.Register("key-test", keyTestSchema, "val-test", valTestV3Schema)).Should(Succeed())
+			})
+		})
+	})
+
+	Context("with the registry's REST API", func() {
+
+		var (
+			server   *httptest.Server
+			requests []*http.Request
+			bodies   []map[string]interface{}
+			respond  func(w http.ResponseWriter, r *http.Request)
+			client   CompatibilityClient
+		)
+
+		BeforeEach(func() {
+			requests, bodies = nil, nil
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := map[string]interface{}{}
+				b, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(b, &body)
+				requests = append(requests, r)
+				bodies = append(bodies, body)
+				respond(w, r)
+			}))
+			c, err := NewRegistryClient(server.URL + "/")
+			Ω(err).ShouldNot(HaveOccurred())
+			client = c.(CompatibilityClient)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should check compatibility with the latest version", func() {
+			respond = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"is_compatible": false}`))
+			}
+			Ω(client.CheckCompatibility("a/b", valTestSchema)).Should(BeFalse())
+			Ω(requests[0].Method).Should(Equal("POST"))
+			Ω(requests[0].URL.EscapedPath()).Should(Equal("/compatibility/subjects/a%2Fb/versions/latest"))
+			Ω(bodies[0]).Should(Equal(map[string]interface{}{"schema": valTestSchema}))
+		})
+
+		It("should return typed errors", func() {
+			respond = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+			}
+			_, err := client.CheckCompatibility("val-test", valTestSchema)
+			Ω(errors.Cause(err)).Should(Equal(ErrSubjectNotFound))
+		})
+
+		It("should look up and register schemas", func() {
+			respond = func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method + " " + r.URL.Path {
+				case "GET /subjects/val-test/versions/latest":
+					w.Write([]byte(`{"subject": "val-test", "version": 2, "id": 7, "schema": "\"string\""}`))
+				case "GET /schemas/ids/7":
+					w.Write([]byte(`{"schema": "\"string\""}`))
+				case "POST /subjects/val-test/versions":
+					w.Write([]byte(`{"id": 8}`))
+				case "POST /subjects/val-test":
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error_code": 40403, "message": "Schema not found"}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+				}
+			}
+			registry := client.(schemaregistry.Client)
+			s, err := registry.GetLatestSchema("val-test")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s).Should(Equal(schemaregistry.Schema{Subject: "val-test", Version: 2, Id: 7, Schema: `"string"`}))
+			Ω(registry.GetSchemaById(7)).Should(Equal(`"string"`))
+			Ω(registry.RegisterNewSchema("val-test", valTestSchema)).Should(Equal(8))
+			Ω(bodies[2]).Should(Equal(map[string]interface{}{"schema": valTestSchema}))
+			registered, _, err := registry.IsRegistered("val-test", valTestSchema)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(registered).Should(BeFalse())
+			_, err = registry.Versions("nothing")
+			Ω(errors.Cause(err)).Should(Equal(ErrSubjectNotFound))
+		})
+
+		It("should look up the types of schemas", func() {
+			respond = func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/schemas/ids/7":
+					w.Write([]byte(`{"schema": "\"string\""}`))
+				case "/schemas/ids/8":
+					w.Write([]byte(`{"schema": "message A {}", "schemaType": "PROTOBUF"}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error_code": 40403, "message": "Schema not found"}`))
+				}
+			}
+			registry := client.(SchemaTypeClient)
+			Ω(registry.SchemaType(7)).Should(Equal("AVRO"))
+			Ω(registry.SchemaType(8)).Should(Equal("PROTOBUF"))
+			_, err := registry.SchemaType(9)
+			Ω(errors.Cause(err)).Should(Equal(ErrSchemaNotFound))
+		})
+
+		It("should fall back to the default compatibility level", func() {
+			respond = func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/config" {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+					return
+				}
+				w.Write([]byte(`{"compatibilityLevel": "FULL"}`))
+			}
+			Ω(client.CompatibilityLevel("val-test")).Should(Equal(CompatibilityFull))
+			Ω(requests).Should(HaveLen(2))
+		})
+
+		It("should set compatibility levels", func() {
+			respond = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"compatibility": "NONE"}`))
+			}
+			Ω(client.SetCompatibilityLevel("val-test", CompatibilityNone)).Should(Succeed())
+			Ω(requests[0].Method).Should(Equal("PUT"))
+			Ω(requests[0].URL.Path).Should(Equal("/config/val-test"))
+			Ω(bodies[0]).Should(Equal(map[string]interface{}{"compatibility": "NONE"}))
+		})
+
+		It("should time out waiting for the registry", func() {
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte(`["val-test"]`))
+			}))
+			defer slow.Close()
+			c, err := NewRegistryClient(slow.URL, WithRegistryTimeout(20*time.Millisecond))
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = c.Subjects()
+			Ω(err).Should(HaveOccurred())
+			c, err = NewRegistryClient(slow.URL)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Subjects()).Should(Equal([]string{"val-test"}))
+		})
+	})
+})
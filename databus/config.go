@@ -0,0 +1,153 @@
+package databus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Keys of the databus settings in viper, set by the flags AddDatabusOptions
+// adds.
+const (
+	DatabusBrokersConfig               = "databus.brokers"
+	DatabusSchemaRegistryConfig        = "databus.schema_registry"
+	DatabusClientIDConfig              = "databus.client_id"
+	DatabusKafkaVersionConfig          = "databus.kafka_version"
+	DatabusTLSEnabledConfig            = "databus.tls.enabled"
+	DatabusTLSCAFileConfig             = "databus.tls.ca_file"
+	DatabusTLSCertFileConfig           = "databus.tls.cert_file"
+	DatabusTLSKeyFileConfig            = "databus.tls.key_file"
+	DatabusTLSInsecureSkipVerifyConfig = "databus.tls.insecure_skip_verify"
+	DatabusSASLMechanismConfig         = "databus.sasl.mechanism"
+	DatabusSASLUserConfig              = "databus.sasl.user"
+	DatabusSASLPasswordConfig          = "databus.sasl.password"
+	DatabusCompressionConfig           = "databus.compression"
+	DatabusRetriesConfig               = "databus.retries"
+	DatabusInitialOffsetConfig         = "databus.initial_offset"
+)
+
+// AddDatabusOptions adds flags configuring the databus to a command, bound to
+// the Databus*Config keys in viper.
+func AddDatabusOptions(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringSlice("databus-brokers", []string{"kafka:9092"}, "Addresses of the Kafka brokers")
+	viper.BindPFlag(DatabusBrokersConfig, cmd.PersistentFlags().Lookup("databus-brokers"))
+	viper.SetDefault(DatabusBrokersConfig, []string{"kafka:9092"})
+
+	cmd.PersistentFlags().String("databus-schema-registry", "schema-registry:8081", "Address of the schema registry")
+	viper.BindPFlag(DatabusSchemaRegistryConfig, cmd.PersistentFlags().Lookup("databus-schema-registry"))
+	viper.SetDefault(DatabusSchemaRegistryConfig, "schema-registry:8081")
+
+	cmd.PersistentFlags().String("databus-client-id", "", "Client ID identifying the service to Kafka")
+	viper.BindPFlag(DatabusClientIDConfig, cmd.PersistentFlags().Lookup("databus-client-id"))
+	viper.SetDefault(DatabusClientIDConfig, "")
+
+	cmd.PersistentFlags().String("databus-kafka-version", "0.11.0.0", "Version of Kafka the brokers run")
+	viper.BindPFlag(DatabusKafkaVersionConfig, cmd.PersistentFlags().Lookup("databus-kafka-version"))
+	viper.SetDefault(DatabusKafkaVersionConfig, "0.11.0.0")
+
+	cmd.PersistentFlags().Bool("databus-tls-enabled", false, "Connect to Kafka over TLS")
+	viper.BindPFlag(DatabusTLSEnabledConfig, cmd.PersistentFlags().Lookup("databus-tls-enabled"))
+	viper.SetDefault(DatabusTLSEnabledConfig, false)
+
+	cmd.PersistentFlags().String("databus-tls-ca-file", "", "File containing the CA certificates that verify Kafka brokers")
+	viper.BindPFlag(DatabusTLSCAFileConfig, cmd.PersistentFlags().Lookup("databus-tls-ca-file"))
+	viper.SetDefault(DatabusTLSCAFileConfig, "")
+
+	cmd.PersistentFlags().String("databus-tls-cert-file", "", "File containing the client certificate presented to Kafka brokers")
+	viper.BindPFlag(DatabusTLSCertFileConfig, cmd.PersistentFlags().Lookup("databus-tls-cert-file"))
+	viper.SetDefault(DatabusTLSCertFileConfig, "")
+
+	cmd.PersistentFlags().String("databus-tls-key-file", "", "File containing the key of the client certificate")
+	viper.BindPFlag(DatabusTLSKeyFileConfig, cmd.PersistentFlags().Lookup("databus-tls-key-file"))
+	viper.SetDefault(DatabusTLSKeyFileConfig, "")
+
+	cmd.PersistentFlags().Bool("databus-tls-insecure-skip-verify", false, "Don't verify the certificates of Kafka brokers")
+	viper.BindPFlag(DatabusTLSInsecureSkipVerifyConfig, cmd.PersistentFlags().Lookup("databus-tls-insecure-skip-verify"))
+	viper.SetDefault(DatabusTLSInsecureSkipVerifyConfig, false)
+
+	cmd.PersistentFlags().String("databus-sasl-mechanism", "", "SASL mechanism with which to authenticate with Kafka: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
+	viper.BindPFlag(DatabusSASLMechanismConfig, cmd.PersistentFlags().Lookup("databus-sasl-mechanism"))
+	viper.SetDefault(DatabusSASLMechanismConfig, "")
+
+	cmd.PersistentFlags().String("databus-sasl-user", "", "User with which to authenticate with Kafka")
+	viper.BindPFlag(DatabusSASLUserConfig, cmd.PersistentFlags().Lookup("databus-sasl-user"))
+	viper.SetDefault(DatabusSASLUserConfig, "")
+
+	cmd.PersistentFlags().String("databus-sasl-password", "", "Password with which to authenticate with Kafka")
+	viper.BindPFlag(DatabusSASLPasswordConfig, cmd.PersistentFlags().Lookup("databus-sasl-password"))
+	viper.SetDefault(DatabusSASLPasswordConfig, "")
+
+	cmd.PersistentFlags().String("databus-compression", "none", "Codec messages are compressed with: none, gzip, snappy, lz4 or zstd")
+	viper.BindPFlag(DatabusCompressionConfig, cmd.PersistentFlags().Lookup("databus-compression"))
+	viper.SetDefault(DatabusCompressionConfig, "none")
+
+	cmd.PersistentFlags().Int("databus-retries", 0, "Number of times sending a message is retried, or 0 for the default")
+	viper.BindPFlag(DatabusRetriesConfig, cmd.PersistentFlags().Lookup("databus-retries"))
+	viper.SetDefault(DatabusRetriesConfig, 0)
+
+	cmd.PersistentFlags().String("databus-initial-offset", "newest", "Where a new consumer group starts consuming: newest or oldest")
+	viper.BindPFlag(DatabusInitialOffsetConfig, cmd.PersistentFlags().Lookup("databus-initial-offset"))
+	viper.SetDefault(DatabusInitialOffsetConfig, "newest")
+}
+
+// DatabusKafkaOptions returns the Kafka options configured by the flags added
+// by AddDatabusOptions.
+func DatabusKafkaOptions() (KafkaOptions, error) {
+	opts := KafkaOptions{
+		ClientID: viper.GetString(DatabusClientIDConfig),
+		Retries:  viper.GetInt(DatabusRetriesConfig),
+	}
+	var err error
+	if v := viper.GetString(DatabusKafkaVersionConfig); v != "" {
+		if opts.Version, err = sarama.ParseKafkaVersion(v); err != nil {
+			return opts, errors.Wrap(err, "invalid Kafka version")
+		}
+	}
+	if opts.Compression, err = ParseCompression(viper.GetString(DatabusCompressionConfig)); err != nil {
+		return opts, err
+	}
+	if opts.InitialOffset, err = ParseInitialOffset(viper.GetString(DatabusInitialOffsetConfig)); err != nil {
+		return opts, err
+	}
+	if opts.SASL.Mechanism, err = ParseSASLMechanism(viper.GetString(DatabusSASLMechanismConfig)); err != nil {
+		return opts, err
+	}
+	opts.SASL.User = viper.GetString(DatabusSASLUserConfig)
+	opts.SASL.Password = viper.GetString(DatabusSASLPasswordConfig)
+	if viper.GetBool(DatabusTLSEnabledConfig) {
+		if opts.TLS, err = flagsTLSConfig(); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func flagsTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: viper.GetBool(DatabusTLSInsecureSkipVerifyConfig),
+	}
+	if file := viper.GetString(DatabusTLSCAFileConfig); file != "" {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read databus CA file")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in databus CA file %s", file)
+		}
+	}
+	certFile, keyFile := viper.GetString(DatabusTLSCertFileConfig), viper.GetString(DatabusTLSKeyFileConfig)
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load databus client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
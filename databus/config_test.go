@@ -0,0 +1,98 @@
+package databus_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Databus flags", func() {
+
+	var (
+		prefix string
+		cmd    *cobra.Command
+	)
+
+	setenv := func(s, v string) {
+		varname := fmt.Sprintf("%s_%s", strings.ToUpper(prefix), s)
+		os.Setenv(varname, v)
+	}
+
+	BeforeEach(func() {
+		prefix = test.RandString(8)
+		cmd = &cobra.Command{Use: "c", Run: func(*cobra.Command, []string) {}}
+		viper.Reset()
+		viper.AutomaticEnv()
+		viper.SetEnvPrefix(prefix)
+		viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+		AddDatabusOptions(cmd)
+	})
+
+	It("should have default Kafka options", func() {
+		Ω(viper.GetStringSlice(DatabusBrokersConfig)).Should(Equal([]string{"kafka:9092"}))
+		opts, err := DatabusKafkaOptions()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(opts.Version).Should(Equal(sarama.V0_11_0_0))
+		Ω(opts.Compression).Should(Equal(sarama.CompressionNone))
+		Ω(opts.InitialOffset).Should(Equal(sarama.OffsetNewest))
+		Ω(opts.TLS).Should(BeNil())
+		Ω(opts.SASL.Mechanism).Should(BeEmpty())
+	})
+
+	It("should allow configuring Kafka via env var", func() {
+		setenv("DATABUS_CLIENT_ID", "my-service")
+		setenv("DATABUS_SASL_MECHANISM", "SCRAM-SHA-256")
+		setenv("DATABUS_SASL_USER", "user")
+		setenv("DATABUS_SASL_PASSWORD", "pass")
+		setenv("DATABUS_INITIAL_OFFSET", "oldest")
+		opts, err := DatabusKafkaOptions()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(opts.ClientID).Should(Equal("my-service"))
+		Ω(opts.SASL.Mechanism).Should(Equal(sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA256)))
+		Ω(opts.SASL.User).Should(Equal("user"))
+		Ω(opts.SASL.Password).Should(Equal("pass"))
+		Ω(opts.InitialOffset).Should(Equal(sarama.OffsetOldest))
+	})
+
+	It("should allow configuring Kafka via command line", func() {
+		err := cmd.ParseFlags([]string{
+			"--databus-brokers", "k1:9092,k2:9092",
+			"--databus-kafka-version", "2.1.0",
+			"--databus-compression", "snappy",
+			"--databus-retries", "5",
+			"--databus-tls-enabled",
+			"--databus-tls-insecure-skip-verify",
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(viper.GetStringSlice(DatabusBrokersConfig)).Should(Equal([]string{"k1:9092", "k2:9092"}))
+		opts, err := DatabusKafkaOptions()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(opts.Version).Should(Equal(sarama.V2_1_0_0))
+		Ω(opts.Compression).Should(Equal(sarama.CompressionSnappy))
+		Ω(opts.Retries).Should(Equal(5))
+		Ω(opts.TLS).ShouldNot(BeNil())
+		Ω(opts.TLS.InsecureSkipVerify).Should(BeTrue())
+	})
+
+	It("should reject invalid Kafka options", func() {
+		setenv("DATABUS_COMPRESSION", "brotli")
+		_, err := DatabusKafkaOptions()
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should fail if TLS files can't be read", func() {
+		err := cmd.ParseFlags([]string{"--databus-tls-enabled", "--databus-tls-ca-file", "/nonexistent/ca.pem"})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = DatabusKafkaOptions()
+		Ω(err).Should(HaveOccurred())
+	})
+})
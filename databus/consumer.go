@@ -3,11 +3,15 @@ package databus
 import (
 	"context"
 	"reflect"
+	"regexp"
+	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	cluster "github.com/bsm/sarama-cluster"
-	"github.com/datamountaineer/schema-registry"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/zenoss/zenkit/healthcheck"
 )
 
 const (
@@ -22,6 +26,24 @@ var (
 	ErrInvalidMessageType = errors.New("invalid message type")
 	// ErrConsumerClosed is thrown when the databus consumer is no longer open
 	ErrConsumerClosed = errors.New("consumer is closed")
+	// ErrConsumerFlapping is reported to a consumer's health check when its
+	// group rebalances too often
+	ErrConsumerFlapping = errors.New("consumer group is rebalancing too often")
+
+	// FlappingRebalances is the number of rebalances within FlappingWindow
+	// after which a consumer is reported as flapping.
+	FlappingRebalances = 5
+	// FlappingWindow is the period over which rebalances are counted.
+	FlappingWindow = time.Minute
+)
+
+const (
+	// ConsumerErrorsMetric counts the errors reported by a consumer.
+	ConsumerErrorsMetric = "databus.consumer.errors"
+	// ConsumerRebalancesMetric counts the rebalances of a consumer's group.
+	ConsumerRebalancesMetric = "databus.consumer.rebalances"
+	// ConsumerPartitionsMetric is the number of partitions a consumer owns.
+	ConsumerPartitionsMetric = "databus.consumer.partitions"
 )
 
 // DatabusConsumer is capable of receiving schema-encoded messages from a databus.
@@ -33,13 +55,238 @@ type DatabusConsumer interface {
 	Close() error
 }
 
+// ConsumerOption configures a DatabusConsumer.
+type ConsumerOption func(*consumerOptions)
+
+type consumerOptions struct {
+	failurePolicy FailurePolicy
+	onError       func(error)
+	onClaimed     PartitionHandler
+	onReleased    PartitionHandler
+	health        healthcheck.Updater
+	metrics       metrics.Registry
+	kafka         KafkaOptions
+	topicPattern  *regexp.Regexp
+	startTime     time.Time
+
+	mu         sync.Mutex
+	rebalances []time.Time
+	// erred is set when an error has been reported to the health updater
+	// since the consumer last received a message or rebalanced, and
+	// flapped while the group is reported as flapping.
+	erred   bool
+	flapped bool
+	acks    *offsetTracker
+	// marks are the offsets after the last message marked in each
+	// partition, or of the first message received if none has been.
+	marks map[topicPartition]int64
+}
+
+// PartitionHandler is called with the partitions of each topic claimed or
+// released by a consumer when its group rebalances.
+type PartitionHandler func(partitions map[string][]int32)
+
+func newConsumerOptions(opts []ConsumerOption) *consumerOptions {
+	o := &consumerOptions{acks: newOffsetTracker()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithFailurePolicy sets the policy for messages that Consume can't decode.
+// Without one, Consume returns the error and the message is left unmarked.
+func WithFailurePolicy(policy FailurePolicy) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.failurePolicy = policy
+	}
+}
+
+// OnConsumerError sets a function to call with each error reported by the
+// consumer, such as a failure to fetch messages or commit offsets.
+func OnConsumerError(f func(error)) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.onError = f
+	}
+}
+
+// OnPartitionsClaimed sets a function to call with the partitions the
+// consumer claims when its group rebalances.
+func OnPartitionsClaimed(f PartitionHandler) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.onClaimed = f
+	}
+}
+
+// OnPartitionsReleased sets a function to call with the partitions the
+// consumer releases when its group rebalances.
+func OnPartitionsReleased(f PartitionHandler) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.onReleased = f
+	}
+}
+
+// WithConsumerHealth reports the consumer's status to a health check updater.
+// Each consumer error is reported as a failure, as is rebalancing more than
+// FlappingRebalances times within FlappingWindow, or holding a partition
+// blocked by a nacked message. Any other rebalance, and the first message
+// received after an error, are reported as a success. Use a
+// threshold updater to tolerate transient errors.
+func WithConsumerHealth(u healthcheck.Updater) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.health = u
+	}
+}
+
+// WithConsumerMetrics records the consumer's errors, rebalances and
+// partitions in a metrics registry, along with the metrics of the messages it
+// receives with a context that has no registry of its own.
+func WithConsumerMetrics(registry metrics.Registry) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.metrics = registry
+	}
+}
+
+// WithKafkaOptions configures the connection to Kafka of a consumer created
+// by NewDatabusConsumer. It has no effect on consumers created from an
+// existing cluster.Consumer.
+func WithKafkaOptions(kafka KafkaOptions) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.kafka = kafka
+	}
+}
+
+// consumerError reports an error from the consumer.
+func (o *consumerOptions) consumerError(err error) {
+	if o.metrics != nil {
+		metrics.GetOrRegisterCounter(ConsumerErrorsMetric, o.metrics).Inc(1)
+	}
+	if o.health != nil {
+		o.mu.Lock()
+		o.erred = true
+		o.mu.Unlock()
+		o.health.Update(err)
+	}
+	if o.onError != nil {
+		o.onError(err)
+	}
+}
+
+// rebalanced reports a rebalance of the consumer's group.
+func (o *consumerOptions) rebalanced(claimed, released, current map[string][]int32) {
+	if o.metrics != nil {
+		metrics.GetOrRegisterCounter(ConsumerRebalancesMetric, o.metrics).Inc(1)
+		partitions := 0
+		for _, p := range current {
+			partitions += len(p)
+		}
+		metrics.GetOrRegisterGauge(ConsumerPartitionsMetric, o.metrics).Update(int64(partitions))
+	}
+	o.acks.reset(released)
+	o.mu.Lock()
+	for topic, partitions := range released {
+		for _, partition := range partitions {
+			delete(o.marks, topicPartition{topic, partition})
+		}
+	}
+	o.mu.Unlock()
+	if o.health != nil {
+		if o.flapping(time.Now()) {
+			o.health.Update(ErrConsumerFlapping)
+		} else if o.acks.blocking() {
+			o.health.Update(errors.WithStack(ErrPartitionBlocked))
+		} else {
+			o.health.Update(nil)
+		}
+	}
+	if o.onReleased != nil && len(released) > 0 {
+		o.onReleased(released)
+	}
+	if o.onClaimed != nil && len(claimed) > 0 {
+		o.onClaimed(claimed)
+	}
+}
+
+// flapping records a rebalance and reports whether there have been more than
+// FlappingRebalances within FlappingWindow.
+func (o *consumerOptions) flapping(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	recent := o.rebalances[:0]
+	for _, t := range o.rebalances {
+		if now.Sub(t) < FlappingWindow {
+			recent = append(recent, t)
+		}
+	}
+	o.rebalances = append(recent, now)
+	o.erred = false
+	o.flapped = len(o.rebalances) > FlappingRebalances
+	return o.flapped
+}
+
+// progressed reports that the consumer received a message, clearing the
+// error last reported to its health updater, if any. A flapping group, or a
+// partition blocked by a nacked message, is only cleared by a later
+// rebalance.
+func (o *consumerOptions) progressed() {
+	if o.health == nil {
+		return
+	}
+	o.mu.Lock()
+	recovered := o.erred && !o.flapped && !o.acks.blocking()
+	if recovered {
+		o.erred = false
+	}
+	o.mu.Unlock()
+	if recovered {
+		o.health.Update(nil)
+	}
+}
+
+// position records the offset of a message received from a partition, as
+// the consumer's position in it until it marks a message.
+func (o *consumerOptions) position(topic string, partition int32, offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.marks == nil {
+		o.marks = map[topicPartition]int64{}
+	}
+	tp := topicPartition{topic, partition}
+	if _, ok := o.marks[tp]; !ok {
+		o.marks[tp] = offset
+	}
+}
+
+// marked records that a message, and every message before it in its
+// partition, has been marked as processed.
+func (o *consumerOptions) marked(msg *ReceivedMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.marks == nil {
+		o.marks = map[topicPartition]int64{}
+	}
+	tp := topicPartition{msg.Topic(), msg.Partition}
+	if offset, ok := o.marks[tp]; !ok || msg.Offset+1 > offset {
+		o.marks[tp] = msg.Offset + 1
+	}
+}
+
+// markedOffset returns the offset after the last message marked in a
+// partition, if the consumer has received any from it.
+func (o *consumerOptions) markedOffset(topic string, partition int32) (int64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	offset, ok := o.marks[topicPartition{topic, partition}]
+	return offset, ok
+}
+
 // NewDatabusConsumer returns the default implementation of a DatabusConsumer,
 // which reads Avro-encoded messages from a Kafka consumer.
-func NewDatabusConsumer(brokers []string, schemaRegistry, topic, keySubject, valueSubject, groupId string) (DatabusConsumer, error) {
+func NewDatabusConsumer(brokers []string, schemaRegistry, topic, keySubject, valueSubject, groupId string, opts ...ConsumerOption) (DatabusConsumer, error) {
 	// Get our schema registry
-	schemaRegistryClient, err := schemaregistry.NewClient(schemaRegistry)
+	schemaRegistryClient, err := SharedSchemaCache(schemaRegistry)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create schema registry client")
+		return nil, errors.Wrap(err, "failed to get schema registry client")
 	}
 
 	// Get our message factory
@@ -49,30 +296,45 @@ func NewDatabusConsumer(brokers []string, schemaRegistry, topic, keySubject, val
 	}
 
 	// Get our sarama cluster consumer
-	// init (custom) config, disable errors and notifications
+	// init (custom) config, reporting errors and notifications
+	o := newConsumerOptions(opts)
 	config := cluster.NewConfig()
-	config.Consumer.Return.Errors = false
-	config.Group.Return.Notifications = false
+	config.Config = *o.kafka.Config()
+	config.Consumer.Return.Errors = true
+	config.Group.Return.Notifications = true
+	if err := o.startOffsets(brokers, &config.Config, groupId, []string{topic}); err != nil {
+		return nil, err
+	}
 
 	consumer, err := cluster.NewConsumer(brokers, groupId, []string{topic}, config)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create cluster consumer")
 	}
-	return NewSaramaClusterDatabusConsumer(consumer, messageFactory)
+	return NewSaramaClusterDatabusConsumer(consumer, messageFactory, opts...)
 }
 
 // NewSaramaClusterDatabusConsumer returns a SaramaDatabusConsumer created from
 // an existing cluster.Consumer directly, instead of creating a new one from
 // broker addresses.
-func NewSaramaClusterDatabusConsumer(consumer SaramaClusterConsumer, messageFactory MessageFactory) (DatabusConsumer, error) {
+func NewSaramaClusterDatabusConsumer(consumer SaramaClusterConsumer, messageFactory MessageFactory, opts ...ConsumerOption) (DatabusConsumer, error) {
 
 	c := &saramaClusterDatabusConsumer{
-		con:            consumer,
-		messageFactory: messageFactory,
+		con:             consumer,
+		messageFactory:  messageFactory,
+		consumerOptions: newConsumerOptions(opts),
+		done:            make(chan struct{}),
 	}
+	go c.watch()
 	return c, nil
 }
 
+// NewSaramaClusterMessageSource returns a MessageSource that receives raw
+// messages from an existing cluster.Consumer, e.g. for a retry or dead-letter
+// topic. The messages it receives can't be decoded.
+func NewSaramaClusterMessageSource(consumer SaramaClusterConsumer) MessageSource {
+	return &saramaClusterDatabusConsumer{con: consumer, consumerOptions: newConsumerOptions(nil)}
+}
+
 // SaramaClusterConsumer is implemented by sarama's cluster.Consumer, and is
 // here for the sake of tests, since sarama doesn't provide an interface.
 type SaramaClusterConsumer interface {
@@ -88,56 +350,124 @@ type SaramaClusterConsumer interface {
 type saramaClusterDatabusConsumer struct {
 	con            SaramaClusterConsumer
 	messageFactory MessageFactory
+	*consumerOptions
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// watch reports the errors and notifications of the cluster consumer until
+// the consumer is closed. They must be read for the consumer to make progress
+// when they are enabled.
+func (c *saramaClusterDatabusConsumer) watch() {
+	errs, notifications := c.con.Errors(), c.con.Notifications()
+	for errs != nil || notifications != nil {
+		select {
+		case <-c.done:
+			return
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			c.consumerError(err)
+		case n, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+			c.rebalanced(n.Claimed, n.Released, n.Current)
+		}
+	}
 }
 
 func (c *saramaClusterDatabusConsumer) Consume(ctx context.Context, v interface{}) error {
 	// Make sure what was passed in is a pointer to messageType
-	keyField, valueField, err := c.validateType(v)
+	keyField, valueField, err := validateType(v)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	// Get errors and notifications first
-	stop := false
-	for !stop {
-		select {
-		case <-ctx.Done():
-			stop = true
-		case <-c.con.Errors():
-		// TODO
-		case <-c.con.Notifications():
-		// TODO
-		default:
-			stop = true
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		err = msg.decode(v, keyField, valueField)
+		if err == nil {
+			return c.MarkOffset(msg) // mark message as processed
+		}
+		err = errors.Wrap(err, "failed to decode message")
+		if c.failurePolicy == nil {
+			return err
+		}
+		// Let the failure policy deal with the message and move on
+		if err := handleFailure(ctx, c.failurePolicy, c, msg, err); err != nil {
+			return err
 		}
 	}
+}
 
+// Receive returns the next message from Kafka without marking its offset.
+func (c *saramaClusterDatabusConsumer) Receive(ctx context.Context) (*ReceivedMessage, error) {
 	select {
 	case <-ctx.Done():
-		return errors.Wrap(ErrConsumerClosed, "context is cancelled")
+		return nil, errors.Wrap(ErrConsumerClosed, "context is cancelled")
 	case msg, more := <-c.con.Messages():
-		if more {
-			err := c.decodeMessage(msg, v, keyField, valueField)
-			if err != nil {
-				return errors.Wrap(err, "failed to decode message")
-			}
-
-			c.con.MarkOffset(msg, "") // mark message as processed
-			return nil
-		} else {
-			return errors.Wrap(ErrConsumerClosed, "messages channel closed")
+		if !more {
+			return nil, errors.Wrap(ErrConsumerClosed, "messages channel closed")
+		}
+		var lag partitionLag
+		if _, ok := c.con.(highWaterMarker); ok {
+			lag = c.lag
 		}
+		return c.receivedMessage(ctx, &SaramaMessage{msg}, msg.Partition, msg.Offset, c.messageFactory, lag), nil
 	}
 }
 
+// MarkOffset marks a message received from Kafka as processed.
+func (c *saramaClusterDatabusConsumer) MarkOffset(msg *ReceivedMessage) error {
+	c.con.MarkOffset(&sarama.ConsumerMessage{
+		Topic:     msg.Topic(),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	}, "")
+	msg.marked()
+	return nil
+}
+
+// lag returns the number of messages in a partition after the last marked,
+// from its high-water mark.
+func (c *saramaClusterDatabusConsumer) lag(topic string, partition int32) int64 {
+	hwm, ok := c.con.(highWaterMarker).HighWaterMarks()[topic][partition]
+	if !ok {
+		return 0
+	}
+	marked, ok := c.markedOffset(topic, partition)
+	if !ok {
+		return 0
+	}
+	return hwm - marked
+}
+
+// highWaterMarker is implemented by cluster.Consumer, and reports the offset
+// of the next message to be written to each partition it consumes.
+type highWaterMarker interface {
+	HighWaterMarks() map[string]map[int32]int64
+}
+
 func (c *saramaClusterDatabusConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		if c.done != nil {
+			close(c.done)
+		}
+	})
 	return c.con.Close()
 }
 
 // validateType ensures that the message type is valid. It must be a pointer to
 // a struct with fields tagged as `zenkit:"message-key"` and
 // `zenkit:"message-value"`.
-func (c *saramaClusterDatabusConsumer) validateType(message interface{}) (int, int, error) {
+func validateType(message interface{}) (int, int, error) {
 	messageType := reflect.TypeOf(message)
 	if messageType.Kind() != reflect.Ptr {
 		return 0, 0, errors.Wrap(ErrInvalidMessageType, "type is not a pointer")
@@ -172,16 +502,15 @@ func (c *saramaClusterDatabusConsumer) validateType(message interface{}) (int, i
 	return keyField, valueField, nil
 }
 
-func (c *saramaClusterDatabusConsumer) decodeMessage(rawMsg *sarama.ConsumerMessage, v interface{}, keyField, valueField int) error {
+// decodeMessage decodes a message with the factory provided into the key and
+// value fields of v, which has been checked by validateType.
+func decodeMessage(factory MessageFactory, msg Message, v interface{}, keyField, valueField int) error {
 	messageType := reflect.TypeOf(v).Elem()
 
-	// Get a Message object we can pass to MessageFactory.Decode
-	wrappedMessage := &SaramaMessage{rawMsg}
-
 	// Decode the Key and Value
 	key := reflect.New(messageType.Field(keyField).Type).Interface()
 	value := reflect.New(messageType.Field(valueField).Type).Interface()
-	err := c.messageFactory.Decode(wrappedMessage, key, value)
+	err := factory.Decode(msg, key, value)
 	if err != nil {
 		return errors.Wrap(err, "failed to decode key or value")
 	}
@@ -209,3 +538,14 @@ func (m *SaramaMessage) Key() []byte {
 func (m *SaramaMessage) Value() []byte {
 	return m.Message.Value
 }
+
+func (m *SaramaMessage) Headers() map[string][]byte {
+	if len(m.Message.Headers) == 0 {
+		return nil
+	}
+	headers := make(map[string][]byte, len(m.Message.Headers))
+	for _, h := range m.Message.Headers {
+		headers[string(h.Key)] = h.Value
+	}
+	return headers
+}
@@ -12,7 +12,9 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
 	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/healthcheck"
 	"github.com/zenoss/zenkit/test"
 )
 
@@ -109,7 +111,8 @@ var _ = Describe("Consumer", func() {
 			"key-test":     3,
 			"val-test":     4,
 		}
-		codecs map[string]*goavro.Codec
+		codecs       map[string]*goavro.Codec
+		consumerOpts []ConsumerOption
 	)
 
 	BeforeEach(func() {
@@ -120,6 +123,7 @@ var _ = Describe("Consumer", func() {
 		valueSchema = "object-value"
 		schemaRegistryClient = GetSchemaRegistryMockClient(schemas, ids)
 		err = nil
+		consumerOpts = nil
 		codecs = map[string]*goavro.Codec{}
 		for k, s := range schemas {
 			codecs[k], _ = goavro.NewCodec(s)
@@ -140,7 +144,110 @@ var _ = Describe("Consumer", func() {
 		}()
 		Eventually(done).Should(BeClosed())
 
-		databusConsumer, err = NewSaramaClusterDatabusConsumer(clusterConsumer, messageFactory)
+		databusConsumer, err = NewSaramaClusterDatabusConsumer(clusterConsumer, messageFactory, consumerOpts...)
+	})
+
+	Context("reporting errors and rebalances", func() {
+		var (
+			health   healthcheck.Updater
+			registry metrics.Registry
+			errs     chan error
+			claimed  chan map[string][]int32
+			released chan map[string][]int32
+		)
+
+		BeforeEach(func() {
+			health = healthcheck.NewStatusUpdater()
+			registry = metrics.NewRegistry()
+			errs = make(chan error, 10)
+			claimed = make(chan map[string][]int32, 10)
+			released = make(chan map[string][]int32, 10)
+			consumerOpts = []ConsumerOption{
+				OnConsumerError(func(err error) { errs <- err }),
+				OnPartitionsClaimed(func(p map[string][]int32) { claimed <- p }),
+				OnPartitionsReleased(func(p map[string][]int32) { released <- p }),
+				WithConsumerHealth(health),
+				WithConsumerMetrics(registry),
+			}
+		})
+
+		AfterEach(func() {
+			databusConsumer.Close()
+		})
+
+		It("should report consumer errors", func() {
+			clusterConsumer.errors <- errors.New("A")
+			clusterConsumer.errors <- errors.New("B")
+			Eventually(errs).Should(Receive(MatchError("A")))
+			Eventually(errs).Should(Receive(MatchError("B")))
+			Ω(health.Check()).Should(MatchError("B"))
+			Ω(metrics.GetOrRegisterCounter(ConsumerErrorsMetric, registry).Count()).Should(BeNumerically("==", 2))
+		})
+
+		It("should report rebalances", func() {
+			clusterConsumer.errors <- errors.New("A")
+			Eventually(health.Check).Should(HaveOccurred())
+			clusterConsumer.notifications <- &cluster.Notification{
+				Claimed:  map[string][]int32{topic: {0, 1}},
+				Released: map[string][]int32{topic: {2}},
+				Current:  map[string][]int32{topic: {0, 1}},
+			}
+			Eventually(claimed).Should(Receive(Equal(map[string][]int32{topic: {0, 1}})))
+			Eventually(released).Should(Receive(Equal(map[string][]int32{topic: {2}})))
+			Ω(health.Check()).ShouldNot(HaveOccurred())
+			Ω(metrics.GetOrRegisterCounter(ConsumerRebalancesMetric, registry).Count()).Should(BeNumerically("==", 1))
+			Ω(metrics.GetOrRegisterGauge(ConsumerPartitionsMetric, registry).Value()).Should(BeNumerically("==", 2))
+		})
+
+		It("should report recovering from an error once a message is received", func() {
+			clusterConsumer.errors <- errors.New("A")
+			Eventually(errs).Should(Receive(MatchError("A")))
+			Ω(health.Check()).Should(MatchError("A"))
+			msg, err := messageFactory.Message("a", 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			go func() {
+				clusterConsumer.messages <- &sarama.ConsumerMessage{Topic: topic, Key: msg.Key(), Value: msg.Value()}
+			}()
+			var v TestMessageType
+			Ω(databusConsumer.Consume(context.Background(), &v)).Should(Succeed())
+			Ω(v.TestValue).Should(Equal(1))
+			Ω(health.Check()).ShouldNot(HaveOccurred())
+		})
+
+		It("should report healthy once a blocked partition is released", func() {
+			msg, err := messageFactory.Message("a", 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			go func() {
+				clusterConsumer.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 2, Key: msg.Key(), Value: msg.Value()}
+			}()
+			var v TestMessageType
+			ack, err := databusConsumer.(AckConsumer).ConsumeWithAck(context.Background(), &v)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(errors.Cause(ack.Nack())).Should(Equal(ErrPartitionBlocked))
+			Ω(errors.Cause(health.Check())).Should(Equal(ErrPartitionBlocked))
+
+			// Keeping the partition keeps it blocked
+			clusterConsumer.notifications <- &cluster.Notification{
+				Claimed: map[string][]int32{topic: {3}},
+				Current: map[string][]int32{topic: {2, 3}},
+			}
+			Eventually(claimed).Should(Receive())
+			Ω(errors.Cause(health.Check())).Should(Equal(ErrPartitionBlocked))
+
+			clusterConsumer.notifications <- &cluster.Notification{
+				Released: map[string][]int32{topic: {2}},
+				Current:  map[string][]int32{topic: {3}},
+			}
+			Eventually(released).Should(Receive())
+			Ω(health.Check()).ShouldNot(HaveOccurred())
+		})
+
+		It("should report a flapping consumer group as unhealthy", func() {
+			for i := 0; i <= FlappingRebalances; i++ {
+				clusterConsumer.notifications <- &cluster.Notification{}
+			}
+			Eventually(health.Check).Should(Equal(ErrConsumerFlapping))
+		})
 	})
 
 	Context("with primitive keys and values", func() {
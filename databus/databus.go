@@ -14,6 +14,57 @@ Typically, you would create a producer using `NewDatabusProducer`, passing in th
 
 	err := producer.Send(key, value)
 
+To carry the request ID, X-Ray trace and tenant identity of a request across the databus, use `SendContext` instead. They are sent as Kafka record headers, which require Kafka 0.11 or later, and are restored into the context given to `Subscribe` handlers.
+
+	err := producer.SendContext(ctx, key, value)
+
+`Send` waits for each message to be acknowledged. High-volume services can use `NewAsyncDatabusProducer` instead, which batches messages in the background according to its `AsyncProducerOptions` and reports each delivery to a callback or through the `Delivery` returned by `SendAsync`. Closing it flushes the messages still queued.
+
+	snappy := sarama.CompressionSnappy
+	producer, _ := NewAsyncDatabusProducer(brokers, registry, "topic", "message-key-schema", "message-value-schema", AsyncProducerOptions{
+		Linger:      10 * time.Millisecond,
+		Compression: &snappy,
+		OnDelivery: func(d *Delivery) {
+			if err := d.Err(); err != nil {
+				log.Println(err)
+			}
+		},
+	})
+
+The connection to Kafka is configured with `KafkaOptions`, passed to `NewDatabusProducer` with `WithProducerKafkaOptions`, to `NewDatabusConsumer` with `WithKafkaOptions`, in `AsyncProducerOptions`, or to `KafkaCheckerWithOptions`. They set the client ID, Kafka version, TLS, SASL (PLAIN or SCRAM), compression, retries and where a new consumer group starts consuming. Services can read them from the flags added by `AddDatabusOptions`.
+
+	opts, err := DatabusKafkaOptions()
+	producer, err := NewDatabusProducer(viper.GetStringSlice(DatabusBrokersConfig), registry, "topic", "message-key-schema", "message-value-schema", WithProducerKafkaOptions(opts))
+
+Schemas can be derived from Go types with `SchemaFor`, which maps struct fields named by their `json` tags to Avro record fields, and registered with `RegisterTypes`.
+
+	err := RegisterTypes(DefaultSchemaRegistryFactory(ctx).NewSchemaRegistry(registry), "message-key-schema", SomeStruct{}, "message-value-schema", MoreInterestingStruct{})
+
+Before registering a schema, the `SchemaRegistry` created by `DefaultSchemaRegistryFactory` checks it is compatible with the schemas already registered under its subject, failing with `ErrIncompatibleSchema` if not. It also implements `CompatibilityRegistry`, which checks compatibility without registering and manages the compatibility levels of subjects. With `WithDryRun`, `Register` only checks compatibility, so a CI job can reject breaking schema changes before they are deployed. Clients created by `NewRegistryClient`, which `DefaultSchemaRegistryFactory` and `SharedSchemaCache` use, decode the error codes of the registry's responses into typed errors such as `ErrSubjectNotFound`, as `ParseRegistryError` does for a `RegistryError`. Their requests time out after `DefaultRegistryTimeout`, unless `WithRegistryTimeout` sets another timeout.
+
+	registry := DefaultSchemaRegistryFactory(ctx, WithDryRun()).NewSchemaRegistry(registryURL)
+	if err := registry.Register("message-key-schema", keySchema, "message-value-schema", valueSchema); errors.Cause(err) == ErrIncompatibleSchema {
+		log.Fatal(err)
+	}
+
+Instead of naming the key and value subjects of a topic, a factory created with `NewMessageFactoryWithStrategy` derives them from the topic and the record each key and value is encoded as, using the Confluent `TopicNameStrategy`, `RecordNameStrategy` or `TopicRecordNameStrategy`. With either of the latter, one topic can carry several types of event. Records are named after their Go package and type, as `SchemaFor` names them, unless they implement `RecordNamer`.
+
+	factory, _ := NewMessageFactoryWithStrategy("topic", TopicNameStrategy, RecordNameStrategy, client)
+	producer := NewSaramaDatabusProducer(syncProducer, factory)
+	err := producer.Send(key, UserCreated{ID: id}) // Subject "events.UserCreated"
+
+Keys and values can be encoded as JSON validated against a JSON Schema, or as Protocol Buffers, instead of Avro, by creating a factory with `NewMessageFactoryWithFormat` and `JSONSchemaFormat` or `ProtobufFormat`. The producers and consumers using the factory work unchanged. Payloads use the schema registry's wire format, as Avro payloads do, written by `SerializePayload`, with the indexes of the message type in its schema before a protobuf message. A protobuf message encoded or decoded as another type than the one those indexes select in the schema fails with `ErrProtobufMessageMismatch`. A message written with a schema registered in another format than the factory's fails to decode with `ErrSchemaFormatMismatch`. Other formats can be plugged in by implementing `PayloadFormat`. The schema registry client doesn't register schemas in other formats than Avro, so they must be registered with the registry's API, or with `RegisterNewSchemaWithFormat` on a `MemorySchemaRegistryClient`.
+
+	factory, _ := NewMessageFactoryWithFormat("topic", "message-key-schema", "message-value-schema", ProtobufFormat, client)
+	producer := NewSaramaDatabusProducer(syncProducer, factory)
+	err := producer.Send(&pb.Key{Id: id}, &pb.UserCreated{Name: name}) // Generated by protoc-gen-go
+
+Producers and consumers created from a schema registry address share a `SchemaCache` for it, returned by `SharedSchemaCache`, so each schema is fetched and compiled once per process. The latest schemas of subjects are refreshed in the background, and the cached schemas continue to be used while the registry is unavailable. Factories encode with, and resolve messages to, the schemas that were latest when they were created, so registering a new version of a schema doesn't change what running producers write; a factory created with `WithLatestSchemas` picks up new versions as they are refreshed instead. A factory's cache is available from its `SchemaCache` method, which factories implement as a `SchemaCacheHaver`, and a cache created with `NewSchemaCache` can be passed to `NewMessageFactory` in place of a registry client.
+
+	cache := NewSchemaCache(client, SchemaCacheOptions{TTL: time.Minute})
+	defer cache.Close()
+	factory, _ := NewMessageFactory("topic", "message-key-schema", "message-value-schema", cache)
+
 Similarly, you would create a consumer using `NewDatabusConsumer`, passing in the addresses for Kafka and the schema registry, the Kafka topic to consume from, the names of the key and value schemas, and the group ID to which this consumer should belong (consumers in the same group consume as a group, rather than each consuming from the topic independently).
 
 	type MyMessage struct {
@@ -26,8 +77,97 @@ Similarly, you would create a consumer using `NewDatabusConsumer`, passing in th
 	defer consumer.Close()
 
 	var msg MyMessage
-	for consumer.Consume(&msg) == nil {
-		go Process(msg) // Get a copy here, since the pointer will be reused
+	for consumer.Consume(ctx, &msg) == nil {
+		Process(msg)
+	}
+
+Consume marks each message as processed as soon as it is decoded. To process messages concurrently, and only mark them once they have been processed, use `Subscribe` instead. Messages with the same key are handled in order by the same worker, and cancelling the context drains the messages already received.
+
+	err := Subscribe(ctx, func(ctx context.Context, m *ReceivedMessage) error {
+		var msg MyMessage
+		if err := m.Decode(&msg); err != nil {
+			return err
+		}
+		return Process(msg)
+	}, SubscribeOptions{Consumer: consumer, Workers: 8})
+
+For at-least-once processing without `Subscribe`, use `ConsumeWithAck`, which the consumers implement as an `AckConsumer`. It returns an `Acknowledgement` for each message, and a partition's offset is only marked up to the last message below which every message has been acknowledged with `Ack`. A message that is negatively acknowledged with `Nack` is passed to the consumer's failure policy, if it has one, or else blocks its partition, whose offset is no longer marked, so that it is delivered again; `Nack` then returns `ErrPartitionBlocked`, and the consumer reports it as an error and is unhealthy until its group rebalances the partition away.
+
+	ack, err := consumer.(AckConsumer).ConsumeWithAck(ctx, &msg)
+	if err != nil {
+		return err
 	}
+	if err := Process(msg); err != nil {
+		return ack.Nack()
+	}
+	return ack.Ack()
+
+Consumers that process messages in bulk, e.g. to write them to a datastore, can receive them in batches with `ConsumeBatch`, which the consumers implement as a `BatchConsumer`. None of the messages in a batch are marked as processed until it is acknowledged.
+
+	var msgs []MyMessage
+	batch, err := consumer.(BatchConsumer).ConsumeBatch(ctx, 500, time.Second, &msgs)
+	if err == nil && BulkWrite(msgs) == nil {
+		err = batch.Ack()
+	}
+
+One consumer group can consume several topics, or every topic matching a pattern with `WithTopicPattern`, with `NewMultiTopicDatabusConsumer`. A `Router` routes each message to the factory for its topic and the message type it is decoded as; a topic carrying several types of event is routed by the record its value was written as, named by the factory's format: after the named type of an Avro schema, the title of a JSON Schema, or the message type of a protobuf payload. `ConsumeAny` returns a pointer to a new value of the routed type.
+
+	router := NewRouter()
+	router.Route(usersFactory, UserCreatedMessage{})
+	router.Route(usersFactory, UserDeletedMessage{})
+	router.Route(ordersFactory, OrderMessage{})
+	consumer, _ := NewMultiTopicDatabusConsumer(brokers, "my-cool-group", router)
+	v, err := consumer.ConsumeAny(ctx)
+	switch msg := v.(type) {
+	case *UserCreatedMessage:
+		...
+	}
+
+By default, a message that can't be decoded or handled stops consumption. A `FailurePolicy`, passed to a consumer with `WithFailurePolicy` or to `Subscribe` in its options, can instead skip it (`SkipFailures`), send it to a dead-letter topic (`DeadLetter`), or retry it after a delay via retry topics (`RetryWithBackoff`, served by `ServeRetries`). `Replay` moves messages from a dead-letter topic back to the topic they came from.
+
+	policy := RetryWithBackoff(producer.(MessageSender), []time.Duration{time.Second, time.Minute}, DeadLetter(producer.(MessageSender)))
+
+To reprocess messages, e.g. after a bad deploy, stop a group's consumers and reset its offsets with `ResetGroupOffsets` (or `ResetOffsets`, given a sarama client) to the oldest or newest offsets, an offset per partition, or the first messages written at or after a time. `ParseOffsetReset` parses these from a command-line argument such as "earliest", "1h" or "0=42,1=17". A new consumer group can also start from a time with `WithStartTime`.
+
+	reset, err := ParseOffsetReset("1h")
+	offsets, err := ResetGroupOffsets(brokers, "my-cool-group", "topic", reset, opts)
+
+Consume-transform-produce pipelines can publish the messages they derive and commit the offsets of the messages they derived them from atomically with a `TransactionalProducer`, so that a crash never duplicates derived messages. `NewTransactionalProducer` runs the transactions on Kafka 0.11 or later, with a transactional ID that fences off any previous producer with the same ID, whose transactions then fail with `ErrProducerFenced` for good, and `NewMemoryTransactionalProducer` on a `MemoryBroker`. The consumers of the derived messages should set `ReadCommitted` in their `KafkaOptions`, to skip the messages of transactions that failed.
+
+	producer, err := NewTransactionalProducer(brokers, registry, "derived", "derived-key", "derived-value", "my-cool-transformer")
+	tx, _ := producer.Begin("my-cool-group")
+	tx.Send(key, Derived(msg))
+	tx.MarkOffset(received)
+	err := tx.Commit()
+
+Services that change their database and then send a message describing the change lose the message if they crash in between. Instead, they can add the message to an outbox table in the same database transaction with an `SQLOutboxStore` (or a `MemoryOutboxStore` in tests), and run an `OutboxRelay`, which publishes the messages waiting in the outbox in order, retrying failures, and marks them sent. An `SQLOutboxStore` orders messages by ID, and doesn't read past an ID that hasn't committed yet until `WithOutboxGapTimeout` passes, so messages are published in the order their transactions committed in unless a transaction takes longer than that to commit. The relay reports its backlog to a health check and metrics registry.
+
+	store := NewSQLOutboxStore(db, "outbox", DollarPlaceholders)
+	relay := NewOutboxRelay(store, producer.(MessageSender), OutboxRelayOptions{MaxBacklog: 10000, Health: u, Metrics: registry})
+	go relay.Run(ctx)
+
+	msg, err := NewOutboxMessage(ctx, factory, key, value)
+	err = store.Add(ctx, tx, msg) // tx is the *sql.Tx making the change
+	err = tx.Commit()
+	relay.Notify()
+
+Consumer errors and consumer group rebalances are reported to the functions set with `OnConsumerError`, `OnPartitionsClaimed` and `OnPartitionsReleased`, to a health check with `WithConsumerHealth`, and to a metrics registry with `WithConsumerMetrics`.
+
+	u := healthcheck.NewThresholdStatusUpdater(3)
+	healthcheck.Register("databus-consumer", u)
+	consumer, _ := NewDatabusConsumer(brokers, registry, "topic", "message-key-schema", "message-value-schema", "my-cool-group", WithConsumerHealth(u))
+
+Producers and consumers also record the rate, size and encoding or decoding time of the messages they send and receive, their errors by type, and the lag of each partition consumed, computed from its high-water mark whenever it is read, in the metrics registry of the context given to `SendContext`, `Consume` or `Subscribe`. In a zenkit service, that is the registry reported by the admin `/metrics` endpoint. See `ProducerMetricsPrefix` for the metrics recorded.
+
+For unit tests and local development, a `MemoryBroker` and a `MemorySchemaRegistryClient` stand in for Kafka and the schema registry, so the full produce→consume path runs in-process.
+
+	client := NewMemorySchemaRegistryClient()
+	client.RegisterNewSchema("message-key-schema", keySchema)
+	client.RegisterNewSchema("message-value-schema", valueSchema)
+	factory, _ := NewMessageFactory("topic", "message-key-schema", "message-value-schema", client)
+
+	broker := NewMemoryBroker(0)
+	producer := NewMemoryDatabusProducer(broker, factory)
+	consumer, _ := NewMemoryDatabusConsumer(broker, factory, "my-cool-group")
 */
 package databus
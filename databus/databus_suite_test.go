@@ -32,5 +32,13 @@ func GetSchemaRegistryMockClient(schemas map[string]string, ids map[string]int)
 				Version: 1,
 			}, nil
 		},
+		GetSchemaByIdFn: func(id int) (string, error) {
+			for subject, schemaID := range ids {
+				if schemaID == id {
+					return schemas[subject], nil
+				}
+			}
+			return "", errors.New("Nope")
+		},
 	}
 }
@@ -1,13 +1,15 @@
 package databus
 
 import (
-	"encoding/json"
+	"sync"
 
 	schemaregistry "github.com/datamountaineer/schema-registry"
 	"github.com/pkg/errors"
 )
 
 var (
+	// ErrSchemaMismatch is returned when a message was written with a schema
+	// that can't be resolved to the schema of the factory decoding it.
 	ErrSchemaMismatch = errors.New("message schema doesn't match deserializer")
 )
 
@@ -27,42 +29,117 @@ type MessageFactory interface {
 	ValueCodec() SchemaCodec
 	// Message produces an encoded message, ready to publish to Kafka
 	Message(key, value interface{}) (Message, error)
-	// Decode decodes a message received from Kafka into the types provided
+	// Decode decodes a message received from Kafka into the types provided.
+	// Messages written with an older or newer version of a schema are
+	// resolved to the factory's schema.
 	Decode(msg Message, key, value interface{}) error
 }
 
+// SchemaCacheHaver is implemented by MessageFactories that look up schemas in
+// a SchemaCache, such as those created by NewMessageFactory.
+type SchemaCacheHaver interface {
+	// SchemaCache is the cache of schemas and codecs the factory looks up
+	// the schemas messages were written with in
+	SchemaCache() *SchemaCache
+}
+
+// factoryCache returns the SchemaCache of a factory.
+func factoryCache(factory MessageFactory) (*SchemaCache, error) {
+	if h, ok := factory.(SchemaCacheHaver); ok {
+		return h.SchemaCache(), nil
+	}
+	return nil, errors.Errorf("factory for topic %s has no schema cache", factory.Topic())
+}
+
+// FactoryOption configures a MessageFactory.
+type FactoryOption func(*factoryOptions)
+
+type factoryOptions struct {
+	latestSchemas bool
+}
+
+func newFactoryOptions(opts []FactoryOption) factoryOptions {
+	var o factoryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithLatestSchemas makes a factory encode with, and resolve messages to, the
+// latest schemas of its subjects as of the last time its SchemaCache fetched
+// them, instead of the schemas that were latest when it was created. A factory
+// using a SchemaCache that refreshes its schemas then upgrades to new versions
+// of them as they are registered, so the values it encodes must already be
+// compatible with any version that might be registered.
+func WithLatestSchemas() FactoryOption {
+	return func(o *factoryOptions) {
+		o.latestSchemas = true
+	}
+}
+
 // NewMessageFactory creates a MessageFactory using the topic, key and value
-// schemas provided.
-func NewMessageFactory(topic, keySubject, valueSubject string, client schemaregistry.Client) (MessageFactory, error) {
-	keyID, keyCodec, err := GetCodec(client, keySubject)
+// schemas provided. If the client is a SchemaCache, such as the one returned
+// by SharedSchemaCache, the schemas and codecs are shared with every other
+// factory using it; otherwise they are cached by the factory alone.
+//
+// The factory encodes with, and resolves the messages it decodes to, the
+// latest schemas of its subjects when it was created, so new versions of them
+// registered later don't change what it writes. WithLatestSchemas makes it
+// pick up new versions instead.
+func NewMessageFactory(topic, keySubject, valueSubject string, client schemaregistry.Client, opts ...FactoryOption) (MessageFactory, error) {
+	f := &avroMessageFactory{
+		topic:        topic,
+		keySubject:   keySubject,
+		valSubject:   valueSubject,
+		cache:        schemaCacheFor(client),
+		options:      newFactoryOptions(opts),
+		converters:   map[int]*nativeConverter{},
+		writerCodecs: map[[2]int]*writerCodec{},
+	}
+	var err error
+	if f.keyID, _, err = f.cache.LatestCodec(keySubject); err == nil {
+		_, _, _, err = f.schema(true)
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get codec for key subject: %s", keySubject)
 	}
-	valID, valCodec, err := GetCodec(client, valueSubject)
+	if f.valID, _, err = f.cache.LatestCodec(valueSubject); err == nil {
+		_, _, _, err = f.schema(false)
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get codec for value subject: %s", valueSubject)
 	}
-	return &avroMessageFactory{
-		topic:       topic,
-		keySubject:  keySubject,
-		valSubject:  valueSubject,
-		keySchemaID: keyID,
-		valSchemaID: valID,
-		keyCodec:    keyCodec,
-		valCodec:    valCodec,
-	}, nil
+	return f, nil
 }
 
 // avroMessageFactory is the default implementation of MessageFactory. It uses
 // goavro.Codecs to do the encoding/decoding.
 type avroMessageFactory struct {
-	topic       string
-	keySubject  string
-	valSubject  string
-	keySchemaID int
-	valSchemaID int
-	keyCodec    SchemaCodec
-	valCodec    SchemaCodec
+	topic      string
+	keySubject string
+	valSubject string
+	cache      *SchemaCache
+	options    factoryOptions
+	// keyID and valID are the IDs of the key and value schemas when the
+	// factory was created.
+	keyID int
+	valID int
+
+	mu sync.RWMutex
+	// converters convert between Go values and the native types of the
+	// codecs of the key and value schemas, by schema ID.
+	converters map[int]*nativeConverter
+	// writerCodecs caches the resolvers for schemas messages were written
+	// with that differ from the key or value schema, by writer and reader ID.
+	writerCodecs map[[2]int]*writerCodec
+}
+
+// writerCodec decodes data written with a schema other than the reader's and
+// resolves it to the reader's schema.
+type writerCodec struct {
+	codec    SchemaCodec
+	resolver *schemaResolver
 }
 
 func (f *avroMessageFactory) Topic() string {
@@ -70,11 +147,23 @@ func (f *avroMessageFactory) Topic() string {
 }
 
 func (f *avroMessageFactory) KeyCodec() SchemaCodec {
-	return f.keyCodec
-
+	_, codec, _, err := f.schema(true)
+	if err != nil {
+		return nil
+	}
+	return codec
 }
+
 func (f *avroMessageFactory) ValueCodec() SchemaCodec {
-	return f.valCodec
+	_, codec, _, err := f.schema(false)
+	if err != nil {
+		return nil
+	}
+	return codec
+}
+
+func (f *avroMessageFactory) SchemaCache() *SchemaCache {
+	return f.cache
 }
 
 func (f *avroMessageFactory) KeySubject() string {
@@ -86,17 +175,25 @@ func (f *avroMessageFactory) ValueSubject() string {
 }
 
 func (f *avroMessageFactory) Message(key, value interface{}) (Message, error) {
-	encodedKey, err := encode(f.keyCodec, key)
+	keyID, keyCodec, keyConverter, err := f.schema(true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for key subject: %s", f.keySubject)
+	}
+	encodedKey, err := encode(keyCodec, keyConverter, key)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to encode key")
 	}
-	avroEncodedKey := AvroSerialize(encodedKey, f.keySchemaID)
-	encodedValue, err := encode(f.valCodec, value)
+	avroEncodedKey := AvroSerialize(encodedKey, keyID)
+	valID, valCodec, valConverter, err := f.schema(false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for value subject: %s", f.valSubject)
+	}
+	encodedValue, err := encode(valCodec, valConverter, value)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to encode value")
 	}
-	avroEncodedValue := AvroSerialize(encodedValue, f.valSchemaID)
-	return &defaultMessage{f.topic, avroEncodedKey, avroEncodedValue}, nil
+	avroEncodedValue := AvroSerialize(encodedValue, valID)
+	return NewMessage(f.topic, avroEncodedKey, avroEncodedValue), nil
 }
 
 func (f *avroMessageFactory) Decode(msg Message, key, value interface{}) error {
@@ -104,44 +201,125 @@ func (f *avroMessageFactory) Decode(msg Message, key, value interface{}) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to deserialize key as an Avro message")
 	}
-	if keyID != f.keySchemaID {
-		return ErrSchemaMismatch
-	}
-	if err := decode(f.keyCodec, keyBytes, key); err != nil {
+	if err := f.decodeWith(keyID, true, keyBytes, key); err != nil {
 		return errors.Wrap(err, "failed to decode key")
 	}
 	valID, valBytes, err := AvroDeserialize(msg.Value())
 	if err != nil {
 		return errors.Wrap(err, "failed to deserialize value as an Avro message")
 	}
-	if valID != f.valSchemaID {
-		return ErrSchemaMismatch
-	}
-	if err := decode(f.valCodec, valBytes, value); err != nil {
+	if err := f.decodeWith(valID, false, valBytes, value); err != nil {
 		return errors.Wrap(err, "failed to decode value")
 	}
 	return nil
 }
 
-// encode massages the data specified into Go native types via JSON
-// marshal/unmarshal, then encodes it using the SchemaEncoder provided.
-func encode(codec SchemaEncoder, data interface{}) ([]byte, error) {
-	marshalled, err := json.Marshal(data)
+// schema returns the ID of the key or value schema, its codec and the
+// converter for it: the schema of the subject when the factory was created
+// or, with WithLatestSchemas, as of the last time the cache fetched it.
+func (f *avroMessageFactory) schema(isKey bool) (int, SchemaCodec, *nativeConverter, error) {
+	subject, id := f.valSubject, f.valID
+	if isKey {
+		subject, id = f.keySubject, f.keyID
+	}
+	var (
+		codec SchemaCodec
+		err   error
+	)
+	if f.options.latestSchemas {
+		id, codec, err = f.cache.LatestCodec(subject)
+	} else {
+		codec, err = f.cache.Codec(id)
+	}
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	f.mu.RLock()
+	converter, ok := f.converters[id]
+	f.mu.RUnlock()
+	if ok {
+		return id, codec, converter, nil
+	}
+	converter, err = newNativeConverter(codec.Schema())
+	if err != nil {
+		return 0, nil, nil, errors.Wrapf(err, "failed to parse schema %d", id)
+	}
+	f.mu.Lock()
+	f.converters[id] = converter
+	f.mu.Unlock()
+	return id, codec, converter, nil
+}
+
+// decodeWith decodes data written with the schema writerID into ptr, reading
+// it with the key or value schema. If the writer schema isn't the reader
+// schema, the data is decoded with the writer schema and then resolved to the
+// reader schema.
+func (f *avroMessageFactory) decodeWith(writerID int, isKey bool, data []byte, ptr interface{}) error {
+	readerID, reader, converter, err := f.schema(isKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to get reader codec")
+	}
+	if writerID == readerID {
+		return decode(reader, converter, data, ptr)
+	}
+	wc, err := f.writerCodec(writerID, readerID, reader)
+	if err != nil {
+		return err
+	}
+	native, _, err := wc.codec.NativeFromBinary(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to get native value from text")
+	}
+	resolved, err := wc.resolver.Resolve(native)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve schema %d to schema %d", writerID, readerID)
+	}
+	return converter.fromNative(resolved, ptr)
+}
+
+// writerCodec returns the codec for the writer schema with the ID provided,
+// looking it up in the schema cache the first time it is seen.
+func (f *avroMessageFactory) writerCodec(writerID, readerID int, reader SchemaCodec) (*writerCodec, error) {
+	key := [2]int{writerID, readerID}
+	f.mu.RLock()
+	wc, ok := f.writerCodecs[key]
+	f.mu.RUnlock()
+	if ok {
+		return wc, nil
+	}
+
+	codec, err := f.cache.Codec(writerID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for writer schema %d", writerID)
+	}
+	resolver, err := newSchemaResolver(codec.Schema(), reader.Schema())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve schema %d to schema %d", writerID, readerID)
+	}
+	wc = &writerCodec{codec, resolver}
+
+	f.mu.Lock()
+	f.writerCodecs[key] = wc
+	f.mu.Unlock()
+	return wc, nil
+}
+
+// encode converts the data specified into Go native types according to the
+// schema, then encodes it using the SchemaEncoder provided.
+func encode(codec SchemaEncoder, converter *nativeConverter, data interface{}) ([]byte, error) {
+	native, err := converter.toNative(data)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal data to json")
+		return nil, errors.Wrap(err, "failed to convert data to native types")
 	}
-	var v interface{}
-	json.Unmarshal(marshalled, &v)
-	return codec.BinaryFromNative([]byte{}, v)
+	return codec.BinaryFromNative(nil, native)
 }
 
 // decode decodes data using the SchemaDecoder provided, then applies it to the
-// pointer provided via JSON marshal/unmarshal.
-func decode(codec SchemaDecoder, data []byte, ptr interface{}) error {
+// pointer provided according to the schema.
+func decode(codec SchemaDecoder, converter *nativeConverter, data []byte, ptr interface{}) error {
 	native, _, err := codec.NativeFromBinary(data)
 	if err != nil {
 		return errors.Wrap(err, "failed to get native value from text")
 	}
-	marshalled, _ := json.Marshal(native)
-	return json.Unmarshal(marshalled, ptr)
+	return converter.fromNative(native, ptr)
 }
@@ -0,0 +1,140 @@
+package databus_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro"
+	. "github.com/zenoss/zenkit/databus"
+)
+
+type benchmarkValue struct {
+	ID      int64             `json:"id"`
+	Name    string            `json:"name"`
+	Ratio   float64           `json:"ratio"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels"`
+	Parent  *string           `json:"parent"`
+	Created time.Time         `json:"created"`
+}
+
+var benchmarkValueSchema = `{
+	"type": "record",
+	"name": "benchmarkValue",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "ratio", "type": "double"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "labels", "type": {"type": "map", "values": "string"}},
+		{"name": "parent", "type": ["null", "string"], "default": null},
+		{"name": "created", "type": "string"}
+	]
+}`
+
+func newBenchmarkFactory(b *testing.B) (MessageFactory, benchmarkValue) {
+	client := NewMemorySchemaRegistryClient()
+	client.RegisterNewSchema("key", `"string"`)
+	client.RegisterNewSchema("value", benchmarkValueSchema)
+	factory, err := NewMessageFactory("topic", "key", "value", client)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return factory, benchmarkValue{
+		ID:      1234567890,
+		Name:    "benchmark",
+		Ratio:   0.75,
+		Tags:    []string{"a", "b", "c"},
+		Labels:  map[string]string{"zone": "us-east-1", "tier": "gold"},
+		Created: time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// jsonNative reaches goavro native types through a JSON round trip, as
+// messages used to be encoded.
+func jsonNative(v interface{}) interface{} {
+	b, _ := json.Marshal(v)
+	var native interface{}
+	json.Unmarshal(b, &native)
+	if m, ok := native.(map[string]interface{}); ok && m["parent"] != nil {
+		m["parent"] = goavro.Union("string", m["parent"])
+	}
+	return native
+}
+
+func BenchmarkEncode(b *testing.B) {
+	factory, value := newBenchmarkFactory(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := factory.Message("key", value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	factory, value := newBenchmarkFactory(b)
+	keyCodec, valCodec := factory.KeyCodec(), factory.ValueCodec()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := keyCodec.BinaryFromNative(nil, jsonNative("key")); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := valCodec.BinaryFromNative(nil, jsonNative(value)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	factory, value := newBenchmarkFactory(b)
+	msg, err := factory.Message("key", value)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var (
+			key string
+			val benchmarkValue
+		)
+		if err := factory.Decode(msg, &key, &val); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	factory, value := newBenchmarkFactory(b)
+	msg, err := factory.Message("key", value)
+	if err != nil {
+		b.Fatal(err)
+	}
+	keyCodec, valCodec := factory.KeyCodec(), factory.ValueCodec()
+	_, keyData, _ := AvroDeserialize(msg.Key())
+	_, valData, _ := AvroDeserialize(msg.Value())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var (
+			key string
+			val benchmarkValue
+		)
+		native, _, err := keyCodec.NativeFromBinary(keyData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		k, _ := json.Marshal(native)
+		json.Unmarshal(k, &key)
+		native, _, err = valCodec.NativeFromBinary(valData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		v, _ := json.Marshal(native)
+		json.Unmarshal(v, &val)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	schemaregistry "github.com/datamountaineer/schema-registry"
 	"github.com/linkedin/goavro"
+	"github.com/pkg/errors"
 	. "github.com/zenoss/zenkit/databus"
 	"github.com/zenoss/zenkit/test"
 
@@ -42,6 +43,24 @@ var valTestSchema = `{
 	}]
 }`
 
+type ValTestV2 struct {
+	TotallyCool string
+	Count       int64
+}
+
+var valTestV2Schema = `{
+	"type": "record",
+	"name": "valTest",
+	"fields": [{
+		"type": "string",
+		"name": "TotallyCool"
+	},{
+		"type": "long",
+		"name": "Count",
+		"default": 42
+	}]
+}`
+
 func stripAvroHeader(b []byte) []byte {
 	_, k, err := AvroDeserialize(b)
 	Ω(err).ShouldNot(HaveOccurred())
@@ -63,6 +82,8 @@ var _ = Describe("Factory", func() {
 			"object-value": `"int"`,
 			"key-test":     keyTestSchema,
 			"val-test":     valTestSchema,
+			"val-test-v2":  valTestV2Schema,
+			"long-value":   `"long"`,
 		}
 
 		ids = map[string]int{
@@ -70,6 +91,8 @@ var _ = Describe("Factory", func() {
 			"object-value": 2,
 			"key-test":     3,
 			"val-test":     4,
+			"val-test-v2":  5,
+			"long-value":   6,
 		}
 	)
 
@@ -232,7 +255,7 @@ var _ = Describe("Factory", func() {
 			otherMsg, err := otherFactory.Message("abc123", value)
 			Ω(err).ShouldNot(HaveOccurred())
 			err = factory.Decode(otherMsg, &otherk, &otherv)
-			Ω(err).Should(MatchError(ErrSchemaMismatch))
+			Ω(errors.Cause(err)).Should(Equal(ErrSchemaMismatch))
 		})
 
 		It("should fail to decode messages with an alternate value schema", func() {
@@ -245,7 +268,64 @@ var _ = Describe("Factory", func() {
 			otherMsg, err := otherFactory.Message(key, 123)
 			Ω(err).ShouldNot(HaveOccurred())
 			err = factory.Decode(otherMsg, &otherk, &otherv)
-			Ω(err).Should(MatchError(ErrSchemaMismatch))
+			Ω(errors.Cause(err)).Should(Equal(ErrSchemaMismatch))
+		})
+
+		It("should decode messages written with a newer version of the value schema", func() {
+			var (
+				k KeyTest
+				v ValTest
+			)
+			newFactory, err := NewMessageFactory(topic, keySubject, "val-test-v2", client)
+			Ω(err).ShouldNot(HaveOccurred())
+			newMsg, err := newFactory.Message(key, ValTestV2{value.TotallyCool, 7})
+			Ω(err).ShouldNot(HaveOccurred())
+			err = factory.Decode(newMsg, &k, &v)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(k).Should(BeEquivalentTo(key))
+			Ω(v).Should(BeEquivalentTo(value))
+		})
+
+		It("should fill in defaults for fields missing from an older value schema", func() {
+			var (
+				k KeyTest
+				v ValTestV2
+			)
+			newFactory, err := NewMessageFactory(topic, keySubject, "val-test-v2", client)
+			Ω(err).ShouldNot(HaveOccurred())
+			msg, err := factory.Message(key, value)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = newFactory.Decode(msg, &k, &v)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(v).Should(Equal(ValTestV2{value.TotallyCool, 42}))
+		})
+
+		It("should promote values written with a narrower primitive type", func() {
+			var (
+				k KeyTest
+				v int64
+			)
+			intFactory, err := NewMessageFactory(topic, keySubject, "object-value", client)
+			Ω(err).ShouldNot(HaveOccurred())
+			longFactory, err := NewMessageFactory(topic, keySubject, "long-value", client)
+			Ω(err).ShouldNot(HaveOccurred())
+			msg, err := intFactory.Message(key, 123)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = longFactory.Decode(msg, &k, &v)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(v).Should(BeEquivalentTo(123))
+		})
+
+		It("should fail to decode messages whose writer schema isn't registered", func() {
+			var (
+				k KeyTest
+				v ValTest
+			)
+			msg, err := factory.Message(key, value)
+			Ω(err).ShouldNot(HaveOccurred())
+			newval := AvroSerialize(stripAvroHeader(msg.Value()), 99)
+			err = factory.Decode(NewMessage(topic, msg.Key(), newval), &k, &v)
+			Ω(err).Should(HaveOccurred())
 		})
 
 		It("should fail to decode messages without a valid Avro key header", func() {
@@ -0,0 +1,247 @@
+package databus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultReplayIdleTimeout is how long Replay waits for another message
+	// before deciding the dead-letter topic is drained, unless configured
+	// otherwise.
+	DefaultReplayIdleTimeout = 5 * time.Second
+)
+
+// RetryTopic returns the name of the topic to which messages from a topic are
+// sent for their nth retry.
+func RetryTopic(topic string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", topic, attempt)
+}
+
+// DeadLetterTopic returns the name of the topic to which messages from a topic
+// are sent once they can't be processed.
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// MessageSender sends messages that have already been encoded. The producers
+// returned by NewDatabusProducer, NewSaramaDatabusProducer and
+// NewMemoryDatabusProducer all implement it.
+type MessageSender interface {
+	SendMessage(Message) error
+}
+
+// FailurePolicy decides what happens to a message that can't be decoded or
+// handled.
+type FailurePolicy interface {
+	// Failed deals with a failed message. If it returns nil, the message is
+	// marked as processed and consumption continues; otherwise, the error is
+	// returned to the consumer.
+	Failed(ctx context.Context, msg *ReceivedMessage, err error) error
+}
+
+// FailurePolicyFunc adapts a function to a FailurePolicy.
+type FailurePolicyFunc func(context.Context, *ReceivedMessage, error) error
+
+// Failed calls f(ctx, msg, err).
+func (f FailurePolicyFunc) Failed(ctx context.Context, msg *ReceivedMessage, err error) error {
+	return f(ctx, msg, err)
+}
+
+// SkipFailures returns a FailurePolicy that logs failed messages and moves on.
+func SkipFailures() FailurePolicy {
+	return FailurePolicyFunc(func(ctx context.Context, msg *ReceivedMessage, err error) error {
+		goa.LogError(ctx, "skipping failed message", "topic", msg.Topic(), "partition", msg.Partition, "offset", msg.Offset, "err", err)
+		return nil
+	})
+}
+
+// DeadLetter returns a FailurePolicy that sends failed messages to the
+// dead-letter topic of the topic they were received from.
+func DeadLetter(sender MessageSender) FailurePolicy {
+	return FailurePolicyFunc(func(ctx context.Context, msg *ReceivedMessage, err error) error {
+		failed := newFailedMessage(msg, err, msg.Attempts)
+		return errors.Wrap(failed.send(sender, DeadLetterTopic(msg.Topic())), "failed to send message to dead-letter topic")
+	})
+}
+
+// RetryWithBackoff returns a FailurePolicy that sends the nth failure of a
+// message to the nth retry topic of the topic it was received from, to be
+// handled again by ServeRetries after the nth delay. Once the delays are
+// exhausted, the message is passed to the next policy, which is typically
+// DeadLetter.
+func RetryWithBackoff(sender MessageSender, delays []time.Duration, next FailurePolicy) FailurePolicy {
+	return FailurePolicyFunc(func(ctx context.Context, msg *ReceivedMessage, err error) error {
+		attempt := msg.Attempts + 1
+		if attempt > len(delays) {
+			return next.Failed(ctx, msg, err)
+		}
+		failed := newFailedMessage(msg, err, attempt)
+		failed.RetryAt = failed.FailedAt.Add(delays[attempt-1])
+		return errors.Wrap(failed.send(sender, RetryTopic(msg.Topic(), attempt)), "failed to send message to retry topic")
+	})
+}
+
+// FailedMessage is the envelope in which failed messages are sent to retry and
+// dead-letter topics. It carries the original message and where it came
+// from, along with why and when it failed. It is encoded as JSON in the value
+// of the message, whose key is the original key.
+type FailedMessage struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       []byte            `json:"key"`
+	Value     []byte            `json:"value"`
+	Headers   map[string][]byte `json:"headers,omitempty"`
+	Error     string            `json:"error"`
+	Attempts  int               `json:"attempts"`
+	FailedAt  time.Time         `json:"failed_at"`
+	RetryAt   time.Time         `json:"retry_at,omitempty"`
+}
+
+func newFailedMessage(msg *ReceivedMessage, err error, attempts int) *FailedMessage {
+	return &FailedMessage{
+		Topic:     msg.Topic(),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key(),
+		Value:     msg.Value(),
+		Headers:   MessageHeaders(msg),
+		Error:     err.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UTC(),
+	}
+}
+
+// ParseFailedMessage decodes the envelope of a message received from a retry
+// or dead-letter topic.
+func ParseFailedMessage(msg Message) (*FailedMessage, error) {
+	var failed FailedMessage
+	if err := json.Unmarshal(msg.Value(), &failed); err != nil {
+		return nil, errors.Wrap(err, "failed to decode failed message")
+	}
+	return &failed, nil
+}
+
+// Original returns the message as it was originally received.
+func (f *FailedMessage) Original() Message {
+	return NewMessageWithHeaders(f.Topic, f.Key, f.Value, f.Headers)
+}
+
+func (f *FailedMessage) send(sender MessageSender, topic string) error {
+	value, err := json.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode failed message")
+	}
+	return sender.SendMessage(NewMessageWithHeaders(topic, f.Key, value, f.Headers))
+}
+
+// handleFailure applies a failure policy to a message, marking it as
+// processed if the policy deals with it.
+func handleFailure(ctx context.Context, policy FailurePolicy, source MessageSource, msg *ReceivedMessage, err error) error {
+	if policy == nil {
+		return err
+	}
+	if perr := policy.Failed(ctx, msg, err); perr != nil {
+		return errors.Wrapf(perr, "failure policy failed after %s", err)
+	}
+	return source.MarkOffset(msg)
+}
+
+// ServeRetries consumes a retry topic written by RetryWithBackoff until the
+// context is cancelled. It waits until each message is due, then handles the
+// original message again, with its headers restored into the context, using
+// the factory and handler provided. Messages that fail again are passed to the
+// failure policy, which is typically the same RetryWithBackoff policy, so they
+// move on to the next retry topic.
+func ServeRetries(ctx context.Context, source MessageSource, factory MessageFactory, handler Handler, policy FailurePolicy) error {
+	for {
+		msg, err := source.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "failed to receive message")
+		}
+		failed, err := ParseFailedMessage(msg)
+		if err != nil {
+			// A message that isn't an envelope can never be retried
+			goa.LogError(ctx, "skipping malformed retry message", "topic", msg.Topic(), "offset", msg.Offset, "err", err)
+			if err := source.MarkOffset(msg); err != nil {
+				return errors.Wrap(err, "failed to mark offset")
+			}
+			continue
+		}
+
+		if wait := time.Until(failed.RetryAt); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil
+			case <-t.C:
+			}
+		}
+
+		original := NewReceivedMessage(failed.Original(), failed.Partition, failed.Offset, factory)
+		original.Attempts = failed.Attempts
+		if err := handler(WithHeaders(ctx, failed.Headers), original); err != nil {
+			if perr := policy.Failed(ctx, original, err); perr != nil {
+				return errors.Wrapf(perr, "failure policy failed after %s", err)
+			}
+		}
+		if err := source.MarkOffset(msg); err != nil {
+			return errors.Wrap(err, "failed to mark offset")
+		}
+	}
+}
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Limit is the most messages to replay. Zero means no limit.
+	Limit int
+	// IdleTimeout is how long to wait for another message before deciding
+	// the dead-letter topic is drained. Defaults to
+	// DefaultReplayIdleTimeout.
+	IdleTimeout time.Duration
+}
+
+// Replay moves messages from a dead-letter topic back to the topics they were
+// originally received from, until the dead-letter topic is drained, the limit
+// is reached or the context is cancelled. It returns the number of messages
+// replayed.
+func Replay(ctx context.Context, source MessageSource, sender MessageSender, opts ReplayOptions) (int, error) {
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultReplayIdleTimeout
+	}
+	replayed := 0
+	for opts.Limit == 0 || replayed < opts.Limit {
+		receiveCtx, cancel := context.WithTimeout(ctx, opts.IdleTimeout)
+		msg, err := source.Receive(receiveCtx)
+		cancel()
+		if err != nil {
+			if receiveCtx.Err() != nil {
+				// Drained, or cancelled
+				return replayed, nil
+			}
+			return replayed, errors.Wrap(err, "failed to receive message")
+		}
+		failed, err := ParseFailedMessage(msg)
+		if err != nil {
+			return replayed, errors.Wrapf(err, "failed to replay message at offset %d", msg.Offset)
+		}
+		if err := sender.SendMessage(failed.Original()); err != nil {
+			return replayed, errors.Wrap(err, "failed to send message")
+		}
+		if err := source.MarkOffset(msg); err != nil {
+			return replayed, errors.Wrap(err, "failed to mark offset")
+		}
+		replayed++
+	}
+	return replayed, nil
+}
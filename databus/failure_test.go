@@ -0,0 +1,206 @@
+package databus_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failure policies", func() {
+
+	var (
+		broker   *MemoryBroker
+		factory  MessageFactory
+		producer DatabusProducer
+		sender   MessageSender
+		topic    string
+		ctx      context.Context
+		cancel   context.CancelFunc
+		bad      Message
+		good     memoryTestMessage
+	)
+
+	committed := func(group, topic string) int64 {
+		var total int64
+		for _, o := range broker.Offsets(group, topic) {
+			total += o
+		}
+		return total
+	}
+
+	deadLetters := func() []*FailedMessage {
+		var result []*FailedMessage
+		for _, msg := range broker.Messages(DeadLetterTopic(topic)) {
+			failed, err := ParseFailedMessage(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			result = append(result, failed)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		broker = NewMemoryBroker(1)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		topic = test.RandString(8)
+		var err error
+		factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		producer = NewMemoryDatabusProducer(broker, factory)
+		sender = NewMemoryMessageSender(broker)
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+
+		bad = NewMessage(topic, []byte("not"), []byte("avro"))
+		good = memoryTestMessage{KeyTest{SomeString: "key"}, ValTest{TotallyCool: "value"}}
+		broker.Send(bad)
+		Ω(producer.Send(good.Key, good.Value)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Context("when Consume can't decode a message", func() {
+
+		It("should return an error and leave it unmarked without a policy", func() {
+			consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group")
+			defer consumer.Close()
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).ShouldNot(Succeed())
+			Ω(committed("group", topic)).Should(BeZero())
+		})
+
+		It("should skip it", func() {
+			consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group", WithFailurePolicy(SkipFailures()))
+			defer consumer.Close()
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(msg).Should(Equal(good))
+			Ω(committed("group", topic)).Should(BeNumerically("==", 2))
+		})
+
+		It("should send it to the dead-letter topic", func() {
+			consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group", WithFailurePolicy(DeadLetter(sender)))
+			defer consumer.Close()
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(msg).Should(Equal(good))
+
+			failed := deadLetters()
+			Ω(failed).Should(HaveLen(1))
+			Ω(failed[0].Topic).Should(Equal(topic))
+			Ω(failed[0].Offset).Should(BeZero())
+			Ω(failed[0].Key).Should(Equal(bad.Key()))
+			Ω(failed[0].Value).Should(Equal(bad.Value()))
+			Ω(failed[0].Error).Should(ContainSubstring("failed to decode message"))
+			Ω(failed[0].FailedAt).ShouldNot(BeZero())
+		})
+
+		It("should return an error if the policy fails", func() {
+			policy := FailurePolicyFunc(func(context.Context, *ReceivedMessage, error) error {
+				return errors.New("o no")
+			})
+			consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group", WithFailurePolicy(policy))
+			defer consumer.Close()
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(MatchError(ContainSubstring("o no")))
+			Ω(committed("group", topic)).Should(BeZero())
+		})
+	})
+
+	Context("when a subscription's handler fails", func() {
+		var (
+			mu       sync.Mutex
+			attempts []int
+			failures int
+			handler  Handler
+		)
+
+		BeforeEach(func() {
+			attempts = nil
+			failures = 0
+			handler = func(ctx context.Context, msg *ReceivedMessage) error {
+				var m memoryTestMessage
+				if err := msg.Decode(&m); err != nil {
+					return SkipFailures().Failed(ctx, msg, err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				attempts = append(attempts, msg.Attempts)
+				if len(attempts) <= failures {
+					return errors.New("try again")
+				}
+				return nil
+			}
+		})
+
+		serve := func(policy FailurePolicy, retries int) {
+			consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group")
+			go Subscribe(ctx, handler, SubscribeOptions{Consumer: consumer, FailurePolicy: policy})
+			for i := 1; i <= retries; i++ {
+				source := NewMemoryMessageSource(broker, RetryTopic(topic, i), "retries")
+				go ServeRetries(ctx, source, factory, handler, policy)
+			}
+		}
+
+		It("should retry it after a delay", func() {
+			failures = 1
+			policy := RetryWithBackoff(sender, []time.Duration{50 * time.Millisecond}, DeadLetter(sender))
+			start := time.Now()
+			serve(policy, 1)
+			Eventually(func() []int {
+				mu.Lock()
+				defer mu.Unlock()
+				return attempts
+			}).Should(Equal([]int{0, 1}))
+			Ω(time.Since(start)).Should(BeNumerically(">=", 50*time.Millisecond))
+			Ω(deadLetters()).Should(BeEmpty())
+			Eventually(func() int64 { return committed("group", topic) }).Should(BeNumerically("==", 2))
+		})
+
+		It("should send it to the dead-letter topic once the retries are exhausted", func() {
+			failures = 3
+			policy := RetryWithBackoff(sender, []time.Duration{time.Millisecond, time.Millisecond}, DeadLetter(sender))
+			serve(policy, 2)
+			Eventually(deadLetters).Should(HaveLen(1))
+			failed := deadLetters()[0]
+			Ω(failed.Attempts).Should(Equal(2))
+			Ω(failed.Error).Should(ContainSubstring("try again"))
+			mu.Lock()
+			defer mu.Unlock()
+			Ω(attempts).Should(Equal([]int{0, 1, 2}))
+		})
+	})
+
+	It("should replay dead letters to the topic they came from", func() {
+		consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group", WithFailurePolicy(DeadLetter(sender)))
+		var msg memoryTestMessage
+		Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+		consumer.Close()
+		Ω(deadLetters()).Should(HaveLen(1))
+
+		source := NewMemoryMessageSource(broker, DeadLetterTopic(topic), "replay")
+		n, err := Replay(ctx, source, sender, ReplayOptions{IdleTimeout: 50 * time.Millisecond})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(n).Should(Equal(1))
+		Ω(committed("replay", DeadLetterTopic(topic))).Should(BeNumerically("==", 1))
+
+		msgs := broker.Messages(topic)
+		Ω(msgs).Should(HaveLen(3))
+		Ω(msgs[2].Key()).Should(Equal(bad.Key()))
+		Ω(msgs[2].Value()).Should(Equal(bad.Value()))
+
+		By("stopping once the dead-letter topic is drained")
+		n, err = Replay(ctx, source, sender, ReplayOptions{IdleTimeout: 50 * time.Millisecond})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(n).Should(BeZero())
+	})
+})
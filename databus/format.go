@@ -0,0 +1,334 @@
+package databus
+
+import (
+	"sync"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/linkedin/goavro"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidPayload is returned when a value doesn't match the schema it
+	// is encoded with.
+	ErrInvalidPayload = errors.New("payload doesn't match its schema")
+	// ErrSchemaFormatMismatch is returned when a message was written with a
+	// schema registered in another format than the factory decoding it.
+	ErrSchemaFormatMismatch = errors.New("message schema is in another format")
+)
+
+// PayloadFormat is the format in which a MessageFactory encodes the keys and
+// values of messages according to their schemas. Whatever the format, each
+// payload is framed with the schema registry's wire format, as
+// SerializePayload frames it: a zero magic byte followed by the 4-byte ID of
+// the schema it was written with.
+type PayloadFormat interface {
+	// Name is the schema type of the format in the schema registry.
+	Name() string
+	// Codec compiles a schema into a codec for payloads in the format.
+	Codec(schema string) (PayloadCodec, error)
+}
+
+// PayloadNamer returns the full name of the record a payload, without its
+// wire format header, is encoded as.
+type PayloadNamer func(payload []byte) (string, error)
+
+// RecordNamingFormat is implemented by PayloadFormats that name the records
+// payloads are written as, so that a Router can route the messages of a topic
+// carrying several types of record. AvroFormat, JSONSchemaFormat and
+// ProtobufFormat implement it.
+type RecordNamingFormat interface {
+	// PayloadNamer compiles a schema into a PayloadNamer for the payloads
+	// written with it.
+	PayloadNamer(schema string) (PayloadNamer, error)
+}
+
+// PayloadFormatHaver is implemented by MessageFactories that encode keys and
+// values in a PayloadFormat other than Avro, such as those created by
+// NewMessageFactoryWithFormat.
+type PayloadFormatHaver interface {
+	// PayloadFormat is the format the factory encodes keys and values in
+	PayloadFormat() PayloadFormat
+}
+
+// SchemaTypeClient is implemented by schema registry clients that report the
+// type each schema was registered as, such as those returned by
+// NewRegistryClient and NewMemorySchemaRegistryClient, and SchemaCache.
+type SchemaTypeClient interface {
+	// SchemaType returns the type of the schema with the ID provided, as
+	// named by PayloadFormat.Name: AVRO, JSON or PROTOBUF.
+	SchemaType(id int) (string, error)
+}
+
+// factoryFormat returns the PayloadFormat of a factory.
+func factoryFormat(factory MessageFactory) PayloadFormat {
+	if h, ok := factory.(PayloadFormatHaver); ok {
+		return h.PayloadFormat()
+	}
+	return AvroFormat
+}
+
+// PayloadCodec encodes Go values into payloads according to a schema, and
+// decodes them. Payloads don't include the wire format header.
+type PayloadCodec interface {
+	SchemaCodec
+	// Encode encodes a Go value into a payload.
+	Encode(v interface{}) ([]byte, error)
+	// Decode decodes a payload into the Go value ptr points to.
+	Decode(data []byte, ptr interface{}) error
+}
+
+var (
+	// AvroFormat encodes payloads as Avro binary, converting Go values as
+	// SchemaFor maps them to Avro. It is the format of NewMessageFactory.
+	AvroFormat PayloadFormat = avroFormat{}
+	// JSONSchemaFormat encodes payloads as JSON, with encoding/json, and
+	// validates them against a JSON Schema.
+	JSONSchemaFormat PayloadFormat = jsonSchemaFormat{}
+	// ProtobufFormat encodes payloads as Protocol Buffers. Values must be
+	// messages generated by protoc-gen-go from the schema, and payloads are
+	// prefixed with the indexes of their message types in it.
+	ProtobufFormat PayloadFormat = protobufFormat{}
+)
+
+type avroFormat struct{}
+
+func (avroFormat) Name() string {
+	return "AVRO"
+}
+
+// PayloadNamer names payloads after the schema's named type.
+func (avroFormat) PayloadNamer(schema string) (PayloadNamer, error) {
+	name, err := schemaName(schema)
+	if err != nil {
+		return nil, err
+	}
+	return func([]byte) (string, error) { return name, nil }, nil
+}
+
+func (avroFormat) Codec(schema string) (PayloadCodec, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create codec")
+	}
+	converter, err := newNativeConverter(codec.Schema())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse schema")
+	}
+	return &avroCodec{codec, converter}, nil
+}
+
+// avroCodec is a PayloadCodec that converts Go values to the native types of
+// a goavro codec. It only decodes payloads written with its own schema.
+type avroCodec struct {
+	SchemaCodec
+	converter *nativeConverter
+}
+
+func (c *avroCodec) Encode(v interface{}) ([]byte, error) {
+	return encode(c.SchemaCodec, c.converter, v)
+}
+
+func (c *avroCodec) Decode(data []byte, ptr interface{}) error {
+	return decode(c.SchemaCodec, c.converter, data, ptr)
+}
+
+// NewMessageFactoryWithFormat creates a MessageFactory like NewMessageFactory,
+// encoding keys and values in the format provided. Producers and consumers
+// created with the factory work unchanged, whatever its format.
+//
+// Factories in the Avro format are those created by NewMessageFactory. Those
+// in other formats encode with the latest schemas of their subjects when they
+// were created or, with WithLatestSchemas, as of the last time the cache
+// fetched them, and decode a message with the schema it was written with,
+// failing with ErrSchemaFormatMismatch if that schema was registered in
+// another format. Since JSON and Protocol Buffers payloads are decoded by field
+// name or number, they aren't resolved to the latest schemas.
+func NewMessageFactoryWithFormat(topic, keySubject, valueSubject string, format PayloadFormat, client schemaregistry.Client, opts ...FactoryOption) (MessageFactory, error) {
+	if format == AvroFormat {
+		return NewMessageFactory(topic, keySubject, valueSubject, client, opts...)
+	}
+	f := &formatMessageFactory{
+		topic:      topic,
+		keySubject: keySubject,
+		valSubject: valueSubject,
+		format:     format,
+		cache:      schemaCacheFor(client),
+		options:    newFactoryOptions(opts),
+		codecs:     map[int]PayloadCodec{},
+	}
+	var err error
+	if f.keyID, _, err = f.latest(keySubject); err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for key subject: %s", keySubject)
+	}
+	if f.valID, _, err = f.latest(valueSubject); err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for value subject: %s", valueSubject)
+	}
+	return f, nil
+}
+
+// formatMessageFactory is a MessageFactory that encodes keys and values with
+// the PayloadCodecs of a format.
+type formatMessageFactory struct {
+	topic      string
+	keySubject string
+	valSubject string
+	format     PayloadFormat
+	cache      *SchemaCache
+	options    factoryOptions
+	// keyID and valID are the IDs of the key and value schemas when the
+	// factory was created.
+	keyID int
+	valID int
+
+	mu sync.RWMutex
+	// codecs are the codecs compiled from the schemas messages are encoded
+	// or were written with, by schema ID.
+	codecs map[int]PayloadCodec
+}
+
+func (f *formatMessageFactory) Topic() string {
+	return f.topic
+}
+
+func (f *formatMessageFactory) KeySubject() string {
+	return f.keySubject
+}
+
+func (f *formatMessageFactory) ValueSubject() string {
+	return f.valSubject
+}
+
+func (f *formatMessageFactory) KeyCodec() SchemaCodec {
+	_, codec, err := f.schema(true)
+	if err != nil {
+		return nil
+	}
+	return codec
+}
+
+func (f *formatMessageFactory) ValueCodec() SchemaCodec {
+	_, codec, err := f.schema(false)
+	if err != nil {
+		return nil
+	}
+	return codec
+}
+
+func (f *formatMessageFactory) SchemaCache() *SchemaCache {
+	return f.cache
+}
+
+func (f *formatMessageFactory) PayloadFormat() PayloadFormat {
+	return f.format
+}
+
+func (f *formatMessageFactory) Message(key, value interface{}) (Message, error) {
+	keyID, keyCodec, err := f.schema(true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for key subject: %s", f.keySubject)
+	}
+	encodedKey, err := keyCodec.Encode(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode key")
+	}
+	valID, valCodec, err := f.schema(false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get codec for value subject: %s", f.valSubject)
+	}
+	encodedValue, err := valCodec.Encode(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode value")
+	}
+	return NewMessage(f.topic, SerializePayload(encodedKey, keyID), SerializePayload(encodedValue, valID)), nil
+}
+
+func (f *formatMessageFactory) Decode(msg Message, key, value interface{}) error {
+	if err := f.decode(msg.Key(), key); err != nil {
+		return errors.Wrap(err, "failed to decode key")
+	}
+	if err := f.decode(msg.Value(), value); err != nil {
+		return errors.Wrap(err, "failed to decode value")
+	}
+	return nil
+}
+
+// decode decodes a key or value into ptr with the codec of the schema it was
+// written with.
+func (f *formatMessageFactory) decode(data []byte, ptr interface{}) error {
+	id, payload, err := DeserializePayload(data)
+	if err != nil {
+		return err
+	}
+	typ, err := f.cache.SchemaType(id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get type of writer schema %d", id)
+	}
+	if typ != f.format.Name() {
+		return errors.Wrapf(ErrSchemaFormatMismatch, "schema %d is %s, not %s", id, typ, f.format.Name())
+	}
+	schema, err := f.cache.GetSchemaById(id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get writer schema %d", id)
+	}
+	codec, err := f.codec(id, schema)
+	if err != nil {
+		return err
+	}
+	return codec.Decode(payload, ptr)
+}
+
+// schema returns the ID of the key or value schema and its codec: the schema
+// of the subject when the factory was created or, with WithLatestSchemas, as
+// of the last time the cache fetched it.
+func (f *formatMessageFactory) schema(isKey bool) (int, PayloadCodec, error) {
+	subject, id := f.valSubject, f.valID
+	if isKey {
+		subject, id = f.keySubject, f.keyID
+	}
+	if f.options.latestSchemas {
+		return f.latest(subject)
+	}
+	schema, err := f.cache.GetSchemaById(id)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "failed to get schema %d", id)
+	}
+	codec, err := f.codec(id, schema)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, codec, nil
+}
+
+// latest returns the ID of the latest schema of a subject and its codec, as
+// of the last time the cache fetched it.
+func (f *formatMessageFactory) latest(subject string) (int, PayloadCodec, error) {
+	s, err := f.cache.GetLatestSchema(subject)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "failed to get latest schema for subject %s", subject)
+	}
+	codec, err := f.codec(s.Id, s.Schema)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.Id, codec, nil
+}
+
+// codec returns the codec for the schema with the ID provided, compiling it
+// the first time it is used.
+func (f *formatMessageFactory) codec(id int, schema string) (PayloadCodec, error) {
+	f.mu.RLock()
+	codec, ok := f.codecs[id]
+	f.mu.RUnlock()
+	if ok {
+		return codec, nil
+	}
+	codec, err := f.format.Codec(schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compile %s schema %d", f.format.Name(), id)
+	}
+	f.mu.Lock()
+	f.codecs[id] = codec
+	f.mu.Unlock()
+	return codec, nil
+}
@@ -0,0 +1,89 @@
+package databus
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type jsonSchemaFormat struct{}
+
+func (jsonSchemaFormat) Name() string {
+	return "JSON"
+}
+
+// PayloadNamer names payloads after the schema's title, or its $id if it has
+// none.
+func (jsonSchemaFormat) PayloadNamer(schema string) (PayloadNamer, error) {
+	var parsed struct {
+		Title string `json:"title"`
+		ID    string `json:"$id"`
+	}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		return nil, errors.Wrap(err, "failed to parse JSON schema")
+	}
+	name := parsed.Title
+	if name == "" {
+		name = parsed.ID
+	}
+	if name == "" {
+		return nil, errors.New("schema has no title or $id")
+	}
+	return func([]byte) (string, error) { return name, nil }, nil
+}
+
+func (jsonSchemaFormat) Codec(schema string) (PayloadCodec, error) {
+	validator, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse JSON schema")
+	}
+	return &jsonSchemaCodec{schema, validator}, nil
+}
+
+// jsonSchemaCodec is a PayloadCodec that encodes values as JSON that is valid
+// according to a JSON Schema.
+type jsonSchemaCodec struct {
+	schema    string
+	validator *gojsonschema.Schema
+}
+
+func (c *jsonSchemaCodec) Schema() string {
+	return c.schema
+}
+
+func (c *jsonSchemaCodec) BinaryFromNative(buf []byte, native interface{}) ([]byte, error) {
+	data, err := json.Marshal(native)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode JSON")
+	}
+	result, err := c.validator.Validate(gojsonschema.NewStringLoader(string(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate JSON")
+	}
+	if !result.Valid() {
+		problems := make([]string, len(result.Errors()))
+		for i, e := range result.Errors() {
+			problems[i] = e.Field() + ": " + e.Description()
+		}
+		return nil, errors.Wrap(ErrInvalidPayload, strings.Join(problems, "; "))
+	}
+	return append(buf, data...), nil
+}
+
+func (c *jsonSchemaCodec) NativeFromBinary(data []byte) (interface{}, []byte, error) {
+	var native interface{}
+	if err := json.Unmarshal(data, &native); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode JSON")
+	}
+	return native, nil, nil
+}
+
+func (c *jsonSchemaCodec) Encode(v interface{}) ([]byte, error) {
+	return c.BinaryFromNative(nil, v)
+}
+
+func (c *jsonSchemaCodec) Decode(data []byte, ptr interface{}) error {
+	return errors.Wrap(json.Unmarshal(data, ptr), "failed to decode JSON")
+}
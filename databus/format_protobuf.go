@@ -0,0 +1,263 @@
+package databus
+
+import (
+	"encoding/binary"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotProtoMessage is returned when a value to be encoded or decoded as
+	// Protocol Buffers isn't a generated message.
+	ErrNotProtoMessage = errors.New("value is not a protobuf message")
+
+	// ErrProtobufSerialization is returned when a protobuf payload does not
+	// begin with proper message indexes.
+	ErrProtobufSerialization = errors.New("message did not include proper protobuf message indexes")
+
+	// ErrProtobufMessageMismatch is returned when a protobuf message is
+	// encoded or decoded as another message type than the one its message
+	// indexes select in the schema.
+	ErrProtobufMessageMismatch = errors.New("protobuf message type does not match schema")
+)
+
+type protobufFormat struct{}
+
+func (protobufFormat) Name() string {
+	return "PROTOBUF"
+}
+
+func (protobufFormat) Codec(schema string) (PayloadCodec, error) {
+	pkg, messages, err := parseProtoMessages(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &protobufCodec{schema: schema, pkg: pkg, messages: messages}, nil
+}
+
+// PayloadNamer names payloads after the full name of the message type their
+// message indexes select in the schema, e.g. "events.UserCreated".
+func (protobufFormat) PayloadNamer(schema string) (PayloadNamer, error) {
+	pkg, messages, err := parseProtoMessages(schema)
+	if err != nil {
+		return nil, err
+	}
+	return func(payload []byte) (string, error) {
+		indexes, _, err := readMessageIndexes(payload)
+		if err != nil {
+			return "", err
+		}
+		return protoMessageName(pkg, messages, indexes)
+	}, nil
+}
+
+// protoMessageName returns the full name of the message type that message
+// indexes select among the message types of a package.
+func protoMessageName(pkg string, messages []*protoMessageType, indexes []int) (string, error) {
+	name, level := pkg, messages
+	for _, i := range indexes {
+		if i < 0 || i >= len(level) {
+			return "", errors.Wrapf(ErrProtobufSerialization, "no message at indexes %v", indexes)
+		}
+		if name != "" {
+			name += "."
+		}
+		name += level[i].name
+		level = level[i].nested
+	}
+	return name, nil
+}
+
+// protoMessageType is a message type declared in a .proto file, and the
+// message types nested in it, in the order they are declared.
+type protoMessageType struct {
+	name   string
+	nested []*protoMessageType
+}
+
+// protoTokens matches the comments, strings, identifiers and punctuation of a
+// .proto file.
+var protoTokens = regexp.MustCompile(`//[^\n]*|/\*(?s:.*?)\*/|"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|[A-Za-z_][A-Za-z0-9_.]*|\S`)
+
+// parseProtoMessages returns the package of a .proto file and the message
+// types declared at its top level, which is all message indexes need.
+func parseProtoMessages(schema string) (string, []*protoMessageType, error) {
+	var tokens []string
+	for _, t := range protoTokens.FindAllString(schema, -1) {
+		if !strings.HasPrefix(t, "//") && !strings.HasPrefix(t, "/*") {
+			tokens = append(tokens, t)
+		}
+	}
+	var (
+		pkg  string
+		root = &protoMessageType{}
+		// scopes holds the message type each open brace belongs to, or
+		// nil for the braces of enums, services, options and the like
+		scopes = []*protoMessageType{root}
+	)
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case tokens[i] == "package" && i+1 < len(tokens):
+			pkg = tokens[i+1]
+		case tokens[i] == "message" && i+2 < len(tokens) && tokens[i+2] == "{":
+			m := &protoMessageType{name: tokens[i+1]}
+			if parent := scopes[len(scopes)-1]; parent != nil {
+				parent.nested = append(parent.nested, m)
+			}
+			scopes = append(scopes, m)
+			i += 2
+		case tokens[i] == "{":
+			scopes = append(scopes, nil)
+		case tokens[i] == "}":
+			if len(scopes) == 1 {
+				return "", nil, errors.New("failed to parse protobuf schema: unbalanced braces")
+			}
+			scopes = scopes[:len(scopes)-1]
+		}
+	}
+	if len(scopes) != 1 {
+		return "", nil, errors.New("failed to parse protobuf schema: unbalanced braces")
+	}
+	return pkg, root.nested, nil
+}
+
+// protobufCodec is a PayloadCodec for generated protobuf messages. The
+// messages carry their own descriptors, so the schema is only parsed for the
+// names of its message types, which messages must match.
+type protobufCodec struct {
+	schema   string
+	pkg      string
+	messages []*protoMessageType
+}
+
+// describedMessage is implemented by messages generated by protoc-gen-go,
+// which return the path of their message type in its .proto file.
+type describedMessage interface {
+	Descriptor() ([]byte, []int)
+}
+
+func (c *protobufCodec) Schema() string {
+	return c.schema
+}
+
+func (c *protobufCodec) BinaryFromNative(buf []byte, native interface{}) ([]byte, error) {
+	m, err := protoMessage(native)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode protobuf message")
+	}
+	indexes := []int{0}
+	if d, ok := m.(describedMessage); ok {
+		_, indexes = d.Descriptor()
+	}
+	if err := c.check(m, indexes); err != nil {
+		return nil, err
+	}
+	return append(appendMessageIndexes(buf, indexes), data...), nil
+}
+
+// check returns ErrProtobufMessageMismatch unless a message is of the type
+// the message indexes select in the schema.
+func (c *protobufCodec) check(m proto.Message, indexes []int) error {
+	name, err := protoMessageName(c.pkg, c.messages, indexes)
+	if err != nil {
+		return errors.Wrap(ErrProtobufMessageMismatch, err.Error())
+	}
+	if actual := proto.MessageName(m); actual != name {
+		return errors.Wrapf(ErrProtobufMessageMismatch, "%s is not %s", actual, name)
+	}
+	return nil
+}
+
+// NativeFromBinary fails: protobuf messages can only be decoded into their
+// generated types, with Decode.
+func (c *protobufCodec) NativeFromBinary(data []byte) (interface{}, []byte, error) {
+	return nil, nil, errors.Wrap(ErrNotProtoMessage, "protobuf payloads must be decoded into generated messages")
+}
+
+func (c *protobufCodec) Encode(v interface{}) ([]byte, error) {
+	return c.BinaryFromNative(nil, v)
+}
+
+func (c *protobufCodec) Decode(data []byte, ptr interface{}) error {
+	indexes, data, err := readMessageIndexes(data)
+	if err != nil {
+		return err
+	}
+	// Allocate the message a pointer to a nil message pointer points to
+	if v := reflect.ValueOf(ptr); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Ptr {
+		if v.Elem().IsNil() {
+			v.Elem().Set(reflect.New(v.Elem().Type().Elem()))
+		}
+		ptr = v.Elem().Interface()
+	}
+	m, ok := ptr.(proto.Message)
+	if !ok {
+		return errors.Wrapf(ErrNotProtoMessage, "%T", ptr)
+	}
+	if err := c.check(m, indexes); err != nil {
+		return err
+	}
+	return errors.Wrap(proto.Unmarshal(data, m), "failed to decode protobuf message")
+}
+
+// protoMessage returns a value as a protobuf message, taking the address of
+// messages passed by value.
+func protoMessage(v interface{}) (proto.Message, error) {
+	if m, ok := v.(proto.Message); ok {
+		return m, nil
+	}
+	if v == nil {
+		return nil, errors.Wrap(ErrNotProtoMessage, "nil")
+	}
+	p := reflect.New(reflect.TypeOf(v))
+	p.Elem().Set(reflect.ValueOf(v))
+	if m, ok := p.Interface().(proto.Message); ok {
+		return m, nil
+	}
+	return nil, errors.Wrapf(ErrNotProtoMessage, "%T", v)
+}
+
+// appendMessageIndexes appends the path of a message type in its schema as
+// the schema registry encodes it: the number of indexes followed by each
+// index, as zig-zag varints, or a single zero for the first message.
+func appendMessageIndexes(buf []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(buf, 0)
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	buf = append(buf, varint[:binary.PutVarint(varint, int64(len(indexes)))]...)
+	for _, i := range indexes {
+		buf = append(buf, varint[:binary.PutVarint(varint, int64(i))]...)
+	}
+	return buf
+}
+
+// readMessageIndexes reads the message indexes appendMessageIndexes writes,
+// returning them and the rest of the data.
+func readMessageIndexes(data []byte) ([]int, []byte, error) {
+	n, read := binary.Varint(data)
+	if read <= 0 || n < 0 || n > int64(len(data)) {
+		return nil, nil, ErrProtobufSerialization
+	}
+	data = data[read:]
+	if n == 0 {
+		return []int{0}, data, nil
+	}
+	indexes := make([]int, n)
+	for i := range indexes {
+		index, read := binary.Varint(data)
+		if read <= 0 {
+			return nil, nil, ErrProtobufSerialization
+		}
+		indexes[i], data = int(index), data[read:]
+	}
+	return indexes, data, nil
+}
@@ -0,0 +1,261 @@
+package databus_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	jsonKeySchema = `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`
+	jsonValSchema = `{"type": "object", "properties": {"name": {"type": "string"}, "count": {"type": "integer", "minimum": 0}}, "required": ["name"]}`
+)
+
+type jsonKey struct {
+	ID string `json:"id"`
+}
+
+type jsonValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type jsonTestMessage struct {
+	Key   jsonKey   `zenkit:"message-key"`
+	Value jsonValue `zenkit:"message-value"`
+}
+
+type protobufTestMessage struct {
+	Key   wrappers.StringValue `zenkit:"message-key"`
+	Value *wrappers.Int64Value `zenkit:"message-value"`
+}
+
+var _ = Describe("Payload formats", func() {
+
+	var (
+		ctx    context.Context
+		client *MemorySchemaRegistryClient
+		broker *MemoryBroker
+		topic  string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		client = NewMemorySchemaRegistryClient()
+		broker = NewMemoryBroker(1)
+		topic = test.RandString(8)
+	})
+
+	Context("JSON Schema", func() {
+
+		var factory MessageFactory
+
+		BeforeEach(func() {
+			_, err := client.RegisterNewSchemaWithFormat("json-key", jsonKeySchema, JSONSchemaFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = client.RegisterNewSchemaWithFormat("json-val", jsonValSchema, JSONSchemaFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+			factory, err = NewMessageFactoryWithFormat(topic, "json-key", "json-val", JSONSchemaFormat, client)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should produce and consume JSON messages", func() {
+			producer := NewMemoryDatabusProducer(broker, factory)
+			defer producer.Close()
+			Ω(producer.Send(jsonKey{ID: "a"}, jsonValue{Name: "b", Count: 3})).Should(Succeed())
+
+			consumer, err := NewMemoryDatabusConsumer(broker, factory, "group")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Close()
+			var msg jsonTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(msg).Should(Equal(jsonTestMessage{jsonKey{"a"}, jsonValue{"b", 3}}))
+		})
+
+		It("should frame payloads with the schema ID", func() {
+			msg, err := factory.Message(jsonKey{ID: "a"}, jsonValue{Name: "b"})
+			Ω(err).ShouldNot(HaveOccurred())
+			id, payload, err := DeserializePayload(msg.Value())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(payload).Should(MatchJSON(`{"name": "b", "count": 0}`))
+			schema, err := client.GetSchemaById(id)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(schema).Should(Equal(jsonValSchema))
+		})
+
+		It("should keep encoding with the schemas it was created with", func() {
+			cache := NewSchemaCache(client, SchemaCacheOptions{TTL: 20 * time.Millisecond})
+			defer cache.Close()
+			pinned, err := NewMessageFactoryWithFormat(topic, "json-key", "json-val", JSONSchemaFormat, cache)
+			Ω(err).ShouldNot(HaveOccurred())
+			latest, err := NewMessageFactoryWithFormat(topic, "json-key", "json-val", JSONSchemaFormat, cache, WithLatestSchemas())
+			Ω(err).ShouldNot(HaveOccurred())
+			msg, err := pinned.Message(jsonKey{ID: "a"}, jsonValue{Name: "b"})
+			Ω(err).ShouldNot(HaveOccurred())
+			oldID, _, _ := DeserializePayload(msg.Value())
+			newID, err := client.RegisterNewSchemaWithFormat("json-val", `{"type": "object"}`, JSONSchemaFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			valueID := func(factory MessageFactory) func() int {
+				return func() int {
+					msg, err := factory.Message(jsonKey{ID: "a"}, jsonValue{Name: "b"})
+					Ω(err).ShouldNot(HaveOccurred())
+					id, _, _ := DeserializePayload(msg.Value())
+					return id
+				}
+			}
+			Eventually(valueID(latest)).Should(Equal(newID))
+			Consistently(valueID(pinned), 100*time.Millisecond).Should(Equal(oldID))
+		})
+
+		It("should reject values that don't match the schema", func() {
+			_, err := factory.Message(jsonKey{ID: "a"}, jsonValue{Name: "b", Count: -1})
+			Ω(errors.Cause(err)).Should(Equal(ErrInvalidPayload))
+		})
+
+		It("should reject invalid schemas", func() {
+			_, err := client.RegisterNewSchemaWithFormat("json-val", `{"type": "object"`, JSONSchemaFormat)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should reject messages written with a schema in another format", func() {
+			avroID, err := client.RegisterNewSchema("key-test", keyTestSchema)
+			Ω(err).ShouldNot(HaveOccurred())
+			msg := NewMessage(topic, SerializePayload([]byte(`{"id": "a"}`), avroID), SerializePayload([]byte(`{"name": "b"}`), avroID))
+			var v jsonTestMessage
+			Ω(errors.Cause(factory.Decode(msg, &v.Key, &v.Value))).Should(Equal(ErrSchemaFormatMismatch))
+		})
+
+		It("should name payloads after the titles of their schemas", func() {
+			naming := JSONSchemaFormat.(RecordNamingFormat)
+			namer, err := naming.PayloadNamer(`{"title": "events.Created", "$id": "https://example.com/created.json"}`)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(namer([]byte(`{}`))).Should(Equal("events.Created"))
+			namer, err = naming.PayloadNamer(`{"$id": "https://example.com/created.json"}`)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(namer([]byte(`{}`))).Should(Equal("https://example.com/created.json"))
+			_, err = naming.PayloadNamer(jsonValSchema)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Protobuf", func() {
+
+		var factory MessageFactory
+
+		BeforeEach(func() {
+			_, err := client.RegisterNewSchemaWithFormat("proto-key", wrappersProto, ProtobufFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = client.RegisterNewSchemaWithFormat("proto-val", wrappersProto, ProtobufFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+			factory, err = NewMessageFactoryWithFormat(topic, "proto-key", "proto-val", ProtobufFormat, client)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should produce and consume protobuf messages", func() {
+			producer := NewMemoryDatabusProducer(broker, factory)
+			defer producer.Close()
+			Ω(producer.Send(wrappers.StringValue{Value: "a"}, &wrappers.Int64Value{Value: 42})).Should(Succeed())
+
+			consumer, err := NewMemoryDatabusConsumer(broker, factory, "group")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Close()
+			var msg protobufTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(msg.Key.Value).Should(Equal("a"))
+			Ω(msg.Value.Value).Should(BeNumerically("==", 42))
+		})
+
+		It("should prefix payloads with the message indexes", func() {
+			msg, err := factory.Message(&wrappers.StringValue{Value: "a"}, &wrappers.Int64Value{Value: 1})
+			Ω(err).ShouldNot(HaveOccurred())
+			// StringValue and Int64Value are the 8th and 3rd messages of
+			// wrappers.proto: one index, zig-zag encoded
+			_, key, err := DeserializePayload(msg.Key())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(key[:2]).Should(Equal([]byte{2, 14}))
+			_, val, err := DeserializePayload(msg.Value())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(val[:2]).Should(Equal([]byte{2, 4}))
+		})
+
+		It("should reject values that aren't protobuf messages", func() {
+			_, err := factory.Message(KeyTest{}, ValTest{})
+			Ω(errors.Cause(err)).Should(Equal(ErrNotProtoMessage))
+		})
+
+		It("should reject messages of types the schema doesn't declare", func() {
+			_, err := client.RegisterNewSchemaWithFormat("proto-val", `syntax = "proto3"; package events; message Created { string value = 1; }`, ProtobufFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+			events, err := NewMessageFactoryWithFormat(topic, "proto-key", "proto-val", ProtobufFormat, client)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = events.Message(&wrappers.StringValue{Value: "a"}, &wrappers.StringValue{Value: "b"})
+			Ω(errors.Cause(err)).Should(Equal(ErrProtobufMessageMismatch))
+		})
+
+		It("should reject payloads decoded as another message type", func() {
+			msg, err := factory.Message(&wrappers.StringValue{Value: "a"}, &wrappers.Int64Value{Value: 1})
+			Ω(err).ShouldNot(HaveOccurred())
+			var key, val wrappers.StringValue
+			Ω(errors.Cause(factory.Decode(msg, &key, &val))).Should(Equal(ErrProtobufMessageMismatch))
+		})
+
+		It("should reject payloads without message indexes", func() {
+			msg := NewMessage(topic, SerializePayload(nil, 1), SerializePayload(nil, 2))
+			var v protobufTestMessage
+			Ω(errors.Cause(factory.Decode(msg, &v.Key, &v.Value))).Should(Equal(ErrProtobufSerialization))
+		})
+
+		It("should reject messages written with a schema in another format", func() {
+			jsonID, err := client.RegisterNewSchemaWithFormat("json-val", jsonValSchema, JSONSchemaFormat)
+			Ω(err).ShouldNot(HaveOccurred())
+			written, err := factory.Message(&wrappers.StringValue{Value: "a"}, &wrappers.Int64Value{Value: 1})
+			Ω(err).ShouldNot(HaveOccurred())
+			msg := NewMessage(topic, written.Key(), SerializePayload([]byte{0}, jsonID))
+			var v protobufTestMessage
+			Ω(errors.Cause(factory.Decode(msg, &v.Key, &v.Value))).Should(Equal(ErrSchemaFormatMismatch))
+		})
+
+		It("should name payloads after their message types", func() {
+			namer, err := ProtobufFormat.(RecordNamingFormat).PayloadNamer(`
+				syntax = "proto3";
+				// message Commented {}
+				package events;
+				enum Kind { A = 0; }
+				message Created {
+					message Details { string note = 1; }
+					Details details = 1;
+				}
+				message Deleted {
+					oneof reason { string note = 1; }
+					message Cause {}
+					message Source {}
+				}`)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(namer([]byte{0})).Should(Equal("events.Created"))
+			Ω(namer([]byte{4, 0, 0})).Should(Equal("events.Created.Details"))
+			Ω(namer([]byte{4, 2, 2})).Should(Equal("events.Deleted.Source"))
+			_, err = namer([]byte{2, 4})
+			Ω(errors.Cause(err)).Should(Equal(ErrProtobufSerialization))
+		})
+	})
+
+	It("should create Avro factories like NewMessageFactory", func() {
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		factory, err := NewMessageFactoryWithFormat(topic, "key-test", "val-test", AvroFormat, client)
+		Ω(err).ShouldNot(HaveOccurred())
+		msg, err := factory.Message(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "b"})
+		Ω(err).ShouldNot(HaveOccurred())
+		var v memoryTestMessage
+		Ω(factory.Decode(msg, &v.Key, &v.Value)).Should(Succeed())
+		Ω(v.Value.TotallyCool).Should(Equal("b"))
+	})
+})
@@ -0,0 +1,120 @@
+package databus
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/goadesign/goa/middleware"
+	"github.com/goadesign/goa/middleware/xray"
+	"github.com/zenoss/zenkit/auth"
+)
+
+const (
+	// HeaderRequestID carries the ID of the request that sent a message.
+	HeaderRequestID = middleware.RequestIDHeader
+	// HeaderTraceID carries the ID of the trace that sent a message.
+	HeaderTraceID = "X-Trace-Id"
+	// HeaderSpanID carries the ID of the span that sent a message, which
+	// becomes the parent of the span that handles it.
+	HeaderSpanID = "X-Span-Id"
+	// HeaderTenant carries the tenant of the identity that sent a message.
+	HeaderTenant = "X-Tenant"
+	// HeaderIdentity carries the ID of the identity that sent a message.
+	HeaderIdentity = "X-Identity"
+)
+
+// ContextHeaders returns the record headers that propagate the request ID,
+// trace and tenant identity of a context across the databus.
+func ContextHeaders(ctx context.Context) map[string][]byte {
+	headers := map[string][]byte{}
+	set := func(name, value string) {
+		if value != "" {
+			headers[name] = []byte(value)
+		}
+	}
+	set(HeaderRequestID, middleware.ContextRequestID(ctx))
+	if traceID := middleware.ContextTraceID(ctx); traceID != "" {
+		set(HeaderTraceID, traceID)
+		set(HeaderSpanID, middleware.ContextSpanID(ctx))
+	}
+	if identity := auth.ContextTenantIdentity(ctx); identity != nil {
+		set(HeaderTenant, identity.Tenant())
+		set(HeaderIdentity, identity.ID())
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// WithHeaders returns a context carrying the request ID, trace and tenant
+// identity propagated in a message's record headers. The trace continues
+// with a new span whose parent is the span that sent the message.
+func WithHeaders(ctx context.Context, headers map[string][]byte) context.Context {
+	if id := string(headers[HeaderRequestID]); id != "" {
+		ctx = withRequestID(ctx, id)
+	}
+	if traceID := string(headers[HeaderTraceID]); traceID != "" {
+		ctx = middleware.WithTrace(ctx, traceID, xray.NewID(), string(headers[HeaderSpanID]))
+	}
+	tenant, id := string(headers[HeaderTenant]), string(headers[HeaderIdentity])
+	if tenant != "" || id != "" {
+		ctx = auth.WithTenantIdentity(ctx, &headerIdentity{id, tenant})
+	}
+	return ctx
+}
+
+// withRequestID returns a context with a request ID. goa only sets request
+// IDs in its middleware, so it runs the middleware on a request carrying the
+// ID.
+func withRequestID(ctx context.Context, id string) context.Context {
+	req := &http.Request{Header: http.Header{}}
+	req.Header.Set(middleware.RequestIDHeader, id)
+	middleware.RequestID()(func(c context.Context, _ http.ResponseWriter, _ *http.Request) error {
+		ctx = c
+		return nil
+	})(ctx, nil, req)
+	return ctx
+}
+
+// withContextHeaders returns a message with the headers of a context added
+// to its own.
+func withContextHeaders(ctx context.Context, msg Message) Message {
+	headers := ContextHeaders(ctx)
+	if len(headers) == 0 {
+		return msg
+	}
+	for name, value := range MessageHeaders(msg) {
+		if _, ok := headers[name]; !ok {
+			headers[name] = value
+		}
+	}
+	return NewMessageWithHeaders(msg.Topic(), msg.Key(), msg.Value(), headers)
+}
+
+// headerIdentity is the tenant identity that sent a message.
+type headerIdentity struct {
+	id     string
+	tenant string
+}
+
+func (i *headerIdentity) ID() string {
+	return i.id
+}
+
+func (i *headerIdentity) Tenant() string {
+	return i.tenant
+}
+
+// recordHeaders converts message headers to sarama's record headers.
+func recordHeaders(headers map[string][]byte) []sarama.RecordHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+	result := make([]sarama.RecordHeader, 0, len(headers))
+	for name, value := range headers {
+		result = append(result, sarama.RecordHeader{Key: []byte(name), Value: value})
+	}
+	return result
+}
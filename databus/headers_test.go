@@ -0,0 +1,94 @@
+package databus_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/goadesign/goa/middleware"
+	"github.com/zenoss/zenkit/auth"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testIdentity struct{}
+
+func (testIdentity) ID() string     { return "thedude" }
+func (testIdentity) Tenant() string { return "acme" }
+
+// requestContext returns a context with a request ID, trace and tenant
+// identity, as a goa service would give its handlers.
+func requestContext() context.Context {
+	ctx := context.Background()
+	req := &http.Request{Header: http.Header{}}
+	req.Header.Set(middleware.RequestIDHeader, "request-1")
+	middleware.RequestID()(func(c context.Context, _ http.ResponseWriter, _ *http.Request) error {
+		ctx = c
+		return nil
+	})(ctx, nil, req)
+	ctx = middleware.WithTrace(ctx, "trace-1", "span-1", "")
+	return auth.WithTenantIdentity(ctx, testIdentity{})
+}
+
+var _ = Describe("Headers", func() {
+
+	It("should propagate the request ID, trace and tenant identity of a context", func() {
+		headers := ContextHeaders(requestContext())
+		Ω(headers).Should(Equal(map[string][]byte{
+			HeaderRequestID: []byte("request-1"),
+			HeaderTraceID:   []byte("trace-1"),
+			HeaderSpanID:    []byte("span-1"),
+			HeaderTenant:    []byte("acme"),
+			HeaderIdentity:  []byte("thedude"),
+		}))
+
+		ctx := WithHeaders(context.Background(), headers)
+		Ω(middleware.ContextRequestID(ctx)).Should(Equal("request-1"))
+		Ω(middleware.ContextTraceID(ctx)).Should(Equal("trace-1"))
+		Ω(middleware.ContextParentSpanID(ctx)).Should(Equal("span-1"))
+		Ω(middleware.ContextSpanID(ctx)).ShouldNot(BeEmpty())
+		identity := auth.ContextTenantIdentity(ctx)
+		Ω(identity).ShouldNot(BeNil())
+		Ω(identity.ID()).Should(Equal("thedude"))
+		Ω(identity.Tenant()).Should(Equal("acme"))
+	})
+
+	It("should have no headers for an empty context", func() {
+		Ω(ContextHeaders(context.Background())).Should(BeNil())
+		ctx := WithHeaders(context.Background(), nil)
+		Ω(auth.ContextTenantIdentity(ctx)).Should(BeNil())
+		Ω(middleware.ContextTraceID(ctx)).Should(BeEmpty())
+	})
+
+	It("should restore headers into the context given to subscription handlers", func() {
+		broker := NewMemoryBroker(1)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		factory, err := NewMessageFactory(test.RandString(8), "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		producer := NewMemoryDatabusProducer(broker, factory)
+		Ω(producer.SendContext(requestContext(), KeyTest{SomeString: "key"}, ValTest{TotallyCool: "value"})).Should(Succeed())
+		Ω(MessageHeaders(broker.Messages(factory.Topic())[0])).Should(HaveKey(HeaderTraceID))
+
+		consumer, _ := NewMemoryDatabusConsumer(broker, factory, "group")
+		defer consumer.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		contexts := make(chan context.Context, 1)
+		go Subscribe(ctx, func(ctx context.Context, msg *ReceivedMessage) error {
+			contexts <- ctx
+			return nil
+		}, SubscribeOptions{Consumer: consumer})
+
+		var handlerCtx context.Context
+		Eventually(contexts).Should(Receive(&handlerCtx))
+		Ω(middleware.ContextRequestID(handlerCtx)).Should(Equal("request-1"))
+		Ω(middleware.ContextTraceID(handlerCtx)).Should(Equal("trace-1"))
+		Ω(auth.ContextTenantIdentity(handlerCtx).Tenant()).Should(Equal("acme"))
+	})
+})
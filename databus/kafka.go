@@ -0,0 +1,160 @@
+package databus
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"github.com/xdg/scram"
+)
+
+// KafkaOptions configures the connection to Kafka of the clients created by
+// NewDatabusProducer (with WithProducerKafkaOptions), NewDatabusConsumer (with
+// WithKafkaOptions), NewAsyncDatabusProducer and KafkaCheckerWithOptions. The zero value
+// connects in plaintext, without authentication, to Kafka 0.11 or later.
+type KafkaOptions struct {
+	// ClientID identifies the client to the brokers, e.g. in their logs and
+	// quotas. Defaults to sarama's.
+	ClientID string
+	// Version is the version of Kafka the brokers run. Defaults to 0.11,
+	// the oldest version supporting record headers.
+	Version sarama.KafkaVersion
+	// TLS, if set, connects to the brokers over TLS with the configuration
+	// provided.
+	TLS *tls.Config
+	// SASL authenticates with the brokers using SASL.
+	SASL SASLOptions
+	// Compression is the codec messages are produced with.
+	Compression sarama.CompressionCodec
+	// Retries is the number of times sending a message is retried. Zero
+	// means sarama's default of 3, and a negative number never retries.
+	Retries int
+	// InitialOffset is where a consumer group with no committed offset for
+	// a partition starts consuming it: sarama.OffsetNewest or
+	// sarama.OffsetOldest. Defaults to sarama.OffsetNewest.
+	InitialOffset int64
+	// ReadCommitted makes consumers skip the messages of aborted
+	// transactions, and wait for those of open ones to be committed.
+	ReadCommitted bool
+}
+
+// SASLOptions configures SASL authentication with Kafka.
+type SASLOptions struct {
+	// Mechanism is sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256 or
+	// sarama.SASLTypeSCRAMSHA512. SASL is disabled if it is empty.
+	Mechanism sarama.SASLMechanism
+	User      string
+	Password  string
+}
+
+// Config returns the sarama configuration for the options.
+func (o KafkaOptions) Config() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_11_0_0
+	if o.Version != (sarama.KafkaVersion{}) {
+		config.Version = o.Version
+	}
+	if o.ClientID != "" {
+		config.ClientID = o.ClientID
+	}
+	if o.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = o.TLS
+	}
+	if o.SASL.Mechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = o.SASL.Mechanism
+		config.Net.SASL.User = o.SASL.User
+		config.Net.SASL.Password = o.SASL.Password
+		switch o.SASL.Mechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: sha256.New}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: sha512.New}
+			}
+		}
+	}
+	config.Producer.Compression = o.Compression
+	switch {
+	case o.Retries > 0:
+		config.Producer.Retry.Max = o.Retries
+	case o.Retries < 0:
+		config.Producer.Retry.Max = 0
+	}
+	if o.InitialOffset != 0 {
+		config.Consumer.Offsets.Initial = o.InitialOffset
+	}
+	if o.ReadCommitted {
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	return config
+}
+
+// ParseCompression returns the compression codec with the name provided:
+// none, gzip, snappy, lz4 or zstd.
+func ParseCompression(name string) (sarama.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	}
+	return sarama.CompressionNone, errors.Errorf("unknown compression codec %s", name)
+}
+
+// ParseInitialOffset returns the initial offset with the name provided:
+// newest or oldest.
+func ParseInitialOffset(name string) (int64, error) {
+	switch strings.ToLower(name) {
+	case "", "newest":
+		return sarama.OffsetNewest, nil
+	case "oldest":
+		return sarama.OffsetOldest, nil
+	}
+	return 0, errors.Errorf("unknown initial offset %s", name)
+}
+
+// ParseSASLMechanism returns the SASL mechanism with the name provided:
+// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. The empty name disables SASL.
+func ParseSASLMechanism(name string) (sarama.SASLMechanism, error) {
+	switch m := sarama.SASLMechanism(strings.ToUpper(name)); m {
+	case "", sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		return m, nil
+	}
+	return "", errors.Errorf("unsupported SASL mechanism %s", name)
+}
+
+// scramClient implements sarama.SCRAMClient.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return errors.Wrap(err, "failed to create SCRAM client")
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
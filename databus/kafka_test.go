@@ -0,0 +1,110 @@
+package databus_test
+
+import (
+	"crypto/tls"
+
+	"github.com/Shopify/sarama"
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KafkaOptions", func() {
+
+	It("should connect to Kafka 0.11 in plaintext by default", func() {
+		config := KafkaOptions{}.Config()
+		Ω(config.Validate()).Should(Succeed())
+		Ω(config.Version).Should(Equal(sarama.V0_11_0_0))
+		Ω(config.Net.TLS.Enable).Should(BeFalse())
+		Ω(config.Net.SASL.Enable).Should(BeFalse())
+		Ω(config.Producer.Retry.Max).Should(Equal(3))
+		Ω(config.Consumer.Offsets.Initial).Should(Equal(sarama.OffsetNewest))
+		Ω(config.Consumer.IsolationLevel).Should(Equal(sarama.ReadUncommitted))
+	})
+
+	It("should configure the client", func() {
+		tlsConfig := &tls.Config{ServerName: "kafka"}
+		config := KafkaOptions{
+			ClientID:      "my-service",
+			Version:       sarama.V1_0_0_0,
+			TLS:           tlsConfig,
+			Compression:   sarama.CompressionGZIP,
+			Retries:       7,
+			InitialOffset: sarama.OffsetOldest,
+			ReadCommitted: true,
+		}.Config()
+		Ω(config.Validate()).Should(Succeed())
+		Ω(config.ClientID).Should(Equal("my-service"))
+		Ω(config.Version).Should(Equal(sarama.V1_0_0_0))
+		Ω(config.Net.TLS.Enable).Should(BeTrue())
+		Ω(config.Net.TLS.Config).Should(Equal(tlsConfig))
+		Ω(config.Producer.Compression).Should(Equal(sarama.CompressionGZIP))
+		Ω(config.Producer.Retry.Max).Should(Equal(7))
+		Ω(config.Consumer.Offsets.Initial).Should(Equal(sarama.OffsetOldest))
+		Ω(config.Consumer.IsolationLevel).Should(Equal(sarama.ReadCommitted))
+		Ω(KafkaOptions{Retries: -1}.Config().Producer.Retry.Max).Should(BeZero())
+	})
+
+	It("should authenticate with SASL", func() {
+		config := KafkaOptions{SASL: SASLOptions{
+			Mechanism: sarama.SASLTypePlaintext,
+			User:      "user",
+			Password:  "pass",
+		}}.Config()
+		Ω(config.Validate()).Should(Succeed())
+		Ω(config.Net.SASL.Enable).Should(BeTrue())
+		Ω(config.Net.SASL.User).Should(Equal("user"))
+		Ω(config.Net.SASL.Password).Should(Equal("pass"))
+		Ω(config.Net.SASL.SCRAMClientGeneratorFunc).Should(BeNil())
+	})
+
+	It("should authenticate with SCRAM", func() {
+		for _, mechanism := range []sarama.SASLMechanism{sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512} {
+			config := KafkaOptions{SASL: SASLOptions{
+				Mechanism: mechanism,
+				User:      "user",
+				Password:  "pass",
+			}}.Config()
+			Ω(config.Validate()).Should(Succeed())
+			Ω(config.Net.SASL.Mechanism).Should(Equal(mechanism))
+			client := config.Net.SASL.SCRAMClientGeneratorFunc()
+			Ω(client.Begin("user", "pass", "")).Should(Succeed())
+			first, err := client.Step("")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(first).Should(HavePrefix("n,,n=user,r="))
+			Ω(client.Done()).Should(BeFalse())
+		}
+	})
+
+	It("should parse compression codecs, offsets and mechanisms", func() {
+		Ω(ParseCompression("snappy")).Should(Equal(sarama.CompressionSnappy))
+		Ω(ParseCompression("ZSTD")).Should(Equal(sarama.CompressionZSTD))
+		Ω(ParseCompression("")).Should(Equal(sarama.CompressionNone))
+		_, err := ParseCompression("brotli")
+		Ω(err).Should(HaveOccurred())
+
+		Ω(ParseInitialOffset("oldest")).Should(Equal(sarama.OffsetOldest))
+		Ω(ParseInitialOffset("newest")).Should(Equal(sarama.OffsetNewest))
+		_, err = ParseInitialOffset("middle")
+		Ω(err).Should(HaveOccurred())
+
+		Ω(ParseSASLMechanism("scram-sha-512")).Should(Equal(sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512)))
+		Ω(ParseSASLMechanism("")).Should(BeEmpty())
+		_, err = ParseSASLMechanism("GSSAPI")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should configure async producers", func() {
+		config := AsyncProducerOptions{
+			Kafka: KafkaOptions{
+				ClientID:    "my-service",
+				Compression: sarama.CompressionLZ4,
+			},
+		}.Config()
+		Ω(config.Validate()).Should(Succeed())
+		Ω(config.ClientID).Should(Equal("my-service"))
+		Ω(config.Producer.Compression).Should(Equal(sarama.CompressionLZ4))
+		Ω(config.Version).Should(Equal(sarama.V0_11_0_0))
+	})
+})
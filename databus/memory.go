@@ -0,0 +1,467 @@
+package databus
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrProducerClosed is thrown when the databus producer is no longer open
+	ErrProducerClosed = errors.New("producer is closed")
+)
+
+// DefaultMemoryPartitions is the number of partitions each topic of a
+// MemoryBroker has, unless configured otherwise.
+const DefaultMemoryPartitions = 4
+
+// MemoryBroker is an in-process stand-in for Kafka, for unit tests and local
+// development. Topics are created on first use with a fixed number of
+// partitions. Messages are assigned to a partition by a hash of their key, so
+// messages with the same key are consumed in the order they were sent.
+// Consumers in the same group share offsets, so each message is consumed once
+// per group. A new group starts from the oldest message, and once every
+// consumer in a group has closed, messages received but not marked as
+// processed are delivered again.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	partitions int
+	topics     map[string][][]Message
+	sent       map[string][][]time.Time
+	groups     map[string]*memoryGroup
+	notify     chan struct{}
+}
+
+// memoryGroup is the state of a consumer group: the offset of the next
+// message to deliver and the offset of the next message to process, by topic
+// and partition.
+type memoryGroup struct {
+	members   int
+	position  map[string][]int64
+	committed map[string][]int64
+}
+
+// NewMemoryBroker returns an empty MemoryBroker whose topics have the number
+// of partitions provided, or DefaultMemoryPartitions if it is not positive.
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions <= 0 {
+		partitions = DefaultMemoryPartitions
+	}
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     map[string][][]Message{},
+		sent:       map[string][][]time.Time{},
+		groups:     map[string]*memoryGroup{},
+		notify:     make(chan struct{}),
+	}
+}
+
+// Send appends a message to its topic, returning the partition and offset at
+// which it was stored.
+func (b *MemoryBroker) Send(msg Message) (int32, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.append(msg)
+}
+
+// append appends a message to its topic. The caller must hold the lock.
+func (b *MemoryBroker) append(msg Message) (int32, int64) {
+	log := b.topic(msg.Topic())
+	partition := b.partition(msg.Key())
+	offset := int64(len(log[partition]))
+	log[partition] = append(log[partition], msg)
+	b.sent[msg.Topic()][partition] = append(b.sent[msg.Topic()][partition], time.Now())
+
+	// Wake up any consumers waiting for a message
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return partition, offset
+}
+
+// Messages returns every message sent to a topic, partition by partition.
+func (b *MemoryBroker) Messages(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var result []Message
+	for _, partition := range b.topics[topic] {
+		result = append(result, partition...)
+	}
+	return result
+}
+
+// Offsets returns the offset of the next message a consumer group will
+// process from each partition of a topic.
+func (b *MemoryBroker) Offsets(group, topic string) []int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int64{}, topicOffsets(b.group(group).committed, topic, b.partitions)...)
+}
+
+// topic returns the partitions of a topic, creating it if necessary. The
+// caller must hold the lock.
+func (b *MemoryBroker) topic(name string) [][]Message {
+	log, ok := b.topics[name]
+	if !ok {
+		log = make([][]Message, b.partitions)
+		b.topics[name] = log
+		b.sent[name] = make([][]time.Time, b.partitions)
+	}
+	return log
+}
+
+// group returns a consumer group, creating it if necessary. The caller must
+// hold the lock.
+func (b *MemoryBroker) group(name string) *memoryGroup {
+	g, ok := b.groups[name]
+	if !ok {
+		g = &memoryGroup{
+			position:  map[string][]int64{},
+			committed: map[string][]int64{},
+		}
+		b.groups[name] = g
+	}
+	return g
+}
+
+// topicOffsets returns the offsets of a topic, creating them if necessary.
+func topicOffsets(offsets map[string][]int64, topic string, partitions int) []int64 {
+	o, ok := offsets[topic]
+	if !ok {
+		o = make([]int64, partitions)
+		offsets[topic] = o
+	}
+	return o
+}
+
+// partition hashes a key to a partition the same way sarama's default
+// partitioner does.
+func (b *MemoryBroker) partition(key []byte) int32 {
+	h := fnv.New32a()
+	h.Write(key)
+	p := int32(h.Sum32()) % int32(b.partitions)
+	if p < 0 {
+		p = -p
+	}
+	return p
+}
+
+// next claims the next message for a consumer group, starting the search at
+// the partition provided so that no partition is starved. If there is none,
+// it returns a channel that is closed when another message is sent. The
+// caller must hold the lock.
+func (b *MemoryBroker) next(group, topic string, start int) (Message, int32, int64, <-chan struct{}) {
+	log := b.topic(topic)
+	g := b.group(group)
+	position := topicOffsets(g.position, topic, b.partitions)
+	for i := 0; i < b.partitions; i++ {
+		p := (start + i) % b.partitions
+		if offset := position[p]; offset < int64(len(log[p])) {
+			position[p]++
+			return log[p][offset], int32(p), offset, nil
+		}
+	}
+	return nil, 0, 0, b.notify
+}
+
+// commit marks the messages of a partition before offset as processed by a
+// consumer group.
+func (b *MemoryBroker) commit(group, topic string, partition int32, offset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commitOffset(group, topic, partition, offset)
+}
+
+// commitOffset is commit for a caller that holds the lock.
+func (b *MemoryBroker) commitOffset(group, topic string, partition int32, offset int64) {
+	g := b.group(group)
+	committed := topicOffsets(g.committed, topic, b.partitions)
+	if offset > committed[partition] {
+		committed[partition] = offset
+	}
+}
+
+// lag returns the number of messages in a partition after the last one a
+// consumer group committed.
+func (b *MemoryBroker) lag(group, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	committed := topicOffsets(b.group(group).committed, topic, b.partitions)
+	return int64(len(b.topic(topic)[partition])) - committed[partition]
+}
+
+// join adds a consumer to a group.
+func (b *MemoryBroker) join(group string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.group(group).members++
+}
+
+// leave removes a consumer from a group. Once the last consumer has left,
+// the group resumes from its committed offsets.
+func (b *MemoryBroker) leave(group string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(group)
+	if g.members--; g.members > 0 {
+		return
+	}
+	g.position = map[string][]int64{}
+	for topic, committed := range g.committed {
+		g.position[topic] = append([]int64{}, committed...)
+	}
+}
+
+// NewMemoryDatabusProducer returns a DatabusProducer that encodes messages
+// with the factory provided and sends them to a MemoryBroker.
+func NewMemoryDatabusProducer(broker *MemoryBroker, factory MessageFactory) DatabusProducer {
+	return &memoryDatabusProducer{broker: broker, factory: factory}
+}
+
+type memoryDatabusProducer struct {
+	mu      sync.RWMutex
+	broker  *MemoryBroker
+	factory MessageFactory
+	closed  bool
+}
+
+func (p *memoryDatabusProducer) Send(key, value interface{}) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.WithStack(ErrProducerClosed)
+	}
+	message, err := p.factory.Message(key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+	p.broker.Send(message)
+	return nil
+}
+
+func (p *memoryDatabusProducer) SendContext(ctx context.Context, key, value interface{}) error {
+	message, m, err := encodeMessage(ctx, p.factory, key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+	err = p.SendMessage(message)
+	m.sent(err)
+	return err
+}
+
+// NewMemoryMessageSender returns a MessageSender that sends encoded messages
+// to a MemoryBroker.
+func NewMemoryMessageSender(broker *MemoryBroker) MessageSender {
+	return &memoryDatabusProducer{broker: broker}
+}
+
+// SendMessage sends an encoded message, e.g. to a retry or dead-letter topic.
+func (p *memoryDatabusProducer) SendMessage(message Message) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.WithStack(ErrProducerClosed)
+	}
+	p.broker.Send(message)
+	return nil
+}
+
+func (p *memoryDatabusProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// NewMemoryDatabusConsumer returns a DatabusConsumer that receives messages
+// from the factory's topic on a MemoryBroker as a member of the consumer
+// group provided, and decodes them with the factory.
+func NewMemoryDatabusConsumer(broker *MemoryBroker, messageFactory MessageFactory, groupId string, opts ...ConsumerOption) (DatabusConsumer, error) {
+	c := &memoryDatabusConsumer{
+		broker:          broker,
+		messageFactory:  messageFactory,
+		topics:          []string{messageFactory.Topic()},
+		group:           groupId,
+		closed:          make(chan struct{}),
+		consumerOptions: newConsumerOptions(opts),
+	}
+	c.join()
+	return c, nil
+}
+
+// NewMemoryMessageSource returns a MessageSource that receives raw messages
+// from a topic on a MemoryBroker as a member of the consumer group provided,
+// e.g. for a retry or dead-letter topic. The messages it receives can't be
+// decoded.
+func NewMemoryMessageSource(broker *MemoryBroker, topic, groupId string) MessageSource {
+	return &memoryDatabusConsumer{
+		broker:          broker,
+		topics:          []string{topic},
+		group:           groupId,
+		closed:          make(chan struct{}),
+		consumerOptions: newConsumerOptions(nil),
+	}
+}
+
+type memoryDatabusConsumer struct {
+	broker         *MemoryBroker
+	messageFactory MessageFactory
+	topics         []string
+	group          string
+	next           int // guarded by the broker's lock
+	partition      int // guarded by the broker's lock
+	closed         chan struct{}
+	closeOnce      sync.Once
+	*consumerOptions
+}
+
+func (c *memoryDatabusConsumer) Consume(ctx context.Context, v interface{}) error {
+	keyField, valueField, err := validateType(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		err = msg.decode(v, keyField, valueField)
+		if err == nil {
+			return c.MarkOffset(msg)
+		}
+		err = errors.Wrap(err, "failed to decode message")
+		if c.failurePolicy == nil {
+			return err
+		}
+		if err := handleFailure(ctx, c.failurePolicy, c, msg, err); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive returns the next message for the consumer's group without marking
+// it as processed.
+func (c *memoryDatabusConsumer) Receive(ctx context.Context) (*ReceivedMessage, error) {
+	for {
+		select {
+		case <-c.closed:
+			return nil, errors.Wrap(ErrConsumerClosed, "consumer closed")
+		default:
+		}
+
+		c.broker.mu.Lock()
+		msg, partition, offset, wait := c.claim()
+		c.broker.mu.Unlock()
+
+		if msg != nil {
+			return c.receivedMessage(ctx, msg, partition, offset, c.messageFactory, c.lag), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ErrConsumerClosed, "context is cancelled")
+		case <-c.closed:
+			return nil, errors.Wrap(ErrConsumerClosed, "consumer closed")
+		case <-wait:
+		}
+	}
+}
+
+// MarkOffset marks a message, and every message before it in its partition,
+// as processed by the consumer's group.
+func (c *memoryDatabusConsumer) MarkOffset(msg *ReceivedMessage) error {
+	c.broker.commit(c.group, msg.Topic(), msg.Partition, msg.Offset+1)
+	return nil
+}
+
+// lag returns the number of messages in a partition after the last one the
+// consumer's group committed.
+func (c *memoryDatabusConsumer) lag(topic string, partition int32) int64 {
+	return c.broker.lag(c.group, topic, partition)
+}
+
+func (c *memoryDatabusConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.broker.leave(c.group)
+		c.rebalanced(nil, c.partitions(), nil)
+	})
+	return nil
+}
+
+// join adds the consumer to its group, starting the group at the consumer's
+// start time if it has one.
+func (c *memoryDatabusConsumer) join() {
+	c.broker.join(c.group)
+	if !c.startTime.IsZero() {
+		c.broker.mu.Lock()
+		for _, topic := range c.topics {
+			c.broker.resetOffsets(c.group, topic, ResetToTime(c.startTime), true)
+		}
+		c.broker.mu.Unlock()
+	}
+	// Every consumer of a MemoryBroker receives from every partition
+	c.rebalanced(c.partitions(), nil, c.partitions())
+}
+
+// claim claims the next message for the consumer's group from any of its
+// topics, starting after the topic and partition it last received from. The
+// caller must hold the broker's lock.
+func (c *memoryDatabusConsumer) claim() (Message, int32, int64, <-chan struct{}) {
+	topics := c.subscribed()
+	for i := range topics {
+		t := (c.next + i) % len(topics)
+		msg, partition, offset, _ := c.broker.next(c.group, topics[t], c.partition)
+		if msg != nil {
+			c.next, c.partition = t+1, int(partition)+1
+			return msg, partition, offset, nil
+		}
+	}
+	return nil, 0, 0, c.broker.notify
+}
+
+// subscribed returns the topics the consumer receives from, including those
+// on the broker that match its topic pattern. The caller must hold the
+// broker's lock.
+func (c *memoryDatabusConsumer) subscribed() []string {
+	if c.topicPattern == nil {
+		return c.topics
+	}
+	topics := append([]string{}, c.topics...)
+	for topic := range c.broker.topics {
+		if c.topicPattern.MatchString(topic) && !containsString(c.topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics[len(c.topics):])
+	return topics
+}
+
+// partitions returns every partition of the consumer's topics.
+func (c *memoryDatabusConsumer) partitions() map[string][]int32 {
+	c.broker.mu.Lock()
+	topics := c.subscribed()
+	c.broker.mu.Unlock()
+	result := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		partitions := make([]int32, c.broker.partitions)
+		for i := range partitions {
+			partitions[i] = int32(i)
+		}
+		result[topic] = partitions
+	}
+	return result
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package databus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/linkedin/goavro"
+)
+
+// MemorySchemaRegistryClient is an in-process schemaregistry.Client, for use
+// with a MemoryBroker in unit tests and local development. Like the real
+// schema registry, it gives a schema the same ID under every subject it is
+// registered with, rejects schemas that aren't compatible with those already
+// registered under a subject according to its compatibility level, which is
+// BACKWARD by default, and reports missing subjects and schemas with the
+// registry's error codes. Schemas in formats other than Avro are registered
+// with RegisterNewSchemaWithFormat, and are only checked for validity.
+type MemorySchemaRegistryClient struct {
+	mu       sync.RWMutex
+	ids      map[string]int
+	schemas  map[int]string
+	formats  map[int]PayloadFormat
+	subjects map[string][]int
+	levels   map[string]CompatibilityLevel
+}
+
+var (
+	_ schemaregistry.Client = &MemorySchemaRegistryClient{}
+	_ CompatibilityClient   = &MemorySchemaRegistryClient{}
+	_ SchemaTypeClient      = &MemorySchemaRegistryClient{}
+)
+
+// NewMemorySchemaRegistryClient returns an empty MemorySchemaRegistryClient.
+func NewMemorySchemaRegistryClient() *MemorySchemaRegistryClient {
+	return &MemorySchemaRegistryClient{
+		ids:      map[string]int{},
+		schemas:  map[int]string{},
+		formats:  map[int]PayloadFormat{},
+		subjects: map[string][]int{},
+		levels:   map[string]CompatibilityLevel{"": CompatibilityBackward},
+	}
+}
+
+// Subjects returns every subject with a registered schema.
+func (c *MemorySchemaRegistryClient) Subjects() ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	subjects := make([]string, 0, len(c.subjects))
+	for subject := range c.subjects {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects, nil
+}
+
+// Versions returns the versions registered for a subject.
+func (c *MemorySchemaRegistryClient) Versions(subject string) ([]int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids, ok := c.subjects[subject]
+	if !ok {
+		return nil, subjectNotFound(subject)
+	}
+	versions := make([]int, len(ids))
+	for i := range ids {
+		versions[i] = i + 1
+	}
+	return versions, nil
+}
+
+// RegisterNewSchema registers a schema under a subject, returning its ID. A
+// schema that is already the subject's latest version is not registered
+// again.
+func (c *MemorySchemaRegistryClient) RegisterNewSchema(subject, schema string) (int, error) {
+	return c.RegisterNewSchemaWithFormat(subject, schema, AvroFormat)
+}
+
+// RegisterNewSchemaWithFormat registers a schema in the format provided under
+// a subject, returning its ID.
+func (c *MemorySchemaRegistryClient) RegisterNewSchemaWithFormat(subject, schema string, format PayloadFormat) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.subjects[subject]); n == 0 || c.schemas[c.subjects[subject][n-1]] != schema {
+		compatible, err := c.compatible(subject, schema, format)
+		if err != nil {
+			return 0, err
+		}
+		if !compatible {
+			return 0, registryError(409, "schema being registered is incompatible with an earlier schema of subject %s", subject)
+		}
+	}
+	id, ok := c.ids[schema]
+	if !ok {
+		id = len(c.schemas) + 1
+		c.ids[schema] = id
+		c.schemas[id] = schema
+		c.formats[id] = format
+	}
+	versions := c.subjects[subject]
+	if n := len(versions); n == 0 || versions[n-1] != id {
+		c.subjects[subject] = append(versions, id)
+	}
+	return id, nil
+}
+
+// IsRegistered reports whether a schema is registered under a subject.
+func (c *MemorySchemaRegistryClient) IsRegistered(subject, schema string) (bool, schemaregistry.Schema, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids, ok := c.subjects[subject]
+	if !ok {
+		return false, schemaregistry.Schema{}, subjectNotFound(subject)
+	}
+	for i, id := range ids {
+		if c.schemas[id] == schema {
+			return true, c.schema(subject, i+1), nil
+		}
+	}
+	return false, schemaregistry.Schema{}, nil
+}
+
+// GetSchemaById returns the schema with the ID provided.
+func (c *MemorySchemaRegistryClient) GetSchemaById(id int) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	schema, ok := c.schemas[id]
+	if !ok {
+		return "", registryError(40403, "schema %d not found", id)
+	}
+	return schema, nil
+}
+
+// SchemaType returns the name of the format the schema with the ID provided
+// was registered in.
+func (c *MemorySchemaRegistryClient) SchemaType(id int) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	format, ok := c.formats[id]
+	if !ok {
+		return "", registryError(40403, "schema %d not found", id)
+	}
+	return format.Name(), nil
+}
+
+// GetSchemaBySubject returns a version of the schema for a subject.
+func (c *MemorySchemaRegistryClient) GetSchemaBySubject(subject string, version int) (schemaregistry.Schema, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids, ok := c.subjects[subject]
+	if !ok {
+		return schemaregistry.Schema{}, subjectNotFound(subject)
+	}
+	if version < 1 || version > len(ids) {
+		return schemaregistry.Schema{}, registryError(40402, "version %d of subject %s not found", version, subject)
+	}
+	return c.schema(subject, version), nil
+}
+
+// GetLatestSchema returns the latest version of the schema for a subject.
+func (c *MemorySchemaRegistryClient) GetLatestSchema(subject string) (schemaregistry.Schema, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids, ok := c.subjects[subject]
+	if !ok {
+		return schemaregistry.Schema{}, subjectNotFound(subject)
+	}
+	return c.schema(subject, len(ids)), nil
+}
+
+// CheckCompatibility reports whether a schema is compatible with the schemas
+// registered under a subject, according to the subject's compatibility level.
+func (c *MemorySchemaRegistryClient) CheckCompatibility(subject, schema string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if _, ok := c.subjects[subject]; !ok {
+		return false, subjectNotFound(subject)
+	}
+	return c.compatible(subject, schema, AvroFormat)
+}
+
+// CompatibilityLevel returns the compatibility level of a subject, or the
+// default level if it has none of its own or the subject is empty.
+func (c *MemorySchemaRegistryClient) CompatibilityLevel(subject string) (CompatibilityLevel, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.level(subject), nil
+}
+
+// SetCompatibilityLevel sets the compatibility level of a subject, or the
+// default level if the subject is empty.
+func (c *MemorySchemaRegistryClient) SetCompatibilityLevel(subject string, level CompatibilityLevel) error {
+	switch level {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityBackwardTransitive,
+		CompatibilityForward, CompatibilityForwardTransitive,
+		CompatibilityFull, CompatibilityFullTransitive:
+	default:
+		return registryError(42203, "invalid compatibility level %s", level)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.levels[subject] = level
+	return nil
+}
+
+// level returns the compatibility level of a subject. The caller must hold
+// the lock.
+func (c *MemorySchemaRegistryClient) level(subject string) CompatibilityLevel {
+	if level, ok := c.levels[subject]; ok {
+		return level
+	}
+	return c.levels[""]
+}
+
+// compatible reports whether a schema is compatible with the versions of a
+// subject its compatibility level requires. Schemas in other formats than
+// Avro are only checked for validity. The caller must hold the lock.
+func (c *MemorySchemaRegistryClient) compatible(subject, schema string, format PayloadFormat) (bool, error) {
+	if format != AvroFormat {
+		if _, err := format.Codec(schema); err != nil {
+			return false, registryError(42201, "invalid schema: %s", err)
+		}
+		return true, nil
+	}
+	if _, err := goavro.NewCodec(schema); err != nil {
+		return false, registryError(42201, "invalid schema: %s", err)
+	}
+	var ids []int
+	for _, id := range c.subjects[subject] {
+		if c.formats[id] == AvroFormat {
+			ids = append(ids, id)
+		}
+	}
+	level := c.level(subject)
+	switch level {
+	case CompatibilityNone:
+		return true, nil
+	case CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		if len(ids) > 1 {
+			ids = ids[len(ids)-1:]
+		}
+	}
+	for _, id := range ids {
+		existing := c.schemas[id]
+		var checks [][2]string
+		switch level {
+		case CompatibilityBackward, CompatibilityBackwardTransitive:
+			checks = [][2]string{{existing, schema}}
+		case CompatibilityForward, CompatibilityForwardTransitive:
+			checks = [][2]string{{schema, existing}}
+		default:
+			checks = [][2]string{{existing, schema}, {schema, existing}}
+		}
+		for _, check := range checks {
+			resolver, err := newSchemaResolver(check[0], check[1])
+			if err != nil {
+				return false, err
+			}
+			if resolver.Compatible() != nil {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// schema returns a version of a subject. The caller must hold the lock.
+func (c *MemorySchemaRegistryClient) schema(subject string, version int) schemaregistry.Schema {
+	id := c.subjects[subject][version-1]
+	return schemaregistry.Schema{
+		Id:      id,
+		Schema:  c.schemas[id],
+		Subject: subject,
+		Version: version,
+	}
+}
+
+func subjectNotFound(subject string) error {
+	return registryError(40401, "subject %s not found", subject)
+}
+
+// registryError returns a RegistryError with the error code provided, as the
+// schema registry reports it.
+func registryError(code int, format string, args ...interface{}) error {
+	return &RegistryError{ErrorCode: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,256 @@
+package databus_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/goadesign/goa"
+	goalogrus "github.com/goadesign/goa/logging/logrus"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type memoryTestMessage struct {
+	Key   KeyTest `zenkit:"message-key"`
+	Value ValTest `zenkit:"message-value"`
+}
+
+var _ = Describe("Memory", func() {
+
+	Context("schema registry client", func() {
+		var client *MemorySchemaRegistryClient
+
+		BeforeEach(func() {
+			client = NewMemorySchemaRegistryClient()
+		})
+
+		It("should register new versions of a subject", func() {
+			id1, err := client.RegisterNewSchema("val-test", valTestSchema)
+			Ω(err).ShouldNot(HaveOccurred())
+			id2, err := client.RegisterNewSchema("val-test", valTestV2Schema)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(id2).ShouldNot(Equal(id1))
+
+			versions, err := client.Versions("val-test")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(versions).Should(Equal([]int{1, 2}))
+
+			latest, err := client.GetLatestSchema("val-test")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(latest.Id).Should(Equal(id2))
+			Ω(latest.Version).Should(Equal(2))
+			Ω(latest.Schema).Should(Equal(valTestV2Schema))
+
+			first, err := client.GetSchemaBySubject("val-test", 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(first.Id).Should(Equal(id1))
+
+			schema, err := client.GetSchemaById(id1)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(schema).Should(Equal(valTestSchema))
+		})
+
+		It("should give a schema the same ID under every subject", func() {
+			id1, _ := client.RegisterNewSchema("a", `"string"`)
+			id2, _ := client.RegisterNewSchema("b", `"string"`)
+			id3, _ := client.RegisterNewSchema("a", `"string"`)
+			Ω(id2).Should(Equal(id1))
+			Ω(id3).Should(Equal(id1))
+			versions, _ := client.Versions("a")
+			Ω(versions).Should(HaveLen(1))
+			subjects, _ := client.Subjects()
+			Ω(subjects).Should(Equal([]string{"a", "b"}))
+		})
+
+		It("should report missing subjects with the registry's error code", func() {
+			_, _, err := client.IsRegistered("nothing", `"string"`)
+			Ω(err.Error()).Should(ContainSubstring("40401"))
+			_, err = client.GetLatestSchema("nothing")
+			Ω(err).Should(HaveOccurred())
+			_, err = client.GetSchemaById(42)
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should register schemas through a SchemaRegistry", func() {
+			ctx := goa.WithLogger(context.Background(), goalogrus.New(test.TestLogger()))
+			factory := BuildSchemaRegistryFactory(ctx, func(string) (schemaregistry.Client, error) {
+				return client, nil
+			})
+			err := factory.NewSchemaRegistry("memory").Register("key-test", keyTestSchema, "val-test", valTestSchema)
+			Ω(err).ShouldNot(HaveOccurred())
+			registered, _, err := client.IsRegistered("key-test", keyTestSchema)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(registered).Should(BeTrue())
+		})
+	})
+
+	Context("broker", func() {
+		var (
+			broker   *MemoryBroker
+			client   *MemorySchemaRegistryClient
+			factory  MessageFactory
+			producer DatabusProducer
+			topic    string
+			ctx      context.Context
+			cancel   context.CancelFunc
+		)
+
+		newConsumer := func(group string) DatabusConsumer {
+			consumer, err := NewMemoryDatabusConsumer(broker, factory, group)
+			Ω(err).ShouldNot(HaveOccurred())
+			return consumer
+		}
+
+		send := func(n int) []memoryTestMessage {
+			sent := make([]memoryTestMessage, n)
+			for i := range sent {
+				sent[i] = memoryTestMessage{
+					KeyTest{SomeString: test.RandString(8), AnInt: i},
+					ValTest{TotallyCool: fmt.Sprintf("value-%d", i)},
+				}
+				Ω(producer.Send(sent[i].Key, sent[i].Value)).Should(Succeed())
+			}
+			return sent
+		}
+
+		consume := func(consumer DatabusConsumer, n int) []memoryTestMessage {
+			received := make([]memoryTestMessage, n)
+			for i := range received {
+				Ω(consumer.Consume(ctx, &received[i])).Should(Succeed())
+			}
+			return received
+		}
+
+		BeforeEach(func() {
+			broker = NewMemoryBroker(0)
+			client = NewMemorySchemaRegistryClient()
+			client.RegisterNewSchema("key-test", keyTestSchema)
+			client.RegisterNewSchema("val-test", valTestSchema)
+			topic = test.RandString(8)
+			var err error
+			factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+			Ω(err).ShouldNot(HaveOccurred())
+			producer = NewMemoryDatabusProducer(broker, factory)
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("should deliver every message to a consumer", func() {
+			consumer := newConsumer("group")
+			defer consumer.Close()
+			sent := send(10)
+			Ω(consume(consumer, 10)).Should(ConsistOf(sent))
+		})
+
+		It("should store Avro-encoded messages", func() {
+			send(1)
+			msgs := broker.Messages(topic)
+			Ω(msgs).Should(HaveLen(1))
+			id, _, err := AvroDeserialize(msgs[0].Value())
+			Ω(err).ShouldNot(HaveOccurred())
+			latest, _ := client.GetLatestSchema("val-test")
+			Ω(id).Should(Equal(latest.Id))
+		})
+
+		It("should partition messages by key and consume them in order", func() {
+			key := KeyTest{SomeString: "same"}
+			for i := 0; i < 5; i++ {
+				msg, err := factory.Message(key, ValTest{TotallyCool: fmt.Sprint(i)})
+				Ω(err).ShouldNot(HaveOccurred())
+				p, o := broker.Send(msg)
+				Ω(p).Should(BeNumerically("<", DefaultMemoryPartitions))
+				Ω(o).Should(BeNumerically("==", i))
+			}
+			consumer := newConsumer("group")
+			defer consumer.Close()
+			for i, msg := range consume(consumer, 5) {
+				Ω(msg.Value.TotallyCool).Should(Equal(fmt.Sprint(i)))
+			}
+		})
+
+		It("should deliver every message to each consumer group", func() {
+			a, b := newConsumer("a"), newConsumer("b")
+			defer a.Close()
+			defer b.Close()
+			sent := send(5)
+			Ω(consume(a, 5)).Should(ConsistOf(sent))
+			Ω(consume(b, 5)).Should(ConsistOf(sent))
+		})
+
+		It("should share offsets between consumers in a group", func() {
+			a, b := newConsumer("group"), newConsumer("group")
+			defer a.Close()
+			defer b.Close()
+			sent := send(6)
+			received := append(consume(a, 3), consume(b, 3)...)
+			Ω(received).Should(ConsistOf(sent))
+
+			var total int64
+			for _, o := range broker.Offsets("group", topic) {
+				total += o
+			}
+			Ω(total).Should(BeNumerically("==", 6))
+
+			By("resuming from the group's offsets")
+			c := newConsumer("group")
+			defer c.Close()
+			more := send(1)
+			Ω(consume(c, 1)).Should(Equal(more))
+		})
+
+		It("should wait for a message to be sent", func() {
+			consumer := newConsumer("group")
+			defer consumer.Close()
+			done := make(chan memoryTestMessage)
+			go func() {
+				defer GinkgoRecover()
+				done <- consume(consumer, 1)[0]
+			}()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+			sent := send(1)
+			Eventually(done).Should(Receive(Equal(sent[0])))
+		})
+
+		It("should stop waiting when the context is cancelled", func() {
+			consumer := newConsumer("group")
+			defer consumer.Close()
+			cancel()
+			var msg memoryTestMessage
+			err := consumer.Consume(ctx, &msg)
+			Ω(errors.Cause(err)).Should(Equal(ErrConsumerClosed))
+		})
+
+		It("should stop consuming when closed", func() {
+			consumer := newConsumer("group")
+			Ω(consumer.Close()).Should(Succeed())
+			send(1)
+			var msg memoryTestMessage
+			err := consumer.Consume(ctx, &msg)
+			Ω(errors.Cause(err)).Should(Equal(ErrConsumerClosed))
+		})
+
+		It("should stop producing when closed", func() {
+			Ω(producer.Close()).Should(Succeed())
+			err := producer.Send(KeyTest{}, ValTest{})
+			Ω(errors.Cause(err)).Should(Equal(ErrProducerClosed))
+		})
+
+		It("should reject invalid message types", func() {
+			consumer := newConsumer("group")
+			defer consumer.Close()
+			err := consumer.Consume(ctx, &KeyTest{})
+			Ω(errors.Cause(err)).Should(Equal(ErrInvalidMessageType))
+			Ω(strings.Contains(err.Error(), "missing key or value field")).Should(BeTrue())
+		})
+	})
+})
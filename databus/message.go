@@ -11,17 +11,44 @@ type Message interface {
 	Value() []byte
 }
 
+// HeaderMessage is a Message with record headers, which carry metadata such
+// as trace and tenant identifiers alongside the key and value. Headers
+// require Kafka 0.11 or later.
+type HeaderMessage interface {
+	Message
+	// Headers are the record headers of the message
+	Headers() map[string][]byte
+}
+
 // NewMessage creates a new Message with the values provided.
 func NewMessage(topic string, key, value []byte) Message {
-	return &defaultMessage{topic, key, value}
+	return &defaultMessage{topic: topic, key: key, value: value}
+}
+
+// NewMessageWithHeaders creates a new HeaderMessage with the values and
+// headers provided.
+func NewMessageWithHeaders(topic string, key, value []byte, headers map[string][]byte) HeaderMessage {
+	return &defaultMessage{topic: topic, key: key, value: value, headers: headers}
+}
+
+// MessageHeaders returns the headers of a message, or nil if it has none.
+func MessageHeaders(msg Message) map[string][]byte {
+	if m, ok := msg.(*ReceivedMessage); ok {
+		msg = m.Message
+	}
+	if m, ok := msg.(HeaderMessage); ok {
+		return m.Headers()
+	}
+	return nil
 }
 
 // defaultMessage is the default implementation of Message, which simply passes
 // through values without any further modification.
 type defaultMessage struct {
-	topic string
-	key   []byte
-	value []byte
+	topic   string
+	key     []byte
+	value   []byte
+	headers map[string][]byte
 }
 
 func (m *defaultMessage) Topic() string {
@@ -35,3 +62,7 @@ func (m *defaultMessage) Key() []byte {
 func (m *defaultMessage) Value() []byte {
 	return m.value
 }
+
+func (m *defaultMessage) Headers() map[string][]byte {
+	return m.headers
+}
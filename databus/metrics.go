@@ -0,0 +1,188 @@
+package databus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	zkmetrics "github.com/zenoss/zenkit/metrics"
+)
+
+// Producers record metrics for the messages sent with SendContext in the
+// metrics registry of its context. Consumers record metrics for the messages
+// they receive in the registry of the context given to Consume, Receive or
+// Subscribe or, absent that, the one given to WithConsumerMetrics. For a topic
+// "foo" they record:
+//
+//	databus.producer.foo.sent               a meter of messages sent
+//	databus.producer.foo.encode.time        a timer of encoding messages
+//	databus.producer.foo.bytes              a histogram of message sizes
+//	databus.producer.foo.errors.encode      a counter of messages that couldn't be encoded
+//	databus.producer.foo.errors.send        a counter of messages that couldn't be sent
+//	databus.consumer.foo.consumed           a meter of messages received
+//	databus.consumer.foo.decode.time        a timer of decoding messages
+//	databus.consumer.foo.bytes              a histogram of message sizes
+//	databus.consumer.foo.errors.decode      a counter of messages that couldn't be decoded
+//	databus.consumer.foo.errors.handler     a counter of messages Subscribe handlers failed
+//	databus.consumer.foo.lag.0              a gauge of the messages in partition 0 after the last marked
+//
+// Message sizes are the sizes of their encoded keys and values. The lag of a
+// partition is computed from its high-water mark each time it is read, so it
+// grows as messages are written even if the consumer stops marking them.
+const (
+	// ProducerMetricsPrefix is the prefix of the names of the metrics
+	// recorded when sending messages.
+	ProducerMetricsPrefix = "databus.producer"
+	// ConsumerMetricsPrefix is the prefix of the names of the metrics
+	// recorded when receiving messages.
+	ConsumerMetricsPrefix = "databus.consumer"
+)
+
+// The types of error counted by the errors metrics.
+const (
+	encodeError  = "encode"
+	sendError    = "send"
+	decodeError  = "decode"
+	handlerError = "handler"
+)
+
+// databusMetrics records the metrics of a producer or consumer of a topic.
+type databusMetrics struct {
+	registry metrics.Registry
+	prefix   string
+}
+
+// newDatabusMetrics returns the metrics of a topic in a registry. It returns
+// nil, which records nothing, if the registry is nil.
+func newDatabusMetrics(registry metrics.Registry, prefix, topic string) *databusMetrics {
+	if registry == nil {
+		return nil
+	}
+	r := strings.NewReplacer(".", "_", " ", "_")
+	return &databusMetrics{registry, fmt.Sprintf("%s.%s", prefix, r.Replace(topic))}
+}
+
+// producerMetrics returns the metrics of the topic a factory produces to in
+// the context's registry.
+func producerMetrics(ctx context.Context, factory MessageFactory) *databusMetrics {
+	return newDatabusMetrics(zkmetrics.ContextMetrics(ctx), ProducerMetricsPrefix, factory.Topic())
+}
+
+func (m *databusMetrics) name(name string) string {
+	return m.prefix + "." + name
+}
+
+// mark records an event on a meter.
+func (m *databusMetrics) mark(name string) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrRegisterMeter(m.name(name), m.registry).Mark(1)
+}
+
+// timeSince records the time since begin on a timer.
+func (m *databusMetrics) timeSince(name string, begin time.Time) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrRegisterTimer(m.name(name), m.registry).Update(zkmetrics.TimeFunc().Sub(begin))
+}
+
+// size records the size of a message.
+func (m *databusMetrics) size(msg Message) {
+	if m == nil {
+		return
+	}
+	h := metrics.GetOrRegisterHistogram(m.name("bytes"), m.registry, metrics.NewExpDecaySample(1028, 0.015))
+	h.Update(int64(len(msg.Key()) + len(msg.Value())))
+}
+
+// failed counts an error of the type provided.
+func (m *databusMetrics) failed(errType string) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrRegisterCounter(m.name("errors."+errType), m.registry).Inc(1)
+}
+
+// sent records the result of sending a message.
+func (m *databusMetrics) sent(err error) {
+	if err != nil {
+		m.failed(sendError)
+		return
+	}
+	m.mark("sent")
+}
+
+// received records a message received by a consumer.
+func (m *databusMetrics) received(msg Message) {
+	m.mark("consumed")
+	m.size(msg)
+}
+
+// lag registers a gauge of the number of messages in a partition after the
+// last marked, which calls f each time it is read.
+func (m *databusMetrics) lag(partition int32, f func() int64) {
+	if m == nil {
+		return
+	}
+	m.registry.GetOrRegister(fmt.Sprintf("%s.%d", m.name("lag"), partition), func() metrics.Gauge {
+		return metrics.NewFunctionalGauge(func() int64 {
+			if lag := f(); lag > 0 {
+				return lag
+			}
+			return 0
+		})
+	})
+}
+
+// encodeMessage encodes a message with the factory, with record headers
+// propagating the context, recording the time taken and its size in the
+// context's metrics registry.
+func encodeMessage(ctx context.Context, factory MessageFactory, key, value interface{}) (Message, *databusMetrics, error) {
+	m := producerMetrics(ctx, factory)
+	begin := zkmetrics.TimeFunc()
+	message, err := factory.Message(key, value)
+	if err != nil {
+		m.failed(encodeError)
+		return nil, m, err
+	}
+	m.timeSince("encode.time", begin)
+	m.size(message)
+	return withContextHeaders(ctx, message), m, nil
+}
+
+// registry returns the metrics registry of the context or, absent that, the
+// one the consumer was configured with.
+func (o *consumerOptions) registry(ctx context.Context) metrics.Registry {
+	if r := zkmetrics.ContextMetrics(ctx); r != nil {
+		return r
+	}
+	return o.metrics
+}
+
+// partitionLag returns the number of messages in a partition after the last
+// one a consumer marked.
+type partitionLag func(topic string, partition int32) int64
+
+// receivedMessage returns a ReceivedMessage that records its metrics in the
+// consumer's registry for the context, along with the lag of its partition if
+// the consumer can compute it, and reports that the consumer is making
+// progress.
+func (o *consumerOptions) receivedMessage(ctx context.Context, msg Message, partition int32, offset int64, factory MessageFactory, lag partitionLag) *ReceivedMessage {
+	o.progressed()
+	received := NewReceivedMessage(msg, partition, offset, factory)
+	received.consumer = o
+	received.metrics = newDatabusMetrics(o.registry(ctx), ConsumerMetricsPrefix, msg.Topic())
+	received.metrics.received(msg)
+	if lag != nil {
+		topic := msg.Topic()
+		o.position(topic, partition, offset)
+		received.metrics.lag(partition, func() int64 {
+			return lag(topic, partition)
+		})
+	}
+	return received
+}
@@ -0,0 +1,212 @@
+package databus_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	. "github.com/zenoss/zenkit/databus"
+	zkmetrics "github.com/zenoss/zenkit/metrics"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// highWaterMarkConsumer is a cluster consumer reporting high-water marks.
+type highWaterMarkConsumer struct {
+	*mockClusterConsumer
+	marks map[string]map[int32]int64
+}
+
+func (c *highWaterMarkConsumer) HighWaterMarks() map[string]map[int32]int64 {
+	return c.marks
+}
+
+var _ = Describe("Metrics", func() {
+
+	var (
+		registry metrics.Registry
+		ctx      context.Context
+		cancel   context.CancelFunc
+		broker   *MemoryBroker
+		factory  MessageFactory
+		producer DatabusProducer
+		topic    string
+		prefix   string
+	)
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+		ctx, cancel = context.WithTimeout(zkmetrics.WithMetrics(context.Background(), registry), time.Second)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		topic = test.RandString(8)
+		var err error
+		factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		broker = NewMemoryBroker(1)
+		producer = NewMemoryDatabusProducer(broker, factory)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	meter := func(name string) int64 {
+		if m, ok := registry.Get(prefix + name).(metrics.Meter); ok {
+			return m.Count()
+		}
+		return 0
+	}
+
+	counter := func(name string) int64 {
+		if c, ok := registry.Get(prefix + name).(metrics.Counter); ok {
+			return c.Count()
+		}
+		return 0
+	}
+
+	Context("of producers", func() {
+
+		BeforeEach(func() {
+			prefix = "databus.producer." + topic + "."
+		})
+
+		It("should record messages sent in the context's registry", func() {
+			for i := 0; i < 3; i++ {
+				Ω(producer.SendContext(ctx, KeyTest{SomeString: "a", AnInt: i}, ValTest{TotallyCool: "b"})).Should(Succeed())
+			}
+			Ω(meter("sent")).Should(BeNumerically("==", 3))
+			Ω(registry.Get(prefix + "encode.time").(metrics.Timer).Count()).Should(BeNumerically("==", 3))
+			size := registry.Get(prefix + "bytes").(metrics.Histogram)
+			Ω(size.Count()).Should(BeNumerically("==", 3))
+			Ω(size.Min()).Should(BeNumerically(">", 10))
+		})
+
+		It("should count errors by type", func() {
+			Ω(producer.SendContext(ctx, "not a key", ValTest{})).ShouldNot(Succeed())
+			Ω(counter("errors.encode")).Should(BeNumerically("==", 1))
+			producer.Close()
+			Ω(producer.SendContext(ctx, KeyTest{}, ValTest{})).ShouldNot(Succeed())
+			Ω(counter("errors.send")).Should(BeNumerically("==", 1))
+			Ω(meter("sent")).Should(BeZero())
+		})
+
+		It("should record nothing without a registry", func() {
+			Ω(producer.SendContext(context.Background(), KeyTest{}, ValTest{})).Should(Succeed())
+			Ω(producer.Send(KeyTest{}, ValTest{})).Should(Succeed())
+			Ω(registry.GetAll()).Should(BeEmpty())
+		})
+
+		It("should name metrics after topics", func() {
+			dotted, err := NewMessageFactory("a.b", "key-test", "val-test", factory.(SchemaCacheHaver).SchemaCache())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(NewMemoryDatabusProducer(broker, dotted).SendContext(ctx, KeyTest{}, ValTest{})).Should(Succeed())
+			Ω(registry.Get("databus.producer.a_b.sent")).ShouldNot(BeNil())
+		})
+	})
+
+	Context("of consumers", func() {
+
+		var consumer DatabusConsumer
+
+		BeforeEach(func() {
+			prefix = "databus.consumer." + topic + "."
+			for i := 0; i < 3; i++ {
+				Ω(producer.Send(KeyTest{SomeString: "a", AnInt: i}, ValTest{TotallyCool: "b"})).Should(Succeed())
+			}
+			var err error
+			consumer, err = NewMemoryDatabusConsumer(broker, factory, "group")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			consumer.Close()
+		})
+
+		lag := func() int64 {
+			return registry.Get(prefix + "lag.0").(metrics.Gauge).Value()
+		}
+
+		It("should record messages consumed and the lag of their partitions", func() {
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(meter("consumed")).Should(BeNumerically("==", 1))
+			Ω(registry.Get(prefix + "decode.time").(metrics.Timer).Count()).Should(BeNumerically("==", 1))
+			Ω(registry.Get(prefix + "bytes").(metrics.Histogram).Count()).Should(BeNumerically("==", 1))
+			Ω(lag()).Should(BeNumerically("==", 2))
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(lag()).Should(BeNumerically("==", 1))
+		})
+
+		It("should compute lag when it is read", func() {
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			Ω(lag()).Should(BeNumerically("==", 2))
+			Ω(producer.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "b"})).Should(Succeed())
+			Ω(lag()).Should(BeNumerically("==", 3))
+		})
+
+		It("should count messages that can't be decoded or handled", func() {
+			broker.Send(NewMessage(topic, []byte("nope"), []byte("nope")))
+			subCtx, stop := context.WithCancel(ctx)
+			handled := 0
+			err := Subscribe(subCtx, func(ctx context.Context, m *ReceivedMessage) error {
+				if handled++; handled == 4 {
+					defer stop()
+				}
+				var msg memoryTestMessage
+				if err := m.Decode(&msg); err != nil {
+					return err
+				}
+				if handled == 2 {
+					return errors.New("oops")
+				}
+				return nil
+			}, SubscribeOptions{Consumer: consumer, Workers: 1, FailurePolicy: SkipFailures()})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(meter("consumed")).Should(BeNumerically("==", 4))
+			Ω(counter("errors.decode")).Should(BeNumerically("==", 1))
+			Ω(counter("errors.handler")).Should(BeNumerically("==", 2))
+			Ω(lag()).Should(BeZero())
+		})
+
+		It("should fall back to the consumer's registry", func() {
+			own := metrics.NewRegistry()
+			c, err := NewMemoryDatabusConsumer(broker, factory, "other", WithConsumerMetrics(own))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer c.Close()
+			var msg memoryTestMessage
+			Ω(c.Consume(context.Background(), &msg)).Should(Succeed())
+			Ω(own.Get(prefix + "consumed")).ShouldNot(BeNil())
+			Ω(registry.Get(prefix + "consumed")).Should(BeNil())
+		})
+
+		It("should report the lag of Kafka partitions", func() {
+			mock := &highWaterMarkConsumer{newMockClusterConsumer(1), map[string]map[int32]int64{topic: {3: 10}}}
+			c, err := NewSaramaClusterDatabusConsumer(mock, factory)
+			Ω(err).ShouldNot(HaveOccurred())
+			message, _ := factory.Message(KeyTest{}, ValTest{})
+			mock.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: 3, Offset: 6, Key: message.Key(), Value: message.Value()}
+			var msg memoryTestMessage
+			Ω(c.Consume(ctx, &msg)).Should(Succeed())
+			Ω(registry.Get(prefix + "lag.3").(metrics.Gauge).Value()).Should(BeNumerically("==", 3))
+			mock.marks[topic][3] = 12
+			Ω(registry.Get(prefix + "lag.3").(metrics.Gauge).Value()).Should(BeNumerically("==", 5))
+		})
+
+		It("should be exposed in the Prometheus format", func() {
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			var buf bytes.Buffer
+			Ω(zkmetrics.WritePrometheus(&buf, registry)).Should(Succeed())
+			Ω(buf.String()).Should(ContainSubstring(zkmetrics.PrometheusName(prefix + "lag.0")))
+			Ω(buf.String()).Should(ContainSubstring(zkmetrics.PrometheusName(prefix + "consumed")))
+		})
+	})
+})
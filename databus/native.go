@@ -0,0 +1,667 @@
+package databus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+	// structFields caches the fields of each struct type converted.
+	structFields sync.Map
+)
+
+// nativeConverter converts between Go values and the native types goavro
+// encodes for a schema, following the schema rather than the Go types: ints
+// and longs stay integers, bytes stay bytes, unions are wrapped as goavro
+// expects and time.Time is a timestamp in a long. Struct fields are named
+// by their `json` tags, as for SchemaFor. Types with their own JSON encoding
+// are converted through it.
+type nativeConverter struct {
+	schema interface{}
+	names  map[string]interface{}
+}
+
+// newNativeConverter parses the schema provided.
+func newNativeConverter(schema string) (*nativeConverter, error) {
+	c := &nativeConverter{names: map[string]interface{}{}}
+	if err := json.Unmarshal([]byte(schema), &c.schema); err != nil {
+		return nil, errors.Wrap(err, "failed to parse schema")
+	}
+	indexNamedTypes(c.schema, "", c.names)
+	return c, nil
+}
+
+// toNative converts a Go value to goavro native types.
+func (c *nativeConverter) toNative(v interface{}) (interface{}, error) {
+	return c.to(c.schema, reflect.ValueOf(v))
+}
+
+// fromNative applies goavro native types to the pointer provided.
+func (c *nativeConverter) fromNative(native interface{}, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.Wrap(ErrInvalidMessageType, "cannot decode into a non-pointer")
+	}
+	return c.from(c.schema, native, v.Elem())
+}
+
+// deref replaces a reference to a named type with its definition.
+func (c *nativeConverter) deref(schema interface{}) interface{} {
+	if s, ok := schema.(string); ok && isPrimitive(s) {
+		return s
+	}
+	return deref(schema, c.names)
+}
+
+func mismatch(schema interface{}, v interface{}) error {
+	return errors.Wrapf(ErrInvalidMessageType, "cannot convert %T to %s", v, typeName(schema))
+}
+
+func (c *nativeConverter) to(schema interface{}, v reflect.Value) (interface{}, error) {
+	logical := logicalType(schema)
+	schema = c.deref(schema)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			v = reflect.Value{}
+			break
+		}
+		v = v.Elem()
+	}
+
+	if branches, ok := schema.([]interface{}); ok {
+		return c.toUnion(branches, v)
+	}
+	if !v.IsValid() {
+		if typeName(schema) == "null" {
+			return nil, nil
+		}
+		return nil, mismatch(schema, nil)
+	}
+	if v.Type() == timeType && typeName(schema) == "long" {
+		t := v.Interface().(time.Time)
+		if logical == "timestamp-micros" {
+			return t.UnixNano() / int64(time.Microsecond), nil
+		}
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		generic, err := jsonGeneric(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return c.to(schema, reflect.ValueOf(generic))
+	}
+
+	switch t := typeName(schema); t {
+	case "null":
+		return nil, mismatch(schema, v.Interface())
+	case "boolean":
+		if v.Kind() == reflect.Bool {
+			return v.Bool(), nil
+		}
+	case "int":
+		if n, ok := toInt(v); ok && n >= math.MinInt32 && n <= math.MaxInt32 {
+			return int32(n), nil
+		}
+	case "long":
+		if n, ok := toInt(v); ok {
+			return n, nil
+		}
+	case "float":
+		if f, ok := toFloat(v); ok {
+			return float32(f), nil
+		}
+	case "double":
+		if f, ok := toFloat(v); ok {
+			return f, nil
+		}
+	case "bytes":
+		if isBytes(v.Type()) {
+			return append([]byte(nil), v.Bytes()...), nil
+		}
+		if v.Kind() == reflect.String {
+			return []byte(v.String()), nil
+		}
+	case "string":
+		if v.Kind() == reflect.String {
+			return v.String(), nil
+		}
+		if isBytes(v.Type()) {
+			return string(v.Bytes()), nil
+		}
+	case "array":
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			items := schema.(map[string]interface{})["items"]
+			result := make([]interface{}, v.Len())
+			for i := range result {
+				item, err := c.to(items, v.Index(i))
+				if err != nil {
+					return nil, errors.Wrapf(err, "item %d", i)
+				}
+				result[i] = item
+			}
+			return result, nil
+		}
+	case "map":
+		if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+			values := schema.(map[string]interface{})["values"]
+			result := make(map[string]interface{}, v.Len())
+			for _, k := range v.MapKeys() {
+				value, err := c.to(values, v.MapIndex(k))
+				if err != nil {
+					return nil, errors.Wrapf(err, "key %s", k.String())
+				}
+				result[k.String()] = value
+			}
+			return result, nil
+		}
+	default:
+		s, _ := schema.(map[string]interface{})
+		switch s["type"] {
+		case "record", "error":
+			if v.Kind() == reflect.Struct || v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+				return c.toRecord(s, v)
+			}
+		case "enum":
+			if v.Kind() == reflect.String {
+				return v.String(), nil
+			}
+		case "fixed":
+			if isBytes(v.Type()) {
+				return append([]byte(nil), v.Bytes()...), nil
+			}
+			if v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 {
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				return b, nil
+			}
+		}
+	}
+	return nil, mismatch(schema, v.Interface())
+}
+
+// toUnion wraps a value in the first branch of a union it can be converted
+// to, or returns nil if it is nil and the union allows null.
+func (c *nativeConverter) toUnion(branches []interface{}, v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		for _, b := range branches {
+			if typeName(c.deref(b)) == "null" {
+				return nil, nil
+			}
+		}
+		return nil, errors.Wrap(ErrInvalidMessageType, "union does not allow null")
+	}
+	for _, b := range branches {
+		name := typeName(c.deref(b))
+		if name == "null" {
+			continue
+		}
+		if native, err := c.to(b, v); err == nil {
+			return map[string]interface{}{name: native}, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrInvalidMessageType, "no branch of union matches %s", v.Type())
+}
+
+// toRecord converts a struct, or a map with string keys, to a record. Fields
+// the value doesn't have take their defaults.
+func (c *nativeConverter) toRecord(schema map[string]interface{}, v reflect.Value) (interface{}, error) {
+	fs := fields(schema)
+	result := make(map[string]interface{}, len(fs))
+	for _, f := range fs {
+		name, _ := f["name"].(string)
+		var (
+			fv       reflect.Value
+			asString bool
+		)
+		if v.Kind() == reflect.Struct {
+			if sf := lookupField(v.Type(), name); sf != nil {
+				fv, _ = fieldByIndex(v, sf.index, false)
+				asString = sf.asString
+			}
+		} else {
+			fv = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		}
+		if !fv.IsValid() {
+			def, ok := f["default"]
+			if !ok {
+				return nil, errors.Wrapf(ErrInvalidMessageType, "no value or default for field %s", name)
+			}
+			native, err := (&schemaResolver{readerNames: c.names}).defaultValue(f["type"], def)
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", name)
+			}
+			result[name] = native
+			continue
+		}
+		if asString && isScalar(fv.Type()) {
+			fv = reflect.ValueOf(fmt.Sprint(fv.Interface()))
+		}
+		native, err := c.to(f["type"], fv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", name)
+		}
+		result[name] = native
+	}
+	return result, nil
+}
+
+func (c *nativeConverter) from(schema, native interface{}, v reflect.Value) error {
+	logical := logicalType(schema)
+	schema = c.deref(schema)
+
+	if branches, ok := schema.([]interface{}); ok && native != nil {
+		m, ok := native.(map[string]interface{})
+		if ok && len(m) == 1 {
+			for name, value := range m {
+				for _, b := range branches {
+					if typeName(c.deref(b)) == name {
+						return c.from(b, value, v)
+					}
+				}
+			}
+		}
+		return errors.Wrapf(ErrInvalidMessageType, "%T is not a union value", native)
+	}
+	if native == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return c.from(schema, native, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(c.generic(schema, native)))
+			return nil
+		}
+	}
+	if v.Type() == timeType {
+		if n, ok := native.(int64); ok {
+			unit := time.Millisecond
+			if logical == "timestamp-micros" {
+				unit = time.Microsecond
+			}
+			v.Set(reflect.ValueOf(time.Unix(0, n*int64(unit)).UTC()))
+			return nil
+		}
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(jsonUnmarshalerType) {
+		b, err := json.Marshal(c.generic(schema, native))
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal value to json")
+		}
+		return v.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(b)
+	}
+
+	switch n := native.(type) {
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(n)
+			return nil
+		}
+	case int32:
+		return setInt(v, int64(n))
+	case int64:
+		return setInt(v, n)
+	case float32:
+		return setFloat(v, float64(n))
+	case float64:
+		return setFloat(v, n)
+	case []byte:
+		switch {
+		case isBytes(v.Type()):
+			v.SetBytes(append([]byte(nil), n...))
+			return nil
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			reflect.Copy(v, reflect.ValueOf(n))
+			return nil
+		case v.Kind() == reflect.String:
+			v.SetString(string(n))
+			return nil
+		}
+	case string:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(n)
+			return nil
+		case isBytes(v.Type()):
+			v.SetBytes([]byte(n))
+			return nil
+		}
+	case []interface{}:
+		if typeName(schema) != "array" {
+			break
+		}
+		items := schema.(map[string]interface{})["items"]
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), len(n), len(n)))
+		case reflect.Array:
+			if len(n) > v.Len() {
+				n = n[:v.Len()]
+			}
+		default:
+			return mismatch(schema, v.Interface())
+		}
+		for i, item := range n {
+			if err := c.from(items, item, v.Index(i)); err != nil {
+				return errors.Wrapf(err, "item %d", i)
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		s, ok := schema.(map[string]interface{})
+		if !ok {
+			break
+		}
+		if typeName(s) == "map" {
+			return c.fromMap(s["values"], nil, n, v)
+		}
+		if v.Kind() == reflect.Struct {
+			return c.fromRecord(s, n, v)
+		}
+		fieldSchemas := map[string]interface{}{}
+		for _, f := range fields(s) {
+			name, _ := f["name"].(string)
+			fieldSchemas[name] = f["type"]
+		}
+		return c.fromMap(nil, fieldSchemas, n, v)
+	}
+	return mismatch(schema, v.Interface())
+}
+
+// fromRecord applies a record to a struct. Fields the struct doesn't have
+// are ignored.
+func (c *nativeConverter) fromRecord(schema map[string]interface{}, record map[string]interface{}, v reflect.Value) error {
+	for _, f := range fields(schema) {
+		name, _ := f["name"].(string)
+		sf := lookupField(v.Type(), name)
+		if sf == nil {
+			continue
+		}
+		fv, ok := fieldByIndex(v, sf.index, true)
+		if !ok {
+			continue
+		}
+		value := record[name]
+		if s, ok := value.(string); ok && sf.asString && fv.Kind() != reflect.String && isScalar(fv.Type()) {
+			if err := json.Unmarshal([]byte(s), fv.Addr().Interface()); err != nil {
+				return errors.Wrapf(err, "field %s", name)
+			}
+			continue
+		}
+		if err := c.from(f["type"], value, fv); err != nil {
+			return errors.Wrapf(err, "field %s", name)
+		}
+	}
+	return nil
+}
+
+// fromMap applies a map or record to a map with string keys. A map's values
+// all have the same schema, while a record's fields have their own.
+func (c *nativeConverter) fromMap(values interface{}, fieldSchemas map[string]interface{}, m map[string]interface{}, v reflect.Value) error {
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return errors.Wrapf(ErrInvalidMessageType, "cannot convert map to %s", v.Type())
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), len(m)))
+	}
+	for k, value := range m {
+		s := values
+		if fieldSchemas != nil {
+			s = fieldSchemas[k]
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := c.from(s, value, elem); err != nil {
+			return errors.Wrapf(err, "key %s", k)
+		}
+		v.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+	}
+	return nil
+}
+
+// generic converts goavro native types to the types they would be decoded
+// into by encoding/json, unwrapping unions.
+func (c *nativeConverter) generic(schema, native interface{}) interface{} {
+	schema = c.deref(schema)
+	switch n := native.(type) {
+	case []interface{}:
+		s, ok := schema.(map[string]interface{})
+		if !ok {
+			return native
+		}
+		items := s["items"]
+		result := make([]interface{}, len(n))
+		for i, item := range n {
+			result[i] = c.generic(items, item)
+		}
+		return result
+	case map[string]interface{}:
+		if branches, ok := schema.([]interface{}); ok {
+			for name, value := range n {
+				for _, b := range branches {
+					if typeName(c.deref(b)) == name {
+						return c.generic(b, value)
+					}
+				}
+			}
+			return nil
+		}
+		s, ok := schema.(map[string]interface{})
+		if !ok {
+			return native
+		}
+		result := make(map[string]interface{}, len(n))
+		if typeName(s) == "map" {
+			for k, value := range n {
+				result[k] = c.generic(s["values"], value)
+			}
+			return result
+		}
+		for _, f := range fields(s) {
+			name, _ := f["name"].(string)
+			result[name] = c.generic(f["type"], n[name])
+		}
+		return result
+	}
+	return native
+}
+
+// logicalType returns the logical type of a schema, if any.
+func logicalType(schema interface{}) string {
+	if s, ok := schema.(map[string]interface{}); ok {
+		t, _ := s["logicalType"].(string)
+		return t
+	}
+	return ""
+}
+
+// jsonGeneric converts a value to the types encoding/json decodes it into.
+func jsonGeneric(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal data to json")
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal data from json")
+	}
+	return generic, nil
+}
+
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+func toInt(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		return int64(n), n <= math.MaxInt64
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit
+		return int64(f), f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+	}
+	return 0, false
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
+
+func setInt(v reflect.Value, n int64) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !v.OverflowInt(n) {
+			v.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n >= 0 && !v.OverflowUint(uint64(n)) {
+			v.SetUint(uint64(n))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+		return nil
+	}
+	return errors.Wrapf(ErrInvalidMessageType, "cannot set %s to %d", v.Type(), n)
+}
+
+func setFloat(v reflect.Value, f float64) error {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if !v.OverflowFloat(f) {
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if f == math.Trunc(f) {
+			return setInt(v, int64(f))
+		}
+	}
+	return errors.Wrapf(ErrInvalidMessageType, "cannot set %s to %v", v.Type(), f)
+}
+
+// structField is a field of a struct as encoding/json sees it.
+type structField struct {
+	name     string
+	index    []int
+	typ      reflect.Type
+	asString bool
+}
+
+// cachedFields returns the fields of a struct type, computing them the first
+// time the type is seen.
+func cachedFields(t reflect.Type) []structField {
+	if f, ok := structFields.Load(t); ok {
+		return f.([]structField)
+	}
+	f, _ := structFields.LoadOrStore(t, typeFields(t, nil))
+	return f.([]structField)
+}
+
+// typeFields returns the fields of a struct type, following the rules
+// encoding/json uses to name, skip and promote them.
+func typeFields(t reflect.Type, index []int) []structField {
+	var result []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma+1:]
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				result = append(result, typeFields(ft, fieldIndex)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// Unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		result = append(result, structField{
+			name:     name,
+			index:    fieldIndex,
+			typ:      f.Type,
+			asString: hasOption(opts, "string") && isScalar(f.Type),
+		})
+	}
+	return result
+}
+
+// lookupField finds the field of a struct type with a name, preferring an
+// exact match but falling back to a case-insensitive one like encoding/json.
+func lookupField(t reflect.Type, name string) *structField {
+	fs := cachedFields(t)
+	for i := range fs {
+		if fs[i].name == name {
+			return &fs[i]
+		}
+	}
+	for i := range fs {
+		if strings.EqualFold(fs[i].name, name) {
+			return &fs[i]
+		}
+	}
+	return nil
+}
+
+// fieldByIndex returns a nested field of a struct, allocating the embedded
+// structs it passes through if alloc is set.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,155 @@
+package databus_test
+
+import (
+	"time"
+
+	"github.com/linkedin/goavro"
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type nativeTestChild struct {
+	Name string `json:"name"`
+}
+
+type nativeTestValue struct {
+	ID       int64                      `json:"id"`
+	Count    int32                      `json:"count"`
+	Ratio    float64                    `json:"ratio"`
+	Enabled  bool                       `json:"enabled"`
+	Raw      []byte                     `json:"raw"`
+	At       time.Time                  `json:"at"`
+	Optional *string                    `json:"optional"`
+	Missing  *string                    `json:"missing"`
+	Tags     []string                   `json:"tags"`
+	Labels   map[string]int64           `json:"labels"`
+	Child    nativeTestChild            `json:"child"`
+	Children map[string]nativeTestChild `json:"children"`
+	Quoted   int                        `json:"quoted,string"`
+}
+
+var _ = Describe("Native conversion", func() {
+
+	var (
+		factory MessageFactory
+		value   nativeTestValue
+	)
+
+	BeforeEach(func() {
+		schema, err := SchemaFor(nativeTestValue{})
+		Ω(err).ShouldNot(HaveOccurred())
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key", `"string"`)
+		client.RegisterNewSchema("value", schema)
+		factory, err = NewMessageFactory("topic", "key", "value", client)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		optional := "here"
+		value = nativeTestValue{
+			ID:       1<<62 + 1,
+			Count:    7,
+			Ratio:    0.5,
+			Enabled:  true,
+			Raw:      []byte{0, 1, 2, 255},
+			At:       time.Date(2018, 1, 2, 3, 4, 5, 6000000, time.UTC),
+			Optional: &optional,
+			Tags:     []string{"a", "b"},
+			Labels:   map[string]int64{"big": 1<<53 + 1},
+			Child:    nativeTestChild{"child"},
+			Children: map[string]nativeTestChild{"x": {"grandchild"}},
+			Quoted:   42,
+		}
+	})
+
+	It("should round trip values without losing precision", func() {
+		msg, err := factory.Message("key", value)
+		Ω(err).ShouldNot(HaveOccurred())
+		var (
+			key     string
+			decoded nativeTestValue
+		)
+		Ω(factory.Decode(msg, &key, &decoded)).Should(Succeed())
+		Ω(decoded).Should(Equal(value))
+	})
+
+	It("should encode values as their Avro types", func() {
+		msg, err := factory.Message("key", value)
+		Ω(err).ShouldNot(HaveOccurred())
+		codec, err := goavro.NewCodec(factory.ValueCodec().Schema())
+		Ω(err).ShouldNot(HaveOccurred())
+		native, _, err := codec.NativeFromBinary(stripAvroHeader(msg.Value()))
+		Ω(err).ShouldNot(HaveOccurred())
+		record := native.(map[string]interface{})
+		Ω(record["id"]).Should(Equal(int64(1<<62 + 1)))
+		Ω(record["raw"]).Should(Equal([]byte{0, 1, 2, 255}))
+		Ω(record["at"]).Should(Equal(value.At.UnixNano() / int64(time.Millisecond)))
+		Ω(record["optional"]).Should(Equal(map[string]interface{}{"string": "here"}))
+		Ω(record["missing"]).Should(BeNil())
+		Ω(record["quoted"]).Should(Equal("42"))
+	})
+
+	It("should decode records into maps", func() {
+		msg, err := factory.Message("key", value)
+		Ω(err).ShouldNot(HaveOccurred())
+		var (
+			key     string
+			decoded map[string]interface{}
+		)
+		Ω(factory.Decode(msg, &key, &decoded)).Should(Succeed())
+		Ω(decoded["id"]).Should(Equal(int64(1<<62 + 1)))
+		Ω(decoded["optional"]).Should(Equal("here"))
+		Ω(decoded["child"]).Should(Equal(map[string]interface{}{"name": "child"}))
+	})
+
+	It("should encode maps as records", func() {
+		msg, err := factory.Message("key", map[string]interface{}{
+			"id":       1,
+			"count":    2,
+			"ratio":    0.25,
+			"enabled":  false,
+			"raw":      []byte("raw"),
+			"at":       value.At,
+			"tags":     []interface{}{"a"},
+			"labels":   map[string]interface{}{},
+			"child":    map[string]interface{}{"name": "c"},
+			"children": map[string]interface{}{},
+			"quoted":   "1",
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		var (
+			key     string
+			decoded nativeTestValue
+		)
+		Ω(factory.Decode(msg, &key, &decoded)).Should(Succeed())
+		Ω(decoded.ID).Should(BeNumerically("==", 1))
+		Ω(decoded.Child.Name).Should(Equal("c"))
+		Ω(decoded.Optional).Should(BeNil())
+	})
+
+	It("should fail to encode values that don't match the schema", func() {
+		_, err := factory.Message("key", struct{ ID string }{"nope"})
+		Ω(err).Should(HaveOccurred())
+		_, err = factory.Message("key", map[string]interface{}{"id": "nope"})
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should fail to encode integers too large for a long", func() {
+		record := func(id interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"id": id, "count": 2, "ratio": 0.25, "enabled": false, "raw": []byte{},
+				"at": value.At, "tags": []interface{}{}, "labels": map[string]interface{}{},
+				"child": map[string]interface{}{"name": "c"}, "children": map[string]interface{}{}, "quoted": "1",
+			}
+		}
+		_, err := factory.Message("key", record(float64(1<<62)))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = factory.Message("key", record(uint64(1<<63-1)))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = factory.Message("key", record(uint64(1<<63)))
+		Ω(err).Should(HaveOccurred())
+		_, err = factory.Message("key", record(float64(1<<63)))
+		Ω(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,334 @@
+package databus
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidOffsetReset is returned when resetting offsets to an
+	// OffsetReset that names no offsets, or offsets of partitions that
+	// don't exist.
+	ErrInvalidOffsetReset = errors.New("invalid offset reset")
+)
+
+// OffsetReset names the offsets a consumer group's offsets are reset to by
+// ResetOffsets: the oldest or newest offset of each partition, the offset of
+// the first message written at or after a time, or an offset per partition.
+type OffsetReset struct {
+	position int64
+	time     time.Time
+	offsets  map[int32]int64
+}
+
+// ResetToOldest resets each partition to its oldest message, to reprocess
+// every message retained.
+func ResetToOldest() OffsetReset {
+	return OffsetReset{position: sarama.OffsetOldest}
+}
+
+// ResetToNewest resets each partition past its newest message, to skip every
+// message not yet processed.
+func ResetToNewest() OffsetReset {
+	return OffsetReset{position: sarama.OffsetNewest}
+}
+
+// ResetToTime resets each partition to the first message written at or after
+// the time provided, or past its newest message if there is none.
+func ResetToTime(t time.Time) OffsetReset {
+	return OffsetReset{time: t}
+}
+
+// ResetToOffsets resets each partition provided to its offset, leaving the
+// other partitions as they are.
+func ResetToOffsets(offsets map[int32]int64) OffsetReset {
+	return OffsetReset{offsets: offsets}
+}
+
+// ParseOffsetReset returns the OffsetReset described by a string, e.g. a
+// command-line argument: "oldest" or "earliest", "newest" or "latest", an
+// RFC 3339 time, a duration such as "1h" for that long ago, or a
+// comma-separated list of partition=offset pairs such as "0=42,1=17".
+func ParseOffsetReset(s string) (OffsetReset, error) {
+	switch strings.ToLower(s) {
+	case "oldest", "earliest":
+		return ResetToOldest(), nil
+	case "newest", "latest":
+		return ResetToNewest(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return ResetToTime(t), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return ResetToTime(time.Now().Add(-d)), nil
+	}
+	offsets := map[int32]int64{}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return OffsetReset{}, errors.Wrapf(ErrInvalidOffsetReset, "can't parse %q", s)
+		}
+		partition, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+		if err != nil {
+			return OffsetReset{}, errors.Wrapf(ErrInvalidOffsetReset, "invalid partition %q", parts[0])
+		}
+		offset, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil || offset < 0 {
+			return OffsetReset{}, errors.Wrapf(ErrInvalidOffsetReset, "invalid offset %q", parts[1])
+		}
+		offsets[int32(partition)] = offset
+	}
+	return ResetToOffsets(offsets), nil
+}
+
+func (r OffsetReset) String() string {
+	switch {
+	case r.offsets != nil:
+		partitions := make([]int, 0, len(r.offsets))
+		for p := range r.offsets {
+			partitions = append(partitions, int(p))
+		}
+		sort.Ints(partitions)
+		pairs := make([]string, len(partitions))
+		for i, p := range partitions {
+			pairs[i] = fmt.Sprintf("%d=%d", p, r.offsets[int32(p)])
+		}
+		return strings.Join(pairs, ",")
+	case !r.time.IsZero():
+		return r.time.Format(time.RFC3339)
+	case r.position == sarama.OffsetOldest:
+		return "oldest"
+	case r.position == sarama.OffsetNewest:
+		return "newest"
+	}
+	return ""
+}
+
+// offsetLookup returns the offset of a partition at a time, in milliseconds
+// since the epoch, or at sarama.OffsetOldest or sarama.OffsetNewest, as
+// sarama.Client.GetOffset does.
+type offsetLookup func(partition int32, time int64) (int64, error)
+
+// resolve returns the offset to reset a partition to, and whether it is
+// reset at all.
+func (r OffsetReset) resolve(partition int32, lookup offsetLookup) (int64, bool, error) {
+	switch {
+	case r.offsets != nil:
+		offset, ok := r.offsets[partition]
+		return offset, ok, nil
+	case !r.time.IsZero():
+		offset, err := lookup(partition, r.time.UnixNano()/int64(time.Millisecond))
+		if err == nil && offset < 0 {
+			// No message has been written since
+			offset, err = lookup(partition, sarama.OffsetNewest)
+		}
+		return offset, err == nil, err
+	case r.position != 0:
+		offset, err := lookup(partition, r.position)
+		return offset, err == nil, err
+	}
+	return 0, false, errors.WithStack(ErrInvalidOffsetReset)
+}
+
+// validate ensures that every partition the reset names exists.
+func (r OffsetReset) validate(partitions []int32) error {
+	for p := range r.offsets {
+		found := false
+		for _, partition := range partitions {
+			found = found || partition == p
+		}
+		if !found {
+			return errors.Wrapf(ErrInvalidOffsetReset, "partition %d doesn't exist", p)
+		}
+	}
+	return nil
+}
+
+// ResetOffsets resets the offsets a consumer group has committed for the
+// partitions of a topic, through sarama's offset manager, and returns the
+// offsets committed. The group's consumers should be stopped first, since
+// they would otherwise commit their own offsets over the new ones; they
+// resume from the new offsets when they next join the group. Errors
+// committing the offsets are only returned if the client's configuration
+// sets Consumer.Return.Errors.
+func ResetOffsets(client sarama.Client, groupId, topic string, reset OffsetReset) (map[int32]int64, error) {
+	return resetOffsets(client, groupId, topic, reset, false)
+}
+
+// ResetGroupOffsets resets the offsets a consumer group has committed for
+// the partitions of a topic, as ResetOffsets does, connecting to the Kafka
+// brokers provided with the KafkaOptions provided, e.g. from a command-line
+// tool.
+func ResetGroupOffsets(brokers []string, groupId, topic string, reset OffsetReset, kafka KafkaOptions) (map[int32]int64, error) {
+	config := kafka.Config()
+	config.Consumer.Return.Errors = true
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kafka client")
+	}
+	defer client.Close()
+	return ResetOffsets(client, groupId, topic, reset)
+}
+
+// resetOffsets resets the offsets of a consumer group for the partitions of
+// a topic, or only those for which it has committed no offset.
+func resetOffsets(client sarama.Client, groupId, topic string, reset OffsetReset, uncommitted bool) (map[int32]int64, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get partitions of topic %s", topic)
+	}
+	if err := reset.validate(partitions); err != nil {
+		return nil, err
+	}
+	om, err := sarama.NewOffsetManagerFromClient(groupId, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create offset manager")
+	}
+
+	result := map[int32]int64{}
+	var poms []sarama.PartitionOffsetManager
+	for _, p := range partitions {
+		offset, ok, err := reset.resolve(p, func(partition int32, time int64) (int64, error) {
+			return client.GetOffset(topic, partition, time)
+		})
+		if err != nil {
+			om.Close()
+			return nil, errors.Wrapf(err, "failed to get offset of partition %d", p)
+		}
+		if !ok {
+			continue
+		}
+		pom, err := om.ManagePartition(topic, p)
+		if err != nil {
+			om.Close()
+			return nil, errors.Wrapf(err, "failed to manage offset of partition %d", p)
+		}
+		poms = append(poms, pom)
+		next, _ := pom.NextOffset()
+		if uncommitted && next >= 0 {
+			continue
+		}
+		// The offset manager only moves offsets forward when they are marked,
+		// and back when they are reset. Partitions without a committed offset
+		// are at the initial offset, before any other.
+		if offset > next {
+			pom.MarkOffset(offset, "")
+		} else {
+			pom.ResetOffset(offset, "")
+		}
+		result[p] = offset
+	}
+
+	// Closing the offset manager commits the offsets, reporting the errors
+	// committing them to the partition offset managers, which it closes
+	errs := make(chan error, len(poms))
+	for _, pom := range poms {
+		go func(pom sarama.PartitionOffsetManager) {
+			var err error
+			for e := range pom.Errors() {
+				if err == nil {
+					err = e
+				}
+			}
+			errs <- err
+		}(pom)
+	}
+	om.Close()
+	var commitErr error
+	for range poms {
+		if err := <-errs; err != nil && commitErr == nil {
+			commitErr = err
+		}
+	}
+	if commitErr != nil {
+		return nil, errors.Wrapf(commitErr, "failed to commit offsets of group %s", groupId)
+	}
+	return result, nil
+}
+
+// WithStartTime starts a consumer group that has committed no offset for a
+// partition from the first message written to it at or after the time
+// provided, instead of its initial offset, the first time it joins. It has
+// no effect on consumers created from an existing cluster.Consumer.
+func WithStartTime(t time.Time) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.startTime = t
+	}
+}
+
+// startOffsets commits the offsets at the consumer's start time for the
+// partitions of the topics provided for which its group has committed no
+// offset.
+func (o *consumerOptions) startOffsets(brokers []string, config *sarama.Config, groupId string, topics []string) error {
+	if o.startTime.IsZero() {
+		return nil
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create kafka client")
+	}
+	defer client.Close()
+	for _, topic := range topics {
+		if _, err := resetOffsets(client, groupId, topic, ResetToTime(o.startTime), true); err != nil {
+			return errors.Wrapf(err, "failed to start topic %s at %s", topic, o.startTime)
+		}
+	}
+	return nil
+}
+
+// ResetOffsets resets the offsets of a consumer group for the partitions of
+// a topic, as ResetOffsets does for Kafka. Unlike Kafka, the group's
+// consumers needn't be stopped first: they receive from the new offsets.
+func (b *MemoryBroker) ResetOffsets(group, topic string, reset OffsetReset) (map[int32]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.resetOffsets(group, topic, reset, false)
+}
+
+// resetOffsets resets the offsets of a consumer group for the partitions of
+// a topic, or only those from which it has neither received nor processed a
+// message. The caller must hold the lock.
+func (b *MemoryBroker) resetOffsets(group, topic string, reset OffsetReset, uncommitted bool) (map[int32]int64, error) {
+	partitions := make([]int32, b.partitions)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	if err := reset.validate(partitions); err != nil {
+		return nil, err
+	}
+	log, sent := b.topic(topic), b.sent[topic]
+	g := b.group(group)
+	position := topicOffsets(g.position, topic, b.partitions)
+	committed := topicOffsets(g.committed, topic, b.partitions)
+
+	result := map[int32]int64{}
+	for _, p := range partitions {
+		offset, ok, err := reset.resolve(p, func(partition int32, t int64) (int64, error) {
+			switch t {
+			case sarama.OffsetOldest:
+				return 0, nil
+			case sarama.OffsetNewest:
+				return int64(len(log[partition])), nil
+			}
+			at := time.Unix(0, t*int64(time.Millisecond))
+			return int64(sort.Search(len(sent[partition]), func(i int) bool {
+				return !sent[partition][i].Before(at)
+			})), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok || (uncommitted && (position[p] > 0 || committed[p] > 0)) {
+			continue
+		}
+		position[p], committed[p] = offset, offset
+		result[p] = offset
+	}
+	return result, nil
+}
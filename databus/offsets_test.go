@@ -0,0 +1,255 @@
+package databus_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Offset reset", func() {
+
+	It("should parse offset resets", func() {
+		for s, expected := range map[string]string{
+			"earliest":             "oldest",
+			"Oldest":               "oldest",
+			"latest":               "newest",
+			"2026-01-02T15:04:05Z": "2026-01-02T15:04:05Z",
+			"1=17, 0=42":           "0=42,1=17",
+		} {
+			reset, err := ParseOffsetReset(s)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(reset.String()).Should(Equal(expected))
+		}
+		reset, err := ParseOffsetReset("1h")
+		Ω(err).ShouldNot(HaveOccurred())
+		t, err := time.Parse(time.RFC3339, reset.String())
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(t).Should(BeTemporally("~", time.Now().Add(-time.Hour), 2*time.Second))
+
+		for _, s := range []string{"", "yesterday", "0=x", "x=1", "0=-1"} {
+			_, err := ParseOffsetReset(s)
+			Ω(errors.Cause(err)).Should(Equal(ErrInvalidOffsetReset), s)
+		}
+	})
+
+	Context("on a MemoryBroker", func() {
+
+		var (
+			ctx      context.Context
+			cancel   context.CancelFunc
+			broker   *MemoryBroker
+			factory  MessageFactory
+			producer DatabusProducer
+			topic    string
+		)
+
+		send := func(from, to int) {
+			for i := from; i < to; i++ {
+				Ω(producer.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: fmt.Sprint(i)})).Should(Succeed())
+			}
+		}
+
+		next := func(consumer DatabusConsumer) string {
+			var msg memoryTestMessage
+			Ω(consumer.Consume(ctx, &msg)).Should(Succeed())
+			return msg.Value.TotallyCool
+		}
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+			client := NewMemorySchemaRegistryClient()
+			client.RegisterNewSchema("key-test", keyTestSchema)
+			client.RegisterNewSchema("val-test", valTestSchema)
+			topic = test.RandString(8)
+			var err error
+			factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+			Ω(err).ShouldNot(HaveOccurred())
+			broker = NewMemoryBroker(1)
+			producer = NewMemoryDatabusProducer(broker, factory)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("should replay messages from the oldest offset or a given offset", func() {
+			send(0, 3)
+			consumer, err := NewMemoryDatabusConsumer(broker, factory, "group")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Close()
+			for i := 0; i < 3; i++ {
+				next(consumer)
+			}
+
+			offsets, err := broker.ResetOffsets("group", topic, ResetToOldest())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 0}))
+			Ω(next(consumer)).Should(Equal("0"))
+
+			_, err = broker.ResetOffsets("group", topic, ResetToOffsets(map[int32]int64{0: 2}))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(broker.Offsets("group", topic)).Should(Equal([]int64{2}))
+			Ω(next(consumer)).Should(Equal("2"))
+		})
+
+		It("should skip to the newest offset", func() {
+			send(0, 3)
+			offsets, err := broker.ResetOffsets("group", topic, ResetToNewest())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 3}))
+			send(3, 4)
+			consumer, err := NewMemoryDatabusConsumer(broker, factory, "group")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Close()
+			Ω(next(consumer)).Should(Equal("3"))
+		})
+
+		It("should reset to the first message at or after a time", func() {
+			send(0, 2)
+			time.Sleep(5 * time.Millisecond)
+			t := time.Now()
+			time.Sleep(5 * time.Millisecond)
+			send(2, 4)
+			offsets, err := broker.ResetOffsets("group", topic, ResetToTime(t))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 2}))
+			offsets, err = broker.ResetOffsets("group", topic, ResetToTime(time.Now().Add(time.Hour)))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 4}))
+		})
+
+		It("should start a new group at its start time", func() {
+			send(0, 2)
+			time.Sleep(5 * time.Millisecond)
+			t := time.Now()
+			time.Sleep(5 * time.Millisecond)
+			send(2, 3)
+			consumer, err := NewMemoryDatabusConsumer(broker, factory, "group", WithStartTime(t))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(next(consumer)).Should(Equal("2"))
+			consumer.Close()
+
+			// A group that has already consumed resumes where it left off
+			send(3, 4)
+			consumer, err = NewMemoryDatabusConsumer(broker, factory, "group", WithStartTime(t))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Close()
+			Ω(next(consumer)).Should(Equal("3"))
+		})
+
+		It("should reject partitions that don't exist", func() {
+			_, err := broker.ResetOffsets("group", topic, ResetToOffsets(map[int32]int64{3: 0}))
+			Ω(errors.Cause(err)).Should(Equal(ErrInvalidOffsetReset))
+			_, err = broker.ResetOffsets("group", topic, OffsetReset{})
+			Ω(errors.Cause(err)).Should(Equal(ErrInvalidOffsetReset))
+		})
+	})
+
+	Context("on Kafka", func() {
+
+		var (
+			broker   *sarama.MockBroker
+			handlers map[string]sarama.MockResponse
+			client   sarama.Client
+			at       time.Time
+		)
+
+		BeforeEach(func() {
+			at = time.Now().Add(-time.Hour)
+			broker = sarama.NewMockBroker(GinkgoT(), 1)
+			handlers = map[string]sarama.MockResponse{
+				"MetadataRequest": sarama.NewMockMetadataResponse(GinkgoT()).
+					SetBroker(broker.Addr(), broker.BrokerID()).
+					SetLeader("events", 0, broker.BrokerID()).
+					SetLeader("events", 1, broker.BrokerID()),
+				"OffsetRequest": sarama.NewMockOffsetResponse(GinkgoT()).
+					SetVersion(1).
+					SetOffset("events", 0, sarama.OffsetOldest, 10).
+					SetOffset("events", 1, sarama.OffsetOldest, 20).
+					SetOffset("events", 0, sarama.OffsetNewest, 100).
+					SetOffset("events", 1, sarama.OffsetNewest, 200).
+					SetOffset("events", 0, at.UnixNano()/int64(time.Millisecond), 50).
+					SetOffset("events", 1, at.UnixNano()/int64(time.Millisecond), -1),
+				"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(GinkgoT()).
+					SetCoordinator(sarama.CoordinatorGroup, "group", broker),
+				"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(GinkgoT()).
+					SetOffset("group", "events", 0, 60, "", sarama.ErrNoError).
+					SetOffset("group", "events", 1, -1, "", sarama.ErrNoError),
+				"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(GinkgoT()),
+			}
+			broker.SetHandlerByMap(handlers)
+			config := KafkaOptions{}.Config()
+			config.Consumer.Return.Errors = true
+			var err error
+			client, err = sarama.NewClient([]string{broker.Addr()}, config)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			client.Close()
+			broker.Close()
+		})
+
+		// committed returns the offsets the group last committed for each
+		// partition.
+		committed := func() map[int32]int64 {
+			offsets := map[int32]int64{}
+			for _, rr := range broker.History() {
+				if req, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+					for _, p := range []int32{0, 1} {
+						if offset, _, err := req.Offset("events", p); err == nil {
+							offsets[p] = offset
+						}
+					}
+				}
+			}
+			return offsets
+		}
+
+		It("should commit the offsets of each partition", func() {
+			offsets, err := ResetOffsets(client, "group", "events", ResetToOldest())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 10, 1: 20}))
+			Ω(committed()).Should(Equal(offsets))
+		})
+
+		It("should move offsets forward", func() {
+			offsets, err := ResetOffsets(client, "group", "events", ResetToNewest())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 100, 1: 200}))
+			Ω(committed()).Should(Equal(offsets))
+		})
+
+		It("should reset to a time, or the newest offset if nothing has been written since", func() {
+			offsets, err := ResetOffsets(client, "group", "events", ResetToTime(at))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{0: 50, 1: 200}))
+			Ω(committed()).Should(Equal(offsets))
+		})
+
+		It("should fail if the offsets can't be committed", func() {
+			handlers["OffsetCommitRequest"] = sarama.NewMockOffsetCommitResponse(GinkgoT()).
+				SetError("group", "events", 1, sarama.ErrOffsetMetadataTooLarge)
+			broker.SetHandlerByMap(handlers)
+			_, err := ResetOffsets(client, "group", "events", ResetToOldest())
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should only reset the partitions given", func() {
+			offsets, err := ResetOffsets(client, "group", "events", ResetToOffsets(map[int32]int64{1: 7}))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(offsets).Should(Equal(map[int32]int64{1: 7}))
+			Ω(committed()).Should(Equal(offsets))
+			_, err = ResetOffsets(client, "group", "events", ResetToOffsets(map[int32]int64{2: 7}))
+			Ω(errors.Cause(err)).Should(Equal(ErrInvalidOffsetReset))
+		})
+	})
+})
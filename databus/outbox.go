@@ -0,0 +1,290 @@
+package databus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/zenoss/zenkit/healthcheck"
+)
+
+var (
+	// ErrOutboxBacklog is reported to an outbox relay's health check when
+	// more messages are waiting to be published than its MaxBacklog.
+	ErrOutboxBacklog = errors.New("outbox backlog is too large")
+)
+
+const (
+	// OutboxBacklogMetric is the number of messages in an outbox waiting to
+	// be published.
+	OutboxBacklogMetric = "databus.outbox.backlog"
+	// OutboxSentMetric is the rate at which an outbox relay publishes
+	// messages.
+	OutboxSentMetric = "databus.outbox.sent"
+	// OutboxErrorsMetric counts the errors of an outbox relay.
+	OutboxErrorsMetric = "databus.outbox.errors"
+)
+
+// OutboxMessage is an encoded message stored in an outbox until it has been
+// published.
+type OutboxMessage struct {
+	Message
+	// ID identifies the message in its store. Messages are published in
+	// the order of their IDs.
+	ID int64
+	// Created is when the message was added to the outbox.
+	Created time.Time
+}
+
+// NewOutboxMessage encodes a key and value with a factory into a message to
+// add to an outbox, with headers carrying the request, trace and identity of
+// the context, as SendContext sends them.
+func NewOutboxMessage(ctx context.Context, factory MessageFactory, key, value interface{}) (Message, error) {
+	message, _, err := encodeMessage(ctx, factory, key, value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get message from factory")
+	}
+	return message, nil
+}
+
+// OutboxStore stores messages until an OutboxRelay has published them, so
+// that a service can record the messages describing a change in the same
+// transaction as the change, instead of sending them once it has been made
+// and losing them if it crashes in between. How messages are added depends
+// on the store.
+type OutboxStore interface {
+	// Pending returns up to limit messages that haven't been sent, in the
+	// order they were added.
+	Pending(ctx context.Context, limit int) ([]*OutboxMessage, error)
+	// MarkSent marks the messages with the IDs provided as sent, and no
+	// others.
+	MarkSent(ctx context.Context, ids ...int64) error
+	// Backlog returns the number of messages that haven't been sent.
+	Backlog(ctx context.Context) (int64, error)
+}
+
+// MemoryOutboxStore is an OutboxStore in memory, for unit tests and local
+// development.
+type MemoryOutboxStore struct {
+	mu       sync.Mutex
+	messages []*OutboxMessage
+	sent     map[int64]bool
+	// unsent is the index of the first message that hasn't been sent.
+	unsent int
+}
+
+// NewMemoryOutboxStore returns an empty MemoryOutboxStore.
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{}
+}
+
+// Add adds a message to the outbox.
+func (s *MemoryOutboxStore) Add(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, &OutboxMessage{
+		Message: msg,
+		ID:      int64(len(s.messages) + 1),
+		Created: time.Now(),
+	})
+	return nil
+}
+
+func (s *MemoryOutboxStore) Pending(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []*OutboxMessage
+	for _, msg := range s.messages[s.unsent:] {
+		if limit > 0 && len(pending) == limit {
+			break
+		}
+		if !s.sent[msg.ID] {
+			pending = append(pending, msg)
+		}
+	}
+	return pending, nil
+}
+
+func (s *MemoryOutboxStore) MarkSent(ctx context.Context, ids ...int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if id <= 0 || id > int64(len(s.messages)) {
+			return errors.Errorf("no outbox message %d", id)
+		}
+	}
+	if s.sent == nil {
+		s.sent = map[int64]bool{}
+	}
+	for _, id := range ids {
+		s.sent[id] = true
+	}
+	for s.unsent < len(s.messages) && s.sent[s.messages[s.unsent].ID] {
+		delete(s.sent, s.messages[s.unsent].ID)
+		s.unsent++
+	}
+	return nil
+}
+
+func (s *MemoryOutboxStore) Backlog(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.messages) - s.unsent - len(s.sent)), nil
+}
+
+// OutboxRelayOptions configure an OutboxRelay.
+type OutboxRelayOptions struct {
+	// Interval is how often the store is polled for messages to publish
+	// when it is empty. Defaults to a second.
+	Interval time.Duration
+	// BatchSize is the number of messages read from the store at once.
+	// Defaults to 100.
+	BatchSize int
+	// MinRetryDelay and MaxRetryDelay bound the delay before retrying after
+	// a failure, which doubles with each consecutive failure. They default
+	// to 100ms and 30s.
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	// MaxBacklog is the number of messages waiting to be published above
+	// which the health check reports ErrOutboxBacklog. Zero means no limit.
+	MaxBacklog int64
+	// Health is updated with the status of the relay: each failure to read
+	// the store or publish a message, or a backlog above MaxBacklog, is
+	// reported as a failure, and any other pass as a success.
+	Health healthcheck.Updater
+	// Metrics is the registry the relay records its backlog, the messages
+	// it publishes and its errors in.
+	Metrics metrics.Registry
+}
+
+// OutboxRelay publishes the messages of an OutboxStore, in the order its
+// Pending method returns them, and marks them as sent. A message is published at least once: if the relay stops
+// after publishing a message but before marking it, it is published again.
+type OutboxRelay struct {
+	store  OutboxStore
+	sender MessageSender
+	opts   OutboxRelayOptions
+	wake   chan struct{}
+}
+
+// NewOutboxRelay returns an OutboxRelay that publishes the messages of the
+// store with the sender provided.
+func NewOutboxRelay(store OutboxStore, sender MessageSender, opts OutboxRelayOptions) *OutboxRelay {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.MinRetryDelay <= 0 {
+		opts.MinRetryDelay = 100 * time.Millisecond
+	}
+	if opts.MaxRetryDelay < opts.MinRetryDelay {
+		opts.MaxRetryDelay = 30 * time.Second
+	}
+	return &OutboxRelay{store: store, sender: sender, opts: opts, wake: make(chan struct{}, 1)}
+}
+
+// Notify wakes the relay up to publish messages just added to the store,
+// instead of waiting for its next poll.
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes messages until the context is cancelled, retrying after
+// each failure with a growing delay. It is meant to be run in its own
+// goroutine.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	delay := time.Duration(0)
+	for {
+		wait, wake := r.opts.Interval, r.wake
+		if err := r.Relay(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			goa.LogError(ctx, "failed to relay outbox messages", "err", err)
+			if delay *= 2; delay < r.opts.MinRetryDelay {
+				delay = r.opts.MinRetryDelay
+			} else if delay > r.opts.MaxRetryDelay {
+				delay = r.opts.MaxRetryDelay
+			}
+			// Don't retry early when notified
+			wait, wake = delay, nil
+		} else {
+			delay = 0
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Relay publishes the messages waiting in the store, in order, until there
+// are none left or one fails.
+func (r *OutboxRelay) Relay(ctx context.Context) error {
+	err := r.relay(ctx)
+	if err != nil && r.opts.Metrics != nil {
+		metrics.GetOrRegisterCounter(OutboxErrorsMetric, r.opts.Metrics).Inc(1)
+	}
+	backlog, berr := r.store.Backlog(ctx)
+	if berr == nil && r.opts.Metrics != nil {
+		metrics.GetOrRegisterGauge(OutboxBacklogMetric, r.opts.Metrics).Update(backlog)
+	}
+	if err == nil && berr != nil {
+		err = errors.Wrap(berr, "failed to count outbox backlog")
+	}
+	if r.opts.Health != nil {
+		switch {
+		case err != nil:
+			r.opts.Health.Update(err)
+		case r.opts.MaxBacklog > 0 && backlog > r.opts.MaxBacklog:
+			r.opts.Health.Update(errors.Wrapf(ErrOutboxBacklog, "%d messages waiting", backlog))
+		default:
+			r.opts.Health.Update(nil)
+		}
+	}
+	return err
+}
+
+func (r *OutboxRelay) relay(ctx context.Context) error {
+	for ctx.Err() == nil {
+		pending, err := r.store.Pending(ctx, r.opts.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to read outbox")
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		sent := make([]int64, 0, len(pending))
+		var serr error
+		for _, msg := range pending {
+			// Stop at the first failure, so that messages stay in order
+			if serr = r.sender.SendMessage(msg.Message); serr != nil {
+				serr = errors.Wrapf(serr, "failed to publish outbox message %d", msg.ID)
+				break
+			}
+			sent = append(sent, msg.ID)
+		}
+		if len(sent) > 0 {
+			if r.opts.Metrics != nil {
+				metrics.GetOrRegisterMeter(OutboxSentMetric, r.opts.Metrics).Mark(int64(len(sent)))
+			}
+			if err := r.store.MarkSent(ctx, sent...); err != nil {
+				return errors.Wrap(err, "failed to mark outbox messages sent")
+			}
+		}
+		if serr != nil {
+			return serr
+		}
+	}
+	return nil
+}
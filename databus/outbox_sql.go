@@ -0,0 +1,206 @@
+package databus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SQLPlaceholders is the style of the query parameters of a SQL database.
+type SQLPlaceholders int
+
+const (
+	// QuestionPlaceholders are the "?" parameters of MySQL and SQLite.
+	QuestionPlaceholders SQLPlaceholders = iota
+	// DollarPlaceholders are the "$1" parameters of PostgreSQL.
+	DollarPlaceholders
+)
+
+// DefaultOutboxGapTimeout is how long an SQLOutboxStore waits for a missing
+// ID to be committed, unless configured otherwise.
+var DefaultOutboxGapTimeout = 10 * time.Second
+
+// SQLExecer is implemented by *sql.DB and *sql.Tx.
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLOutboxStore is an OutboxStore in a SQL table, to which a service adds
+// messages in the same transaction as the changes they describe. The table
+// must have these columns, e.g. in PostgreSQL:
+//
+//	CREATE TABLE outbox (
+//		id         BIGSERIAL PRIMARY KEY,
+//		topic      VARCHAR(255) NOT NULL,
+//		msg_key    BYTEA,
+//		msg_value  BYTEA,
+//		headers    BYTEA,
+//		created_at TIMESTAMP NOT NULL,
+//		sent_at    TIMESTAMP
+//	);
+//	CREATE INDEX outbox_pending ON outbox (id) WHERE sent_at IS NULL;
+//
+// Only one OutboxRelay should publish the messages of a table at a time.
+//
+// Pending messages are published in the order of their IDs. Since a
+// transaction may take an ID and commit after another that takes a higher
+// one, the store doesn't read past an ID missing after the last message it
+// read until the ID commits or the gap timeout passes, when the transaction
+// that took it is assumed to have rolled back. A message committed after
+// that is published out of order, as are the messages of transactions
+// committed before the store first reads the table.
+type SQLOutboxStore struct {
+	db           *sql.DB
+	table        string
+	placeholders SQLPlaceholders
+	gapTimeout   time.Duration
+
+	mu sync.Mutex
+	// next is the ID after the last message read, or zero before the
+	// first, and gapAt a missing ID, first found missing at gapSince.
+	next     int64
+	gapAt    int64
+	gapSince time.Time
+}
+
+// SQLOutboxOption configures an SQLOutboxStore.
+type SQLOutboxOption func(*SQLOutboxStore)
+
+// WithOutboxGapTimeout sets how long an SQLOutboxStore waits for a missing ID
+// to be committed before reading the messages after it,
+// DefaultOutboxGapTimeout unless it is set.
+func WithOutboxGapTimeout(timeout time.Duration) SQLOutboxOption {
+	return func(s *SQLOutboxStore) {
+		s.gapTimeout = timeout
+	}
+}
+
+// NewSQLOutboxStore returns an SQLOutboxStore for a table in the database
+// provided.
+func NewSQLOutboxStore(db *sql.DB, table string, placeholders SQLPlaceholders, opts ...SQLOutboxOption) *SQLOutboxStore {
+	s := &SQLOutboxStore{db: db, table: table, placeholders: placeholders, gapTimeout: DefaultOutboxGapTimeout}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Add adds a message to the outbox through a transaction, or the database,
+// provided.
+func (s *SQLOutboxStore) Add(ctx context.Context, exec SQLExecer, msg Message) error {
+	var headers []byte
+	if h := MessageHeaders(msg); len(h) > 0 {
+		var err error
+		if headers, err = json.Marshal(h); err != nil {
+			return errors.Wrap(err, "failed to encode headers")
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %s (topic, msg_key, msg_value, headers, created_at) VALUES (%s)", s.table, s.params(1, 5))
+	if _, err := exec.ExecContext(ctx, query, msg.Topic(), msg.Key(), msg.Value(), headers, time.Now().UTC()); err != nil {
+		return errors.Wrap(err, "failed to add message to outbox")
+	}
+	return nil
+}
+
+// Pending returns up to limit messages that haven't been sent, in the order
+// of their IDs, up to the first ID missing after the last message read that
+// hasn't been missing for the gap timeout.
+func (s *SQLOutboxStore) Pending(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	query := fmt.Sprintf("SELECT id, topic, msg_key, msg_value, headers, created_at FROM %s WHERE sent_at IS NULL ORDER BY id", s.table)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query outbox")
+	}
+	defer rows.Close()
+	var pending []*OutboxMessage
+	for rows.Next() {
+		var (
+			msg                 OutboxMessage
+			topic               string
+			key, value, headers []byte
+		)
+		if err := rows.Scan(&msg.ID, &topic, &key, &value, &headers, &msg.Created); err != nil {
+			return nil, errors.Wrap(err, "failed to read outbox message")
+		}
+		var h map[string][]byte
+		if len(headers) > 0 {
+			if err := json.Unmarshal(headers, &h); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode headers of outbox message %d", msg.ID)
+			}
+		}
+		msg.Message = NewMessageWithHeaders(topic, key, value, h)
+		pending = append(pending, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read outbox")
+	}
+	return s.contiguous(pending, time.Now()), nil
+}
+
+// contiguous returns the messages read up to the first gap in their IDs that
+// is more recent than the gap timeout.
+func (s *SQLOutboxStore) contiguous(pending []*OutboxMessage, now time.Time) []*OutboxMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, msg := range pending {
+		switch {
+		case s.next == 0 || msg.ID <= s.next:
+		case s.gapAt != s.next:
+			s.gapAt, s.gapSince = s.next, now
+			return pending[:i]
+		case now.Sub(s.gapSince) < s.gapTimeout:
+			return pending[:i]
+		}
+		if msg.ID >= s.next {
+			s.next = msg.ID + 1
+		}
+	}
+	return pending
+}
+
+func (s *SQLOutboxStore) MarkSent(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, time.Now().UTC())
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("UPDATE %s SET sent_at = %s WHERE id IN (%s)", s.table, s.params(1, 1), s.params(2, len(ids)))
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrap(err, "failed to mark outbox messages sent")
+	}
+	return nil
+}
+
+func (s *SQLOutboxStore) Backlog(ctx context.Context) (int64, error) {
+	var backlog int64
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE sent_at IS NULL", s.table)
+	if err := s.db.QueryRowContext(ctx, query).Scan(&backlog); err != nil {
+		return 0, errors.Wrap(err, "failed to count outbox messages")
+	}
+	return backlog, nil
+}
+
+// params returns n comma-separated query parameters, numbered from first.
+func (s *SQLOutboxStore) params(first, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		if s.placeholders == DollarPlaceholders {
+			params[i] = fmt.Sprintf("$%d", first+i)
+		} else {
+			params[i] = "?"
+		}
+	}
+	return strings.Join(params, ", ")
+}
@@ -0,0 +1,138 @@
+package databus_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQLOutboxStore", func() {
+
+	var (
+		ctx   context.Context
+		db    *sql.DB
+		mock  sqlmock.Sqlmock
+		store *SQLOutboxStore
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		db, mock, err = sqlmock.New()
+		Ω(err).ShouldNot(HaveOccurred())
+		store = NewSQLOutboxStore(db, "outbox", DollarPlaceholders)
+	})
+
+	AfterEach(func() {
+		Ω(mock.ExpectationsWereMet()).Should(Succeed())
+		db.Close()
+	})
+
+	It("should add messages in a transaction", func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox (topic, msg_key, msg_value, headers, created_at) VALUES ($1, $2, $3, $4, $5)")).
+			WithArgs("topic", []byte("k"), []byte("v"), []byte(`{"h":"eA=="}`), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		tx, err := db.BeginTx(ctx, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Add(ctx, tx, NewMessageWithHeaders("topic", []byte("k"), []byte("v"), map[string][]byte{"h": []byte("x")}))).Should(Succeed())
+		Ω(tx.Commit()).Should(Succeed())
+	})
+
+	It("should read pending messages in order", func() {
+		created := time.Now()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, topic, msg_key, msg_value, headers, created_at FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT 2")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "msg_key", "msg_value", "headers", "created_at"}).
+				AddRow(3, "topic", []byte("k"), []byte("a"), []byte(`{"h":"eA=="}`), created).
+				AddRow(4, "topic", []byte("k"), []byte("b"), nil, created))
+		pending, err := store.Pending(ctx, 2)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(pending).Should(HaveLen(2))
+		Ω(pending[0].ID).Should(BeNumerically("==", 3))
+		Ω(pending[0].Topic()).Should(Equal("topic"))
+		Ω(pending[0].Value()).Should(Equal([]byte("a")))
+		Ω(MessageHeaders(pending[0].Message)).Should(Equal(map[string][]byte{"h": []byte("x")}))
+		Ω(pending[1].Created).Should(Equal(created))
+		Ω(MessageHeaders(pending[1].Message)).Should(BeEmpty())
+	})
+
+	Context("with gaps in the IDs", func() {
+
+		query := regexp.QuoteMeta("SELECT id, topic, msg_key, msg_value, headers, created_at FROM outbox WHERE sent_at IS NULL ORDER BY id")
+
+		rows := func(ids ...int64) *sqlmock.Rows {
+			rows := sqlmock.NewRows([]string{"id", "topic", "msg_key", "msg_value", "headers", "created_at"})
+			for _, id := range ids {
+				rows.AddRow(id, "topic", []byte("k"), []byte("v"), nil, time.Now())
+			}
+			return rows
+		}
+
+		ids := func() []int64 {
+			pending, err := store.Pending(ctx, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			ids := []int64{}
+			for _, msg := range pending {
+				ids = append(ids, msg.ID)
+			}
+			return ids
+		}
+
+		BeforeEach(func() {
+			store = NewSQLOutboxStore(db, "outbox", DollarPlaceholders, WithOutboxGapTimeout(50*time.Millisecond))
+		})
+
+		It("should wait for missing IDs to commit", func() {
+			mock.ExpectQuery(query).WillReturnRows(rows(3, 5))
+			mock.ExpectQuery(query).WillReturnRows(rows(5))
+			mock.ExpectQuery(query).WillReturnRows(rows(4, 5))
+			Ω(ids()).Should(Equal([]int64{3}))
+			Ω(ids()).Should(BeEmpty())
+			Ω(ids()).Should(Equal([]int64{4, 5}))
+		})
+
+		It("should skip missing IDs after the gap timeout", func() {
+			mock.ExpectQuery(query).WillReturnRows(rows(3, 5, 6))
+			mock.ExpectQuery(query).WillReturnRows(rows(5, 6))
+			Ω(ids()).Should(Equal([]int64{3}))
+			time.Sleep(60 * time.Millisecond)
+			Ω(ids()).Should(Equal([]int64{5, 6}))
+		})
+
+		It("should read messages it has read again until they are sent", func() {
+			mock.ExpectQuery(query).WillReturnRows(rows(3, 4))
+			mock.ExpectQuery(query).WillReturnRows(rows(4, 5))
+			Ω(ids()).Should(Equal([]int64{3, 4}))
+			Ω(ids()).Should(Equal([]int64{4, 5}))
+		})
+	})
+
+	It("should mark messages sent and count the rest", func() {
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET sent_at = $1 WHERE id IN ($2, $3)")).
+			WithArgs(sqlmock.AnyArg(), 3, 4).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+		Ω(store.MarkSent(ctx, 3, 4)).Should(Succeed())
+		Ω(store.Backlog(ctx)).Should(BeNumerically("==", 7))
+	})
+
+	It("should use question mark placeholders", func() {
+		other, m, err := sqlmock.New()
+		Ω(err).ShouldNot(HaveOccurred())
+		defer other.Close()
+		m.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET sent_at = ? WHERE id IN (?)")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		Ω(NewSQLOutboxStore(other, "outbox", QuestionPlaceholders).MarkSent(ctx, 1)).Should(Succeed())
+		Ω(m.ExpectationsWereMet()).Should(Succeed())
+	})
+})
@@ -0,0 +1,169 @@
+package databus_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/healthcheck"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// flakySender fails to send the messages whose values it is told to fail.
+type flakySender struct {
+	MessageSender
+	fail map[string]bool
+}
+
+func (s *flakySender) SendMessage(msg Message) error {
+	if s.fail[string(msg.Value())] {
+		return errors.New("oops")
+	}
+	return s.MessageSender.SendMessage(msg)
+}
+
+var _ = Describe("Outbox", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		broker   *MemoryBroker
+		store    *MemoryOutboxStore
+		sender   *flakySender
+		health   healthcheck.Updater
+		registry metrics.Registry
+		relay    *OutboxRelay
+		topic    string
+	)
+
+	add := func(values ...string) {
+		for _, v := range values {
+			Ω(store.Add(ctx, NewMessage(topic, []byte("key"), []byte(v)))).Should(Succeed())
+		}
+	}
+
+	published := func() []string {
+		var values []string
+		for _, msg := range broker.Messages(topic) {
+			values = append(values, string(msg.Value()))
+		}
+		return values
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		topic = test.RandString(8)
+		broker = NewMemoryBroker(1)
+		store = NewMemoryOutboxStore()
+		sender = &flakySender{MessageSender: NewMemoryMessageSender(broker), fail: map[string]bool{}}
+		health = healthcheck.NewStatusUpdater()
+		registry = metrics.NewRegistry()
+		relay = NewOutboxRelay(store, sender, OutboxRelayOptions{
+			BatchSize:     2,
+			MinRetryDelay: time.Millisecond,
+			MaxBacklog:    2,
+			Health:        health,
+			Metrics:       registry,
+		})
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should publish pending messages in order and mark them sent", func() {
+		add("a", "b", "c")
+		Ω(relay.Relay(ctx)).Should(Succeed())
+		Ω(published()).Should(Equal([]string{"a", "b", "c"}))
+		Ω(store.Backlog(ctx)).Should(BeZero())
+		Ω(store.Pending(ctx, 0)).Should(BeEmpty())
+		Ω(metrics.GetOrRegisterMeter(OutboxSentMetric, registry).Count()).Should(BeNumerically("==", 3))
+		Ω(metrics.GetOrRegisterGauge(OutboxBacklogMetric, registry).Value()).Should(BeZero())
+	})
+
+	It("should stop at a message that fails, and retry it first", func() {
+		add("a", "b", "c")
+		sender.fail["b"] = true
+		Ω(relay.Relay(ctx)).ShouldNot(Succeed())
+		Ω(published()).Should(Equal([]string{"a"}))
+		Ω(store.Backlog(ctx)).Should(BeNumerically("==", 2))
+		Ω(metrics.GetOrRegisterCounter(OutboxErrorsMetric, registry).Count()).Should(BeNumerically("==", 1))
+		Ω(metrics.GetOrRegisterGauge(OutboxBacklogMetric, registry).Value()).Should(BeNumerically("==", 2))
+		Eventually(health.Check).Should(HaveOccurred())
+
+		delete(sender.fail, "b")
+		Ω(relay.Relay(ctx)).Should(Succeed())
+		Ω(published()).Should(Equal([]string{"a", "b", "c"}))
+		Eventually(health.Check).ShouldNot(HaveOccurred())
+	})
+
+	It("should only mark the messages listed as sent", func() {
+		add("a", "b", "c")
+		Ω(store.MarkSent(ctx, 2)).Should(Succeed())
+		pending, err := store.Pending(ctx, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(pending).Should(HaveLen(2))
+		Ω(pending[0].ID).Should(BeNumerically("==", 1))
+		Ω(pending[1].ID).Should(BeNumerically("==", 3))
+		Ω(store.Backlog(ctx)).Should(BeNumerically("==", 2))
+		Ω(store.MarkSent(ctx, 1, 3)).Should(Succeed())
+		Ω(store.Pending(ctx, 0)).Should(BeEmpty())
+		Ω(store.Backlog(ctx)).Should(BeZero())
+		Ω(store.MarkSent(ctx, 4)).ShouldNot(Succeed())
+	})
+
+	It("should report a backlog above the maximum as unhealthy", func() {
+		relay = NewOutboxRelay(&backloggedStore{store}, sender, OutboxRelayOptions{MaxBacklog: 2, Health: health})
+		Ω(relay.Relay(ctx)).Should(Succeed())
+		Eventually(func() error { return errors.Cause(health.Check()) }).Should(Equal(ErrOutboxBacklog))
+	})
+
+	It("should relay messages in the background until cancelled", func() {
+		done := make(chan error)
+		go func() {
+			done <- relay.Run(ctx)
+		}()
+		for i := 0; i < 3; i++ {
+			add(fmt.Sprint(i))
+			relay.Notify()
+		}
+		Eventually(published).Should(Equal([]string{"0", "1", "2"}))
+		cancel()
+		Eventually(done).Should(Receive(Equal(context.Canceled)))
+	})
+
+	It("should encode messages with a factory", func() {
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		factory, err := NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		msg, err := NewOutboxMessage(ctx, factory, KeyTest{SomeString: "a"}, ValTest{TotallyCool: "b"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Add(ctx, msg)).Should(Succeed())
+		Ω(relay.Relay(ctx)).Should(Succeed())
+
+		consumer, err := NewMemoryDatabusConsumer(broker, factory, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		var v memoryTestMessage
+		Ω(consumer.Consume(ctx, &v)).Should(Succeed())
+		Ω(v.Value.TotallyCool).Should(Equal("b"))
+	})
+})
+
+// backloggedStore is an OutboxStore that is always behind, e.g. because
+// messages are added faster than they are published.
+type backloggedStore struct {
+	*MemoryOutboxStore
+}
+
+func (s *backloggedStore) Backlog(ctx context.Context) (int64, error) {
+	return 10, nil
+}
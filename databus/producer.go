@@ -1,8 +1,9 @@
 package databus
 
 import (
+	"context"
+
 	"github.com/Shopify/sarama"
-	"github.com/datamountaineer/schema-registry"
 	"github.com/pkg/errors"
 )
 
@@ -10,15 +11,38 @@ import (
 // a databus.
 type DatabusProducer interface {
 	Send(key, value interface{}) error
+	// SendContext sends a message with record headers propagating the
+	// request ID, trace and tenant identity of the context provided.
+	SendContext(ctx context.Context, key, value interface{}) error
 	Close() error
 }
 
+// ProducerOption configures a DatabusProducer created by NewDatabusProducer.
+type ProducerOption func(*producerOptions)
+
+type producerOptions struct {
+	kafka KafkaOptions
+}
+
+// WithProducerKafkaOptions configures the connection to Kafka of a producer
+// created by NewDatabusProducer, as WithKafkaOptions does for a consumer.
+func WithProducerKafkaOptions(kafka KafkaOptions) ProducerOption {
+	return func(o *producerOptions) {
+		o.kafka = kafka
+	}
+}
+
 // NewDatabusProducer returns the default implementation of DatabusProducer,
 // which sends Avro-encoded messages to a Kafka topic.
-func NewDatabusProducer(brokers []string, schemaRegistry, topic, keySubject, valueSubject string) (DatabusProducer, error) {
-	schemaRegistryClient, err := schemaregistry.NewClient(schemaRegistry)
+func NewDatabusProducer(brokers []string, schemaRegistry, topic, keySubject, valueSubject string, opts ...ProducerOption) (DatabusProducer, error) {
+	o := &producerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	schemaRegistryClient, err := SharedSchemaCache(schemaRegistry)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create schema registry client")
+		return nil, errors.Wrap(err, "failed to get schema registry client")
 	}
 
 	messageFactory, err := NewMessageFactory(topic, keySubject, valueSubject, schemaRegistryClient)
@@ -26,7 +50,10 @@ func NewDatabusProducer(brokers []string, schemaRegistry, topic, keySubject, val
 		return nil, errors.Wrap(err, "failed to create message factory")
 	}
 
-	producer, err := sarama.NewSyncProducer(brokers, nil)
+	config := o.kafka.Config()
+	config.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create sarama producer")
 	}
@@ -53,12 +80,28 @@ func (s *saramaDatabusProducer) Send(key, value interface{}) error {
 		return errors.Wrap(err, "failed to get message from factory")
 	}
 
-	_, _, err = s.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: message.Topic(),
-		Key:   sarama.ByteEncoder(message.Key()),
-		Value: sarama.ByteEncoder(message.Value()),
-	})
+	return s.SendMessage(message)
+}
 
+func (s *saramaDatabusProducer) SendContext(ctx context.Context, key, value interface{}) error {
+	message, m, err := encodeMessage(ctx, s.factory, key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+
+	err = s.SendMessage(message)
+	m.sent(err)
+	return err
+}
+
+// SendMessage sends an encoded message, e.g. to a retry or dead-letter topic.
+func (s *saramaDatabusProducer) SendMessage(message Message) error {
+	_, _, err := s.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   message.Topic(),
+		Key:     sarama.ByteEncoder(message.Key()),
+		Value:   sarama.ByteEncoder(message.Value()),
+		Headers: recordHeaders(MessageHeaders(message)),
+	})
 	return errors.Wrap(err, "failed to send message via sarama producer")
 }
 
@@ -1,6 +1,7 @@
 package databus_test
 
 import (
+	"context"
 	"errors"
 
 	"github.com/Shopify/sarama"
@@ -99,6 +100,22 @@ var _ = Describe("Producer", func() {
 		Ω(result).Should(BeNumerically("==", value))
 	})
 
+	It("should send the headers of a context with a message", func() {
+		err := databusProducer.SendContext(requestContext(), key, value)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(producer.messages).Should(HaveLen(1))
+		Ω(producer.messages[0].Headers).Should(ContainElement(sarama.RecordHeader{
+			Key:   []byte(HeaderTraceID),
+			Value: []byte("trace-1"),
+		}))
+	})
+
+	It("should send a message without headers for an empty context", func() {
+		err := databusProducer.SendContext(context.Background(), key, value)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(producer.messages[0].Headers).Should(BeEmpty())
+	})
+
 	It("should error if the underlying Kafka producer fails to send the message", func() {
 		e := errors.New("NOPE")
 		producer.err = e
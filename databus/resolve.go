@@ -0,0 +1,570 @@
+package databus
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// schemaResolver converts Go native data decoded according to a writer schema
+// into the shape of a reader schema, following the schema resolution rules of
+// the Avro specification: fields the reader doesn't know are dropped, fields
+// the writer didn't send take the reader's default, numbers are promoted and
+// union branches are matched by type.
+type schemaResolver struct {
+	writer      interface{}
+	reader      interface{}
+	writerNames map[string]interface{}
+	readerNames map[string]interface{}
+}
+
+// newSchemaResolver parses the writer and reader schemas provided.
+func newSchemaResolver(writerSchema, readerSchema string) (*schemaResolver, error) {
+	r := &schemaResolver{
+		writerNames: map[string]interface{}{},
+		readerNames: map[string]interface{}{},
+	}
+	if err := json.Unmarshal([]byte(writerSchema), &r.writer); err != nil {
+		return nil, errors.Wrap(err, "failed to parse writer schema")
+	}
+	if err := json.Unmarshal([]byte(readerSchema), &r.reader); err != nil {
+		return nil, errors.Wrap(err, "failed to parse reader schema")
+	}
+	indexNamedTypes(r.writer, "", r.writerNames)
+	indexNamedTypes(r.reader, "", r.readerNames)
+	return r, nil
+}
+
+// Resolve converts datum, decoded according to the writer schema, to the
+// reader schema.
+func (r *schemaResolver) Resolve(datum interface{}) (interface{}, error) {
+	return r.resolve(r.writer, r.reader, datum)
+}
+
+// Compatible returns an error describing why data written with the writer
+// schema can't be read as the reader schema, or nil if it can.
+func (r *schemaResolver) Compatible() error {
+	return r.compatible(r.writer, r.reader, map[[2]string]bool{})
+}
+
+func (r *schemaResolver) compatible(writer, reader interface{}, seen map[[2]string]bool) error {
+	writer = deref(writer, r.writerNames)
+	reader = deref(reader, r.readerNames)
+
+	// Every branch of a writer union may have been written.
+	if branches, ok := writer.([]interface{}); ok {
+		for _, b := range branches {
+			if err := r.compatible(b, reader, seen); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if branches, ok := reader.([]interface{}); ok {
+		for _, allowPromotion := range []bool{false, true} {
+			for _, b := range branches {
+				b = deref(b, r.readerNames)
+				if matches(writer, b, allowPromotion) {
+					return r.compatible(writer, b, seen)
+				}
+			}
+		}
+		return errors.Wrapf(ErrSchemaMismatch, "no branch of reader union matches %s", typeName(writer))
+	}
+
+	if !matches(writer, reader, true) {
+		return errors.Wrapf(ErrSchemaMismatch, "cannot read %s as %s", typeName(writer), typeName(reader))
+	}
+
+	switch rt := typeName(reader); {
+	case isNamed(reader):
+		// Named types may be recursive, so each pair is only checked once.
+		pair := [2]string{typeName(writer), rt}
+		if seen[pair] {
+			return nil
+		}
+		seen[pair] = true
+		ws, rs := writer.(map[string]interface{}), reader.(map[string]interface{})
+		switch rs["type"] {
+		case "record", "error":
+			return r.compatibleRecord(ws, rs, seen)
+		case "enum":
+			if _, ok := rs["default"]; ok {
+				return nil
+			}
+			symbols := map[interface{}]bool{}
+			readerSymbols, _ := rs["symbols"].([]interface{})
+			for _, s := range readerSymbols {
+				symbols[s] = true
+			}
+			writerSymbols, _ := ws["symbols"].([]interface{})
+			for _, s := range writerSymbols {
+				if !symbols[s] {
+					return errors.Wrapf(ErrSchemaMismatch, "symbol %v is not in reader enum", s)
+				}
+			}
+		case "fixed":
+			if ws["size"] != rs["size"] {
+				return errors.Wrapf(ErrSchemaMismatch, "fixed %s changed size", rt)
+			}
+		}
+	case rt == "array":
+		return r.compatible(writer.(map[string]interface{})["items"], reader.(map[string]interface{})["items"], seen)
+	case rt == "map":
+		return r.compatible(writer.(map[string]interface{})["values"], reader.(map[string]interface{})["values"], seen)
+	}
+	return nil
+}
+
+func (r *schemaResolver) compatibleRecord(writer, reader map[string]interface{}, seen map[[2]string]bool) error {
+	writerFields := map[string]map[string]interface{}{}
+	for _, f := range fields(writer) {
+		name, _ := f["name"].(string)
+		writerFields[name] = f
+	}
+	for _, rf := range fields(reader) {
+		name, _ := rf["name"].(string)
+		wf, ok := writerFields[name]
+		if !ok {
+			aliases, _ := rf["aliases"].([]interface{})
+			for _, alias := range aliases {
+				if a, _ := alias.(string); writerFields[a] != nil {
+					wf, ok = writerFields[a], true
+					break
+				}
+			}
+		}
+		if ok {
+			if err := r.compatible(wf["type"], rf["type"], seen); err != nil {
+				return errors.Wrapf(err, "field %s", name)
+			}
+			continue
+		}
+		if _, ok := rf["default"]; !ok {
+			return errors.Wrapf(ErrSchemaMismatch, "reader field %s has no default", name)
+		}
+	}
+	return nil
+}
+
+// indexNamedTypes records every record, enum and fixed definition in the
+// schema by full name, rewriting each definition's name to its full name.
+func indexNamedTypes(schema interface{}, namespace string, names map[string]interface{}) {
+	switch s := schema.(type) {
+	case []interface{}:
+		for _, branch := range s {
+			indexNamedTypes(branch, namespace, names)
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record", "error", "enum", "fixed":
+			name, _ := s["name"].(string)
+			if ns, ok := s["namespace"].(string); ok && ns != "" {
+				namespace = ns
+			}
+			if !strings.Contains(name, ".") && namespace != "" {
+				name = namespace + "." + name
+			}
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				namespace = name[:i]
+			}
+			s["name"] = name
+			names[name] = s
+			for _, f := range fields(s) {
+				indexNamedTypes(f["type"], namespace, names)
+			}
+		case "array":
+			indexNamedTypes(s["items"], namespace, names)
+		case "map":
+			indexNamedTypes(s["values"], namespace, names)
+		default:
+			indexNamedTypes(s["type"], namespace, names)
+		}
+	}
+}
+
+// deref replaces a reference to a named type with its definition, and a
+// primitive type wrapped in an object with its name.
+func deref(schema interface{}, names map[string]interface{}) interface{} {
+	switch s := schema.(type) {
+	case string:
+		if def, ok := names[s]; ok {
+			return def
+		}
+		for name, def := range names {
+			if shortName(name) == s {
+				return def
+			}
+		}
+	case map[string]interface{}:
+		switch t := s["type"].(type) {
+		case string:
+			if isPrimitive(t) {
+				return t
+			}
+		case map[string]interface{}, []interface{}:
+			return deref(t, names)
+		}
+	}
+	return schema
+}
+
+// typeName returns the name goavro uses for a type, e.g. as a union branch.
+func typeName(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case []interface{}:
+		return "union"
+	case map[string]interface{}:
+		t, _ := s["type"].(string)
+		switch t {
+		case "record", "error", "enum", "fixed":
+			name, _ := s["name"].(string)
+			return name
+		}
+		return t
+	}
+	return ""
+}
+
+func isPrimitive(t string) bool {
+	switch t {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return true
+	}
+	return false
+}
+
+func isNamed(schema interface{}) bool {
+	if s, ok := schema.(map[string]interface{}); ok {
+		switch s["type"] {
+		case "record", "error", "enum", "fixed":
+			return true
+		}
+	}
+	return false
+}
+
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func fields(schema map[string]interface{}) []map[string]interface{} {
+	raw, _ := schema["fields"].([]interface{})
+	result := make([]map[string]interface{}, 0, len(raw))
+	for _, f := range raw {
+		if field, ok := f.(map[string]interface{}); ok {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// matches reports whether data written with the writer schema can be read
+// as the reader schema, optionally allowing promotion.
+func matches(writer, reader interface{}, promote bool) bool {
+	wt, rt := typeName(writer), typeName(reader)
+	if isNamed(writer) && isNamed(reader) {
+		w, r := writer.(map[string]interface{}), reader.(map[string]interface{})
+		return w["type"] == r["type"] && shortName(wt) == shortName(rt)
+	}
+	if wt == rt {
+		return true
+	}
+	return promote && promotable(wt, rt)
+}
+
+func promotable(wt, rt string) bool {
+	switch wt {
+	case "int":
+		return rt == "long" || rt == "float" || rt == "double"
+	case "long":
+		return rt == "float" || rt == "double"
+	case "float":
+		return rt == "double"
+	case "string":
+		return rt == "bytes"
+	case "bytes":
+		return rt == "string"
+	}
+	return false
+}
+
+func (r *schemaResolver) resolve(writer, reader, datum interface{}) (interface{}, error) {
+	writer = deref(writer, r.writerNames)
+	reader = deref(reader, r.readerNames)
+
+	// A writer union is resolved by the branch that was actually written.
+	if branches, ok := writer.([]interface{}); ok {
+		branch := "null"
+		if m, ok := datum.(map[string]interface{}); ok && datum != nil {
+			for k, v := range m {
+				branch, datum = k, v
+			}
+		}
+		for _, b := range branches {
+			b = deref(b, r.writerNames)
+			if typeName(b) == branch {
+				return r.resolve(b, reader, datum)
+			}
+		}
+		return nil, errors.Wrapf(ErrSchemaMismatch, "union branch %s is not in writer schema", branch)
+	}
+
+	// A reader union takes the first branch that matches the writer.
+	if branches, ok := reader.([]interface{}); ok {
+		for _, allowPromotion := range []bool{false, true} {
+			for _, b := range branches {
+				b = deref(b, r.readerNames)
+				if !matches(writer, b, allowPromotion) {
+					continue
+				}
+				v, err := r.resolve(writer, b, datum)
+				if err != nil || typeName(b) == "null" {
+					return nil, err
+				}
+				return map[string]interface{}{typeName(b): v}, nil
+			}
+		}
+		return nil, errors.Wrapf(ErrSchemaMismatch, "no branch of reader union matches %s", typeName(writer))
+	}
+
+	if !matches(writer, reader, true) {
+		return nil, errors.Wrapf(ErrSchemaMismatch, "cannot read %s as %s", typeName(writer), typeName(reader))
+	}
+
+	switch rt := typeName(reader); {
+	case isNamed(reader):
+		rs := reader.(map[string]interface{})
+		switch rs["type"] {
+		case "record", "error":
+			return r.resolveRecord(writer.(map[string]interface{}), rs, datum)
+		case "enum":
+			return resolveEnum(rs, datum)
+		case "fixed":
+			if writer.(map[string]interface{})["size"] != rs["size"] {
+				return nil, errors.Wrapf(ErrSchemaMismatch, "fixed %s changed size", rt)
+			}
+		}
+		return datum, nil
+	case rt == "array":
+		ws, rs := writer.(map[string]interface{}), reader.(map[string]interface{})
+		items, _ := datum.([]interface{})
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			v, err := r.resolve(ws["items"], rs["items"], item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case rt == "map":
+		ws, rs := writer.(map[string]interface{}), reader.(map[string]interface{})
+		values, _ := datum.(map[string]interface{})
+		result := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			v, err := r.resolve(ws["values"], rs["values"], value)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = v
+		}
+		return result, nil
+	default:
+		return promote(typeName(writer), rt, datum), nil
+	}
+}
+
+func (r *schemaResolver) resolveRecord(writer, reader map[string]interface{}, datum interface{}) (interface{}, error) {
+	record, _ := datum.(map[string]interface{})
+	writerFields := map[string]map[string]interface{}{}
+	for _, f := range fields(writer) {
+		name, _ := f["name"].(string)
+		writerFields[name] = f
+	}
+	result := make(map[string]interface{}, len(fields(reader)))
+	for _, rf := range fields(reader) {
+		name, _ := rf["name"].(string)
+		wf, ok := writerFields[name]
+		if !ok {
+			aliases, _ := rf["aliases"].([]interface{})
+			for _, alias := range aliases {
+				if a, _ := alias.(string); writerFields[a] != nil {
+					wf, ok = writerFields[a], true
+					break
+				}
+			}
+		}
+		if ok {
+			v, err := r.resolve(wf["type"], rf["type"], record[wf["name"].(string)])
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", name)
+			}
+			result[name] = v
+			continue
+		}
+		def, ok := rf["default"]
+		if !ok {
+			return nil, errors.Wrapf(ErrSchemaMismatch, "reader field %s has no default", name)
+		}
+		v, err := r.defaultValue(rf["type"], def)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", name)
+		}
+		result[name] = v
+	}
+	return result, nil
+}
+
+func resolveEnum(reader map[string]interface{}, datum interface{}) (interface{}, error) {
+	symbols, _ := reader["symbols"].([]interface{})
+	for _, s := range symbols {
+		if s == datum {
+			return datum, nil
+		}
+	}
+	if def, ok := reader["default"]; ok {
+		return def, nil
+	}
+	return nil, errors.Wrapf(ErrSchemaMismatch, "symbol %v is not in reader enum", datum)
+}
+
+// promote converts a Go native value of a writer primitive type to the Go
+// native type of the reader primitive type.
+func promote(wt, rt string, v interface{}) interface{} {
+	if wt == rt {
+		return v
+	}
+	switch n := v.(type) {
+	case int32:
+		switch rt {
+		case "long":
+			return int64(n)
+		case "float":
+			return float32(n)
+		case "double":
+			return float64(n)
+		}
+	case int64:
+		switch rt {
+		case "float":
+			return float32(n)
+		case "double":
+			return float64(n)
+		}
+	case float32:
+		return float64(n)
+	case string:
+		return []byte(n)
+	case []byte:
+		return string(n)
+	}
+	return v
+}
+
+// defaultValue converts the JSON default value of a field to the Go native
+// type goavro uses for its schema.
+func (r *schemaResolver) defaultValue(schema, def interface{}) (interface{}, error) {
+	schema = deref(schema, r.readerNames)
+	if branches, ok := schema.([]interface{}); ok {
+		// The default of a union corresponds to its first branch.
+		if len(branches) == 0 {
+			return nil, errors.Wrap(ErrSchemaMismatch, "empty union")
+		}
+		first := deref(branches[0], r.readerNames)
+		if typeName(first) == "null" {
+			return nil, nil
+		}
+		v, err := r.defaultValue(first, def)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{typeName(first): v}, nil
+	}
+	switch t := typeName(schema); t {
+	case "null":
+		return nil, nil
+	case "boolean", "string":
+		return def, nil
+	case "int":
+		n, _ := def.(float64)
+		return int32(n), nil
+	case "long":
+		n, _ := def.(float64)
+		return int64(n), nil
+	case "float":
+		n, _ := def.(float64)
+		return float32(n), nil
+	case "double":
+		n, _ := def.(float64)
+		return n, nil
+	case "bytes":
+		return defaultBytes(def), nil
+	case "array":
+		s := schema.(map[string]interface{})
+		items, _ := def.([]interface{})
+		result := make([]interface{}, len(items))
+		for i, item := range items {
+			v, err := r.defaultValue(s["items"], item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case "map":
+		s := schema.(map[string]interface{})
+		values, _ := def.(map[string]interface{})
+		result := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			v, err := r.defaultValue(s["values"], value)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = v
+		}
+		return result, nil
+	default:
+		s, _ := schema.(map[string]interface{})
+		switch s["type"] {
+		case "record", "error":
+			values, _ := def.(map[string]interface{})
+			result := map[string]interface{}{}
+			for _, f := range fields(s) {
+				name, _ := f["name"].(string)
+				value, ok := values[name]
+				if !ok {
+					if value, ok = f["default"]; !ok {
+						return nil, errors.Wrapf(ErrSchemaMismatch, "no default for field %s of %s", name, t)
+					}
+				}
+				v, err := r.defaultValue(f["type"], value)
+				if err != nil {
+					return nil, err
+				}
+				result[name] = v
+			}
+			return result, nil
+		case "enum":
+			return def, nil
+		case "fixed":
+			return defaultBytes(def), nil
+		}
+	}
+	return nil, errors.Wrapf(ErrSchemaMismatch, "unsupported default for %s", typeName(schema))
+}
+
+// defaultBytes converts a JSON default for bytes or fixed, whose code points
+// 0-255 are the byte values, to a byte slice.
+func defaultBytes(def interface{}) []byte {
+	s, _ := def.(string)
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return b
+}
@@ -0,0 +1,324 @@
+package databus
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+
+	cluster "github.com/bsm/sarama-cluster"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoRoute is returned when a message is received from a topic, or
+	// was written as a record, for which no message type has been routed.
+	ErrNoRoute = errors.New("no route for message")
+	// ErrDuplicateRoute is returned when routing a message type that has
+	// already been routed for a topic.
+	ErrDuplicateRoute = errors.New("message type is already routed")
+)
+
+// Router routes the messages of several topics to the factories that decode
+// them and the message types they are decoded as, for a MultiTopicConsumer.
+type Router struct {
+	mu     sync.RWMutex
+	routes map[string]*topicRoute
+}
+
+// topicRoute is the factory and message types of a topic, and caches the
+// PayloadNamer for each writer schema ID seen.
+type topicRoute struct {
+	factory MessageFactory
+	types   []*routedType
+	records map[string]*routedType
+	namers  map[int]PayloadNamer
+}
+
+// routedType is a message type and the indices of its key and value fields.
+type routedType struct {
+	typ        reflect.Type
+	keyField   int
+	valueField int
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{routes: map[string]*topicRoute{}}
+}
+
+// Route routes the messages of the factory's topic to the message type of v,
+// a struct, or a pointer to a struct, tagged as for Consume. Several message
+// types may be routed for a topic that carries several types of event, e.g.
+// with a factory created by NewMessageFactoryWithStrategy; each message is
+// then decoded as the type whose value is encoded as the record the message's
+// value was written as, named by RecordName. The record a message was written
+// as is named by the PayloadNamer of the factory's format: after the named
+// type of an Avro schema, the title of a JSON Schema, or the message type of a
+// protobuf payload. Every type routed for a topic must use the same factory.
+func (r *Router) Route(factory MessageFactory, v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return errors.Wrap(ErrInvalidMessageType, "type is not a struct")
+	}
+	keyField, valueField, err := validateType(reflect.New(t).Interface())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	record, err := RecordName(reflect.New(t.Field(valueField).Type).Elem().Interface())
+	if err != nil {
+		return errors.Wrapf(err, "failed to name value record of %s", t)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route, ok := r.routes[factory.Topic()]
+	if !ok {
+		route = &topicRoute{factory: factory, records: map[string]*routedType{}, namers: map[int]PayloadNamer{}}
+		r.routes[factory.Topic()] = route
+	} else if route.factory != factory {
+		return errors.Errorf("topic %s is already routed with another factory", factory.Topic())
+	}
+	if _, ok := route.records[record]; ok {
+		return errors.Wrapf(ErrDuplicateRoute, "record %s on topic %s", record, factory.Topic())
+	}
+	rt := &routedType{typ: t, keyField: keyField, valueField: valueField}
+	route.types = append(route.types, rt)
+	route.records[record] = rt
+	return nil
+}
+
+// Topics returns the topics routed, in order.
+func (r *Router) Topics() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	topics := make([]string, 0, len(r.routes))
+	for topic := range r.routes {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Factory returns the factory routed for a topic, or nil if there is none.
+func (r *Router) Factory(topic string) MessageFactory {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if route, ok := r.routes[topic]; ok {
+		return route.factory
+	}
+	return nil
+}
+
+// Decode decodes a message into a new value of the message type routed for
+// it, and returns a pointer to the value.
+func (r *Router) Decode(msg *ReceivedMessage) (interface{}, error) {
+	rt, err := r.route(msg)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(rt.typ).Interface()
+	if msg.factory == nil {
+		msg.factory = r.Factory(msg.Topic())
+	}
+	if err := msg.decode(v, rt.keyField, rt.valueField); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// route returns the message type routed for a message. If its topic has
+// several, the type is chosen by the record its value was written as.
+func (r *Router) route(msg Message) (*routedType, error) {
+	r.mu.RLock()
+	route, ok := r.routes[msg.Topic()]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrNoRoute, "topic %s", msg.Topic())
+	}
+	if len(route.types) == 1 {
+		return route.types[0], nil
+	}
+	id, payload, err := DeserializePayload(msg.Value())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read writer schema ID")
+	}
+	r.mu.RLock()
+	namer, ok := route.namers[id]
+	r.mu.RUnlock()
+	if !ok {
+		if namer, err = r.namer(route, id); err != nil {
+			return nil, err
+		}
+	}
+	record, err := namer(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to name record written with schema %d", id)
+	}
+	r.mu.RLock()
+	rt, ok := route.records[record]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrNoRoute, "record %s on topic %s", record, msg.Topic())
+	}
+	return rt, nil
+}
+
+// namer returns the PayloadNamer for the writer schema ID provided, in the
+// format of the route's factory.
+func (r *Router) namer(route *topicRoute, id int) (PayloadNamer, error) {
+	format := factoryFormat(route.factory)
+	naming, ok := format.(RecordNamingFormat)
+	if !ok {
+		return nil, errors.Errorf("%s payloads can't be routed by record", format.Name())
+	}
+	cache, err := factoryCache(route.factory)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := cache.GetSchemaById(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get writer schema %d", id)
+	}
+	namer, err := naming.PayloadNamer(schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to name writer schema %d", id)
+	}
+	r.mu.Lock()
+	route.namers[id] = namer
+	r.mu.Unlock()
+	return namer, nil
+}
+
+// MultiTopicConsumer receives messages from several topics as one consumer
+// group, and decodes each as the message type its Router routes it to. It
+// also implements MessageSource, so it can be used with Subscribe, where
+// ReceivedMessage.Decode decodes with the factory routed for the message's
+// topic.
+type MultiTopicConsumer interface {
+	// ConsumeAny reads a message from any of the consumer's topics and
+	// returns a pointer to a new value of the message type routed for it.
+	ConsumeAny(context.Context) (interface{}, error)
+	// Close closes the consumer.
+	Close() error
+}
+
+// WithTopicPattern subscribes a consumer created by
+// NewMultiTopicDatabusConsumer or NewMemoryMultiTopicConsumer to every topic
+// matching the pattern, including those created later, in addition to the
+// topics of its Router. Messages from topics without a route fail with
+// ErrNoRoute, and are passed to the consumer's failure policy, if it has
+// one.
+func WithTopicPattern(pattern *regexp.Regexp) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.topicPattern = pattern
+	}
+}
+
+// NewMultiTopicDatabusConsumer returns a MultiTopicConsumer that reads
+// messages from the topics of a Router from Kafka, as a member of the
+// consumer group provided.
+func NewMultiTopicDatabusConsumer(brokers []string, groupId string, router *Router, opts ...ConsumerOption) (MultiTopicConsumer, error) {
+	o := newConsumerOptions(opts)
+	config := cluster.NewConfig()
+	config.Config = *o.kafka.Config()
+	config.Consumer.Return.Errors = true
+	config.Group.Return.Notifications = true
+	config.Group.Topics.Whitelist = o.topicPattern
+	if err := o.startOffsets(brokers, &config.Config, groupId, router.Topics()); err != nil {
+		return nil, err
+	}
+
+	consumer, err := cluster.NewConsumer(brokers, groupId, router.Topics(), config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cluster consumer")
+	}
+	return NewSaramaClusterMultiTopicConsumer(consumer, router, opts...)
+}
+
+// NewSaramaClusterMultiTopicConsumer returns a MultiTopicConsumer created from
+// an existing cluster.Consumer, which should be subscribed to the topics of
+// the Router.
+func NewSaramaClusterMultiTopicConsumer(consumer SaramaClusterConsumer, router *Router, opts ...ConsumerOption) (MultiTopicConsumer, error) {
+	c := &saramaClusterDatabusConsumer{
+		con:             consumer,
+		consumerOptions: newConsumerOptions(opts),
+		done:            make(chan struct{}),
+	}
+	go c.watch()
+	return &routedConsumer{source: c, router: router, consumerOptions: c.consumerOptions}, nil
+}
+
+// NewMemoryMultiTopicConsumer returns a MultiTopicConsumer that receives
+// messages from the topics of a Router on a MemoryBroker, as a member of the
+// consumer group provided.
+func NewMemoryMultiTopicConsumer(broker *MemoryBroker, router *Router, groupId string, opts ...ConsumerOption) (MultiTopicConsumer, error) {
+	c := &memoryDatabusConsumer{
+		broker:          broker,
+		topics:          router.Topics(),
+		group:           groupId,
+		closed:          make(chan struct{}),
+		consumerOptions: newConsumerOptions(opts),
+	}
+	c.join()
+	return &routedConsumer{source: c, router: router, consumerOptions: c.consumerOptions}, nil
+}
+
+// routedSource is a MessageSource that can be closed.
+type routedSource interface {
+	MessageSource
+	Close() error
+}
+
+// routedConsumer is a MultiTopicConsumer that receives messages from a
+// consumer of several topics and decodes them as its Router routes them.
+type routedConsumer struct {
+	source routedSource
+	router *Router
+	*consumerOptions
+}
+
+func (c *routedConsumer) ConsumeAny(ctx context.Context) (interface{}, error) {
+	for {
+		msg, err := c.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := c.router.Decode(msg)
+		if err == nil {
+			return v, c.MarkOffset(msg)
+		}
+		err = errors.Wrap(err, "failed to decode message")
+		if c.failurePolicy == nil {
+			return nil, err
+		}
+		// Let the failure policy deal with the message and move on
+		if err := handleFailure(ctx, c.failurePolicy, c, msg, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Receive returns the next message from any of the consumer's topics, to be
+// decoded with the factory routed for its topic.
+func (c *routedConsumer) Receive(ctx context.Context) (*ReceivedMessage, error) {
+	msg, err := c.source.Receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg.factory = c.router.Factory(msg.Topic())
+	return msg, nil
+}
+
+// MarkOffset marks a message as processed.
+func (c *routedConsumer) MarkOffset(msg *ReceivedMessage) error {
+	return c.source.MarkOffset(msg)
+}
+
+func (c *routedConsumer) Close() error {
+	return c.source.Close()
+}
@@ -0,0 +1,274 @@
+package databus_test
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type routedCreated struct {
+	Key   string         `zenkit:"message-key"`
+	Value subjectCreated `zenkit:"message-value"`
+}
+
+type routedDeleted struct {
+	Key   string         `zenkit:"message-key"`
+	Value subjectDeleted `zenkit:"message-value"`
+}
+
+type jsonCreated struct {
+	ID string `json:"id"`
+}
+
+func (jsonCreated) RecordName() string {
+	return "Created"
+}
+
+type jsonDeleted struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+func (jsonDeleted) RecordName() string {
+	return "Deleted"
+}
+
+type jsonRoutedCreated struct {
+	Key   jsonKey     `zenkit:"message-key"`
+	Value jsonCreated `zenkit:"message-value"`
+}
+
+type jsonRoutedDeleted struct {
+	Key   jsonKey     `zenkit:"message-key"`
+	Value jsonDeleted `zenkit:"message-value"`
+}
+
+type protobufRoutedString struct {
+	Key   *wrappers.StringValue `zenkit:"message-key"`
+	Value *wrappers.StringValue `zenkit:"message-value"`
+}
+
+type protobufRoutedInt64 struct {
+	Key   *wrappers.StringValue `zenkit:"message-key"`
+	Value *wrappers.Int64Value  `zenkit:"message-value"`
+}
+
+// wrappersProto declares the messages of google/protobuf/wrappers.proto, in
+// order, so the message indexes of the generated wrappers select them.
+const wrappersProto = `syntax = "proto3";
+package google.protobuf;
+message DoubleValue { double value = 1; }
+message FloatValue { float value = 1; }
+message Int64Value { int64 value = 1; }
+message UInt64Value { uint64 value = 1; }
+message Int32Value { int32 value = 1; }
+message UInt32Value { uint32 value = 1; }
+message BoolValue { bool value = 1; }
+message StringValue { string value = 1; }
+message BytesValue { bytes value = 1; }`
+
+var _ = Describe("Router", func() {
+
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		client  *MemorySchemaRegistryClient
+		broker  *MemoryBroker
+		events  MessageFactory
+		factory MessageFactory
+		router  *Router
+		topic   string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		client = NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		client.RegisterNewSchema("events-key", `"string"`)
+		for _, v := range []interface{}{subjectCreated{}, subjectDeleted{}, subjectRenamed{}} {
+			schema, err := SchemaFor(v)
+			Ω(err).ShouldNot(HaveOccurred())
+			name, err := RecordName(v)
+			Ω(err).ShouldNot(HaveOccurred())
+			client.RegisterNewSchema(name, schema)
+		}
+		var err error
+		events, err = NewMessageFactoryWithStrategy("events", TopicNameStrategy, RecordNameStrategy, client)
+		Ω(err).ShouldNot(HaveOccurred())
+		topic = test.RandString(8)
+		factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		broker = NewMemoryBroker(1)
+
+		router = NewRouter()
+		Ω(router.Route(events, routedCreated{})).Should(Succeed())
+		Ω(router.Route(events, &routedDeleted{})).Should(Succeed())
+		Ω(router.Route(factory, memoryTestMessage{})).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should list the topics routed", func() {
+		Ω(router.Topics()).Should(ConsistOf("events", topic))
+		Ω(router.Factory("events")).Should(Equal(events))
+		Ω(router.Factory("nope")).Should(BeNil())
+	})
+
+	It("should reject invalid and duplicate routes", func() {
+		Ω(errors.Cause(router.Route(factory, "nope"))).Should(Equal(ErrInvalidMessageType))
+		Ω(errors.Cause(router.Route(events, routedCreated{}))).Should(Equal(ErrDuplicateRoute))
+		other, err := NewMessageFactory("events", "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(router.Route(other, memoryTestMessage{})).ShouldNot(Succeed())
+	})
+
+	It("should route messages by topic and writer schema", func() {
+		producer := NewMemoryDatabusProducer(broker, events)
+		Ω(producer.Send("a", subjectCreated{ID: "a"})).Should(Succeed())
+		Ω(producer.Send("a", subjectDeleted{ID: "a", Reason: "gone"})).Should(Succeed())
+		Ω(NewMemoryDatabusProducer(broker, factory).Send(KeyTest{}, ValTest{TotallyCool: "yes"})).Should(Succeed())
+
+		consumer, err := NewMemoryMultiTopicConsumer(broker, router, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		var received []interface{}
+		for i := 0; i < 3; i++ {
+			v, err := consumer.ConsumeAny(ctx)
+			Ω(err).ShouldNot(HaveOccurred())
+			received = append(received, v)
+		}
+		Ω(received).Should(ConsistOf(
+			&routedCreated{Key: "a", Value: subjectCreated{ID: "a"}},
+			&routedDeleted{Key: "a", Value: subjectDeleted{ID: "a", Reason: "gone"}},
+			&memoryTestMessage{Value: ValTest{TotallyCool: "yes"}},
+		))
+		Ω(broker.Offsets("group", "events")[0]).Should(BeNumerically("==", 2))
+		Ω(broker.Offsets("group", topic)[0]).Should(BeNumerically("==", 1))
+	})
+
+	It("should leave records without a route to the failure policy", func() {
+		producer := NewMemoryDatabusProducer(broker, events)
+		Ω(producer.Send("a", subjectRenamed{Name: "b"})).Should(Succeed())
+		Ω(producer.Send("a", subjectCreated{ID: "a"})).Should(Succeed())
+
+		consumer, err := NewMemoryMultiTopicConsumer(broker, router, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = consumer.ConsumeAny(ctx)
+		Ω(errors.Cause(err)).Should(Equal(ErrNoRoute))
+		consumer.Close()
+
+		consumer, err = NewMemoryMultiTopicConsumer(broker, router, "group", WithFailurePolicy(SkipFailures()))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		v, err := consumer.ConsumeAny(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(v).Should(Equal(&routedCreated{Key: "a", Value: subjectCreated{ID: "a"}}))
+	})
+
+	It("should subscribe to topics matching a pattern", func() {
+		broker.Send(NewMessage("extra-"+topic, []byte("k"), []byte("v")))
+		consumer, err := NewMemoryMultiTopicConsumer(broker, router, "group", WithTopicPattern(regexp.MustCompile(`^extra-`)))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		_, err = consumer.ConsumeAny(ctx)
+		Ω(errors.Cause(err)).Should(Equal(ErrNoRoute))
+	})
+
+	It("should decode messages received for Subscribe with their topic's factory", func() {
+		Ω(NewMemoryDatabusProducer(broker, factory).Send(KeyTest{}, ValTest{TotallyCool: "yes"})).Should(Succeed())
+		consumer, err := NewMemoryMultiTopicConsumer(broker, router, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		msg, err := consumer.(MessageSource).Receive(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		var v memoryTestMessage
+		Ω(msg.Decode(&v)).Should(Succeed())
+		Ω(v.Value.TotallyCool).Should(Equal("yes"))
+	})
+
+	It("should route messages from Kafka", func() {
+		mock := newMockClusterConsumer(2)
+		consumer, err := NewSaramaClusterMultiTopicConsumer(mock, router)
+		Ω(err).ShouldNot(HaveOccurred())
+		created, err := events.Message("a", subjectCreated{ID: "a"})
+		Ω(err).ShouldNot(HaveOccurred())
+		message, err := factory.Message(KeyTest{}, ValTest{TotallyCool: "yes"})
+		Ω(err).ShouldNot(HaveOccurred())
+		mock.messages <- &sarama.ConsumerMessage{Topic: "events", Key: created.Key(), Value: created.Value()}
+		mock.messages <- &sarama.ConsumerMessage{Topic: topic, Key: message.Key(), Value: message.Value()}
+
+		v, err := consumer.ConsumeAny(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(v).Should(BeAssignableToTypeOf(&routedCreated{}))
+		v, err = consumer.ConsumeAny(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(v).Should(BeAssignableToTypeOf(&memoryTestMessage{}))
+	})
+
+	Context("with other payload formats", func() {
+
+		var consume = func(n int) []interface{} {
+			consumer, err := NewMemoryMultiTopicConsumer(broker, router, "group")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Close()
+			var received []interface{}
+			for i := 0; i < n; i++ {
+				v, err := consumer.ConsumeAny(ctx)
+				Ω(err).ShouldNot(HaveOccurred())
+				received = append(received, v)
+			}
+			return received
+		}
+
+		It("should route JSON messages by the titles of their schemas", func() {
+			client.RegisterNewSchemaWithFormat("json-key", jsonKeySchema, JSONSchemaFormat)
+			client.RegisterNewSchemaWithFormat("json-created", `{"title": "Created", "type": "object"}`, JSONSchemaFormat)
+			client.RegisterNewSchemaWithFormat("json-deleted", `{"title": "Deleted", "type": "object"}`, JSONSchemaFormat)
+			created, err := NewMessageFactoryWithFormat("json-events", "json-key", "json-created", JSONSchemaFormat, client)
+			Ω(err).ShouldNot(HaveOccurred())
+			deleted, err := NewMessageFactoryWithFormat("json-events", "json-key", "json-deleted", JSONSchemaFormat, client)
+			Ω(err).ShouldNot(HaveOccurred())
+			router = NewRouter()
+			Ω(router.Route(created, jsonRoutedCreated{})).Should(Succeed())
+			Ω(router.Route(created, jsonRoutedDeleted{})).Should(Succeed())
+
+			Ω(NewMemoryDatabusProducer(broker, created).Send(jsonKey{"a"}, jsonCreated{ID: "a"})).Should(Succeed())
+			Ω(NewMemoryDatabusProducer(broker, deleted).Send(jsonKey{"a"}, jsonDeleted{ID: "a", Reason: "gone"})).Should(Succeed())
+			Ω(consume(2)).Should(ConsistOf(
+				&jsonRoutedCreated{Key: jsonKey{"a"}, Value: jsonCreated{ID: "a"}},
+				&jsonRoutedDeleted{Key: jsonKey{"a"}, Value: jsonDeleted{ID: "a", Reason: "gone"}},
+			))
+		})
+
+		It("should route protobuf messages by their message types", func() {
+			client.RegisterNewSchemaWithFormat("proto-key", wrappersProto, ProtobufFormat)
+			client.RegisterNewSchemaWithFormat("proto-value", wrappersProto, ProtobufFormat)
+			wrapped, err := NewMessageFactoryWithFormat("proto-events", "proto-key", "proto-value", ProtobufFormat, client)
+			Ω(err).ShouldNot(HaveOccurred())
+			router = NewRouter()
+			Ω(router.Route(wrapped, protobufRoutedString{})).Should(Succeed())
+			Ω(router.Route(wrapped, protobufRoutedInt64{})).Should(Succeed())
+
+			producer := NewMemoryDatabusProducer(broker, wrapped)
+			Ω(producer.Send(&wrappers.StringValue{Value: "a"}, &wrappers.Int64Value{Value: 42})).Should(Succeed())
+			Ω(producer.Send(&wrappers.StringValue{Value: "a"}, &wrappers.StringValue{Value: "b"})).Should(Succeed())
+			received := consume(2)
+			Ω(received[0]).Should(BeAssignableToTypeOf(&protobufRoutedInt64{}))
+			Ω(received[0].(*protobufRoutedInt64).Value.Value).Should(BeNumerically("==", 42))
+			Ω(received[1]).Should(BeAssignableToTypeOf(&protobufRoutedString{}))
+			Ω(received[1].(*protobufRoutedString).Value.Value).Should(Equal("b"))
+		})
+	})
+})
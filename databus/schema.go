@@ -37,8 +37,12 @@ type SchemaCodec interface {
 
 // GetCodec retrieves the Avro schema with the subject specified from a schema
 // registry. It returns a codec that can be used to decode from binary or text
-// to Go native types.
+// to Go native types. If the client is a SchemaCache, the schema and codec are
+// cached.
 func GetCodec(client schemaregistry.Client, subject string) (int, SchemaCodec, error) {
+	if c, ok := client.(*SchemaCache); ok {
+		return c.LatestCodec(subject)
+	}
 	schema, err := client.GetLatestSchema(subject)
 	if err != nil {
 		return 0, nil, errors.Wrapf(err, "failed to get latest schema for subject %s", subject)
@@ -0,0 +1,342 @@
+package databus
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/linkedin/goavro"
+	"github.com/pkg/errors"
+)
+
+// DefaultSchemaCacheTTL is how long a SchemaCache uses the latest schema of a
+// subject before fetching it again, unless its options say otherwise.
+const DefaultSchemaCacheTTL = 5 * time.Minute
+
+var sharedSchemaCaches = struct {
+	sync.Mutex
+	caches map[string]*SchemaCache
+}{caches: map[string]*SchemaCache{}}
+
+// SchemaCacheOptions configures a SchemaCache.
+type SchemaCacheOptions struct {
+	// TTL is how long the latest schema of a subject is used before it is
+	// fetched again in the background. Zero means DefaultSchemaCacheTTL, and
+	// a negative TTL keeps the latest schemas until the cache is closed.
+	TTL time.Duration
+	// OnError, if set, is called with errors refreshing the latest schemas.
+	OnError func(error)
+}
+
+// SchemaCache is a thread-safe schemaregistry.Client that caches the schemas
+// of another client, along with the Avro codecs compiled from them. Schemas
+// looked up by ID or by subject and version never change, so they are kept
+// for the life of the cache. The latest schemas of subjects are refreshed in
+// the background once their TTL has passed; until a refresh succeeds, the
+// schemas already cached continue to be used, so a registry that is briefly
+// unavailable doesn't prevent messages being encoded or decoded.
+type SchemaCache struct {
+	client  schemaregistry.Client
+	options SchemaCacheOptions
+
+	mu       sync.RWMutex
+	ids      map[int]string
+	versions map[subjectVersion]schemaregistry.Schema
+	latest   map[string]latestSchema
+	codecs   map[int]SchemaCodec
+	types    map[int]string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	_ schemaregistry.Client = &SchemaCache{}
+	_ SchemaTypeClient      = &SchemaCache{}
+)
+
+type subjectVersion struct {
+	subject string
+	version int
+}
+
+type latestSchema struct {
+	schema  schemaregistry.Schema
+	fetched time.Time
+}
+
+// NewSchemaCache returns a SchemaCache in front of the client provided. Unless
+// its TTL is negative, the cache refreshes the latest schemas in the background
+// until it is closed.
+func NewSchemaCache(client schemaregistry.Client, options SchemaCacheOptions) *SchemaCache {
+	if options.TTL == 0 {
+		options.TTL = DefaultSchemaCacheTTL
+	}
+	c := &SchemaCache{
+		client:   client,
+		options:  options,
+		ids:      map[int]string{},
+		versions: map[subjectVersion]schemaregistry.Schema{},
+		latest:   map[string]latestSchema{},
+		codecs:   map[int]SchemaCodec{},
+		types:    map[int]string{},
+		done:     make(chan struct{}),
+	}
+	if options.TTL > 0 {
+		go c.refresh()
+	}
+	return c
+}
+
+// SharedSchemaCache returns the SchemaCache for the schema registry at the URL
+// provided that is shared by every producer and consumer in the process,
+// creating it the first time it is requested.
+func SharedSchemaCache(registryURL string) (*SchemaCache, error) {
+	sharedSchemaCaches.Lock()
+	defer sharedSchemaCaches.Unlock()
+	if c, ok := sharedSchemaCaches.caches[registryURL]; ok {
+		return c, nil
+	}
+	client, err := NewRegistryClient(registryURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create schema registry client")
+	}
+	c := NewSchemaCache(client, SchemaCacheOptions{})
+	sharedSchemaCaches.caches[registryURL] = c
+	return c, nil
+}
+
+// schemaCacheFor returns the client provided if it is a SchemaCache, or a
+// SchemaCache in front of it that never refreshes otherwise.
+func schemaCacheFor(client schemaregistry.Client) *SchemaCache {
+	if c, ok := client.(*SchemaCache); ok {
+		return c
+	}
+	return NewSchemaCache(client, SchemaCacheOptions{TTL: -1})
+}
+
+// Subjects returns every subject in the registry. It is not cached.
+func (c *SchemaCache) Subjects() ([]string, error) {
+	return c.client.Subjects()
+}
+
+// Versions returns the versions registered for a subject. It is not cached.
+func (c *SchemaCache) Versions(subject string) ([]int, error) {
+	return c.client.Versions(subject)
+}
+
+// RegisterNewSchema registers a schema under a subject. The latest schema
+// cached for the subject is forgotten, so the next lookup fetches it again.
+func (c *SchemaCache) RegisterNewSchema(subject, schema string) (int, error) {
+	id, err := c.client.RegisterNewSchema(subject, schema)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.ids[id] = schema
+	delete(c.latest, subject)
+	c.mu.Unlock()
+	return id, nil
+}
+
+// IsRegistered reports whether a schema is registered under a subject. It is
+// not cached.
+func (c *SchemaCache) IsRegistered(subject, schema string) (bool, schemaregistry.Schema, error) {
+	return c.client.IsRegistered(subject, schema)
+}
+
+// GetSchemaById returns the schema with the ID provided.
+func (c *SchemaCache) GetSchemaById(id int) (string, error) {
+	c.mu.RLock()
+	schema, ok := c.ids[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+	schema, err := c.client.GetSchemaById(id)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.ids[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+// SchemaType returns the type of the schema with the ID provided: AVRO, JSON
+// or PROTOBUF. If the cache's client isn't a SchemaTypeClient, the type is
+// guessed from the schema: Avro if it compiles as Avro, JSON if it is JSON,
+// and Protocol Buffers otherwise.
+func (c *SchemaCache) SchemaType(id int) (string, error) {
+	c.mu.RLock()
+	typ, ok := c.types[id]
+	c.mu.RUnlock()
+	if ok {
+		return typ, nil
+	}
+	if client, ok := c.client.(SchemaTypeClient); ok {
+		var err error
+		if typ, err = client.SchemaType(id); err != nil {
+			return "", err
+		}
+	} else {
+		schema, err := c.GetSchemaById(id)
+		if err != nil {
+			return "", err
+		}
+		typ = guessSchemaType(schema)
+	}
+	c.mu.Lock()
+	c.types[id] = typ
+	c.mu.Unlock()
+	return typ, nil
+}
+
+// GetSchemaBySubject returns a version of the schema of a subject.
+func (c *SchemaCache) GetSchemaBySubject(subject string, ver int) (schemaregistry.Schema, error) {
+	key := subjectVersion{subject, ver}
+	c.mu.RLock()
+	s, ok := c.versions[key]
+	c.mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+	s, err := c.client.GetSchemaBySubject(subject, ver)
+	if err != nil {
+		return s, err
+	}
+	c.mu.Lock()
+	c.versions[key] = s
+	c.ids[s.Id] = s.Schema
+	c.mu.Unlock()
+	return s, nil
+}
+
+// GetLatestSchema returns the latest schema of a subject, as of the last time
+// it was fetched.
+func (c *SchemaCache) GetLatestSchema(subject string) (schemaregistry.Schema, error) {
+	c.mu.RLock()
+	l, ok := c.latest[subject]
+	c.mu.RUnlock()
+	if ok {
+		return l.schema, nil
+	}
+	return c.fetchLatest(subject)
+}
+
+// Codec returns the compiled Avro codec for the schema with the ID provided.
+func (c *SchemaCache) Codec(id int) (SchemaCodec, error) {
+	c.mu.RLock()
+	codec, ok := c.codecs[id]
+	c.mu.RUnlock()
+	if ok {
+		return codec, nil
+	}
+	schema, err := c.GetSchemaById(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get schema %d", id)
+	}
+	return c.compile(id, schema)
+}
+
+// LatestCodec returns the ID of the latest schema of a subject and the
+// compiled Avro codec for it.
+func (c *SchemaCache) LatestCodec(subject string) (int, SchemaCodec, error) {
+	s, err := c.GetLatestSchema(subject)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "failed to get latest schema for subject %s", subject)
+	}
+	c.mu.RLock()
+	codec, ok := c.codecs[s.Id]
+	c.mu.RUnlock()
+	if ok {
+		return s.Id, codec, nil
+	}
+	codec, err = c.compile(s.Id, s.Schema)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.Id, codec, nil
+}
+
+// Close stops refreshing the latest schemas in the background.
+func (c *SchemaCache) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *SchemaCache) compile(id int, schema string) (SchemaCodec, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create codec")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.codecs[id]; ok {
+		return existing, nil
+	}
+	c.codecs[id] = codec
+	return codec, nil
+}
+
+// guessSchemaType returns the type of a schema registered by a client that
+// doesn't report it.
+func guessSchemaType(schema string) string {
+	if _, err := goavro.NewCodec(schema); err == nil {
+		return AvroFormat.Name()
+	}
+	if json.Valid([]byte(schema)) {
+		return JSONSchemaFormat.Name()
+	}
+	return ProtobufFormat.Name()
+}
+
+func (c *SchemaCache) fetchLatest(subject string) (schemaregistry.Schema, error) {
+	s, err := c.client.GetLatestSchema(subject)
+	if err != nil {
+		return s, err
+	}
+	c.mu.Lock()
+	c.latest[subject] = latestSchema{s, time.Now()}
+	c.ids[s.Id] = s.Schema
+	c.mu.Unlock()
+	return s, nil
+}
+
+// refresh fetches the latest schemas whose TTL has passed until the cache is
+// closed. A schema that can't be fetched is kept until it can.
+func (c *SchemaCache) refresh() {
+	interval := c.options.TTL / 2
+	if interval <= 0 {
+		interval = c.options.TTL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			for _, subject := range c.expired(now) {
+				if _, err := c.fetchLatest(subject); err != nil && c.options.OnError != nil {
+					c.options.OnError(errors.Wrapf(err, "failed to refresh latest schema for subject %s", subject))
+				}
+			}
+		}
+	}
+}
+
+// expired returns the subjects whose latest schemas were fetched more than a
+// TTL before the time provided.
+func (c *SchemaCache) expired(now time.Time) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var subjects []string
+	for subject, l := range c.latest {
+		if now.Sub(l.fetched) >= c.options.TTL {
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
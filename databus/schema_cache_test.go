@@ -0,0 +1,239 @@
+package databus_test
+
+import (
+	"sync"
+	"time"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingClient counts the lookups that reach a schema registry client, and
+// can be made to fail them.
+type countingClient struct {
+	*MemorySchemaRegistryClient
+	mu      sync.Mutex
+	lookups int
+	down    bool
+}
+
+func (c *countingClient) lookup() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lookups++
+	if c.down {
+		return errors.New("registry is down")
+	}
+	return nil
+}
+
+func (c *countingClient) Lookups() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookups
+}
+
+func (c *countingClient) SetDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+func (c *countingClient) GetLatestSchema(subject string) (schemaregistry.Schema, error) {
+	if err := c.lookup(); err != nil {
+		return schemaregistry.Schema{}, err
+	}
+	return c.MemorySchemaRegistryClient.GetLatestSchema(subject)
+}
+
+func (c *countingClient) GetSchemaById(id int) (string, error) {
+	if err := c.lookup(); err != nil {
+		return "", err
+	}
+	return c.MemorySchemaRegistryClient.GetSchemaById(id)
+}
+
+var _ = Describe("SchemaCache", func() {
+
+	var (
+		client *countingClient
+		cache  *SchemaCache
+		errs   chan error
+	)
+
+	BeforeEach(func() {
+		client = &countingClient{MemorySchemaRegistryClient: NewMemorySchemaRegistryClient()}
+		client.RegisterNewSchema("object-key", `"string"`)
+		client.RegisterNewSchema("object-value", valTestSchema)
+		errs = make(chan error, 10)
+		// The refresh goroutine may outlive the test, so don't share errs
+		reported := errs
+		cache = NewSchemaCache(client, SchemaCacheOptions{
+			TTL: 20 * time.Millisecond,
+			OnError: func(err error) {
+				select {
+				case reported <- err:
+				default:
+				}
+			},
+		})
+	})
+
+	AfterEach(func() {
+		cache.Close()
+	})
+
+	It("should fetch each schema and compile each codec once", func() {
+		id, codec, err := cache.LatestCodec("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		id2, codec2, err := cache.LatestCodec("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(id2).Should(Equal(id))
+		Ω(codec2).Should(BeIdenticalTo(codec))
+
+		byID, err := cache.Codec(id)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(byID).Should(BeIdenticalTo(codec))
+		Ω(client.Lookups()).Should(Equal(1))
+	})
+
+	It("should share schemas between the factories using it", func() {
+		first, err := NewMessageFactory("first", "object-key", "object-value", cache)
+		Ω(err).ShouldNot(HaveOccurred())
+		second, err := NewMessageFactory("second", "object-key", "object-value", cache)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(client.Lookups()).Should(Equal(2))
+		Ω(first.(SchemaCacheHaver).SchemaCache()).Should(BeIdenticalTo(cache))
+		Ω(second.ValueCodec()).Should(BeIdenticalTo(first.ValueCodec()))
+	})
+
+	It("should decode messages written with other schemas from the cache", func() {
+		writer, err := NewMessageFactory("topic", "object-key", "object-value", cache)
+		Ω(err).ShouldNot(HaveOccurred())
+		msg, err := writer.Message("key", ValTest{TotallyCool: "yes"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		client.RegisterNewSchema("object-value-v2", valTestV2Schema)
+		reader, err := NewMessageFactory("topic", "object-key", "object-value-v2", cache)
+		Ω(err).ShouldNot(HaveOccurred())
+		lookups := client.Lookups()
+
+		var (
+			key string
+			val ValTestV2
+		)
+		Ω(reader.Decode(msg, &key, &val)).Should(Succeed())
+		Ω(val).Should(Equal(ValTestV2{TotallyCool: "yes", Count: 42}))
+		Ω(client.Lookups()).Should(Equal(lookups))
+	})
+
+	It("should keep encoding with the schemas a factory was created with", func() {
+		factory, err := NewMessageFactory("topic", "object-key", "object-value", cache)
+		Ω(err).ShouldNot(HaveOccurred())
+		msg, err := factory.Message("key", ValTest{TotallyCool: "yes"})
+		Ω(err).ShouldNot(HaveOccurred())
+		oldID, _, _ := DeserializePayload(msg.Value())
+		_, err = cache.RegisterNewSchema("object-value", valTestV2Schema)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Consistently(func() int {
+			msg, err := factory.Message("key", ValTest{TotallyCool: "yes"})
+			Ω(err).ShouldNot(HaveOccurred())
+			id, _, _ := DeserializePayload(msg.Value())
+			return id
+		}, 100*time.Millisecond).Should(Equal(oldID))
+		Ω(factory.ValueCodec().Schema()).Should(MatchJSON(valTestSchema))
+	})
+
+	It("should refresh the schemas of the factories using the latest schemas", func() {
+		factory, err := NewMessageFactory("topic", "object-key", "object-value", cache, WithLatestSchemas())
+		Ω(err).ShouldNot(HaveOccurred())
+		old, err := factory.Message("key", ValTestV2{TotallyCool: "yes", Count: 1})
+		Ω(err).ShouldNot(HaveOccurred())
+		newID, err := client.RegisterNewSchema("object-value", valTestV2Schema)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Eventually(func() int {
+			msg, err := factory.Message("key", ValTestV2{TotallyCool: "yes", Count: 1})
+			if err != nil {
+				return 0
+			}
+			id, _, _ := AvroDeserialize(msg.Value())
+			return id
+		}).Should(Equal(newID))
+		Ω(factory.ValueCodec().Schema()).Should(MatchJSON(valTestV2Schema))
+
+		var (
+			key string
+			val ValTestV2
+		)
+		Ω(factory.Decode(old, &key, &val)).Should(Succeed())
+		Ω(val).Should(Equal(ValTestV2{TotallyCool: "yes", Count: 42}))
+	})
+
+	It("should refresh the latest schemas once their TTL has passed", func() {
+		id, _, err := cache.LatestCodec("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		newID, err := client.RegisterNewSchema("object-value", valTestV2Schema)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(newID).ShouldNot(Equal(id))
+		Eventually(func() int {
+			s, _ := cache.GetLatestSchema("object-value")
+			return s.Id
+		}).Should(Equal(newID))
+	})
+
+	It("should keep using cached schemas while the registry is unavailable", func() {
+		id, _, err := cache.LatestCodec("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		client.SetDown(true)
+		Eventually(errs).Should(Receive())
+		s, err := cache.GetLatestSchema("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(s.Id).Should(Equal(id))
+		_, err = cache.GetLatestSchema("object-key")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should fetch the latest schema again after registering a new one", func() {
+		_, _, err := cache.LatestCodec("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		newID, err := cache.RegisterNewSchema("object-value", valTestV2Schema)
+		Ω(err).ShouldNot(HaveOccurred())
+		id, _, err := cache.LatestCodec("object-value")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(id).Should(Equal(newID))
+	})
+
+	It("should look up the types of schemas", func() {
+		id, err := client.RegisterNewSchemaWithFormat("json-value", jsonValSchema, JSONSchemaFormat)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cache.SchemaType(id)).Should(Equal("JSON"))
+		Ω(cache.SchemaType(1)).Should(Equal("AVRO"))
+		_, err = cache.SchemaType(99)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should guess the types of schemas from clients that don't report them", func() {
+		jsonID, err := client.RegisterNewSchemaWithFormat("json-value", jsonValSchema, JSONSchemaFormat)
+		Ω(err).ShouldNot(HaveOccurred())
+		protoID, err := client.RegisterNewSchemaWithFormat("proto-value", `syntax = "proto3"; message A {}`, ProtobufFormat)
+		Ω(err).ShouldNot(HaveOccurred())
+		guessing := NewSchemaCache(struct{ schemaregistry.Client }{client}, SchemaCacheOptions{TTL: -1})
+		Ω(guessing.SchemaType(1)).Should(Equal("AVRO"))
+		Ω(guessing.SchemaType(jsonID)).Should(Equal("JSON"))
+		Ω(guessing.SchemaType(protoID)).Should(Equal("PROTOBUF"))
+	})
+
+	It("should return the same shared cache for a registry", func() {
+		first, err := SharedSchemaCache("http://registry:8081")
+		Ω(err).ShouldNot(HaveOccurred())
+		second, err := SharedSchemaCache("http://registry:8081")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(second).Should(BeIdenticalTo(first))
+	})
+})
@@ -0,0 +1,202 @@
+package databus
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnsupportedType is returned by SchemaFor for Go types that have no
+	// Avro equivalent, such as channels, functions and interfaces.
+	ErrUnsupportedType = errors.New("type has no avro schema")
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// SchemaFor derives an Avro schema from the type of v, which is typically a
+// struct. Structs become records named after their type, in a namespace named
+// after their package, with a field for each exported field. Fields are named
+// and skipped according to their `json` tags, as messages are encoded, and
+// the fields of embedded structs are promoted. Pointers become unions with
+// null that default to null, slices and arrays become arrays, maps with string
+// keys become maps, []byte becomes bytes and time.Time becomes a long with the
+// timestamp-millis logical type. Unsigned integers become an int or a long,
+// and values too large for a long fail to encode rather than wrapping. A
+// record that appears more than once is referred to by name after it is first
+// defined.
+func SchemaFor(v interface{}) (string, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return "", errors.Wrap(ErrUnsupportedType, "nil has no type")
+	}
+	schema, err := newSchemaGenerator().schema(t)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal schema")
+	}
+	return string(b), nil
+}
+
+// RegisterTypes registers the schemas derived by SchemaFor from the key and
+// value provided under the subjects provided.
+func RegisterTypes(registry SchemaRegistry, keySubject string, key interface{}, valueSubject string, value interface{}) error {
+	keySchema, err := SchemaFor(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to derive key schema")
+	}
+	valueSchema, err := SchemaFor(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to derive value schema")
+	}
+	return registry.Register(keySubject, keySchema, valueSubject, valueSchema)
+}
+
+// schemaGenerator derives Avro schemas from Go types, remembering the records
+// it has defined.
+type schemaGenerator struct {
+	defined map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{defined: map[reflect.Type]string{}}
+}
+
+// schema returns the schema for a type as native Go types.
+func (g *schemaGenerator) schema(t reflect.Type) (interface{}, error) {
+	if t == timeType {
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}, nil
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		return "bytes", nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "int", nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "long", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Ptr:
+		elem, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := elem.([]interface{}); ok {
+			return nil, errors.Wrapf(ErrUnsupportedType, "%s is a pointer to a union", t)
+		}
+		return []interface{}{"null", elem}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Wrapf(ErrUnsupportedType, "%s does not have string keys", t)
+		}
+		values, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "map", "values": values}, nil
+	case reflect.Struct:
+		return g.record(t)
+	}
+	return nil, errors.Wrapf(ErrUnsupportedType, "%s", t)
+}
+
+// record returns the schema for a struct, or its full name if it has already
+// been defined.
+func (g *schemaGenerator) record(t reflect.Type) (interface{}, error) {
+	if name, ok := g.defined[t]; ok {
+		return name, nil
+	}
+	if t.Name() == "" {
+		return nil, errors.Wrapf(ErrUnsupportedType, "%s is an anonymous struct", t)
+	}
+	name := avroName(t.Name())
+	namespace := avroName(path.Base(t.PkgPath()))
+	g.defined[t] = namespace + "." + name
+
+	fields, err := g.fields(t)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"type":      "record",
+		"name":      name,
+		"namespace": namespace,
+		"fields":    fields,
+	}, nil
+}
+
+// fields returns the schemas for the fields of a struct, following the rules
+// encoding/json uses to name and promote them.
+func (g *schemaGenerator) fields(t reflect.Type) ([]interface{}, error) {
+	fields := []interface{}{}
+	for _, f := range cachedFields(t) {
+		var schema interface{} = "string"
+		if !f.asString {
+			s, err := g.schema(f.typ)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to derive schema for field %s of %s", f.name, t)
+			}
+			schema = s
+		}
+		field := map[string]interface{}{"name": f.name, "type": schema}
+		if f.typ.Kind() == reflect.Ptr {
+			field["default"] = nil
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// hasOption reports whether a comma-separated list of tag options contains
+// the option provided.
+func hasOption(opts, option string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isScalar reports whether the `json:",string"` option applies to a type.
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	}
+	return false
+}
+
+// avroName replaces the characters of a Go name that aren't allowed in Avro
+// names, such as the brackets of generic types.
+func avroName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
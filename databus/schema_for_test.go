@@ -0,0 +1,181 @@
+package databus_test
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/linkedin/goavro"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type schemaForBase struct {
+	ID string `json:"id"`
+}
+
+type schemaForChild struct {
+	Name   string
+	Parent *schemaForChild
+}
+
+type schemaForTest struct {
+	schemaForBase
+	Name     string `json:"name"`
+	Skipped  string `json:"-"`
+	Count    int64
+	Small    int32
+	Ratio    float64
+	Enabled  bool
+	Optional *string `json:"optional,omitempty"`
+	Raw      []byte
+	Tags     []string
+	Labels   map[string]string
+	At       time.Time
+	Child    schemaForChild
+	Children []schemaForChild
+	hidden   string
+}
+
+type schemaForUnsigned struct {
+	Count uint64
+	Size  uint
+}
+
+type recordingRegistry struct {
+	schemas map[string]string
+}
+
+func (r *recordingRegistry) Register(keySubject, keySchema, valueSubject, valueSchema string) error {
+	r.schemas = map[string]string{keySubject: keySchema, valueSubject: valueSchema}
+	return nil
+}
+
+var _ = Describe("SchemaFor", func() {
+
+	fields := func(schema string) map[string]interface{} {
+		var record map[string]interface{}
+		Ω(json.Unmarshal([]byte(schema), &record)).Should(Succeed())
+		result := map[string]interface{}{}
+		for _, f := range record["fields"].([]interface{}) {
+			field := f.(map[string]interface{})
+			result[field["name"].(string)] = field["type"]
+		}
+		return result
+	}
+
+	It("should derive a valid record schema from a struct", func() {
+		schema, err := SchemaFor(schemaForTest{})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = goavro.NewCodec(schema)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var record map[string]interface{}
+		Ω(json.Unmarshal([]byte(schema), &record)).Should(Succeed())
+		Ω(record["name"]).Should(Equal("schemaForTest"))
+		Ω(record["namespace"]).Should(Equal("databus_test"))
+
+		f := fields(schema)
+		Ω(f).Should(HaveLen(13))
+		Ω(f).ShouldNot(HaveKey("Skipped"))
+		Ω(f).ShouldNot(HaveKey("hidden"))
+		Ω(f["id"]).Should(Equal("string"))
+		Ω(f["name"]).Should(Equal("string"))
+		Ω(f["Count"]).Should(Equal("long"))
+		Ω(f["Small"]).Should(Equal("int"))
+		Ω(f["Ratio"]).Should(Equal("double"))
+		Ω(f["Enabled"]).Should(Equal("boolean"))
+		Ω(f["optional"]).Should(Equal([]interface{}{"null", "string"}))
+		Ω(f["Raw"]).Should(Equal("bytes"))
+		Ω(f["Tags"]).Should(Equal(map[string]interface{}{"type": "array", "items": "string"}))
+		Ω(f["Labels"]).Should(Equal(map[string]interface{}{"type": "map", "values": "string"}))
+		Ω(f["At"]).Should(Equal(map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}))
+		Ω(f["Children"]).Should(Equal(map[string]interface{}{"type": "array", "items": "databus_test.schemaForChild"}))
+	})
+
+	It("should refer to recursive records by name", func() {
+		schema, err := SchemaFor(schemaForChild{})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = goavro.NewCodec(schema)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(fields(schema)["Parent"]).Should(Equal([]interface{}{"null", "databus_test.schemaForChild"}))
+	})
+
+	It("should derive schemas for primitive types", func() {
+		schema, err := SchemaFor("")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(schema).Should(Equal(`"string"`))
+	})
+
+	It("should derive schemas that encode messages", func() {
+		client := NewMemorySchemaRegistryClient()
+		keySchema, err := SchemaFor(KeyTest{})
+		Ω(err).ShouldNot(HaveOccurred())
+		valSchema, err := SchemaFor(ValTest{})
+		Ω(err).ShouldNot(HaveOccurred())
+		client.RegisterNewSchema("key-test", keySchema)
+		client.RegisterNewSchema("val-test", valSchema)
+		factory, err := NewMessageFactory("topic", "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		msg, err := factory.Message(KeyTest{SomeString: "a", AnInt: 1}, ValTest{TotallyCool: "b"})
+		Ω(err).ShouldNot(HaveOccurred())
+		var key KeyTest
+		var val ValTest
+		Ω(factory.Decode(msg, &key, &val)).Should(Succeed())
+		Ω(key).Should(Equal(KeyTest{SomeString: "a", AnInt: 1}))
+		Ω(val).Should(Equal(ValTest{TotallyCool: "b"}))
+	})
+
+	It("should reject unsigned values that don't fit in a long", func() {
+		client := NewMemorySchemaRegistryClient()
+		keySchema, err := SchemaFor("")
+		Ω(err).ShouldNot(HaveOccurred())
+		valSchema, err := SchemaFor(schemaForUnsigned{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(fields(valSchema)["Count"]).Should(Equal("long"))
+		client.RegisterNewSchema("key-unsigned", keySchema)
+		client.RegisterNewSchema("val-unsigned", valSchema)
+		factory, err := NewMessageFactory("topic", "key-unsigned", "val-unsigned", client)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		max := schemaForUnsigned{Count: math.MaxInt64, Size: math.MaxInt64}
+		msg, err := factory.Message("a", max)
+		Ω(err).ShouldNot(HaveOccurred())
+		var (
+			key string
+			val schemaForUnsigned
+		)
+		Ω(factory.Decode(msg, &key, &val)).Should(Succeed())
+		Ω(val).Should(Equal(max))
+
+		_, err = factory.Message("a", schemaForUnsigned{Count: math.MaxInt64 + 1})
+		Ω(err).Should(HaveOccurred())
+		_, err = factory.Message("a", schemaForUnsigned{Size: math.MaxInt64 + 1})
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should fail for types with no Avro equivalent", func() {
+		for _, v := range []interface{}{
+			nil,
+			make(chan int),
+			map[int]string{},
+			struct{ Name string }{},
+			struct{ F func() }{},
+		} {
+			_, err := SchemaFor(v)
+			Ω(errors.Cause(err)).Should(Equal(ErrUnsupportedType))
+		}
+	})
+
+	It("should register schemas derived from types", func() {
+		registry := &recordingRegistry{}
+		Ω(RegisterTypes(registry, "key-test", KeyTest{}, "val-test", ValTest{})).Should(Succeed())
+		keySchema, _ := SchemaFor(KeyTest{})
+		Ω(registry.schemas).Should(HaveKeyWithValue("key-test", keySchema))
+		Ω(registry.schemas).Should(HaveKey("val-test"))
+	})
+})
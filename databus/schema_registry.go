@@ -20,6 +20,11 @@ const (
 var (
 	// ErrFactoryNotFound occurs when the schema registry factory is not on the context
 	ErrFactoryNotFound = errors.New("schema registry factory not found on context")
+
+	// ErrCompatibilityUnsupported is returned when checking compatibility
+	// with a schema registry client that doesn't implement
+	// CompatibilityClient.
+	ErrCompatibilityUnsupported = errors.New("schema registry client can't check compatibility")
 )
 
 // WithSchemaRegistryFactory returns the context with a schema registry factory attached
@@ -43,26 +48,65 @@ type SchemaRegistry interface {
 	Register(KeySubject string, KeySchema string, ValueSubject string, ValueSchema string) error
 }
 
+// CompatibilityRegistry is a SchemaRegistry that can check schemas are
+// compatible with the schemas already registered, and manage the
+// compatibility levels of subjects. The SchemaRegistry instances created by
+// DefaultSchemaRegistryFactory implement it.
+type CompatibilityRegistry interface {
+	SchemaRegistry
+	// CheckCompatibility returns ErrIncompatibleSchema if a schema isn't
+	// compatible with the schemas registered under a subject.
+	CheckCompatibility(subject, schema string) error
+	// CompatibilityLevel returns the compatibility level of a subject.
+	CompatibilityLevel(subject string) (CompatibilityLevel, error)
+	// SetCompatibilityLevel sets the compatibility level of a subject.
+	SetCompatibilityLevel(subject string, level CompatibilityLevel) error
+}
+
 // SchemaRegistryFactory can be used to generate new instances of SchemaRegistry
 type SchemaRegistryFactory interface {
 	NewSchemaRegistry(registryURI string) SchemaRegistry
 }
 
+// SchemaRegistryOption configures the instances of SchemaRegistry created by
+// a SchemaRegistryFactory.
+type SchemaRegistryOption func(*registryOptions)
+
+type registryOptions struct {
+	dryRun bool
+}
+
+// WithDryRun makes Register check that schemas are compatible with the
+// schemas already registered and log the schemas it would register, without
+// registering them. A CI job can use it to reject breaking schema changes
+// before they are deployed.
+func WithDryRun() SchemaRegistryOption {
+	return func(o *registryOptions) {
+		o.dryRun = true
+	}
+}
+
 type defaultRegistry struct {
 	logger        *logrus.Logger
 	registryURI   string
 	newClientFunc NewSchemaRegistryClientFunc
+	options       registryOptions
 }
 
+var _ CompatibilityRegistry = &defaultRegistry{}
+
 type defaultRegistryFactory struct {
 	newClientFunc NewSchemaRegistryClientFunc
 	logger        *logrus.Logger
+	options       registryOptions
 }
 
 // DefaultSchemaRegistryFactory returns a default implementation of the SchemaRegistryFactory,
 // which uses the Logger from the specified context.
-func DefaultSchemaRegistryFactory(ctx context.Context) SchemaRegistryFactory {
-	return BuildSchemaRegistryFactory(ctx, schemaregistry.NewClient)
+func DefaultSchemaRegistryFactory(ctx context.Context, opts ...SchemaRegistryOption) SchemaRegistryFactory {
+	return BuildSchemaRegistryFactory(ctx, func(baseurl string) (schemaregistry.Client, error) {
+		return NewRegistryClient(baseurl)
+	}, opts...)
 }
 
 // NewSchemaRegistryClient is a function that can be used to generate new clients for the schema registry
@@ -73,11 +117,17 @@ type NewSchemaRegistryClientFunc func(baseurl string) (schemaregistry.Client, er
 // with a caller-specified NewSchemaRegistryClientFunc.
 // This method is primarily intended for unit-testing this package. Users of this package are encouraged
 // to use the simpler DefaultSchemaRegistryFactory() method instead of this method.
-func BuildSchemaRegistryFactory(ctx context.Context, newClientFunc NewSchemaRegistryClientFunc) SchemaRegistryFactory {
-	return &defaultRegistryFactory{
+// Compatibility is only checked before registering schemas if the clients
+// newClientFunc creates implement CompatibilityClient.
+func BuildSchemaRegistryFactory(ctx context.Context, newClientFunc NewSchemaRegistryClientFunc, opts ...SchemaRegistryOption) SchemaRegistryFactory {
+	f := &defaultRegistryFactory{
 		newClientFunc: newClientFunc,
 		logger:        logging.ContextLogger(ctx).Logger,
 	}
+	for _, opt := range opts {
+		opt(&f.options)
+	}
+	return f
 }
 
 // NewSchemaRegistry returns a default implementation of SchemaRegistry
@@ -87,6 +137,7 @@ func (f *defaultRegistryFactory) NewSchemaRegistry(registryURI string) SchemaReg
 		logger:        f.logger,
 		newClientFunc: f.newClientFunc,
 		registryURI:   registryURI,
+		options:       f.options,
 	}
 }
 
@@ -117,27 +168,104 @@ func (r *defaultRegistry) register(client schemaregistry.Client, subject, schema
 	})
 
 	registered, _, err := client.IsRegistered(subject, schema)
-	if err != nil {
+	if err = ParseRegistryError(err); err != nil {
 		// If a schema for the subject has never been registered before, the IsRegistered method
-		// will return an error.  In this case, we want to register the schema.  We don't have access
-		// to the underlying error type to get the error code for this situation (which is 40401) so we
-		// will check the message for the error code.
-		if !strings.Contains(err.Error(), "40401") {
+		// will return an error.  In this case, we want to register the schema.  Clients other
+		// than those of this package don't type the error, so check its message for the error
+		// code (40401) as well.
+		if errors.Cause(err) != ErrSubjectNotFound && !strings.Contains(err.Error(), "40401") {
 			logger.WithError(err).Error("Error occurred checking schema registration")
 			return err
 		}
 	}
 
-	if !registered {
-		_, err = client.RegisterNewSchema(subject, schema)
-		if err != nil {
-			logger.WithError(err).Error("Error occurred registering new schema")
-			return err
-		}
-		logger.Info("New schema registered")
-	} else {
+	if registered {
 		logger.Debug("Schema already registered")
+		return nil
+	}
+
+	if err := checkCompatibility(client, subject, schema); err != nil && errors.Cause(err) != ErrCompatibilityUnsupported {
+		logger.WithError(err).Error("Schema is not compatible with the schemas already registered")
+		return err
+	}
+
+	if r.options.dryRun {
+		logger.Info("New schema would be registered")
+		return nil
+	}
+
+	_, err = client.RegisterNewSchema(subject, schema)
+	if err != nil {
+		logger.WithError(err).Error("Error occurred registering new schema")
+		return ParseRegistryError(err)
+	}
+	logger.Info("New schema registered")
+	return nil
+}
+
+func (r *defaultRegistry) CheckCompatibility(subject, schema string) error {
+	client, err := r.newClientFunc(r.registryURI)
+	if err != nil {
+		return errors.Wrap(err, "failed to create schema registry client")
+	}
+	return checkCompatibility(client, subject, schema)
+}
+
+func (r *defaultRegistry) CompatibilityLevel(subject string) (CompatibilityLevel, error) {
+	client, err := r.compatibilityClient()
+	if err != nil {
+		return "", err
+	}
+	level, err := client.CompatibilityLevel(subject)
+	return level, ParseRegistryError(err)
+}
+
+func (r *defaultRegistry) SetCompatibilityLevel(subject string, level CompatibilityLevel) error {
+	client, err := r.compatibilityClient()
+	if err != nil {
+		return err
 	}
+	if r.options.dryRun {
+		r.logger.WithFields(logrus.Fields{
+			"registry":      r.registryURI,
+			"subject":       subject,
+			"compatibility": level,
+		}).Info("Compatibility level would be set")
+		return nil
+	}
+	return ParseRegistryError(client.SetCompatibilityLevel(subject, level))
+}
+
+func (r *defaultRegistry) compatibilityClient() (CompatibilityClient, error) {
+	client, err := r.newClientFunc(r.registryURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create schema registry client")
+	}
+	c, ok := client.(CompatibilityClient)
+	if !ok {
+		return nil, errors.WithStack(ErrCompatibilityUnsupported)
+	}
+	return c, nil
+}
 
+// checkCompatibility returns ErrIncompatibleSchema if a schema isn't
+// compatible with the schemas registered under a subject. Any schema is
+// compatible with a subject that has none.
+func checkCompatibility(client schemaregistry.Client, subject, schema string) error {
+	c, ok := client.(CompatibilityClient)
+	if !ok {
+		return errors.WithStack(ErrCompatibilityUnsupported)
+	}
+	compatible, err := c.CheckCompatibility(subject, schema)
+	if err = ParseRegistryError(err); err != nil {
+		switch errors.Cause(err) {
+		case ErrSubjectNotFound, ErrVersionNotFound:
+			return nil
+		}
+		return errors.Wrapf(err, "failed to check compatibility of schema for subject %s", subject)
+	}
+	if !compatible {
+		return errors.Wrapf(ErrIncompatibleSchema, "schema for subject %s", subject)
+	}
 	return nil
 }
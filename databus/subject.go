@@ -0,0 +1,341 @@
+package databus
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	schemaregistry "github.com/datamountaineer/schema-registry"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+var (
+	// TopicNameStrategy names subjects after the topic, as <topic>-key and
+	// <topic>-value, so every message on a topic has the same schemas.
+	TopicNameStrategy SubjectNameStrategy = topicNameStrategy{}
+	// RecordNameStrategy names subjects after the full name of the record,
+	// so a record has the same schema on every topic, and a topic can carry
+	// several records.
+	RecordNameStrategy SubjectNameStrategy = recordNameStrategy{}
+	// TopicRecordNameStrategy names subjects after the topic and the full
+	// name of the record, as <topic>-<record>, so a topic can carry several
+	// records whose schemas evolve independently on each topic.
+	TopicRecordNameStrategy SubjectNameStrategy = topicRecordNameStrategy{}
+)
+
+// SubjectNameStrategy names the registry subject of the schema of a message
+// key or value, from the topic the message is sent to and the full name of
+// the record it is encoded as. These are the strategies of the Confluent
+// serializers.
+type SubjectNameStrategy interface {
+	Subject(topic, record string, isKey bool) string
+}
+
+// SubjectNameStrategyFunc is a function that can be used as a
+// SubjectNameStrategy.
+type SubjectNameStrategyFunc func(topic, record string, isKey bool) string
+
+// Subject calls f(topic, record, isKey).
+func (f SubjectNameStrategyFunc) Subject(topic, record string, isKey bool) string {
+	return f(topic, record, isKey)
+}
+
+type topicNameStrategy struct{}
+
+func (topicNameStrategy) Subject(topic, record string, isKey bool) string {
+	if isKey {
+		return topic + "-key"
+	}
+	return topic + "-value"
+}
+
+type recordNameStrategy struct{}
+
+func (recordNameStrategy) Subject(topic, record string, isKey bool) string {
+	return record
+}
+
+type topicRecordNameStrategy struct{}
+
+func (topicRecordNameStrategy) Subject(topic, record string, isKey bool) string {
+	return topic + "-" + record
+}
+
+// RecordNamer is implemented by values that name the records they are
+// encoded as. Protobuf messages are named after their full message names, e.g.
+// "google.protobuf.StringValue", and the records of other values as SchemaFor
+// names them: a struct after its package and type, e.g. "events.UserCreated",
+// and any other value after its primitive type, e.g. "string".
+type RecordNamer interface {
+	RecordName() string
+}
+
+// RecordName returns the full name of the record a value is encoded as, for
+// a SubjectNameStrategy.
+func RecordName(v interface{}) (string, error) {
+	if n, ok := v.(RecordNamer); ok {
+		return n.RecordName(), nil
+	}
+	if m, err := protoMessage(v); err == nil {
+		if name := proto.MessageName(m); name != "" {
+			return name, nil
+		}
+	}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return "", errors.Wrap(ErrUnsupportedType, "nil has no record name")
+	}
+	if t.Kind() == reflect.Struct && t.Name() != "" && t != timeType {
+		return avroName(path.Base(t.PkgPath())) + "." + avroName(t.Name()), nil
+	}
+	schema, err := newSchemaGenerator().schema(t)
+	if err != nil {
+		return "", err
+	}
+	name, ok := schema.(string)
+	if !ok {
+		return "", errors.Wrapf(ErrUnsupportedType, "%s has no record name; implement RecordNamer", t)
+	}
+	return name, nil
+}
+
+// NewMessageFactoryWithStrategy creates a MessageFactory for a topic that
+// derives the subjects of the key and value of each message from the topic
+// and the records they are encoded as, according to the strategies provided.
+// Messages are decoded with the subjects of the records they were written
+// as. Like NewMessageFactory, the factory encodes with, and resolves messages
+// to, the latest schemas of subjects when it first uses them, unless
+// WithLatestSchemas makes it pick up new versions.
+//
+// With the TopicNameStrategy, the subject is the same for every message, and
+// is returned by KeySubject or ValueSubject along with its codec. With other
+// strategies, there is no single subject, so KeySubject or ValueSubject
+// return an empty string, and KeyCodec or ValueCodec nil.
+func NewMessageFactoryWithStrategy(topic string, keyStrategy, valueStrategy SubjectNameStrategy, client schemaregistry.Client, opts ...FactoryOption) (MessageFactory, error) {
+	cache := schemaCacheFor(client)
+	_, keyFixed := keyStrategy.(topicNameStrategy)
+	_, valFixed := valueStrategy.(topicNameStrategy)
+	if keyFixed && valFixed {
+		return NewMessageFactory(topic, keyStrategy.Subject(topic, "", true), valueStrategy.Subject(topic, "", false), cache, opts...)
+	}
+	f := &strategyMessageFactory{
+		topic:         topic,
+		keyStrategy:   keyStrategy,
+		valueStrategy: valueStrategy,
+		cache:         cache,
+		opts:          opts,
+		options:       newFactoryOptions(opts),
+		factories:     map[[2]string]MessageFactory{},
+		names:         map[int]string{},
+	}
+	var err error
+	if keyFixed {
+		f.keySubject = keyStrategy.Subject(topic, "", true)
+		if f.keyID, _, err = cache.LatestCodec(f.keySubject); err != nil {
+			return nil, errors.Wrapf(err, "failed to get codec for key subject: %s", f.keySubject)
+		}
+	}
+	if valFixed {
+		f.valSubject = valueStrategy.Subject(topic, "", false)
+		if f.valID, _, err = cache.LatestCodec(f.valSubject); err != nil {
+			return nil, errors.Wrapf(err, "failed to get codec for value subject: %s", f.valSubject)
+		}
+	}
+	return f, nil
+}
+
+// strategyMessageFactory is a MessageFactory that encodes and decodes each
+// message with the factory for the subjects its strategies name.
+type strategyMessageFactory struct {
+	topic         string
+	keyStrategy   SubjectNameStrategy
+	valueStrategy SubjectNameStrategy
+	keySubject    string
+	valSubject    string
+	cache         *SchemaCache
+	opts          []FactoryOption
+	options       factoryOptions
+	// keyID and valID are the IDs of the schemas of the key and value
+	// subjects when the factory was created, if they are fixed.
+	keyID int
+	valID int
+
+	// factories caches the factories for key and value subjects, and names
+	// the full names of the records written with schemas, by ID.
+	mu        sync.RWMutex
+	factories map[[2]string]MessageFactory
+	names     map[int]string
+}
+
+func (f *strategyMessageFactory) Topic() string {
+	return f.topic
+}
+
+func (f *strategyMessageFactory) KeySubject() string {
+	return f.keySubject
+}
+
+func (f *strategyMessageFactory) ValueSubject() string {
+	return f.valSubject
+}
+
+func (f *strategyMessageFactory) KeyCodec() SchemaCodec {
+	return f.codec(f.keySubject, f.keyID)
+}
+
+func (f *strategyMessageFactory) ValueCodec() SchemaCodec {
+	return f.codec(f.valSubject, f.valID)
+}
+
+// codec returns the codec for the schema of a fixed subject, or nil if there
+// is no single subject.
+func (f *strategyMessageFactory) codec(subject string, id int) SchemaCodec {
+	if subject == "" {
+		return nil
+	}
+	var (
+		codec SchemaCodec
+		err   error
+	)
+	if f.options.latestSchemas {
+		_, codec, err = f.cache.LatestCodec(subject)
+	} else {
+		codec, err = f.cache.Codec(id)
+	}
+	if err != nil {
+		return nil
+	}
+	return codec
+}
+
+func (f *strategyMessageFactory) SchemaCache() *SchemaCache {
+	return f.cache
+}
+
+func (f *strategyMessageFactory) Message(key, value interface{}) (Message, error) {
+	keySubject, err := f.subject(f.keyStrategy, f.keySubject, key, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to name key subject")
+	}
+	valSubject, err := f.subject(f.valueStrategy, f.valSubject, value, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to name value subject")
+	}
+	factory, err := f.factory(keySubject, valSubject)
+	if err != nil {
+		return nil, err
+	}
+	return factory.Message(key, value)
+}
+
+func (f *strategyMessageFactory) Decode(msg Message, key, value interface{}) error {
+	keySubject, err := f.writerSubject(f.keyStrategy, f.keySubject, msg.Key(), true)
+	if err != nil {
+		return errors.Wrap(err, "failed to name key subject")
+	}
+	valSubject, err := f.writerSubject(f.valueStrategy, f.valSubject, msg.Value(), false)
+	if err != nil {
+		return errors.Wrap(err, "failed to name value subject")
+	}
+	factory, err := f.factory(keySubject, valSubject)
+	if err != nil {
+		return err
+	}
+	return factory.Decode(msg, key, value)
+}
+
+// subject returns the subject for a key or value being encoded.
+func (f *strategyMessageFactory) subject(strategy SubjectNameStrategy, fixed string, v interface{}, isKey bool) (string, error) {
+	if fixed != "" {
+		return fixed, nil
+	}
+	record, err := RecordName(v)
+	if err != nil {
+		return "", err
+	}
+	return strategy.Subject(f.topic, record, isKey), nil
+}
+
+// writerSubject returns the subject for a key or value being decoded, naming
+// it after the record it was written as.
+func (f *strategyMessageFactory) writerSubject(strategy SubjectNameStrategy, fixed string, data []byte, isKey bool) (string, error) {
+	if fixed != "" {
+		return fixed, nil
+	}
+	id, _, err := AvroDeserialize(data)
+	if err != nil {
+		return "", err
+	}
+	f.mu.RLock()
+	record, ok := f.names[id]
+	f.mu.RUnlock()
+	if !ok {
+		schema, err := f.cache.GetSchemaById(id)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get writer schema %d", id)
+		}
+		if record, err = schemaName(schema); err != nil {
+			return "", errors.Wrapf(err, "failed to name writer schema %d", id)
+		}
+		f.mu.Lock()
+		f.names[id] = record
+		f.mu.Unlock()
+	}
+	return strategy.Subject(f.topic, record, isKey), nil
+}
+
+// factory returns the factory for the key and value subjects provided,
+// creating it the first time they are seen.
+func (f *strategyMessageFactory) factory(keySubject, valSubject string) (MessageFactory, error) {
+	key := [2]string{keySubject, valSubject}
+	f.mu.RLock()
+	factory, ok := f.factories[key]
+	f.mu.RUnlock()
+	if ok {
+		return factory, nil
+	}
+	factory, err := NewMessageFactory(f.topic, keySubject, valSubject, f.cache, f.opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Fixed subjects keep the schemas they had when this factory was created
+	if af := factory.(*avroMessageFactory); !f.options.latestSchemas {
+		if f.keySubject != "" {
+			af.keyID = f.keyID
+		}
+		if f.valSubject != "" {
+			af.valID = f.valID
+		}
+	}
+	f.mu.Lock()
+	f.factories[key] = factory
+	f.mu.Unlock()
+	return factory, nil
+}
+
+// schemaName returns the full name of the type a schema defines, or the name
+// of its type if it isn't named.
+func schemaName(schema string) (string, error) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		return "", errors.Wrap(err, "failed to parse schema")
+	}
+	if isNamed(parsed) {
+		s := parsed.(map[string]interface{})
+		name, _ := s["name"].(string)
+		if ns, _ := s["namespace"].(string); ns != "" && !strings.Contains(name, ".") {
+			name = ns + "." + name
+		}
+		return name, nil
+	}
+	if name := typeName(deref(parsed, nil)); name != "" {
+		return name, nil
+	}
+	return "", errors.New("schema has no name")
+}
@@ -0,0 +1,134 @@
+package databus_test
+
+import (
+	. "github.com/zenoss/zenkit/databus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type subjectCreated struct {
+	ID string `json:"id"`
+}
+
+type subjectDeleted struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type subjectRenamed struct {
+	Name string `json:"name"`
+}
+
+func (subjectRenamed) RecordName() string {
+	return "com.example.Renamed"
+}
+
+var _ = Describe("Subject name strategies", func() {
+
+	It("should name subjects after topics and records", func() {
+		Ω(TopicNameStrategy.Subject("events", "a.B", true)).Should(Equal("events-key"))
+		Ω(TopicNameStrategy.Subject("events", "a.B", false)).Should(Equal("events-value"))
+		Ω(RecordNameStrategy.Subject("events", "a.B", false)).Should(Equal("a.B"))
+		Ω(TopicRecordNameStrategy.Subject("events", "a.B", false)).Should(Equal("events-a.B"))
+	})
+
+	It("should name records after their types", func() {
+		Ω(RecordName(subjectCreated{})).Should(Equal("databus_test.subjectCreated"))
+		Ω(RecordName(&subjectCreated{})).Should(Equal("databus_test.subjectCreated"))
+		Ω(RecordName(subjectRenamed{})).Should(Equal("com.example.Renamed"))
+		Ω(RecordName("key")).Should(Equal("string"))
+		_, err := RecordName(map[string]interface{}{})
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Context("creating a message factory", func() {
+
+		var client *MemorySchemaRegistryClient
+
+		BeforeEach(func() {
+			client = NewMemorySchemaRegistryClient()
+			client.RegisterNewSchema("events-key", `"string"`)
+			client.RegisterNewSchema("events-value", valTestSchema)
+			created, err := SchemaFor(subjectCreated{})
+			Ω(err).ShouldNot(HaveOccurred())
+			client.RegisterNewSchema("databus_test.subjectCreated", created)
+			deleted, err := SchemaFor(subjectDeleted{})
+			Ω(err).ShouldNot(HaveOccurred())
+			client.RegisterNewSchema("events-databus_test.subjectDeleted", deleted)
+		})
+
+		It("should use the topic's subjects with the TopicNameStrategy", func() {
+			factory, err := NewMessageFactoryWithStrategy("events", TopicNameStrategy, TopicNameStrategy, client)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(factory.KeySubject()).Should(Equal("events-key"))
+			Ω(factory.ValueSubject()).Should(Equal("events-value"))
+			Ω(factory.ValueCodec().Schema()).Should(MatchJSON(valTestSchema))
+		})
+
+		It("should put several records on one topic", func() {
+			factory, err := NewMessageFactoryWithStrategy("events", TopicNameStrategy, SubjectNameStrategyFunc(
+				func(topic, record string, isKey bool) string {
+					if record == "databus_test.subjectDeleted" {
+						return TopicRecordNameStrategy.Subject(topic, record, isKey)
+					}
+					return RecordNameStrategy.Subject(topic, record, isKey)
+				}), client)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(factory.KeySubject()).Should(Equal("events-key"))
+			Ω(factory.ValueSubject()).Should(BeEmpty())
+			Ω(factory.ValueCodec()).Should(BeNil())
+
+			created, err := factory.Message("a", subjectCreated{ID: "a"})
+			Ω(err).ShouldNot(HaveOccurred())
+			deleted, err := factory.Message("b", &subjectDeleted{ID: "b", Reason: "gone"})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			var (
+				key string
+				c   subjectCreated
+				d   map[string]interface{}
+			)
+			Ω(factory.Decode(created, &key, &c)).Should(Succeed())
+			Ω(c).Should(Equal(subjectCreated{ID: "a"}))
+			Ω(factory.Decode(deleted, &key, &d)).Should(Succeed())
+			Ω(key).Should(Equal("b"))
+			Ω(d).Should(Equal(map[string]interface{}{"id": "b", "reason": "gone"}))
+		})
+
+		It("should keep the schemas of fixed subjects it was created with", func() {
+			cache := NewSchemaCache(client, SchemaCacheOptions{TTL: -1})
+			factory, err := NewMessageFactoryWithStrategy("events", TopicNameStrategy, RecordNameStrategy, cache)
+			Ω(err).ShouldNot(HaveOccurred())
+			latest, err := NewMessageFactoryWithStrategy("events", TopicNameStrategy, RecordNameStrategy, cache, WithLatestSchemas())
+			Ω(err).ShouldNot(HaveOccurred())
+			keyID, err := cache.RegisterNewSchema("events-key", `["string", "null"]`)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			msg, err := factory.Message("a", subjectCreated{ID: "a"})
+			Ω(err).ShouldNot(HaveOccurred())
+			id, _, _ := DeserializePayload(msg.Key())
+			Ω(id).ShouldNot(Equal(keyID))
+			Ω(factory.KeyCodec().Schema()).Should(Equal(`"string"`))
+
+			msg, err = latest.Message("a", subjectCreated{ID: "a"})
+			Ω(err).ShouldNot(HaveOccurred())
+			id, _, _ = DeserializePayload(msg.Key())
+			Ω(id).Should(Equal(keyID))
+		})
+
+		It("should fail for records with no subject", func() {
+			factory, err := NewMessageFactoryWithStrategy("events", TopicNameStrategy, RecordNameStrategy, client)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = factory.Message("a", subjectRenamed{Name: "a"})
+			Ω(err).Should(HaveOccurred())
+			_, err = factory.Message("a", map[string]interface{}{"id": "a"})
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should fail if the topic has no subjects with the TopicNameStrategy", func() {
+			_, err := NewMessageFactoryWithStrategy("other", TopicNameStrategy, RecordNameStrategy, client)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
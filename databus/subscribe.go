@@ -0,0 +1,385 @@
+package databus
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	zkmetrics "github.com/zenoss/zenkit/metrics"
+)
+
+var (
+	// ErrUnsupportedConsumer is returned by Subscribe when the consumer it is
+	// given does not implement MessageSource.
+	ErrUnsupportedConsumer = errors.New("consumer does not support subscriptions")
+	// ErrNoMessageFactory is returned when decoding a message received
+	// without a message factory, e.g. from a MessageSource for a retry topic.
+	ErrNoMessageFactory = errors.New("message has no message factory")
+
+	// DefaultDrainTimeout is how long Subscribe waits for in-flight messages
+	// to be handled after its context is cancelled, unless configured
+	// otherwise.
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+// ReceivedMessage is a message received from the databus, along with its
+// position in the topic.
+type ReceivedMessage struct {
+	Message
+	Partition int32
+	Offset    int64
+	// Attempts is the number of times handling the message has already
+	// failed, if it is being retried.
+	Attempts int
+
+	factory  MessageFactory
+	metrics  *databusMetrics
+	consumer *consumerOptions
+}
+
+// NewReceivedMessage returns a ReceivedMessage that decodes with the factory
+// provided.
+func NewReceivedMessage(msg Message, partition int32, offset int64, factory MessageFactory) *ReceivedMessage {
+	return &ReceivedMessage{Message: msg, Partition: partition, Offset: offset, factory: factory}
+}
+
+// marked records that the message has been marked as processed by the
+// consumer it was received from.
+func (m *ReceivedMessage) marked() {
+	if m.consumer != nil {
+		m.consumer.marked(m)
+	}
+}
+
+// Decode decodes the message into the struct at the pointer provided, which
+// must have fields tagged as `zenkit:"message-key"` and
+// `zenkit:"message-value"`, as for DatabusConsumer.Consume.
+func (m *ReceivedMessage) Decode(v interface{}) error {
+	if m.factory == nil {
+		return errors.WithStack(ErrNoMessageFactory)
+	}
+	keyField, valueField, err := validateType(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return m.decode(v, keyField, valueField)
+}
+
+// DecodeKeyValue decodes the key and value of the message into the pointers
+// provided.
+func (m *ReceivedMessage) DecodeKeyValue(key, value interface{}) error {
+	if m.factory == nil {
+		return errors.WithStack(ErrNoMessageFactory)
+	}
+	begin := zkmetrics.TimeFunc()
+	if err := m.factory.Decode(m.Message, key, value); err != nil {
+		m.metrics.failed(decodeError)
+		return err
+	}
+	m.metrics.timeSince("decode.time", begin)
+	return nil
+}
+
+// decode decodes the message into v, which has been checked by validateType,
+// recording the time taken.
+func (m *ReceivedMessage) decode(v interface{}, keyField, valueField int) error {
+	begin := zkmetrics.TimeFunc()
+	if err := decodeMessage(m.factory, m.Message, v, keyField, valueField); err != nil {
+		m.metrics.failed(decodeError)
+		return err
+	}
+	m.metrics.timeSince("decode.time", begin)
+	return nil
+}
+
+// MessageSource is implemented by consumers that can receive messages without
+// marking them as processed. The consumers returned by NewDatabusConsumer,
+// NewSaramaClusterDatabusConsumer and NewMemoryDatabusConsumer all implement
+// it.
+type MessageSource interface {
+	// Receive returns the next message, blocking until one is available or
+	// the context is cancelled.
+	Receive(context.Context) (*ReceivedMessage, error)
+	// MarkOffset marks a message, and every message before it in its
+	// partition, as processed.
+	MarkOffset(*ReceivedMessage) error
+}
+
+// Handler processes a message received by Subscribe. A message is only marked
+// as processed once its handler returns nil. The context it is given carries
+// the request ID, trace and tenant identity propagated in the message's
+// headers.
+type Handler func(context.Context, *ReceivedMessage) error
+
+// SubscribeOptions configures Subscribe.
+type SubscribeOptions struct {
+	// Consumer is the consumer to receive messages from. It must implement
+	// MessageSource, and should not be used for anything else while
+	// subscribed.
+	Consumer DatabusConsumer
+	// Workers is the number of messages handled concurrently. Defaults to
+	// GOMAXPROCS.
+	Workers int
+	// QueueSize is the number of messages buffered for each worker. Defaults
+	// to 1.
+	QueueSize int
+	// DrainTimeout is how long to wait for in-flight messages after the
+	// context is cancelled before cancelling the handlers' context. Defaults
+	// to DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// FailurePolicy deals with messages whose handler fails. If it is nil,
+	// a failed handler stops the subscription.
+	FailurePolicy FailurePolicy
+}
+
+func (o SubscribeOptions) withDefaults() SubscribeOptions {
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1
+	}
+	if o.DrainTimeout == 0 {
+		o.DrainTimeout = DefaultDrainTimeout
+	}
+	return o
+}
+
+// Subscribe receives messages from a consumer and handles them with a pool of
+// workers until the context is cancelled or a handler fails. Messages with the
+// same key are always handled by the same worker, in the order they were
+// received, so ordering is kept within a key. Offsets are marked only once
+// every message up to them has been handled successfully.
+//
+// When the context is cancelled, Subscribe stops receiving, waits for the
+// messages already received to be handled and returns nil. When a handler
+// fails, the failure policy, if any, decides what happens to the message. If
+// there is none, or it fails too, Subscribe stops receiving, skips the
+// messages not yet handled, waits for in-flight handlers and returns the
+// error; the failed message and those after it in its partition are delivered
+// again the next time the consumer group starts.
+func Subscribe(ctx context.Context, handler Handler, opts SubscribeOptions) error {
+	source, ok := opts.Consumer.(MessageSource)
+	if !ok {
+		return errors.WithStack(ErrUnsupportedConsumer)
+	}
+	o := opts.withDefaults()
+
+	// Handlers keep the values of ctx, but aren't cancelled with it so they
+	// can finish while draining.
+	handlerCtx, cancelHandlers := context.WithCancel(detachedContext{ctx})
+	defer cancelHandlers()
+	receiveCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var (
+		errOnce  sync.Once
+		firstErr error
+		failed   = make(chan struct{})
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(failed)
+			stop()
+		})
+	}
+
+	tracker := newOffsetTracker()
+	queues := make([]chan *ReceivedMessage, o.Workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *ReceivedMessage, o.QueueSize)
+		wg.Add(1)
+		go func(queue <-chan *ReceivedMessage) {
+			defer wg.Done()
+			for msg := range queue {
+				select {
+				case <-failed:
+					continue
+				default:
+				}
+				if err := handler(WithHeaders(handlerCtx, MessageHeaders(msg)), msg); err != nil {
+					msg.metrics.failed(handlerError)
+					err = errors.Wrapf(err, "failed to handle message at offset %d of partition %d of topic %s", msg.Offset, msg.Partition, msg.Topic())
+					if o.FailurePolicy == nil {
+						fail(err)
+						continue
+					}
+					if perr := o.FailurePolicy.Failed(handlerCtx, msg, err); perr != nil {
+						fail(errors.Wrapf(perr, "failure policy failed after %s", err))
+						continue
+					}
+				}
+				if mark := tracker.done(msg); mark != nil {
+					if err := source.MarkOffset(mark); err != nil {
+						fail(errors.Wrap(err, "failed to mark offset"))
+					}
+				}
+			}
+		}(queues[i])
+	}
+
+receive:
+	for {
+		msg, err := source.Receive(receiveCtx)
+		if err != nil {
+			if receiveCtx.Err() == nil {
+				fail(errors.Wrap(err, "failed to receive message"))
+			}
+			break
+		}
+		tracker.add(msg)
+		select {
+		case queues[worker(msg.Key(), len(queues))] <- msg:
+		case <-receiveCtx.Done():
+			break receive
+		}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	drained := time.AfterFunc(o.DrainTimeout, cancelHandlers)
+	defer drained.Stop()
+	wg.Wait()
+	return firstErr
+}
+
+// worker returns the worker that handles messages with a key. The key is
+// hashed without its wire format header, so that the same key goes to the
+// same worker whichever version of its schema it was written with.
+func worker(key []byte, workers int) int {
+	if _, payload, err := DeserializePayload(key); err == nil {
+		key = payload
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(workers))
+}
+
+// detachedContext keeps the values of its parent but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// offsetTracker finds the offsets that can be marked as messages are handled
+// out of order: the highest offset in each partition below which every
+// message has been handled.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type partitionOffsets struct {
+	pending []*ReceivedMessage
+	handled map[int64]bool
+	// blocked is set once a message that will never be handled has been
+	// received, at offset blockedAt. The messages after it are no longer
+	// tracked, since they can't be marked.
+	blocked   bool
+	blockedAt int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: map[topicPartition]*partitionOffsets{}}
+}
+
+// add records that a message has been received.
+func (t *offsetTracker) add(msg *ReceivedMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := topicPartition{msg.Topic(), msg.Partition}
+	p, ok := t.partitions[tp]
+	if !ok {
+		p = &partitionOffsets{handled: map[int64]bool{}}
+		t.partitions[tp] = p
+	}
+	if p.blocked {
+		return
+	}
+	p.pending = append(p.pending, msg)
+}
+
+// done records that a message has been handled, and returns the last message
+// whose offset can now be marked, if any.
+func (t *offsetTracker) done(msg *ReceivedMessage) *ReceivedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.partitions[topicPartition{msg.Topic(), msg.Partition}]
+	if p == nil || p.blocked && msg.Offset >= p.blockedAt {
+		return nil
+	}
+	p.handled[msg.Offset] = true
+	var mark *ReceivedMessage
+	for len(p.pending) > 0 && p.handled[p.pending[0].Offset] {
+		mark = p.pending[0]
+		delete(p.handled, mark.Offset)
+		p.pending = p.pending[1:]
+	}
+	return mark
+}
+
+// block records that a message will never be handled, so neither it nor any
+// message after it in its partition can be marked until the partition is
+// reset.
+func (t *offsetTracker) block(msg *ReceivedMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.partitions[topicPartition{msg.Topic(), msg.Partition}]
+	if p == nil || p.blocked && p.blockedAt <= msg.Offset {
+		return
+	}
+	p.blocked, p.blockedAt = true, msg.Offset
+	for i, pending := range p.pending {
+		if pending.Offset >= msg.Offset {
+			p.pending = p.pending[:i]
+			break
+		}
+	}
+	for offset := range p.handled {
+		if offset >= msg.Offset {
+			delete(p.handled, offset)
+		}
+	}
+}
+
+// blocking returns whether any partition is blocked.
+func (t *offsetTracker) blocking() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.partitions {
+		if p.blocked {
+			return true
+		}
+	}
+	return false
+}
+
+// reset forgets the messages of partitions the consumer no longer receives
+// from, unblocking them.
+func (t *offsetTracker) reset(partitions map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			delete(t.partitions, topicPartition{topic, partition})
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package databus_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Subscribe", func() {
+
+	var (
+		broker   *MemoryBroker
+		factory  MessageFactory
+		producer DatabusProducer
+		consumer DatabusConsumer
+		topic    string
+		ctx      context.Context
+		cancel   context.CancelFunc
+		done     chan error
+	)
+
+	send := func(key string, n int) {
+		for i := 0; i < n; i++ {
+			Ω(producer.Send(KeyTest{SomeString: key}, ValTest{TotallyCool: strconv.Itoa(i)})).Should(Succeed())
+		}
+	}
+
+	committed := func() int64 {
+		var total int64
+		for _, o := range broker.Offsets("group", topic) {
+			total += o
+		}
+		return total
+	}
+
+	subscribe := func(handler Handler, opts SubscribeOptions) {
+		opts.Consumer = consumer
+		// Capture the channel, so a subscription that outlives its spec
+		// doesn't report to the next one
+		done, ctx := done, ctx
+		go func() {
+			done <- Subscribe(ctx, handler, opts)
+		}()
+	}
+
+	BeforeEach(func() {
+		broker = NewMemoryBroker(0)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		topic = test.RandString(8)
+		var err error
+		factory, err = NewMessageFactory(topic, "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		producer = NewMemoryDatabusProducer(broker, factory)
+		consumer, err = NewMemoryDatabusConsumer(broker, factory, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan error, 1)
+	})
+
+	AfterEach(func() {
+		cancel()
+		consumer.Close()
+	})
+
+	It("should handle every message and mark its offset", func() {
+		var (
+			mu       sync.Mutex
+			received []memoryTestMessage
+		)
+		subscribe(func(ctx context.Context, msg *ReceivedMessage) error {
+			var m memoryTestMessage
+			if err := msg.Decode(&m); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, m)
+			return nil
+		}, SubscribeOptions{Workers: 4})
+		for i := 0; i < 10; i++ {
+			send(fmt.Sprint(i), 1)
+		}
+		Eventually(committed).Should(BeNumerically("==", 10))
+		mu.Lock()
+		Ω(received).Should(HaveLen(10))
+		mu.Unlock()
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("should keep the order of messages with the same key", func() {
+		var (
+			mu   sync.Mutex
+			seen = map[string][]string{}
+		)
+		subscribe(func(ctx context.Context, msg *ReceivedMessage) error {
+			var (
+				k KeyTest
+				v ValTest
+			)
+			if err := msg.DecodeKeyValue(&k, &v); err != nil {
+				return err
+			}
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+			mu.Lock()
+			defer mu.Unlock()
+			seen[k.SomeString] = append(seen[k.SomeString], v.TotallyCool)
+			return nil
+		}, SubscribeOptions{Workers: 8, QueueSize: 4})
+		for _, key := range []string{"a", "b", "c", "d"} {
+			send(key, 20)
+		}
+		Eventually(committed).Should(BeNumerically("==", 80))
+		mu.Lock()
+		defer mu.Unlock()
+		for _, values := range seen {
+			Ω(values).Should(HaveLen(20))
+			for i, v := range values {
+				Ω(v).Should(Equal(strconv.Itoa(i)))
+			}
+		}
+	})
+
+	It("should keep the order of messages with the same key written with different schemas", func() {
+		var (
+			mu   sync.Mutex
+			seen []string
+		)
+		// On one partition, since the broker partitions by the whole key
+		broker = NewMemoryBroker(1)
+		consumer.Close()
+		var err error
+		consumer, err = NewMemoryDatabusConsumer(broker, factory, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		subscribe(func(ctx context.Context, msg *ReceivedMessage) error {
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, string(msg.Value()))
+			return nil
+		}, SubscribeOptions{Workers: 8, QueueSize: 4})
+		var sent []string
+		for i := 0; i < 20; i++ {
+			sent = append(sent, strconv.Itoa(i))
+			broker.Send(NewMessage(topic, AvroSerialize([]byte("key"), 1+i%2), []byte(sent[i])))
+		}
+		Eventually(committed).Should(BeNumerically("==", 20))
+		mu.Lock()
+		defer mu.Unlock()
+		Ω(seen).Should(Equal(sent))
+	})
+
+	It("should wait for in-flight messages when the context is cancelled", func() {
+		var (
+			started  = make(chan struct{})
+			release  = make(chan struct{})
+			canceled = make(chan bool, 1)
+		)
+		subscribe(func(ctx context.Context, msg *ReceivedMessage) error {
+			close(started)
+			<-release
+			canceled <- ctx.Err() != nil
+			return nil
+		}, SubscribeOptions{})
+		send("a", 1)
+		Eventually(started).Should(BeClosed())
+		cancel()
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+		close(release)
+		Eventually(done).Should(Receive(BeNil()))
+		Ω(canceled).Should(Receive(BeFalse()))
+		Ω(committed()).Should(BeNumerically("==", 1))
+	})
+
+	It("should cancel in-flight handlers after the drain timeout", func() {
+		started := make(chan struct{})
+		subscribe(func(ctx context.Context, msg *ReceivedMessage) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}, SubscribeOptions{DrainTimeout: 50 * time.Millisecond})
+		send("a", 1)
+		Eventually(started).Should(BeClosed())
+		cancel()
+		var err error
+		Eventually(done).Should(Receive(&err))
+		Ω(errors.Cause(err)).Should(Equal(context.Canceled))
+	})
+
+	It("should stop and leave failed messages unmarked when a handler fails", func() {
+		failure := errors.New("o no")
+		subscribe(func(ctx context.Context, msg *ReceivedMessage) error {
+			var v ValTest
+			if err := msg.DecodeKeyValue(&KeyTest{}, &v); err != nil {
+				return err
+			}
+			if v.TotallyCool == "2" {
+				return failure
+			}
+			return nil
+		}, SubscribeOptions{Workers: 2})
+		send("a", 5)
+		var err error
+		Eventually(done).Should(Receive(&err))
+		Ω(errors.Cause(err)).Should(Equal(failure))
+		Ω(committed()).Should(BeNumerically("==", 2))
+
+		By("delivering the failed message again")
+		Ω(consumer.Close()).Should(Succeed())
+		consumer, err = NewMemoryDatabusConsumer(broker, factory, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		var m memoryTestMessage
+		Ω(consumer.Consume(context.Background(), &m)).Should(Succeed())
+		Ω(m.Value.TotallyCool).Should(Equal("2"))
+	})
+
+	It("should return an error if the consumer can't subscribe", func() {
+		err := Subscribe(ctx, nil, SubscribeOptions{Consumer: struct{ DatabusConsumer }{}})
+		Ω(errors.Cause(err)).Should(Equal(ErrUnsupportedConsumer))
+	})
+})
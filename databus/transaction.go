@@ -0,0 +1,214 @@
+package databus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTransactionDone is returned when using a transaction that has
+	// already been committed or aborted.
+	ErrTransactionDone = errors.New("transaction has already been committed or aborted")
+	// ErrProducerFenced is returned by a transactional producer that can no
+	// longer commit transactions, because another producer has begun with
+	// its transactional ID or it isn't authorized to. It is closed, and
+	// every transaction after fails with it.
+	ErrProducerFenced = errors.New("transactional producer has been fenced off")
+)
+
+// TransactionalProducer publishes messages and commits the offsets of the
+// messages they were derived from atomically, for consume-transform-produce
+// pipelines: either every message sent in a transaction is published and
+// every offset marked in it is committed, or none are, so a crash never
+// duplicates derived messages.
+//
+// NewTransactionalProducer runs the transactions on Kafka, and
+// NewMemoryTransactionalProducer on a MemoryBroker.
+type TransactionalProducer interface {
+	// Begin begins a transaction committing the offsets of the consumer
+	// group provided.
+	Begin(groupId string) (Transaction, error)
+	// Close closes the producer.
+	Close() error
+}
+
+// Transaction is a transaction begun by a TransactionalProducer. Nothing sent
+// or marked in a transaction is visible to consumers until it is committed.
+type Transaction interface {
+	// Send encodes a message to be published when the transaction is
+	// committed.
+	Send(key, value interface{}) error
+	// SendContext encodes a message to be published when the transaction
+	// is committed, with headers carrying the request, trace and identity
+	// of the context.
+	SendContext(ctx context.Context, key, value interface{}) error
+	// MarkOffset marks a message received by the transaction's consumer
+	// group, and every message before it in its partition, as processed
+	// when the transaction is committed.
+	MarkOffset(*ReceivedMessage) error
+	// Commit publishes the messages sent and commits the offsets marked.
+	// If the producer has been closed, it fails with ErrProducerClosed
+	// and the transaction is left to be aborted. If the producer has been
+	// fenced off, it fails with ErrProducerFenced.
+	Commit() error
+	// Abort discards the messages sent and the offsets marked.
+	Abort() error
+}
+
+// transactionCommitter commits the messages sent and the offsets marked in
+// the transactions of a TransactionalProducer.
+type transactionCommitter interface {
+	// commit publishes the messages and commits the offsets of a
+	// transaction. It fails with ErrProducerClosed, leaving the
+	// transaction open, if the producer is closed.
+	commit(group string, messages []Message, offsets map[topicPartition]*ReceivedMessage) error
+	// end is called once the transaction is committed or aborted.
+	end()
+}
+
+// transaction buffers the messages sent and the offsets marked until it is
+// committed.
+type transaction struct {
+	committer transactionCommitter
+	factory   MessageFactory
+	group     string
+
+	mu       sync.Mutex
+	done     bool
+	messages []Message
+	metrics  []*databusMetrics
+	offsets  map[topicPartition]*ReceivedMessage
+}
+
+func (t *transaction) Send(key, value interface{}) error {
+	message, err := t.factory.Message(key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+	return t.add(message, nil)
+}
+
+func (t *transaction) SendContext(ctx context.Context, key, value interface{}) error {
+	message, m, err := encodeMessage(ctx, t.factory, key, value)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message from factory")
+	}
+	return t.add(message, m)
+}
+
+func (t *transaction) add(message Message, m *databusMetrics) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errors.WithStack(ErrTransactionDone)
+	}
+	t.messages = append(t.messages, message)
+	t.metrics = append(t.metrics, m)
+	return nil
+}
+
+func (t *transaction) MarkOffset(msg *ReceivedMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errors.WithStack(ErrTransactionDone)
+	}
+	if t.offsets == nil {
+		t.offsets = map[topicPartition]*ReceivedMessage{}
+	}
+	tp := topicPartition{msg.Topic(), msg.Partition}
+	if prev, ok := t.offsets[tp]; !ok || msg.Offset > prev.Offset {
+		t.offsets[tp] = msg
+	}
+	return nil
+}
+
+func (t *transaction) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errors.WithStack(ErrTransactionDone)
+	}
+	err := t.committer.commit(t.group, t.messages, t.offsets)
+	if errors.Cause(err) == ErrProducerClosed {
+		// Leave the transaction to be aborted
+		return err
+	}
+	t.done = true
+	t.committer.end()
+	for _, m := range t.metrics {
+		m.sent(err)
+	}
+	if err == nil {
+		for _, msg := range t.offsets {
+			msg.marked()
+		}
+	}
+	return err
+}
+
+func (t *transaction) Abort() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errors.WithStack(ErrTransactionDone)
+	}
+	t.done = true
+	t.committer.end()
+	t.messages, t.metrics, t.offsets = nil, nil, nil
+	return nil
+}
+
+// NewMemoryTransactionalProducer returns a TransactionalProducer that
+// encodes messages with the factory provided and publishes them to a
+// MemoryBroker.
+func NewMemoryTransactionalProducer(broker *MemoryBroker, factory MessageFactory) TransactionalProducer {
+	return &memoryTransactionalProducer{broker: broker, factory: factory}
+}
+
+type memoryTransactionalProducer struct {
+	mu      sync.RWMutex
+	broker  *MemoryBroker
+	factory MessageFactory
+	closed  bool
+}
+
+func (p *memoryTransactionalProducer) Begin(groupId string) (Transaction, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, errors.WithStack(ErrProducerClosed)
+	}
+	return &transaction{committer: p, factory: p.factory, group: groupId}, nil
+}
+
+func (p *memoryTransactionalProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *memoryTransactionalProducer) commit(group string, messages []Message, offsets map[topicPartition]*ReceivedMessage) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.WithStack(ErrProducerClosed)
+	}
+	// Holding the broker's lock makes the messages and offsets visible at
+	// once
+	b := p.broker
+	b.mu.Lock()
+	for _, message := range messages {
+		b.append(message)
+	}
+	for _, msg := range offsets {
+		b.commitOffset(group, msg.Topic(), msg.Partition, msg.Offset+1)
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+func (p *memoryTransactionalProducer) end() {}
@@ -0,0 +1,127 @@
+// +build integration
+
+package databus_test
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/datamountaineer/schema-registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+)
+
+var _ = Describe("TransactionsIntegration", func() {
+
+	var (
+		brokers      []string
+		registryAddr string
+		topic        string
+		keySubject   string
+		valueSubject string
+		group        string
+		producer     TransactionalProducer
+		input        *ReceivedMessage
+		client       sarama.Client
+	)
+
+	BeforeEach(func() {
+		topic = test.RandString(8)
+		keySubject = test.RandString(8)
+		valueSubject = test.RandString(8)
+		group = test.RandString(8)
+
+		kafka, err := harness.Resolve("kafka", 9092)
+		Ω(err).ShouldNot(HaveOccurred())
+		brokers = []string{kafka}
+		addr, err := harness.Resolve("kafka-schema-registry", 8081)
+		Ω(err).ShouldNot(HaveOccurred())
+		registryAddr = fmt.Sprintf("http://%s/", addr)
+		registry, err := schemaregistry.NewClient(registryAddr)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = registry.RegisterNewSchema(keySubject, `"string"`)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = registry.RegisterNewSchema(valueSubject, `"int"`)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		// The message the transactions derive theirs from
+		partition, offset, err := testProducer.SendMessage(&sarama.ProducerMessage{
+			Topic: "input-" + topic,
+			Value: sarama.StringEncoder("input"),
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		input = NewReceivedMessage(NewMessage("input-"+topic, nil, []byte("input")), partition, offset, nil)
+
+		producer, err = NewTransactionalProducer(brokers, registryAddr, topic, keySubject, valueSubject, test.RandString(8))
+		Ω(err).ShouldNot(HaveOccurred())
+		client, err = sarama.NewClient(brokers, KafkaOptions{ReadCommitted: true}.Config())
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		producer.Close()
+		client.Close()
+	})
+
+	// committed returns the offset the group has committed for the input
+	committed := func() int64 {
+		om, err := sarama.NewOffsetManagerFromClient(group, client)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer om.Close()
+		pom, err := om.ManagePartition(input.Topic(), input.Partition)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer pom.Close()
+		offset, _ := pom.NextOffset()
+		return offset
+	}
+
+	It("should publish messages and commit offsets together", func() {
+		tx, err := producer.Begin(group)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send("key", 42)).Should(Succeed())
+		Ω(tx.MarkOffset(input)).Should(Succeed())
+		Ω(tx.Commit()).Should(Succeed())
+
+		consumer, err := sarama.NewConsumerFromClient(client)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		pc, err := consumer.ConsumePartition(topic, 0, sarama.OffsetOldest)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer pc.Close()
+		var msg *sarama.ConsumerMessage
+		Eventually(pc.Messages(), "10s").Should(Receive(&msg))
+		Ω(msg.Key).ShouldNot(BeEmpty())
+		Ω(committed()).Should(Equal(input.Offset + 1))
+	})
+
+	It("should neither publish nor commit an aborted transaction", func() {
+		tx, err := producer.Begin(group)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send("key", 42)).Should(Succeed())
+		Ω(tx.MarkOffset(input)).Should(Succeed())
+		Ω(tx.Abort()).Should(Succeed())
+		Ω(committed()).Should(BeNumerically("<", 0))
+
+		newest, err := client.GetOffset(topic, 0, sarama.OffsetNewest)
+		if err == nil {
+			Ω(newest).Should(BeZero())
+		}
+	})
+
+	It("should fence off a producer with the same transactional ID", func() {
+		id := test.RandString(8)
+		first, err := NewTransactionalProducer(brokers, registryAddr, topic, keySubject, valueSubject, id)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer first.Close()
+		tx, err := first.Begin(group)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send("key", 1)).Should(Succeed())
+
+		second, err := NewTransactionalProducer(brokers, registryAddr, topic, keySubject, valueSubject, id)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer second.Close()
+		Ω(tx.Commit()).ShouldNot(Succeed())
+	})
+})
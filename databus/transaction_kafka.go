@@ -0,0 +1,597 @@
+package databus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// TransactionTimeout is how long Kafka waits for a transaction to be
+// committed before aborting it.
+var TransactionTimeout = time.Minute
+
+// NewTransactionalProducer returns a TransactionalProducer that sends
+// Avro-encoded messages to a Kafka topic in transactions. The transactional
+// ID identifies the producer across restarts: a producer beginning with the
+// same ID aborts the transaction its predecessor left open, and fences it
+// off: the predecessor's next commit fails with ErrProducerFenced, as do its
+// commits if it isn't authorized to use the ID, and it closes. The connection to Kafka may be configured with
+// WithProducerKafkaOptions, and its version must be 0.11 or later.
+func NewTransactionalProducer(brokers []string, schemaRegistry, topic, keySubject, valueSubject, transactionalID string, opts ...ProducerOption) (TransactionalProducer, error) {
+	o := &producerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	schemaRegistryClient, err := SharedSchemaCache(schemaRegistry)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get schema registry client")
+	}
+
+	messageFactory, err := NewMessageFactory(topic, keySubject, valueSubject, schemaRegistryClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create message factory")
+	}
+
+	client, err := sarama.NewClient(brokers, o.kafka.Config())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kafka client")
+	}
+	producer, err := newKafkaTransactionalProducer(client, transactionalID, messageFactory)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	producer.ownsClient = true
+	return producer, nil
+}
+
+// NewSaramaTransactionalProducer is a way to create a Kafka-based
+// TransactionalProducer using an existing sarama client, which it doesn't
+// close, in distinction to NewTransactionalProducer.
+func NewSaramaTransactionalProducer(client sarama.Client, transactionalID string, factory MessageFactory) (TransactionalProducer, error) {
+	return newKafkaTransactionalProducer(client, transactionalID, factory)
+}
+
+func newKafkaTransactionalProducer(client sarama.Client, transactionalID string, factory MessageFactory) (*kafkaTransactionalProducer, error) {
+	if !client.Config().Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.New("transactions require Kafka 0.11 or later")
+	}
+	p := &kafkaTransactionalProducer{
+		client:          client,
+		factory:         factory,
+		transactionalID: transactionalID,
+		txn:             make(chan struct{}, 1),
+		closing:         make(chan struct{}),
+		groups:          map[string]*sarama.Broker{},
+	}
+	if err := p.initProducerID(); err != nil {
+		p.closeBrokers()
+		return nil, err
+	}
+	p.txn <- struct{}{}
+	return p, nil
+}
+
+// kafkaTransactionalProducer runs transactions with Kafka's transaction
+// protocol. The messages of a transaction are buffered until it is
+// committed, when they are produced, the offsets are committed and the
+// transaction is ended at once.
+type kafkaTransactionalProducer struct {
+	client          sarama.Client
+	factory         MessageFactory
+	transactionalID string
+	ownsClient      bool
+
+	// txn holds a token while no transaction is open, since a
+	// transactional ID only has one open transaction at a time. The state
+	// below belongs to whoever holds it.
+	txn         chan struct{}
+	producerID  int64
+	epoch       int16
+	sequences   map[topicPartition]int32
+	coordinator *sarama.Broker
+	groups      map[string]*sarama.Broker
+
+	mu      sync.RWMutex
+	closed  bool
+	fenced  bool
+	closing chan struct{}
+}
+
+// Begin waits until the producer's previous transaction is committed or
+// aborted, and begins another.
+func (p *kafkaTransactionalProducer) Begin(groupId string) (Transaction, error) {
+	select {
+	case <-p.txn:
+	case <-p.closing:
+		return nil, p.closedError()
+	}
+	select {
+	case <-p.closing:
+		p.end()
+		return nil, p.closedError()
+	default:
+	}
+	return &transaction{committer: p, factory: p.factory, group: groupId}, nil
+}
+
+func (p *kafkaTransactionalProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if !p.fenced {
+		close(p.closing)
+	}
+	p.closeBrokers()
+	if p.ownsClient {
+		return p.client.Close()
+	}
+	return nil
+}
+
+func (p *kafkaTransactionalProducer) end() {
+	p.txn <- struct{}{}
+}
+
+// closedError returns the error the producer fails with once it is closed
+// or fenced off.
+func (p *kafkaTransactionalProducer) closedError() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.fenced && !p.closed {
+		return errors.WithStack(ErrProducerFenced)
+	}
+	return errors.WithStack(ErrProducerClosed)
+}
+
+// fence closes the producer for good after a fatal error. Its client and
+// brokers are left for Close to close.
+func (p *kafkaTransactionalProducer) fence() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.fenced {
+		return
+	}
+	p.fenced = true
+	close(p.closing)
+}
+
+func (p *kafkaTransactionalProducer) commit(group string, messages []Message, offsets map[topicPartition]*ReceivedMessage) error {
+	err := p.tryCommit(group, messages, offsets)
+	if err != nil && fatalTransactionError(errors.Cause(err)) {
+		p.fence()
+		return errors.Wrapf(ErrProducerFenced, "failed to commit transaction: %s", err)
+	}
+	return err
+}
+
+func (p *kafkaTransactionalProducer) tryCommit(group string, messages []Message, offsets map[topicPartition]*ReceivedMessage) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.WithStack(ErrProducerClosed)
+	}
+	if p.fenced {
+		return errors.WithStack(ErrProducerFenced)
+	}
+	if len(messages) == 0 && len(offsets) == 0 {
+		return nil
+	}
+	if p.producerID < 0 {
+		if err := p.initProducerID(); err != nil {
+			return err
+		}
+	}
+	batches, err := p.batches(messages)
+	if err != nil {
+		return err
+	}
+	if err := p.run(group, batches, offsets); err != nil {
+		if fatalTransactionError(errors.Cause(err)) {
+			// Kafka aborts the transaction; another producer owns the
+			// transactional ID now, or this one may not use it
+			return err
+		}
+		// Abort what Kafka has of the transaction, and fence it off with a
+		// new epoch before the next one, since the sequence numbers of its
+		// partitions are unknown
+		p.endTxn(false)
+		p.producerID = -1
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// run adds the partitions of a transaction's messages to it, produces them,
+// commits its offsets and ends it.
+func (p *kafkaTransactionalProducer) run(group string, batches map[topicPartition][]Message, offsets map[topicPartition]*ReceivedMessage) error {
+	if len(batches) > 0 {
+		if err := p.addPartitions(batches); err != nil {
+			return err
+		}
+		if err := p.produce(batches); err != nil {
+			return err
+		}
+	}
+	if len(offsets) > 0 {
+		if err := p.commitOffsets(group, offsets); err != nil {
+			return err
+		}
+	}
+	return p.endTxn(true)
+}
+
+// batches groups messages by the partition sarama's partitioner assigns
+// them to.
+func (p *kafkaTransactionalProducer) batches(messages []Message) (map[topicPartition][]Message, error) {
+	batches := map[topicPartition][]Message{}
+	partitioners := map[string]sarama.Partitioner{}
+	for _, message := range messages {
+		topic := message.Topic()
+		partitions, err := p.client.Partitions(topic)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get partitions of topic %s", topic)
+		}
+		partitioner, ok := partitioners[topic]
+		if !ok {
+			partitioner = p.client.Config().Producer.Partitioner(topic)
+			partitioners[topic] = partitioner
+		}
+		i, err := partitioner.Partition(&sarama.ProducerMessage{
+			Topic: topic,
+			Key:   sarama.ByteEncoder(message.Key()),
+			Value: sarama.ByteEncoder(message.Value()),
+		}, int32(len(partitions)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to partition message for topic %s", topic)
+		}
+		tp := topicPartition{topic, partitions[i]}
+		batches[tp] = append(batches[tp], message)
+	}
+	return batches, nil
+}
+
+// initProducerID gets the producer ID and epoch of the transactional ID,
+// which aborts any transaction left open with it.
+func (p *kafkaTransactionalProducer) initProducerID() error {
+	p.producerID = -1
+	return p.retry(func() error {
+		coordinator, err := p.transactionCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.InitProducerID(&sarama.InitProducerIDRequest{
+			TransactionalID:    &p.transactionalID,
+			TransactionTimeout: TransactionTimeout,
+		})
+		if err != nil {
+			p.closeCoordinator()
+			return errors.Wrap(err, "failed to init producer ID")
+		}
+		if resp.Err != sarama.ErrNoError {
+			return p.coordinatorError(resp.Err, "failed to init producer ID")
+		}
+		p.producerID, p.epoch = resp.ProducerID, resp.ProducerEpoch
+		p.sequences = map[topicPartition]int32{}
+		return nil
+	})
+}
+
+func (p *kafkaTransactionalProducer) addPartitions(batches map[topicPartition][]Message) error {
+	topicPartitions := map[string][]int32{}
+	for tp := range batches {
+		topicPartitions[tp.topic] = append(topicPartitions[tp.topic], tp.partition)
+	}
+	return p.retry(func() error {
+		coordinator, err := p.transactionCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.AddPartitionsToTxn(&sarama.AddPartitionsToTxnRequest{
+			TransactionalID: p.transactionalID,
+			ProducerID:      p.producerID,
+			ProducerEpoch:   p.epoch,
+			TopicPartitions: topicPartitions,
+		})
+		if err != nil {
+			p.closeCoordinator()
+			return errors.Wrap(err, "failed to add partitions to transaction")
+		}
+		for _, errs := range resp.Errors {
+			for _, e := range errs {
+				if e.Err != sarama.ErrNoError {
+					return p.coordinatorError(e.Err, "failed to add partitions to transaction")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// produce sends the batches of a transaction to the leaders of their
+// partitions. It isn't retried: a failure aborts the transaction.
+func (p *kafkaTransactionalProducer) produce(batches map[topicPartition][]Message) error {
+	config := p.client.Config()
+	requests := map[*sarama.Broker]*sarama.ProduceRequest{}
+	now := time.Now()
+	for tp, messages := range batches {
+		leader, err := p.client.Leader(tp.topic, tp.partition)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get leader of partition %d of topic %s", tp.partition, tp.topic)
+		}
+		req, ok := requests[leader]
+		if !ok {
+			req = &sarama.ProduceRequest{
+				TransactionalID: &p.transactionalID,
+				RequiredAcks:    sarama.WaitForAll,
+				Timeout:         int32(config.Producer.Timeout / time.Millisecond),
+				Version:         3,
+			}
+			requests[leader] = req
+		}
+		batch := &sarama.RecordBatch{
+			Version:          2,
+			Codec:            config.Producer.Compression,
+			CompressionLevel: config.Producer.CompressionLevel,
+			FirstTimestamp:   now,
+			MaxTimestamp:     now,
+			ProducerID:       p.producerID,
+			ProducerEpoch:    p.epoch,
+			FirstSequence:    p.sequences[tp],
+			IsTransactional:  true,
+			LastOffsetDelta:  int32(len(messages) - 1),
+		}
+		for i, message := range messages {
+			record := &sarama.Record{
+				OffsetDelta: int64(i),
+				Key:         message.Key(),
+				Value:       message.Value(),
+			}
+			for _, h := range recordHeaders(MessageHeaders(message)) {
+				h := h
+				record.Headers = append(record.Headers, &h)
+			}
+			batch.Records = append(batch.Records, record)
+		}
+		req.AddBatch(tp.topic, tp.partition, batch)
+	}
+	for leader, req := range requests {
+		resp, err := leader.Produce(req)
+		if err != nil {
+			return errors.Wrap(err, "failed to produce messages")
+		}
+		for tp := range batches {
+			block := resp.GetBlock(tp.topic, tp.partition)
+			if block == nil {
+				continue
+			}
+			if block.Err != sarama.ErrNoError {
+				return errors.Wrapf(block.Err, "failed to produce messages to partition %d of topic %s", tp.partition, tp.topic)
+			}
+		}
+	}
+	for tp, messages := range batches {
+		p.sequences[tp] += int32(len(messages))
+	}
+	return nil
+}
+
+// commitOffsets adds a consumer group's offsets to a transaction.
+func (p *kafkaTransactionalProducer) commitOffsets(group string, offsets map[topicPartition]*ReceivedMessage) error {
+	err := p.retry(func() error {
+		coordinator, err := p.transactionCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.AddOffsetsToTxn(&sarama.AddOffsetsToTxnRequest{
+			TransactionalID: p.transactionalID,
+			ProducerID:      p.producerID,
+			ProducerEpoch:   p.epoch,
+			GroupID:         group,
+		})
+		if err != nil {
+			p.closeCoordinator()
+			return errors.Wrap(err, "failed to add offsets to transaction")
+		}
+		if resp.Err != sarama.ErrNoError {
+			return p.coordinatorError(resp.Err, "failed to add offsets to transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	topics := map[string][]*sarama.PartitionOffsetMetadata{}
+	for tp, msg := range offsets {
+		topics[tp.topic] = append(topics[tp.topic], &sarama.PartitionOffsetMetadata{
+			Partition: tp.partition,
+			Offset:    msg.Offset + 1,
+		})
+	}
+	return p.retry(func() error {
+		coordinator, err := p.groupCoordinator(group)
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.TxnOffsetCommit(&sarama.TxnOffsetCommitRequest{
+			TransactionalID: p.transactionalID,
+			GroupID:         group,
+			ProducerID:      p.producerID,
+			ProducerEpoch:   p.epoch,
+			Topics:          topics,
+		})
+		if err != nil {
+			p.closeGroupCoordinator(group)
+			return errors.Wrapf(err, "failed to commit offsets of group %s", group)
+		}
+		for _, errs := range resp.Topics {
+			for _, e := range errs {
+				if e.Err == sarama.ErrNoError {
+					continue
+				}
+				if retriableTransactionError(e.Err) {
+					p.closeGroupCoordinator(group)
+				}
+				return errors.Wrapf(e.Err, "failed to commit offsets of group %s", group)
+			}
+		}
+		return nil
+	})
+}
+
+// endTxn commits or aborts the open transaction.
+func (p *kafkaTransactionalProducer) endTxn(commit bool) error {
+	return p.retry(func() error {
+		coordinator, err := p.transactionCoordinator()
+		if err != nil {
+			return err
+		}
+		resp, err := coordinator.EndTxn(&sarama.EndTxnRequest{
+			TransactionalID:   p.transactionalID,
+			ProducerID:        p.producerID,
+			ProducerEpoch:     p.epoch,
+			TransactionResult: commit,
+		})
+		if err != nil {
+			p.closeCoordinator()
+			return errors.Wrap(err, "failed to end transaction")
+		}
+		if resp.Err != sarama.ErrNoError {
+			return p.coordinatorError(resp.Err, "failed to end transaction")
+		}
+		return nil
+	})
+}
+
+// transactionCoordinator returns the broker coordinating the producer's
+// transactions.
+func (p *kafkaTransactionalProducer) transactionCoordinator() (*sarama.Broker, error) {
+	if p.coordinator == nil {
+		coordinator, err := p.findCoordinator(p.transactionalID, sarama.CoordinatorTransaction)
+		if err != nil {
+			return nil, err
+		}
+		p.coordinator = coordinator
+	}
+	return p.coordinator, nil
+}
+
+// groupCoordinator returns the broker coordinating a consumer group.
+func (p *kafkaTransactionalProducer) groupCoordinator(group string) (*sarama.Broker, error) {
+	if coordinator, ok := p.groups[group]; ok {
+		return coordinator, nil
+	}
+	coordinator, err := p.findCoordinator(group, sarama.CoordinatorGroup)
+	if err != nil {
+		return nil, err
+	}
+	p.groups[group] = coordinator
+	return coordinator, nil
+}
+
+// findCoordinator asks the brokers for the coordinator of a transactional
+// ID or consumer group, and connects to it.
+func (p *kafkaTransactionalProducer) findCoordinator(key string, coordinatorType sarama.CoordinatorType) (*sarama.Broker, error) {
+	config := p.client.Config()
+	err := errors.New("no brokers available")
+	for _, broker := range p.client.Brokers() {
+		broker.Open(config)
+		var resp *sarama.FindCoordinatorResponse
+		resp, err = broker.FindCoordinator(&sarama.FindCoordinatorRequest{
+			Version:         1,
+			CoordinatorKey:  key,
+			CoordinatorType: coordinatorType,
+		})
+		if err != nil {
+			continue
+		}
+		if resp.Err != sarama.ErrNoError {
+			return nil, errors.Wrapf(resp.Err, "failed to find coordinator of %s", key)
+		}
+		coordinator := sarama.NewBroker(resp.Coordinator.Addr())
+		if err := coordinator.Open(config); err != nil {
+			return nil, errors.Wrapf(err, "failed to connect to coordinator of %s", key)
+		}
+		return coordinator, nil
+	}
+	return nil, errors.Wrapf(err, "failed to find coordinator of %s", key)
+}
+
+// coordinatorError returns an error from the transaction coordinator,
+// forgetting the coordinator if it has moved.
+func (p *kafkaTransactionalProducer) coordinatorError(err sarama.KError, message string) error {
+	if err == sarama.ErrNotCoordinatorForConsumer || err == sarama.ErrConsumerCoordinatorNotAvailable {
+		p.closeCoordinator()
+	}
+	return errors.Wrap(err, message)
+}
+
+func (p *kafkaTransactionalProducer) closeCoordinator() {
+	if p.coordinator != nil {
+		p.coordinator.Close()
+		p.coordinator = nil
+	}
+}
+
+func (p *kafkaTransactionalProducer) closeGroupCoordinator(group string) {
+	if coordinator, ok := p.groups[group]; ok {
+		coordinator.Close()
+		delete(p.groups, group)
+	}
+}
+
+func (p *kafkaTransactionalProducer) closeBrokers() {
+	p.closeCoordinator()
+	for group := range p.groups {
+		p.closeGroupCoordinator(group)
+	}
+}
+
+// retry calls f until it succeeds, fails with an error that isn't
+// retriable, or has been retried as many times as the client's producers
+// retry.
+func (p *kafkaTransactionalProducer) retry(f func() error) error {
+	config := p.client.Config()
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= config.Producer.Retry.Max || !retriableTransactionError(errors.Cause(err)) {
+			return err
+		}
+		time.Sleep(config.Producer.Retry.Backoff)
+	}
+}
+
+// fatalTransactionError returns whether a transactional producer failing
+// with an error can never commit a transaction again.
+func fatalTransactionError(err error) bool {
+	switch err {
+	case sarama.ErrInvalidProducerEpoch,
+		sarama.ErrTransactionCoordinatorFenced,
+		sarama.ErrTransactionalIDAuthorizationFailed,
+		sarama.ErrClusterAuthorizationFailed,
+		sarama.ErrTopicAuthorizationFailed,
+		sarama.ErrGroupAuthorizationFailed:
+		return true
+	}
+	return false
+}
+
+// retriableTransactionError returns whether a request to a coordinator
+// failing with an error may succeed if it is retried.
+func retriableTransactionError(err error) bool {
+	switch err {
+	case sarama.ErrNotCoordinatorForConsumer,
+		sarama.ErrConsumerCoordinatorNotAvailable,
+		sarama.ErrOffsetsLoadInProgress,
+		sarama.ErrConcurrentTransactions:
+		return true
+	}
+	return false
+}
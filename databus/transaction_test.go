@@ -0,0 +1,319 @@
+package databus_test
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	. "github.com/zenoss/zenkit/databus"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transactions", func() {
+
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		broker   *MemoryBroker
+		input    MessageFactory
+		output   MessageFactory
+		producer TransactionalProducer
+	)
+
+	// transform consumes a message and sends a message derived from it in a
+	// new transaction
+	transform := func() Transaction {
+		consumer, err := NewMemoryDatabusConsumer(broker, input, "group")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer consumer.Close()
+		msg, err := consumer.(MessageSource).Receive(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		var v memoryTestMessage
+		Ω(msg.Decode(&v)).Should(Succeed())
+		Ω(v.Value.TotallyCool).Should(Equal("in"))
+
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.SendContext(ctx, v.Key, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(tx.MarkOffset(msg)).Should(Succeed())
+		return tx
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		client := NewMemorySchemaRegistryClient()
+		client.RegisterNewSchema("key-test", keyTestSchema)
+		client.RegisterNewSchema("val-test", valTestSchema)
+		var err error
+		input, err = NewMessageFactory(test.RandString(8), "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		output, err = NewMessageFactory(test.RandString(8), "key-test", "val-test", client)
+		Ω(err).ShouldNot(HaveOccurred())
+		broker = NewMemoryBroker(1)
+		Ω(NewMemoryDatabusProducer(broker, input).Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "in"})).Should(Succeed())
+		producer = NewMemoryTransactionalProducer(broker, output)
+	})
+
+	AfterEach(func() {
+		producer.Close()
+		cancel()
+	})
+
+	It("should publish messages and commit offsets together", func() {
+		tx := transform()
+		Ω(broker.Messages(output.Topic())).Should(BeEmpty())
+		Ω(broker.Offsets("group", input.Topic())).Should(Equal([]int64{0}))
+		Ω(tx.Commit()).Should(Succeed())
+		Ω(broker.Messages(output.Topic())).Should(HaveLen(1))
+		Ω(broker.Offsets("group", input.Topic())).Should(Equal([]int64{1}))
+	})
+
+	It("should neither publish nor commit an aborted transaction", func() {
+		Ω(transform().Abort()).Should(Succeed())
+		Ω(broker.Messages(output.Topic())).Should(BeEmpty())
+		Ω(broker.Offsets("group", input.Topic())).Should(Equal([]int64{0}))
+
+		// The input is delivered again, and only its second transformation
+		// is published
+		Ω(transform().Commit()).Should(Succeed())
+		Ω(broker.Messages(output.Topic())).Should(HaveLen(1))
+	})
+
+	It("should not be used once it is done", func() {
+		tx := transform()
+		Ω(tx.Commit()).Should(Succeed())
+		Ω(errors.Cause(tx.Commit())).Should(Equal(ErrTransactionDone))
+		Ω(errors.Cause(tx.Abort())).Should(Equal(ErrTransactionDone))
+		Ω(errors.Cause(tx.Send(KeyTest{}, ValTest{}))).Should(Equal(ErrTransactionDone))
+		Ω(errors.Cause(tx.MarkOffset(&ReceivedMessage{Message: NewMessage("t", nil, nil)}))).Should(Equal(ErrTransactionDone))
+	})
+
+	It("should leave a transaction open if its producer is closed", func() {
+		tx := transform()
+		producer.Close()
+		Ω(errors.Cause(tx.Commit())).Should(Equal(ErrProducerClosed))
+		Ω(tx.Abort()).Should(Succeed())
+		Ω(broker.Messages(output.Topic())).Should(BeEmpty())
+	})
+
+	It("should not begin transactions once closed", func() {
+		producer.Close()
+		_, err := producer.Begin("group")
+		Ω(errors.Cause(err)).Should(Equal(ErrProducerClosed))
+	})
+})
+
+var _ = Describe("Kafka transactions", func() {
+
+	var (
+		broker   *sarama.MockBroker
+		handlers map[string]sarama.MockResponse
+		client   sarama.Client
+		factory  MessageFactory
+		producer TransactionalProducer
+		input    *ReceivedMessage
+	)
+
+	// requests returns the requests of a type the broker received
+	requests := func(name string) []interface{} {
+		var result []interface{}
+		for _, rr := range broker.History() {
+			if reflect.TypeOf(rr.Request).Elem().Name() == name {
+				result = append(result, rr.Request)
+			}
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		registry := NewMemorySchemaRegistryClient()
+		registry.RegisterNewSchema("key-test", keyTestSchema)
+		registry.RegisterNewSchema("val-test", valTestSchema)
+		var err error
+		factory, err = NewMessageFactory("output", "key-test", "val-test", registry)
+		Ω(err).ShouldNot(HaveOccurred())
+		msg, err := factory.Message(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "in"})
+		Ω(err).ShouldNot(HaveOccurred())
+		input = NewReceivedMessage(NewMessage("input", msg.Key(), msg.Value()), 0, 41, factory)
+
+		broker = sarama.NewMockBroker(GinkgoT(), 1)
+		coordinator := sarama.NewBroker(broker.Addr())
+		handlers = map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(GinkgoT()).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("output", 0, broker.BrokerID()),
+			"FindCoordinatorRequest": sarama.NewMockWrapper(&sarama.FindCoordinatorResponse{
+				Version:     1,
+				Coordinator: coordinator,
+			}),
+			"InitProducerIDRequest": sarama.NewMockWrapper(&sarama.InitProducerIDResponse{
+				ProducerID: 7,
+			}),
+			"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{
+				Errors: map[string][]*sarama.PartitionError{"output": {{Partition: 0}}},
+			}),
+			"ProduceRequest":         sarama.NewMockProduceResponse(GinkgoT()).SetVersion(3),
+			"AddOffsetsToTxnRequest": sarama.NewMockWrapper(&sarama.AddOffsetsToTxnResponse{}),
+			"TxnOffsetCommitRequest": sarama.NewMockWrapper(&sarama.TxnOffsetCommitResponse{
+				Topics: map[string][]*sarama.PartitionError{"input": {{Partition: 0}}},
+			}),
+			"EndTxnRequest": sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+		}
+		broker.SetHandlerByMap(handlers)
+		config := KafkaOptions{}.Config()
+		config.Producer.Retry.Backoff = time.Millisecond
+		client, err = sarama.NewClient([]string{broker.Addr()}, config)
+		Ω(err).ShouldNot(HaveOccurred())
+		producer, err = NewSaramaTransactionalProducer(client, "transformer", factory)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		producer.Close()
+		client.Close()
+		broker.Close()
+	})
+
+	It("should produce messages and commit offsets in a transaction", func() {
+		Ω(requests("InitProducerIDRequest")).Should(HaveLen(1))
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(tx.MarkOffset(input)).Should(Succeed())
+		Ω(requests("ProduceRequest")).Should(BeEmpty())
+		Ω(tx.Commit()).Should(Succeed())
+
+		added := requests("AddPartitionsToTxnRequest")
+		Ω(added).Should(HaveLen(1))
+		Ω(added[0].(*sarama.AddPartitionsToTxnRequest).TopicPartitions).Should(Equal(map[string][]int32{"output": {0}}))
+		Ω(added[0].(*sarama.AddPartitionsToTxnRequest).ProducerID).Should(Equal(int64(7)))
+
+		produced := requests("ProduceRequest")
+		Ω(produced).Should(HaveLen(1))
+		Ω(*produced[0].(*sarama.ProduceRequest).TransactionalID).Should(Equal("transformer"))
+
+		Ω(requests("AddOffsetsToTxnRequest")[0].(*sarama.AddOffsetsToTxnRequest).GroupID).Should(Equal("group"))
+		committed := requests("TxnOffsetCommitRequest")
+		Ω(committed).Should(HaveLen(1))
+		Ω(committed[0].(*sarama.TxnOffsetCommitRequest).Topics["input"][0].Offset).Should(Equal(int64(42)))
+
+		ended := requests("EndTxnRequest")
+		Ω(ended).Should(HaveLen(1))
+		Ω(ended[0].(*sarama.EndTxnRequest).TransactionResult).Should(BeTrue())
+	})
+
+	It("should send nothing for an aborted transaction", func() {
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(tx.Abort()).Should(Succeed())
+		Ω(requests("ProduceRequest")).Should(BeEmpty())
+		Ω(requests("EndTxnRequest")).Should(BeEmpty())
+
+		// The producer can begin another transaction
+		tx, err = producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Abort()).Should(Succeed())
+	})
+
+	It("should abort a transaction that fails, and fence it off", func() {
+		handlers["ProduceRequest"] = sarama.NewMockProduceResponse(GinkgoT()).
+			SetVersion(3).
+			SetError("output", 0, sarama.ErrNotEnoughReplicas)
+		broker.SetHandlerByMap(handlers)
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(tx.MarkOffset(input)).Should(Succeed())
+		Ω(errors.Cause(tx.Commit())).Should(Equal(sarama.ErrNotEnoughReplicas))
+		Ω(requests("TxnOffsetCommitRequest")).Should(BeEmpty())
+		ended := requests("EndTxnRequest")
+		Ω(ended).Should(HaveLen(1))
+		Ω(ended[0].(*sarama.EndTxnRequest).TransactionResult).Should(BeFalse())
+
+		// The next transaction gets a new epoch first
+		handlers["ProduceRequest"] = sarama.NewMockProduceResponse(GinkgoT()).SetVersion(3)
+		broker.SetHandlerByMap(handlers)
+		tx, err = producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(tx.Commit()).Should(Succeed())
+		Ω(requests("InitProducerIDRequest")).Should(HaveLen(2))
+	})
+
+	It("should fail for good once another producer begins with its ID", func() {
+		handlers["InitProducerIDRequest"] = sarama.NewMockWrapper(&sarama.InitProducerIDResponse{
+			ProducerID:    7,
+			ProducerEpoch: 1,
+		})
+		broker.SetHandlerByMap(handlers)
+		successor, err := NewSaramaTransactionalProducer(client, "transformer", factory)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer successor.Close()
+
+		// The broker rejects the old epoch
+		handlers["ProduceRequest"] = sarama.NewMockProduceResponse(GinkgoT()).
+			SetVersion(3).
+			SetError("output", 0, sarama.ErrInvalidProducerEpoch)
+		broker.SetHandlerByMap(handlers)
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(errors.Cause(tx.Commit())).Should(Equal(ErrProducerFenced))
+		Ω(requests("EndTxnRequest")).Should(BeEmpty())
+
+		handlers["ProduceRequest"] = sarama.NewMockProduceResponse(GinkgoT()).SetVersion(3)
+		broker.SetHandlerByMap(handlers)
+		_, err = producer.Begin("group")
+		Ω(errors.Cause(err)).Should(Equal(ErrProducerFenced))
+		Ω(requests("InitProducerIDRequest")).Should(HaveLen(2))
+
+		// The successor carries on
+		tx, err = successor.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.Send(KeyTest{SomeString: "a"}, ValTest{TotallyCool: "out"})).Should(Succeed())
+		Ω(tx.Commit()).Should(Succeed())
+		Ω(requests("InitProducerIDRequest")).Should(HaveLen(2))
+	})
+
+	It("should retry requests while the coordinator is busy", func() {
+		handlers["EndTxnRequest"] = sarama.NewMockSequence(
+			sarama.NewMockWrapper(&sarama.EndTxnResponse{Err: sarama.ErrConcurrentTransactions}),
+			sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+		)
+		broker.SetHandlerByMap(handlers)
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tx.MarkOffset(input)).Should(Succeed())
+		Ω(tx.Commit()).Should(Succeed())
+		Ω(requests("EndTxnRequest")).Should(HaveLen(2))
+	})
+
+	It("should wait for the open transaction to begin another", func() {
+		tx, err := producer.Begin("group")
+		Ω(err).ShouldNot(HaveOccurred())
+		begun := make(chan Transaction, 1)
+		go func() {
+			defer GinkgoRecover()
+			next, err := producer.Begin("group")
+			Ω(err).ShouldNot(HaveOccurred())
+			begun <- next
+		}()
+		Consistently(begun).ShouldNot(Receive())
+		Ω(tx.Commit()).Should(Succeed())
+		var next Transaction
+		Eventually(begun).Should(Receive(&next))
+		Ω(next.Abort()).Should(Succeed())
+	})
+
+	It("should not begin transactions once closed", func() {
+		producer.Close()
+		_, err := producer.Begin("group")
+		Ω(errors.Cause(err)).Should(Equal(ErrProducerClosed))
+	})
+})
@@ -1,6 +1,8 @@
 package healthcheck
 
 import (
+	"errors"
+	"io"
 	"sync"
 	"time"
 
@@ -27,6 +29,13 @@ func NewRegistry() *Registry {
 // the registry used by the HTTP handler.
 var DefaultRegistry *Registry
 
+// DrainingCheck is the name under which a draining registry reports
+// ErrDraining.
+const DrainingCheck = "draining"
+
+// ErrDraining is reported by a registry once Drain has been called.
+var ErrDraining = errors.New("service is draining")
+
 // Checker is the interface for a Health Checker
 type Checker interface {
 	// Check returns nil if the service is okay.
@@ -125,33 +134,48 @@ func NewThresholdStatusUpdater(t int) Updater {
 	return &thresholdUpdater{threshold: t}
 }
 
-// PeriodicChecker wraps an updater to provide a periodic checker
-func PeriodicChecker(check Checker, period time.Duration) Checker {
-	u := NewStatusUpdater()
+// periodicChecker updates an Updater with the result of a check on every
+// tick, until it is closed.
+type periodicChecker struct {
+	Updater
+	stop chan struct{}
+	once sync.Once
+}
+
+func newPeriodicChecker(check Checker, period time.Duration, u Updater) *periodicChecker {
+	pc := &periodicChecker{Updater: u, stop: make(chan struct{})}
 	go func() {
 		t := time.NewTicker(period)
+		defer t.Stop()
 		for {
-			<-t.C
-			u.Update(check.Check())
+			select {
+			case <-t.C:
+				u.Update(check.Check())
+			case <-pc.stop:
+				return
+			}
 		}
 	}()
+	return pc
+}
+
+// Close implements io.Closer, stopping the periodic check.
+func (pc *periodicChecker) Close() error {
+	pc.once.Do(func() { close(pc.stop) })
+	return nil
+}
 
-	return u
+// PeriodicChecker wraps an updater to provide a periodic checker. The checker
+// returned implements io.Closer, which stops the periodic check.
+func PeriodicChecker(check Checker, period time.Duration) Checker {
+	return newPeriodicChecker(check, period, NewStatusUpdater())
 }
 
 // PeriodicThresholdChecker wraps an updater to provide a periodic checker that
-// uses a threshold before it changes status
+// uses a threshold before it changes status. The checker returned implements
+// io.Closer, which stops the periodic check.
 func PeriodicThresholdChecker(check Checker, period time.Duration, threshold int) Checker {
-	tu := NewThresholdStatusUpdater(threshold)
-	go func() {
-		t := time.NewTicker(period)
-		for {
-			<-t.C
-			tu.Update(check.Check())
-		}
-	}()
-
-	return tu
+	return newPeriodicChecker(check, period, NewThresholdStatusUpdater(threshold))
 }
 
 // CheckStatus returns a map with all the current health check errors
@@ -175,6 +199,33 @@ func CheckStatus() map[string]string {
 	return DefaultRegistry.CheckStatus()
 }
 
+// Drain marks the registry as draining. From then on CheckStatus reports
+// ErrDraining under DrainingCheck, so load balancers stop routing new requests
+// to the service while it shuts down. Draining more than once has no effect.
+func (registry *Registry) Drain() {
+	registry.registeredChecks.LoadOrStore(DrainingCheck, CheckFunc(func() error {
+		return ErrDraining
+	}))
+}
+
+// Drain marks the default registry as draining.
+func Drain() {
+	DefaultRegistry.Drain()
+}
+
+// Close stops every registered check that implements io.Closer, such as those
+// created by PeriodicChecker. The checks stay registered and keep reporting
+// their last status.
+func (registry *Registry) Close() error {
+	registry.registeredChecks.Range(func(k, v interface{}) bool {
+		if c, ok := v.(io.Closer); ok {
+			c.Close()
+		}
+		return true
+	})
+	return nil
+}
+
 // Register associates the checker with the provided name.
 func (registry *Registry) Register(name string, check Checker) {
 	_, loaded := registry.registeredChecks.LoadOrStore(name, check)
@@ -2,6 +2,7 @@ package healthcheck_test
 
 import (
 	"errors"
+	"io"
 	"time"
 
 	. "github.com/zenoss/zenkit/healthcheck"
@@ -104,6 +105,15 @@ var _ = Describe("Health", func() {
 			Ω(c.Check()).Should(BeNil())
 			Eventually(c.Check, 2*time.Second).ShouldNot(BeNil())
 		})
+
+		It("should stop updating the status once closed", func() {
+			c := PeriodicChecker(u, 10*time.Millisecond)
+			closer, ok := c.(io.Closer)
+			Ω(ok).Should(BeTrue())
+			Ω(closer.Close()).Should(Succeed())
+			u.Update(errors.New("he dead"))
+			Consistently(c.Check, 100*time.Millisecond).Should(BeNil())
+		})
 	})
 
 	Context("with a PeriodicThresholdChecker", func() {
@@ -145,6 +155,38 @@ var _ = Describe("Health", func() {
 		})
 	})
 
+	Context("draining a registry", func() {
+
+		It("should report the registry as draining", func() {
+			Ω(CheckStatus()).Should(BeEmpty())
+			Drain()
+			Ω(CheckStatus()).Should(HaveKeyWithValue(DrainingCheck, ErrDraining.Error()))
+		})
+
+		It("should be safe to drain more than once", func() {
+			Drain()
+			Ω(Drain).ShouldNot(Panic())
+			Ω(CheckStatus()).Should(HaveLen(1))
+		})
+	})
+
+	Context("closing a registry", func() {
+
+		It("should stop its periodic checks", func() {
+			u := NewStatusUpdater()
+			DefaultRegistry.Register("test", PeriodicChecker(u, 10*time.Millisecond))
+			Ω(DefaultRegistry.Close()).Should(Succeed())
+			u.Update(errors.New("he dead"))
+			Consistently(CheckStatus, 100*time.Millisecond).Should(BeEmpty())
+		})
+
+		It("should leave other checks registered", func() {
+			RegisterFunc("test", func() error { return errors.New("he dead") })
+			Ω(DefaultRegistry.Close()).Should(Succeed())
+			Ω(CheckStatus()).Should(HaveKey("test"))
+		})
+	})
+
 	Context("registering a health check", func() {
 
 		It("should register a checker", func() {
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/goadesign/goa"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// HTTPMetricsPrefix is the prefix of the names of the metrics recorded by
+// RecordRequestMetrics.
+const HTTPMetricsPrefix = "http"
+
+// RecordRequestMetrics starts recording metrics for a request handled by the
+// goa controller and action on the context. It returns a function to call
+// with the handler's result once the response has been written. For a
+// controller "Foo" and action "bar" it records:
+//
+//	http.Foo.bar.time           a timer of request durations
+//	http.Foo.bar.status.2xx     a counter of responses per status class
+//	http.Foo.bar.inflight       a gauge of requests being handled
+//	http.Foo.bar.request.bytes  a histogram of request body sizes
+//	http.Foo.bar.response.bytes a histogram of response body sizes
+func RecordRequestMetrics(ctx context.Context, registry metrics.Registry, req *http.Request) func(error) {
+	if registry == nil {
+		return func(error) {}
+	}
+	begin := TimeFunc()
+	name := requestMetricName(ctx)
+	inflight := getOrRegisterInflightGauge(name+".inflight", registry)
+	inflight.add(1)
+	if req.ContentLength >= 0 {
+		getOrRegisterHistogram(name+".request.bytes", registry).Update(req.ContentLength)
+	}
+	return func(err error) {
+		inflight.add(-1)
+		metrics.GetOrRegisterTimer(name+".time", registry).UpdateSince(begin)
+		status, length := http.StatusOK, 0
+		if resp := goa.ContextResponse(ctx); resp != nil {
+			status, length = resp.Status, resp.Length
+		}
+		if status == 0 {
+			status = http.StatusOK
+			if err != nil {
+				status = http.StatusInternalServerError
+			}
+		}
+		metrics.GetOrRegisterCounter(fmt.Sprintf("%s.status.%dxx", name, status/100), registry).Inc(1)
+		getOrRegisterHistogram(name+".response.bytes", registry).Update(int64(length))
+	}
+}
+
+// requestMetricName is the common prefix of the metrics for the controller
+// and action handling a request.
+func requestMetricName(ctx context.Context) string {
+	ctrl, action := goa.ContextController(ctx), goa.ContextAction(ctx)
+	if ctrl == "" {
+		ctrl = "unknown"
+	}
+	if action == "" {
+		action = "unknown"
+	}
+	r := strings.NewReplacer(".", "_", " ", "_")
+	return fmt.Sprintf("%s.%s.%s", HTTPMetricsPrefix, r.Replace(ctrl), r.Replace(action))
+}
+
+func getOrRegisterHistogram(name string, registry metrics.Registry) metrics.Histogram {
+	return metrics.GetOrRegisterHistogram(name, registry, metrics.NewExpDecaySample(1028, 0.015))
+}
+
+// inflightGauge is a gauge that can be adjusted atomically, so concurrent
+// requests can track how many of them are in flight.
+type inflightGauge struct {
+	value int64
+}
+
+func getOrRegisterInflightGauge(name string, registry metrics.Registry) *inflightGauge {
+	g, ok := registry.GetOrRegister(name, func() interface{} { return &inflightGauge{} }).(*inflightGauge)
+	if !ok {
+		// Something else owns the name; count without reporting.
+		return &inflightGauge{}
+	}
+	return g
+}
+
+func (g *inflightGauge) add(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+// Snapshot implements metrics.Gauge.
+func (g *inflightGauge) Snapshot() metrics.Gauge {
+	return metrics.GaugeSnapshot(g.Value())
+}
+
+// Update implements metrics.Gauge.
+func (g *inflightGauge) Update(v int64) {
+	atomic.StoreInt64(&g.value, v)
+}
+
+// Value implements metrics.Gauge.
+func (g *inflightGauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
@@ -0,0 +1,88 @@
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/goadesign/goa"
+	metrics "github.com/rcrowley/go-metrics"
+	. "github.com/zenoss/zenkit/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request metrics", func() {
+
+	var (
+		reg      metrics.Registry
+		ctrl     *goa.Controller
+		rw       *httptest.ResponseRecorder
+		req      *http.Request
+		inflight int64
+	)
+
+	BeforeEach(func() {
+		reg = metrics.NewRegistry()
+		svc := goa.New("test")
+		svc.Context = WithMetrics(svc.Context, reg)
+		ctrl = svc.NewController("TestController")
+		ctrl.Use(MetricsMiddleware())
+		rw = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "http://example.com/", bytes.NewBufferString("hello"))
+	})
+
+	run := func(h goa.Handler) {
+		ctrl.MuxHandler("act", h, nil)(rw, req, url.Values{})
+	}
+
+	ok := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		inflight = reg.Get("http.TestController.act.inflight").(metrics.Gauge).Value()
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("0123456789"))
+		return nil
+	}
+
+	It("should time requests per controller and action", func() {
+		run(ok)
+		run(ok)
+		t, isTimer := reg.Get("http.TestController.act.time").(metrics.Timer)
+		Ω(isTimer).Should(BeTrue())
+		Ω(t.Count()).Should(BeNumerically("==", 2))
+	})
+
+	It("should count responses per status class", func() {
+		run(ok)
+		c, isCounter := reg.Get("http.TestController.act.status.2xx").(metrics.Counter)
+		Ω(isCounter).Should(BeTrue())
+		Ω(c.Count()).Should(BeNumerically("==", 1))
+	})
+
+	It("should count unwritten errors as server errors", func() {
+		run(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return errors.New("o no")
+		})
+		Ω(reg.Get("http.TestController.act.status.5xx")).ShouldNot(BeNil())
+	})
+
+	It("should track requests in flight", func() {
+		run(ok)
+		Ω(inflight).Should(BeNumerically("==", 1))
+		Ω(reg.Get("http.TestController.act.inflight").(metrics.Gauge).Value()).Should(BeNumerically("==", 0))
+	})
+
+	It("should record request and response sizes", func() {
+		run(ok)
+		Ω(reg.Get("http.TestController.act.request.bytes").(metrics.Histogram).Max()).Should(BeNumerically("==", 5))
+		Ω(reg.Get("http.TestController.act.response.bytes").(metrics.Histogram).Max()).Should(BeNumerically("==", 10))
+	})
+
+	It("should not record anything without a registry", func() {
+		done := RecordRequestMetrics(context.Background(), nil, req)
+		Ω(func() { done(nil) }).ShouldNot(Panic())
+	})
+})
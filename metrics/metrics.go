@@ -76,15 +76,22 @@ func UpdateMeter(ctx context.Context, name string, n int64) {
 	ctr.Mark(n)
 }
 
+// MetricsMiddleware attaches a metrics registry to the request context, unless
+// one is already attached, and records request metrics for every
+// controller/action in it. See RecordRequestMetrics for the metrics recorded.
 func MetricsMiddleware() goa.Middleware {
 	return func(h goa.Handler) goa.Handler {
 		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
 			// if the parent context has a metrics registry already, then use it
 			m := ContextMetrics(ctx)
 			if m == nil {
-				ctx = WithMetrics(ctx, metrics.NewRegistry())
+				m = metrics.NewRegistry()
+				ctx = WithMetrics(ctx, m)
 			}
-			return h(ctx, rw, req)
+			done := RecordRequestMetrics(ctx, m, req)
+			err := h(ctx, rw, req)
+			done(err)
+			return err
 		}
 	}
 }
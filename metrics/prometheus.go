@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+const (
+	// PrometheusContentType is the content type of the Prometheus text
+	// exposition format written by WritePrometheus.
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// OpenMetricsContentType is the content type of the OpenMetrics text
+	// format written by WriteOpenMetrics.
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+var (
+	// PrometheusQuantiles are the quantiles reported for timers and
+	// histograms.
+	PrometheusQuantiles = []float64{0.5, 0.75, 0.95, 0.98, 0.99, 0.999}
+
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+)
+
+// PrometheusName sanitizes a go-metrics name for use as a Prometheus metric
+// name, replacing every character that isn't allowed with an underscore.
+func PrometheusName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// WritePrometheus writes a snapshot of every metric in the registry to w in
+// the Prometheus text exposition format. Counters and meters are reported as
+// counters, gauges as gauges, and timers (in seconds) and histograms as
+// summaries.
+func WritePrometheus(w io.Writer, registry metrics.Registry) error {
+	return writeExposition(w, registry, false)
+}
+
+// WriteOpenMetrics writes a snapshot of every metric in the registry to w in
+// the OpenMetrics text format, using the same mapping as WritePrometheus.
+func WriteOpenMetrics(w io.Writer, registry metrics.Registry) error {
+	return writeExposition(w, registry, true)
+}
+
+func writeExposition(w io.Writer, registry metrics.Registry, openMetrics bool) error {
+	all := map[string]interface{}{}
+	names := []string{}
+	if registry != nil {
+		registry.Each(func(name string, m interface{}) {
+			all[name] = m
+			names = append(names, name)
+		})
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		writeMetric(bw, PrometheusName(name), all[name], openMetrics)
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func writeMetric(w *bufio.Writer, name string, m interface{}, openMetrics bool) {
+	switch metric := m.(type) {
+	case metrics.Counter:
+		writeCounter(w, name, metric.Count(), openMetrics)
+	case metrics.Meter:
+		writeCounter(w, name, metric.Count(), openMetrics)
+	case metrics.Gauge:
+		writeType(w, name, "gauge")
+		writeSample(w, name, "", float64(metric.Value()))
+	case metrics.GaugeFloat64:
+		writeType(w, name, "gauge")
+		writeSample(w, name, "", metric.Value())
+	case metrics.Timer:
+		s := metric.Snapshot()
+		name = strings.TrimSuffix(name, "_seconds") + "_seconds"
+		unit := float64(time.Second)
+		writeSummary(w, name, s.Percentiles(PrometheusQuantiles), float64(s.Sum())/unit, s.Count(), unit)
+	case metrics.Histogram:
+		s := metric.Snapshot()
+		writeSummary(w, name, s.Percentiles(PrometheusQuantiles), float64(s.Sum()), s.Count(), 1)
+	}
+}
+
+func writeCounter(w *bufio.Writer, name string, count int64, openMetrics bool) {
+	family := strings.TrimSuffix(name, "_total")
+	if openMetrics {
+		writeType(w, family, "counter")
+	} else {
+		writeType(w, family+"_total", "counter")
+	}
+	writeSample(w, family+"_total", "", float64(count))
+}
+
+func writeSummary(w *bufio.Writer, name string, quantiles []float64, sum float64, count int64, unit float64) {
+	writeType(w, name, "summary")
+	for i, q := range PrometheusQuantiles {
+		writeSample(w, name, fmt.Sprintf(`{quantile="%s"}`, formatFloat(q)), quantiles[i]/unit)
+	}
+	writeSample(w, name+"_sum", "", sum)
+	writeSample(w, name+"_count", "", float64(count))
+}
+
+func writeType(w *bufio.Writer, name, typ string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// formatFloat formats a value as Prometheus expects, including NaN and
+// +Inf/-Inf.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
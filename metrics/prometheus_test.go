@@ -0,0 +1,100 @@
+package metrics_test
+
+import (
+	"bytes"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	. "github.com/zenoss/zenkit/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prometheus", func() {
+
+	var (
+		reg metrics.Registry
+		buf *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		reg = metrics.NewRegistry()
+		buf = &bytes.Buffer{}
+	})
+
+	It("should sanitize metric names", func() {
+		Ω(PrometheusName("func.TimedFunc.time")).Should(Equal("func_TimedFunc_time"))
+		Ω(PrometheusName("my-service/requests:count")).Should(Equal("my_service_requests:count"))
+		Ω(PrometheusName("2xx")).Should(Equal("_2xx"))
+	})
+
+	It("should write nothing for an empty registry", func() {
+		Ω(WritePrometheus(buf, reg)).Should(Succeed())
+		Ω(buf.String()).Should(BeEmpty())
+	})
+
+	It("should write counters and meters as counters", func() {
+		metrics.GetOrRegisterCounter("my.counter", reg).Inc(3)
+		metrics.GetOrRegisterMeter("my.meter", reg).Mark(5)
+		Ω(WritePrometheus(buf, reg)).Should(Succeed())
+		Ω(buf.String()).Should(Equal(
+			"# TYPE my_counter_total counter\n" +
+				"my_counter_total 3\n" +
+				"# TYPE my_meter_total counter\n" +
+				"my_meter_total 5\n"))
+	})
+
+	It("should write gauges as gauges", func() {
+		metrics.GetOrRegisterGauge("my.gauge", reg).Update(7)
+		metrics.GetOrRegisterGaugeFloat64("my.float", reg).Update(1.5)
+		Ω(WritePrometheus(buf, reg)).Should(Succeed())
+		Ω(buf.String()).Should(Equal(
+			"# TYPE my_float gauge\n" +
+				"my_float 1.5\n" +
+				"# TYPE my_gauge gauge\n" +
+				"my_gauge 7\n"))
+	})
+
+	It("should write timers as summaries in seconds", func() {
+		t := metrics.GetOrRegisterTimer("my.timer", reg)
+		t.Update(2 * time.Second)
+		t.Update(4 * time.Second)
+		Ω(WritePrometheus(buf, reg)).Should(Succeed())
+		out := buf.String()
+		Ω(out).Should(ContainSubstring("# TYPE my_timer_seconds summary\n"))
+		Ω(out).Should(ContainSubstring(`my_timer_seconds{quantile="0.5"} 3` + "\n"))
+		Ω(out).Should(ContainSubstring(`my_timer_seconds{quantile="0.999"} 4` + "\n"))
+		Ω(out).Should(ContainSubstring("my_timer_seconds_sum 6\n"))
+		Ω(out).Should(ContainSubstring("my_timer_seconds_count 2\n"))
+	})
+
+	It("should write histograms as summaries", func() {
+		h := metrics.GetOrRegisterHistogram("my.histogram", reg, metrics.NewUniformSample(100))
+		h.Update(10)
+		h.Update(20)
+		Ω(WritePrometheus(buf, reg)).Should(Succeed())
+		out := buf.String()
+		Ω(out).Should(ContainSubstring("# TYPE my_histogram summary\n"))
+		Ω(out).Should(ContainSubstring(`my_histogram{quantile="0.5"} 15` + "\n"))
+		Ω(out).Should(ContainSubstring("my_histogram_sum 30\n"))
+		Ω(out).Should(ContainSubstring("my_histogram_count 2\n"))
+	})
+
+	Context("in the OpenMetrics format", func() {
+
+		It("should name counter families without the _total suffix", func() {
+			metrics.GetOrRegisterCounter("my.counter", reg).Inc(3)
+			Ω(WriteOpenMetrics(buf, reg)).Should(Succeed())
+			Ω(buf.String()).Should(Equal(
+				"# TYPE my_counter counter\n" +
+					"my_counter_total 3\n" +
+					"# EOF\n"))
+		})
+
+		It("should terminate an empty registry with EOF", func() {
+			Ω(WriteOpenMetrics(buf, reg)).Should(Succeed())
+			Ω(buf.String()).Should(Equal("# EOF\n"))
+		})
+	})
+})
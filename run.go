@@ -0,0 +1,173 @@
+package zenkit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/zenoss/zenkit/healthcheck"
+)
+
+var (
+	// DefaultGracePeriod is how long Run keeps serving in-flight requests
+	// after the service starts draining, unless configured otherwise.
+	DefaultGracePeriod = 5 * time.Second
+
+	// DefaultShutdownTimeout bounds how long Run waits for the servers to stop
+	// and the shutdown hooks to complete, unless configured otherwise.
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// ShutdownHook releases a resource when a service shuts down.
+type ShutdownHook func(context.Context) error
+
+// CloserHook adapts an io.Closer, such as a DatabusProducer, a DatabusConsumer
+// or a health check registry, to a ShutdownHook.
+func CloserHook(c io.Closer) ShutdownHook {
+	return func(context.Context) error {
+		return c.Close()
+	}
+}
+
+// RunOptions configures Run. The zero value binds the ports configured by
+// AddHTTPOptions and AddAdminOptions, drains the default health check
+// registry and stops on SIGINT or SIGTERM.
+type RunOptions struct {
+	// Port is the port the main service binds. Defaults to http.port.
+	Port int
+	// AdminPort is the port the admin service binds. Defaults to admin.port.
+	AdminPort int
+	// GracePeriod is how long to keep serving after the service starts
+	// draining. Defaults to shutdown.grace_period.
+	GracePeriod time.Duration
+	// ShutdownTimeout bounds how long to wait for in-flight requests and
+	// shutdown hooks. Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// Signals are the signals that start a shutdown. Defaults to SIGINT and
+	// SIGTERM.
+	Signals []os.Signal
+	// Health is the registry that reports the service as draining. Defaults
+	// to healthcheck.DefaultRegistry.
+	Health *healthcheck.Registry
+	// ShutdownHooks run once both servers have stopped, in the reverse of the
+	// order in which they were registered.
+	ShutdownHooks []ShutdownHook
+}
+
+// OnShutdown registers hooks to run once both servers have stopped. Hooks
+// registered last run first, so resources should be registered in the order
+// they are created.
+func (o *RunOptions) OnShutdown(hooks ...ShutdownHook) {
+	o.ShutdownHooks = append(o.ShutdownHooks, hooks...)
+}
+
+func (o RunOptions) withDefaults() RunOptions {
+	if o.Port == 0 {
+		o.Port = viper.GetInt(HTTPPortConfig)
+	}
+	if o.AdminPort == 0 {
+		o.AdminPort = viper.GetInt(AdminPortConfig)
+	}
+	if o.GracePeriod == 0 {
+		o.GracePeriod = DefaultGracePeriod
+		if viper.IsSet(ShutdownGracePeriodConfig) {
+			o.GracePeriod = viper.GetDuration(ShutdownGracePeriodConfig)
+		}
+	}
+	if o.ShutdownTimeout == 0 {
+		o.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if len(o.Signals) == 0 {
+		o.Signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	if o.Health == nil {
+		o.Health = healthcheck.DefaultRegistry
+	}
+	return o
+}
+
+// Run serves the main service and, if it isn't nil, the admin service until
+// ctx is cancelled, a shutdown signal is received or a server fails. It then
+// marks the service as draining, keeps serving for the grace period so load
+// balancers can stop routing to it, stops both servers, waits for in-flight
+// requests, and finally runs the shutdown hooks. A second signal skips the
+// rest of the grace period.
+//
+// Run returns the error that caused a server to fail, if any, or else the
+// first error encountered while shutting down.
+func Run(ctx context.Context, svc, admin *goa.Service, opts *RunOptions) error {
+	if opts == nil {
+		opts = &RunOptions{}
+	}
+	o := opts.withDefaults()
+
+	type server struct {
+		svc *goa.Service
+		*http.Server
+	}
+	servers := []server{{svc, &http.Server{Addr: fmt.Sprintf(":%d", o.Port), Handler: svc.Mux}}}
+	if admin != nil {
+		servers = append(servers, server{admin, &http.Server{Addr: fmt.Sprintf(":%d", o.AdminPort), Handler: admin.Mux}})
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, o.Signals...)
+	defer signal.Stop(sigc)
+
+	errc := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s server) {
+			s.svc.LogInfo("listen", "transport", "http", "addr", s.Addr)
+			if err := s.ListenAndServe(); err != http.ErrServerClosed {
+				errc <- errors.Wrapf(err, "%s server failed", s.svc.Name)
+			}
+		}(s)
+	}
+
+	var err error
+	select {
+	case sig := <-sigc:
+		svc.LogInfo("shutting down", "signal", sig.String())
+	case <-ctx.Done():
+		svc.LogInfo("shutting down", "reason", ctx.Err().Error())
+	case err = <-errc:
+		svc.LogError("shutting down", "err", err)
+	}
+
+	o.Health.Drain()
+	if err == nil {
+		svc.LogInfo("draining", "grace_period", o.GracePeriod.String())
+		t := time.NewTimer(o.GracePeriod)
+		select {
+		case <-t.C:
+		case <-sigc:
+			t.Stop()
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if e := s.Shutdown(shutdownCtx); e != nil && err == nil {
+			err = errors.Wrapf(e, "failed to shut down %s server", s.svc.Name)
+		}
+	}
+	for i := len(o.ShutdownHooks) - 1; i >= 0; i-- {
+		if e := o.ShutdownHooks[i](shutdownCtx); e != nil {
+			svc.LogError("shutdown hook failed", "err", e)
+			if err == nil {
+				err = errors.Wrap(e, "shutdown hook failed")
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package zenkit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/goadesign/goa"
+	. "github.com/zenoss/zenkit"
+	"github.com/zenoss/zenkit/healthcheck"
+	"github.com/zenoss/zenkit/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+
+	var (
+		svc      *goa.Service
+		adminSvc *goa.Service
+		registry *healthcheck.Registry
+		opts     *RunOptions
+		ctx      context.Context
+		cancel   context.CancelFunc
+		done     chan error
+	)
+
+	freePort := func() int {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer l.Close()
+		return l.Addr().(*net.TCPAddr).Port
+	}
+
+	get := func(port int) func() error {
+		return func() error {
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/hello", port))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				return fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			return nil
+		}
+	}
+
+	hello := func(rw http.ResponseWriter, req *http.Request, params url.Values) {
+		rw.WriteHeader(http.StatusNoContent)
+	}
+
+	BeforeEach(func() {
+		svc = goa.New(test.RandString(8))
+		svc.WithLogger(&NullLogAdapter{})
+		svc.Mux.Handle("GET", "/hello", hello)
+		adminSvc = goa.New("admin")
+		adminSvc.WithLogger(&NullLogAdapter{})
+		adminSvc.Mux.Handle("GET", "/hello", hello)
+		registry = healthcheck.NewRegistry()
+		opts = &RunOptions{
+			Port:        freePort(),
+			AdminPort:   freePort(),
+			GracePeriod: 100 * time.Millisecond,
+			Signals:     []os.Signal{syscall.SIGUSR1},
+			Health:      registry,
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan error, 1)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	start := func() {
+		go func() {
+			done <- Run(ctx, svc, adminSvc, opts)
+		}()
+		Eventually(get(opts.Port)).Should(Succeed())
+		Eventually(get(opts.AdminPort)).Should(Succeed())
+	}
+
+	It("should serve both services until the context is cancelled", func() {
+		start()
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		Ω(get(opts.Port)()).Should(HaveOccurred())
+		Ω(get(opts.AdminPort)()).Should(HaveOccurred())
+	})
+
+	It("should shut down on a signal", func() {
+		start()
+		Ω(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).Should(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("should report draining and keep serving during the grace period", func() {
+		opts.GracePeriod = time.Second
+		start()
+		Ω(registry.CheckStatus()).ShouldNot(HaveKey(healthcheck.DrainingCheck))
+		cancel()
+		Eventually(registry.CheckStatus).Should(HaveKey(healthcheck.DrainingCheck))
+		Ω(get(opts.Port)()).Should(Succeed())
+		Consistently(done, 500*time.Millisecond).ShouldNot(Receive())
+		Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+	})
+
+	It("should run shutdown hooks in reverse order", func() {
+		var order []int
+		for i := 0; i < 3; i++ {
+			i := i
+			opts.OnShutdown(func(context.Context) error {
+				order = append(order, i)
+				return nil
+			})
+		}
+		start()
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		Ω(order).Should(Equal([]int{2, 1, 0}))
+	})
+
+	It("should return the first shutdown hook error after running every hook", func() {
+		ran := false
+		opts.OnShutdown(func(context.Context) error {
+			ran = true
+			return nil
+		})
+		opts.OnShutdown(func(context.Context) error {
+			return errors.New("o no")
+		})
+		start()
+		cancel()
+		var err error
+		Eventually(done).Should(Receive(&err))
+		Ω(err).Should(MatchError(ContainSubstring("o no")))
+		Ω(ran).Should(BeTrue())
+	})
+
+	It("should close io.Closers registered as hooks", func() {
+		u := healthcheck.NewStatusUpdater()
+		registry.Register("periodic", healthcheck.PeriodicChecker(u, 10*time.Millisecond))
+		opts.OnShutdown(CloserHook(registry))
+		start()
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		u.Update(errors.New("he dead"))
+		Consistently(registry.CheckStatus, 100*time.Millisecond).ShouldNot(HaveKey("periodic"))
+	})
+
+	It("should return an error if a server cannot listen", func() {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.AdminPort))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer l.Close()
+		go func() {
+			done <- Run(ctx, svc, adminSvc, opts)
+		}()
+		Eventually(done).Should(Receive(HaveOccurred()))
+	})
+
+	It("should serve only the main service if there is no admin service", func() {
+		go func() {
+			done <- Run(ctx, svc, nil, opts)
+		}()
+		Eventually(get(opts.Port)).Should(Succeed())
+		Ω(get(opts.AdminPort)()).Should(HaveOccurred())
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})